@@ -0,0 +1,54 @@
+package auth
+
+import "context"
+
+// 调用方的角色
+const (
+	// RoleUser 普通用户，只能读写自己的订单
+	RoleUser = "user"
+	// RoleService 内部服务，比如支付、仓储，可以按权限表操作任意用户的订单
+	RoleService = "service"
+	// RoleAdmin 管理员，可以调用全部接口
+	RoleAdmin = "admin"
+)
+
+// Identity 从token 中解析出的调用方身份
+type Identity struct {
+	Subject string
+	// UserId 普通用户的用户ID，服务和管理员的token 可以没有
+	UserId int64
+	Roles  []string
+	// TenantId token 绑定的租户，为空时可以访问请求中指定的任意租户
+	TenantId string
+}
+
+func (i *Identity) HasRole(role string) bool {
+	for _, r := range i.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Privileged 管理员和内部服务不受订单归属的限制
+func (i *Identity) Privileged() bool {
+	return i.HasRole(RoleAdmin) || i.HasRole(RoleService)
+}
+
+// CanAccess 是否可以读写这个用户的订单
+func (i *Identity) CanAccess(userId int64) bool {
+	return i.Privileged() || (i.UserId != 0 && i.UserId == userId)
+}
+
+type identityKey struct{}
+
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext 取出调用方身份，没有启用认证时返回false
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
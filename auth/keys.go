@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key 一个验签密钥，Alg 为空时按密钥类型允许同一族的算法
+type Key struct {
+	Kid string
+	Alg string
+	// HMAC 密钥是 []byte，RSA 和ECDSA 是公钥
+	Value interface{}
+}
+
+// allows 只允许和密钥类型匹配的算法，防止用公钥当HMAC 密钥伪造token
+func (k *Key) allows(method jwt.SigningMethod) bool {
+	if k.Alg != "" {
+		return method.Alg() == k.Alg
+	}
+	switch k.Value.(type) {
+	case []byte:
+		_, ok := method.(*jwt.SigningMethodHMAC)
+		return ok
+	case *rsa.PublicKey:
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return true
+		}
+	case *ecdsa.PublicKey:
+		_, ok := method.(*jwt.SigningMethodECDSA)
+		return ok
+	}
+	return false
+}
+
+// KeySet 按kid 查找密钥
+type KeySet struct {
+	keys map[string]*Key
+}
+
+func NewKeySet(keys ...*Key) *KeySet {
+	set := &KeySet{keys: map[string]*Key{}}
+	for _, key := range keys {
+		set.keys[key.Kid] = key
+	}
+	return set
+}
+
+// Lookup token 没有kid 时只有一个密钥才能确定用哪个
+func (s *KeySet) Lookup(kid string) (*Key, error) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return key, nil
+}
+
+func (s *KeySet) Len() int {
+	return len(s.keys)
+}
+
+// StaticKey 从配置中的静态密钥生成验签密钥，HS 开头的算法使用 secret，其他算法使用PEM 格式的公钥
+func StaticKey(kid, alg, secret, publicKey string) (*Key, error) {
+	key := &Key{Kid: kid, Alg: alg}
+	var err error
+	switch {
+	case strings.HasPrefix(alg, "HS"):
+		if secret == "" {
+			return nil, fmt.Errorf("key %s: %s needs a secret", kid, alg)
+		}
+		key.Value = []byte(secret)
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		key.Value, err = jwt.ParseRSAPublicKeyFromPEM([]byte(publicKey))
+	case strings.HasPrefix(alg, "ES"):
+		key.Value, err = jwt.ParseECPublicKeyFromPEM([]byte(publicKey))
+	default:
+		return nil, fmt.Errorf("key %s: unsupported algorithm %q", kid, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", kid, err)
+	}
+	return key, nil
+}
+
+// jwk JWKS 中的一个密钥，只支持验签需要的字段
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+// LoadJWKS 读取JWKS 文件，用途不是签名的密钥会被跳过
+func LoadJWKS(path string) ([]*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func ParseJWKS(data []byte) ([]*Key, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := []*Key{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		value, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwk %s: %v", k.Kid, err)
+		}
+		keys = append(keys, &Key{Kid: k.Kid, Alg: k.Alg, Value: value})
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(encoded string) (*big.Int, error) {
+	if encoded == "" {
+		return nil, errors.New("missing key parameter")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
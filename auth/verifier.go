@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Claims token 中除了标准字段外的身份信息
+type Claims struct {
+	jwt.RegisteredClaims
+	// UserId 普通用户的用户ID，没有时按 sub 解析
+	UserId int64    `json:"uid,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	// TenantId 调用方所属的租户
+	TenantId string `json:"tid,omitempty"`
+}
+
+// Verifier 校验token 的签名、有效期、签发方和受众
+type Verifier struct {
+	keys *KeySet
+	// 为空时不校验
+	Issuer   string
+	Audience string
+	// 允许的时钟误差
+	Leeway time.Duration
+	now    func() time.Time
+}
+
+func NewVerifier(keys *KeySet, issuer, audience string) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		Issuer:   issuer,
+		Audience: audience,
+		Leeway:   30 * time.Second,
+		now:      time.Now,
+	}
+}
+
+var ErrMissingToken = errors.New("missing bearer token")
+
+// ErrMissingUserId 普通用户的token 既没有 uid 也没有数字的 sub
+var ErrMissingUserId = errors.New("user token has no numeric user id")
+
+// Verify 校验token 并返回调用方身份，没有角色的token 按普通用户处理，
+// 普通用户必须有用户ID，否则按用户查询时会去掉用户条件
+func (v *Verifier) Verify(token string) (*Identity, error) {
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+	claims := &Claims{}
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	if _, err := parser.ParseWithClaims(token, claims, v.keyFunc); err != nil {
+		return nil, err
+	}
+	if err := v.validate(claims); err != nil {
+		return nil, err
+	}
+
+	identity := &Identity{
+		Subject:  claims.Subject,
+		UserId:   claims.UserId,
+		Roles:    claims.Roles,
+		TenantId: claims.TenantId,
+	}
+	if identity.UserId == 0 {
+		identity.UserId, _ = strconv.ParseInt(claims.Subject, 10, 64)
+	}
+	if len(identity.Roles) == 0 {
+		identity.Roles = []string{RoleUser}
+	}
+	if identity.UserId == 0 && !identity.Privileged() {
+		return nil, ErrMissingUserId
+	}
+	return identity, nil
+}
+
+func (v *Verifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := v.keys.Lookup(kid)
+	if err != nil {
+		return nil, err
+	}
+	if !key.allows(token.Method) {
+		return nil, fmt.Errorf("algorithm %s is not allowed for key %q", token.Method.Alg(), key.Kid)
+	}
+	return key.Value, nil
+}
+
+// validate 必须有过期时间，其余标准字段有值时校验
+func (v *Verifier) validate(claims *Claims) error {
+	now := v.now()
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	if now.After(claims.ExpiresAt.Add(v.Leeway)) {
+		return errors.New("token is expired")
+	}
+	if claims.NotBefore != nil && now.Add(v.Leeway).Before(claims.NotBefore.Time) {
+		return errors.New("token is not valid yet")
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if v.Audience != "" && !claims.VerifyAudience(v.Audience, true) {
+		return errors.New("token is not issued for this service")
+	}
+	if claims.Subject == "" {
+		return errors.New("token has no subject")
+	}
+	return nil
+}
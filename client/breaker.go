@@ -0,0 +1,73 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 订单服务连续不可用，熔断期间直接返回，不再发出请求
+var ErrCircuitOpen = errors.New("order service circuit breaker is open")
+
+// breaker 连续 failures 次临时错误后熔断 cooldown，之后只放行一个探测请求，成功后恢复，失败后继续熔断
+// 业务错误说明服务可用，和成功一样清零计数
+type breaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu          sync.Mutex
+	consecutive int
+	openUntil   time.Time
+	probing     bool
+}
+
+func newBreaker(failures int, cooldown time.Duration) *breaker {
+	if failures <= 0 {
+		return nil
+	}
+	return &breaker{failures: failures, cooldown: cooldown}
+}
+
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+func (b *breaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if IsRetryable(err) {
+		b.consecutive++
+		if b.consecutive >= b.failures {
+			b.openUntil = time.Now().Add(b.cooldown)
+		}
+		return
+	}
+	b.consecutive = 0
+	b.openUntil = time.Time{}
+}
+
+// release 调用方自己取消了请求，不计入结果，只释放探测
+func (b *breaker) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	b.probing = false
+	b.mu.Unlock()
+}
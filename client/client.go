@@ -0,0 +1,445 @@
+// Package client 订单服务的Go 客户端，包装生成的 order.OrderService：
+// 每个方法有默认超时，幂等的调用遇到临时错误时按 RetryPolicy 重试，可选对 GetOrder 发出对冲请求和熔断
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/lenny-mo/order/proto/order"
+	microclient "github.com/micro/go-micro/v2/client"
+)
+
+// ServiceName 订单服务在注册中心的名字
+const ServiceName = "go.micro.service.order"
+
+// DefaultTimeout 没有单独配置的方法每次调用的超时
+const DefaultTimeout = 3 * time.Second
+
+// defaultTimeouts 比较慢的方法的默认超时，按endpoint 配置
+var defaultTimeouts = map[string]time.Duration{
+	"Order.PlaceOrder":      15 * time.Second,
+	"Order.SearchOrders":    5 * time.Second,
+	"Order.GetSalesSummary": 10 * time.Second,
+	"Order.GetTopProducts":  10 * time.Second,
+}
+
+// Options 客户端配置
+type Options struct {
+	Service  string
+	Timeouts map[string]time.Duration
+	Retry    RetryPolicy
+	// HedgeDelay GetOrder 超过这个时间没有返回时再发一个相同的请求，先返回的结果生效，为0 时不对冲
+	HedgeDelay time.Duration
+	// BreakerFailures 连续多少次临时错误后熔断，为0 时不熔断
+	BreakerFailures int
+	BreakerCooldown time.Duration
+}
+
+type Option func(*Options)
+
+// WithService 订单服务在注册中心的名字，默认是 ServiceName
+func WithService(name string) Option {
+	return func(o *Options) {
+		o.Service = name
+	}
+}
+
+// WithTimeout 覆盖某个方法每次调用的超时，endpoint 形如 Order.GetOrder
+func WithTimeout(endpoint string, timeout time.Duration) Option {
+	return func(o *Options) {
+		o.Timeouts[endpoint] = timeout
+	}
+}
+
+// WithRetry 替换默认的重试策略，MaxAttempts 为1 时不重试
+func WithRetry(policy RetryPolicy) Option {
+	return func(o *Options) {
+		o.Retry = policy
+	}
+}
+
+// WithHedging GetOrder 超过 delay 没有返回时发出第二个请求，go-micro 的selector 通常会选到另一个实例
+func WithHedging(delay time.Duration) Option {
+	return func(o *Options) {
+		o.HedgeDelay = delay
+	}
+}
+
+// WithCircuitBreaker 连续 failures 次临时错误后 cooldown 内直接返回 ErrCircuitOpen
+func WithCircuitBreaker(failures int, cooldown time.Duration) Option {
+	return func(o *Options) {
+		o.BreakerFailures = failures
+		o.BreakerCooldown = cooldown
+	}
+}
+
+// Client 订单服务客户端，可以并发使用
+type Client struct {
+	rpc     order.OrderService
+	opts    Options
+	breaker *breaker
+}
+
+// New 通过 go-micro 的client 调用订单服务
+func New(c microclient.Client, opts ...Option) *Client {
+	options := newOptions(opts)
+	return Wrap(order.NewOrderService(options.Service, c), opts...)
+}
+
+// Wrap 包装已有的 order.OrderService，比如测试中的实现
+func Wrap(rpc order.OrderService, opts ...Option) *Client {
+	options := newOptions(opts)
+	return &Client{
+		rpc:     rpc,
+		opts:    options,
+		breaker: newBreaker(options.BreakerFailures, options.BreakerCooldown),
+	}
+}
+
+func newOptions(opts []Option) Options {
+	options := Options{
+		Service:  ServiceName,
+		Timeouts: map[string]time.Duration{},
+		Retry:    DefaultRetryPolicy,
+	}
+	for endpoint, timeout := range defaultTimeouts {
+		options.Timeouts[endpoint] = timeout
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.Retry.MaxAttempts <= 0 {
+		options.Retry.MaxAttempts = 1
+	}
+	return options
+}
+
+// Raw 生成的客户端，用于没有包装的方法，比如用户数据导出
+func (c *Client) Raw() order.OrderService {
+	return c.rpc
+}
+
+func (c *Client) timeout(endpoint string) time.Duration {
+	if timeout, ok := c.opts.Timeouts[endpoint]; ok {
+		return timeout
+	}
+	return DefaultTimeout
+}
+
+// callOptions 重试由这里控制，关闭go-micro 自己的重试
+var callOptions = []microclient.CallOption{microclient.WithRetries(0)}
+
+// invoke 按方法的超时调用，idempotent 为 true 时临时错误按策略重试
+func (c *Client) invoke(ctx context.Context, endpoint string, idempotent bool, call func(ctx context.Context) error) error {
+	attempts := 1
+	if idempotent {
+		attempts = c.opts.Retry.MaxAttempts
+	}
+	for attempt := 1; ; attempt++ {
+		if err := c.breaker.allow(); err != nil {
+			return err
+		}
+		callCtx, cancel := context.WithTimeout(ctx, c.timeout(endpoint))
+		err := call(callCtx)
+		cancel()
+		if ctx.Err() != nil {
+			c.breaker.release()
+			return ctx.Err()
+		}
+		c.breaker.record(err)
+		if err == nil || attempt >= attempts || !IsRetryable(err) {
+			return err
+		}
+		if !wait(ctx, c.opts.Retry.delay(attempt)) {
+			return ctx.Err()
+		}
+	}
+}
+
+// GetOption GetOrder 的可选参数
+type GetOption func(*order.GetRequest)
+
+// WithItems 同时返回订单项
+func WithItems() GetOption {
+	return func(req *order.GetRequest) { req.WithItems = true }
+}
+
+// WithDeleted 已软删除的订单也返回
+func WithDeleted() GetOption {
+	return func(req *order.GetRequest) { req.WithDeleted = true }
+}
+
+// WithFulfillment 已发货时同时返回履约信息
+func WithFulfillment() GetOption {
+	return func(req *order.GetRequest) { req.WithFulfillment = true }
+}
+
+// GetOrder 读取订单，配置了对冲时慢请求会再发一次
+func (c *Client) GetOrder(ctx context.Context, orderId string, opts ...GetOption) (*order.OrderInfo, error) {
+	req := &order.GetRequest{OrderId: orderId}
+	for _, opt := range opts {
+		opt(req)
+	}
+	var res *order.GetResponse
+	err := c.invoke(ctx, "Order.GetOrder", true, func(ctx context.Context) (err error) {
+		res, err = c.hedge(ctx, func(ctx context.Context) (*order.GetResponse, error) {
+			return c.rpc.GetOrder(ctx, req, callOptions...)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.OrderData, nil
+}
+
+// InsertOrder 创建订单，不重试：超时后订单可能已经创建，调用方用 GetOrder 确认
+func (c *Client) InsertOrder(ctx context.Context, info *order.OrderInfo) error {
+	return c.invoke(ctx, "Order.InsertOrder", false, func(ctx context.Context) error {
+		_, err := c.rpc.InsertOrder(ctx, &order.InserRequest{OrderData: info}, callOptions...)
+		return err
+	})
+}
+
+// UpdateOrder 按版本号修改订单，info.OrderVersion 是修改后的版本号，通常是 oldVersion+1
+// 版本冲突时返回的错误满足 IsConflict，需要重新读取订单，见 UpdateWithRetry
+func (c *Client) UpdateOrder(ctx context.Context, info *order.OrderInfo, oldVersion int64, reason string) error {
+	return c.invoke(ctx, "Order.UpdateOrder", false, func(ctx context.Context) error {
+		_, err := c.rpc.UpdateOrder(ctx, &order.UpdateRequest{OrderData: info, Oldversion: oldVersion, Reason: reason}, callOptions...)
+		return err
+	})
+}
+
+// GenerateOrderId 生成订单号，分片部署时订单号带有用户所在的槽位
+func (c *Client) GenerateOrderId(ctx context.Context, userId int64) (string, error) {
+	var res *order.GenerateUUIDResponse
+	err := c.invoke(ctx, "Order.GenerateUUID", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GenerateUUID(ctx, &order.GenerateUUIDRequest{UserId: userId}, callOptions...)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.Uuid, nil
+}
+
+func (c *Client) GetOrderHistory(ctx context.Context, orderId string) ([]*order.OrderHistoryEntry, error) {
+	var res *order.GetHistoryResponse
+	err := c.invoke(ctx, "Order.GetOrderHistory", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GetOrderHistory(ctx, &order.GetHistoryRequest{OrderId: orderId}, callOptions...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.History, nil
+}
+
+func (c *Client) ListOrders(ctx context.Context, req *order.ListRequest) ([]*order.OrderInfo, error) {
+	var res *order.ListResponse
+	err := c.invoke(ctx, "Order.ListOrders", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.ListOrders(ctx, req, callOptions...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Orders, nil
+}
+
+// BatchGetOrders 找到的订单按请求顺序返回，不存在或无权查看的订单号在第二个返回值中
+func (c *Client) BatchGetOrders(ctx context.Context, orderIds []string, withDeleted bool) ([]*order.OrderInfo, []string, error) {
+	var res *order.BatchGetResponse
+	err := c.invoke(ctx, "Order.BatchGetOrders", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.BatchGetOrders(ctx, &order.BatchGetRequest{OrderIds: orderIds, WithDeleted: withDeleted}, callOptions...)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return res.Orders, res.MissingIds, nil
+}
+
+// BatchUpdateStatus 不整体重试，每个订单的结果见返回值中的 Code
+func (c *Client) BatchUpdateStatus(ctx context.Context, updates []*order.StatusUpdate) (*order.BatchUpdateStatusResponse, error) {
+	var res *order.BatchUpdateStatusResponse
+	err := c.invoke(ctx, "Order.BatchUpdateStatus", false, func(ctx context.Context) (err error) {
+		res, err = c.rpc.BatchUpdateStatus(ctx, &order.BatchUpdateStatusRequest{Updates: updates}, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+// DeleteOrder 按当前版本号软删除订单
+func (c *Client) DeleteOrder(ctx context.Context, orderId string, version int64, reason string) error {
+	return c.invoke(ctx, "Order.DeleteOrder", false, func(ctx context.Context) error {
+		_, err := c.rpc.DeleteOrder(ctx, &order.DeleteRequest{OrderId: orderId, Version: version, Reason: reason}, callOptions...)
+		return err
+	})
+}
+
+func (c *Client) RestoreOrder(ctx context.Context, orderId string, reason string) error {
+	return c.invoke(ctx, "Order.RestoreOrder", false, func(ctx context.Context) error {
+		_, err := c.rpc.RestoreOrder(ctx, &order.RestoreRequest{OrderId: orderId, Reason: reason}, callOptions...)
+		return err
+	})
+}
+
+// PlaceOrder 下单流程，不重试：流程会自己补偿，超时后用 GetOrder 确认结果
+func (c *Client) PlaceOrder(ctx context.Context, info *order.OrderInfo) (*order.PlaceOrderResponse, error) {
+	var res *order.PlaceOrderResponse
+	err := c.invoke(ctx, "Order.PlaceOrder", false, func(ctx context.Context) (err error) {
+		res, err = c.rpc.PlaceOrder(ctx, &order.PlaceOrderRequest{OrderData: info}, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+// PreviewOrder 计算优惠和应付金额，不落库
+func (c *Client) PreviewOrder(ctx context.Context, info *order.OrderInfo) (*order.PreviewResponse, error) {
+	var res *order.PreviewResponse
+	err := c.invoke(ctx, "Order.PreviewOrder", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.PreviewOrder(ctx, &order.PreviewRequest{OrderData: info}, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+// RefundOrder 传入 RefundId 时重复提交只退款一次，才会重试
+func (c *Client) RefundOrder(ctx context.Context, req *order.RefundRequest) (*order.RefundResponse, error) {
+	var res *order.RefundResponse
+	err := c.invoke(ctx, "Order.RefundOrder", req.RefundId != "", func(ctx context.Context) (err error) {
+		res, err = c.rpc.RefundOrder(ctx, req, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+func (c *Client) ListRefunds(ctx context.Context, orderId string) ([]*order.RefundInfo, error) {
+	var res *order.ListRefundsResponse
+	err := c.invoke(ctx, "Order.ListRefunds", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.ListRefunds(ctx, &order.ListRefundsRequest{OrderId: orderId}, callOptions...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Refunds, nil
+}
+
+// ConfirmPayment 同一个 PaymentId 只生效一次，可以重试
+func (c *Client) ConfirmPayment(ctx context.Context, req *order.ConfirmPaymentRequest) (*order.ConfirmPaymentResponse, error) {
+	var res *order.ConfirmPaymentResponse
+	err := c.invoke(ctx, "Order.ConfirmPayment", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.ConfirmPayment(ctx, req, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+func (c *Client) ShipOrder(ctx context.Context, req *order.ShipRequest) (*order.ShipResponse, error) {
+	var res *order.ShipResponse
+	err := c.invoke(ctx, "Order.ShipOrder", false, func(ctx context.Context) (err error) {
+		res, err = c.rpc.ShipOrder(ctx, req, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+func (c *Client) MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*order.DeliverResponse, error) {
+	req := &order.DeliverRequest{OrderId: orderId}
+	if !deliveredAt.IsZero() {
+		req.DeliveredAt = deliveredAt.Unix()
+	}
+	var res *order.DeliverResponse
+	err := c.invoke(ctx, "Order.MarkDelivered", false, func(ctx context.Context) (err error) {
+		res, err = c.rpc.MarkDelivered(ctx, req, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+// SplitOrder 拆单，不重试：超时后可能已经拆分，重试会因为版本号冲突失败，调用方用 ListSubOrders 确认
+func (c *Client) SplitOrder(ctx context.Context, req *order.SplitOrderRequest) (*order.SplitOrderResponse, error) {
+	var res *order.SplitOrderResponse
+	err := c.invoke(ctx, "Order.SplitOrder", false, func(ctx context.Context) (err error) {
+		res, err = c.rpc.SplitOrder(ctx, req, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+// ListSubOrders 拆单产生的子订单，没有拆单时为空
+func (c *Client) ListSubOrders(ctx context.Context, parentId string) ([]*order.OrderInfo, error) {
+	req := &order.GetRequest{OrderId: parentId, WithSubOrders: true}
+	var res *order.GetResponse
+	err := c.invoke(ctx, "Order.GetOrder", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GetOrder(ctx, req, callOptions...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.SubOrders, nil
+}
+
+// MergeOrders 合单，versions 是每个订单当前的版本号，不重试：超时后可能已经合并，调用方用 GetOrder 查看 MergedInto
+func (c *Client) MergeOrders(ctx context.Context, versions map[string]int64, reason string) (*order.OrderInfo, error) {
+	req := &order.MergeOrdersRequest{Reason: reason}
+	for orderId, version := range versions {
+		req.Orders = append(req.Orders, &order.OrderRef{OrderId: orderId, Version: version})
+	}
+	var res *order.MergeOrdersResponse
+	err := c.invoke(ctx, "Order.MergeOrders", false, func(ctx context.Context) (err error) {
+		res, err = c.rpc.MergeOrders(ctx, req, callOptions...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Order, nil
+}
+
+func (c *Client) SearchOrders(ctx context.Context, req *order.SearchRequest) (*order.SearchResponse, error) {
+	var res *order.SearchResponse
+	err := c.invoke(ctx, "Order.SearchOrders", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.SearchOrders(ctx, req, callOptions...)
+		return err
+	})
+	return res, err
+}
+
+func (c *Client) GetSalesSummary(ctx context.Context, req *order.SalesSummaryRequest) ([]*order.SalesBucket, error) {
+	var res *order.SalesSummaryResponse
+	err := c.invoke(ctx, "Order.GetSalesSummary", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GetSalesSummary(ctx, req, callOptions...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Buckets, nil
+}
+
+func (c *Client) GetTopProducts(ctx context.Context, req *order.TopProductsRequest) ([]*order.ProductSales, error) {
+	var res *order.TopProductsResponse
+	err := c.invoke(ctx, "Order.GetTopProducts", true, func(ctx context.Context) (err error) {
+		res, err = c.rpc.GetTopProducts(ctx, req, callOptions...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.Products, nil
+}
+
+// WatchOrders 打开监听流，不设超时也不重试，断开后用最后收到的版本号重新调用
+func (c *Client) WatchOrders(ctx context.Context, req *order.WatchRequest) (order.Order_WatchOrdersService, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	stream, err := c.rpc.WatchOrders(ctx, req, callOptions...)
+	c.breaker.record(err)
+	return stream, err
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/lenny-mo/order/proto/order"
+)
+
+// hedge 第一个请求超过 HedgeDelay 没有返回时发出第二个请求，返回先成功的结果并取消另一个
+// 两个请求都失败时返回后失败的错误，第一个请求在对冲之前失败时直接返回，由重试处理
+func (c *Client) hedge(ctx context.Context, get func(ctx context.Context) (*order.GetResponse, error)) (*order.GetResponse, error) {
+	if c.opts.HedgeDelay <= 0 {
+		return get(ctx)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		res *order.GetResponse
+		err error
+	}
+	results := make(chan result, 2)
+	send := func() {
+		res, err := get(ctx)
+		results <- result{res, err}
+	}
+	go send()
+	timer := time.NewTimer(c.opts.HedgeDelay)
+	defer timer.Stop()
+
+	pending, hedged := 1, false
+	for {
+		select {
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				pending++
+				go send()
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil || pending == 0 {
+				return r.res, r.err
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+// RetryPolicy 幂等调用遇到临时错误时的重试策略，等待时间每次翻倍并加上随机抖动，避免多个调用方同时重试
+type RetryPolicy struct {
+	// MaxAttempts 包括第一次在内最多调用的次数，UpdateWithRetry 遇到版本冲突时也按它重试
+	MaxAttempts int
+	// Backoff 第一次重试前的等待时间
+	Backoff time.Duration
+	// MaxBackoff 等待时间的上限
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy 默认最多调用3次，等待 50ms、100ms，上限1秒
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: time.Second}
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// delay 第 attempt 次调用失败后的等待时间，在翻倍后的一半到全部之间随机
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	backoff := p.Backoff << uint(attempt-1)
+	if backoff <= 0 || backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return backoff/2 + time.Duration(jitter.Int63n(int64(backoff/2)+1))
+}
+
+// wait 等待重试，context 结束时返回 false
+func wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// microClientId go-micro 客户端自己产生的错误的ID，比如找不到服务节点、连接失败，这时请求还没有到达订单服务
+const microClientId = "go.micro.client"
+
+// IsRetryable 是否是可以重试的临时错误：超时、限流、网关错误，以及请求没有发出去的客户端错误
+// 订单服务返回的业务错误（参数错误、不存在、版本冲突等）都不重试
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	parsed := microerrors.Parse(err.Error())
+	switch parsed.Code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	case http.StatusInternalServerError:
+		return parsed.Id == microClientId
+	}
+	return false
+}
+
+// IsConflict 是否是乐观锁冲突，需要重新读取订单后再修改
+func IsConflict(err error) bool {
+	return err != nil && microerrors.Parse(err.Error()).Code == http.StatusConflict
+}
+
+// IsNotFound 订单不存在，或者属于其他用户
+func IsNotFound(err error) bool {
+	return err != nil && microerrors.Parse(err.Error()).Code == http.StatusNotFound
+}
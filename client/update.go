@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+
+	"github.com/lenny-mo/order/proto/order"
+)
+
+// UpdateWithRetry 读出订单交给 fn 修改，再按读到的版本号提交；遇到版本冲突时重新读取订单并再次调用 fn，
+// 最多按 RetryPolicy.MaxAttempts 提交，返回提交成功的订单
+// fn 只应该根据传入的订单计算修改，可能被调用多次；fn 返回错误时放弃修改并返回这个错误
+func (c *Client) UpdateWithRetry(ctx context.Context, orderId string, reason string, fn func(info *order.OrderInfo) error) (*order.OrderInfo, error) {
+	for attempt := 1; ; attempt++ {
+		current, err := c.GetOrder(ctx, orderId)
+		if err != nil {
+			return nil, err
+		}
+		oldVersion := current.OrderVersion
+		if err := fn(current); err != nil {
+			return nil, err
+		}
+		current.OrderId = orderId
+		current.OrderVersion = oldVersion + 1
+		err = c.UpdateOrder(ctx, current, oldVersion, reason)
+		if err == nil {
+			return current, nil
+		}
+		if !IsConflict(err) || attempt >= c.opts.Retry.MaxAttempts {
+			return nil, err
+		}
+		if !wait(ctx, c.opts.Retry.delay(attempt)) {
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/services"
+)
+
+// exportCommand 导出到 -file，为 - 时写到标准输出，导出的订单数写到标准错误
+func exportCommand(e *env, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", services.FormatJSONL, "文件格式 csv、jsonl 或 parquet")
+	file := flags.String("file", "-", "导出的文件，- 表示标准输出")
+	from := flags.String("from", "", "只导出在此时间及之后创建的订单，比如 2024-01-02 或RFC3339 时间")
+	to := flags.String("to", "", "只导出在此时间之前创建的订单")
+	userId := flags.Int64("user", 0, "只导出这个用户的订单")
+	status := flags.String("status", "", "只导出这个状态的订单，比如 PAID")
+	deleted := flags.Bool("deleted", false, "包含已删除的订单")
+	if _, err := parseArgs(flags, args, 0, 0); err != nil {
+		return err
+	}
+	if e.bulk == nil {
+		return services.ErrBulkNotSupported
+	}
+	query := &dao.ListOrderQuery{UserId: *userId}
+	var err error
+	if query.CreatedAfter, err = parseTime(*from); err != nil {
+		return err
+	}
+	if query.CreatedBefore, err = parseTime(*to); err != nil {
+		return err
+	}
+	if *status != "" {
+		value, err := parseStatus(*status)
+		if err != nil {
+			return err
+		}
+		query.Status = &value
+	}
+	ctx := e.ctx
+	if *deleted {
+		ctx = dao.WithDeleted(ctx)
+	}
+
+	var out io.Writer = os.Stdout
+	if *file != "-" {
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w, err := services.NewOrderWriter(*format, out)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	exported, err := e.bulk.Export(ctx, query, w)
+	if err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d orders\n", exported)
+	return nil
+}
+
+// importCommand 指定 -checkpoint 时每批提交后把进度写到这个文件，中断后用同样的参数重新执行会从进度处继续
+func importCommand(e *env, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", services.FormatJSONL, "文件格式 csv、jsonl 或 parquet")
+	batch := flags.Int("batch", dao.DefaultBulkBatch, "每个事务写入的订单数")
+	resume := flags.Int("resume", 0, "跳过文件开头已经导入的订单数")
+	checkpoint := flags.String("checkpoint", "", "保存导入进度的文件，存在时从其中的进度继续")
+	reason := flags.String("reason", "import", "写入版本记录的原因")
+	positional, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	if e.bulk == nil {
+		return services.ErrBulkNotSupported
+	}
+	options := services.ImportOptions{Batch: *batch, Resume: *resume}
+	if *checkpoint != "" {
+		data, err := ioutil.ReadFile(*checkpoint)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err == nil {
+			if options.Resume, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+				return fmt.Errorf("invalid checkpoint file %s: %v", *checkpoint, err)
+			}
+		}
+		options.OnCheckpoint = func(n int) {
+			if err := ioutil.WriteFile(*checkpoint, []byte(strconv.Itoa(n)+"\n"), 0644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+
+	r, err := services.OpenOrderReader(*format, positional[0])
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	ctx := dao.WithAudit(e.ctx, e.actor, *reason)
+	report, err := e.bulk.Import(ctx, r, options)
+	if printErr := e.printer.ImportReport(report); printErr != nil {
+		return printErr
+	}
+	if err != nil {
+		return fmt.Errorf("%v, resume with -resume %d", err, report.Checkpoint)
+	}
+	return nil
+}
+
+// parseTime 支持日期和RFC3339 时间，日期按本地时区解析
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: invalid time %q", errUsage, value)
+	}
+	return t, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+)
+
+// errUsage 参数错误，退出码为2
+var errUsage = errors.New("invalid arguments")
+
+// env 子命令共用的context、操作人和输出
+type env struct {
+	ctx     context.Context
+	actor   string
+	service services.OrderServiceInterface
+	// DAO 不支持批量导入导出时为空
+	bulk    *services.BulkService
+	printer *printer
+}
+
+var commands = map[string]func(e *env, args []string) error{
+	"get":              getCommand,
+	"list":             listCommand,
+	"history":          historyCommand,
+	"cancel":           cancelCommand,
+	"force-transition": forceTransitionCommand,
+	"republish":        republishCommand,
+	"export":           exportCommand,
+	"import":           importCommand,
+}
+
+// parseArgs 允许参数和位置参数交替出现，比如 cancel <订单号> -reason 原因，返回位置参数
+func parseArgs(flags *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	flags.SetOutput(ioutil.Discard)
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", errUsage, flags.Name(), err)
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(positional) < min || len(positional) > max {
+		return nil, fmt.Errorf("%w: %s needs %d to %d arguments, got %d", errUsage, flags.Name(), min, max, len(positional))
+	}
+	return positional, nil
+}
+
+func getCommand(e *env, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	deleted := flags.Bool("deleted", false, "包含已删除的订单")
+	positional, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	ctx := e.ctx
+	if *deleted {
+		ctx = dao.WithDeleted(ctx)
+	}
+	order, err := e.service.GetOrderById(ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	if order.Items, err = e.service.GetOrderItems(ctx, order.OrderId); err != nil {
+		return err
+	}
+	return e.printer.Order(order)
+}
+
+func listCommand(e *env, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	userId := flags.Int64("user", 0, "只列出这个用户的订单")
+	status := flags.String("status", "", "只列出这个状态的订单，比如 UNPAID")
+	limit := flags.Int("limit", 20, "最多返回的条数")
+	offset := flags.Int("offset", 0, "跳过的条数")
+	deleted := flags.Bool("deleted", false, "包含已删除的订单")
+	if _, err := parseArgs(flags, args, 0, 0); err != nil {
+		return err
+	}
+	query := &dao.ListOrderQuery{UserId: *userId, Limit: *limit, Offset: *offset}
+	if *status != "" {
+		value, err := parseStatus(*status)
+		if err != nil {
+			return err
+		}
+		query.Status = &value
+	}
+	ctx := e.ctx
+	if *deleted {
+		ctx = dao.WithDeleted(ctx)
+	}
+	orders, err := e.service.ListOrders(ctx, query)
+	if err != nil {
+		return err
+	}
+	return e.printer.Orders(orders)
+}
+
+func historyCommand(e *env, args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+	positional, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	history, err := e.service.GetOrderHistory(e.ctx, positional[0])
+	if err != nil {
+		return err
+	}
+	return e.printer.History(history)
+}
+
+func cancelCommand(e *env, args []string) error {
+	flags := flag.NewFlagSet("cancel", flag.ContinueOnError)
+	reason := flags.String("reason", "", "取消原因，写入版本记录")
+	positional, err := parseArgs(flags, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	return e.transition(positional[0], models.StatusCancelled, *reason, false)
+}
+
+func forceTransitionCommand(e *env, args []string) error {
+	flags := flag.NewFlagSet("force-transition", flag.ContinueOnError)
+	reason := flags.String("reason", "", "修改原因，写入版本记录")
+	positional, err := parseArgs(flags, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	status, err := parseStatus(positional[1])
+	if err != nil {
+		return err
+	}
+	// 按结构体更新时零值字段不会写入，未支付是状态的零值
+	if status == models.StatusUnpaid {
+		return fmt.Errorf("%w: orders can not be moved back to %s", errUsage, statusName(status))
+	}
+	return e.transition(positional[0], status, *reason, true)
+}
+
+// transition 按当前版本号修改订单状态，force 为false 时只允许取消未支付的订单
+func (e *env) transition(orderId string, status int8, reason string, force bool) error {
+	if reason == "" {
+		return fmt.Errorf("%w: -reason is required", errUsage)
+	}
+	order, err := e.service.GetOrderById(e.ctx, orderId)
+	if err != nil {
+		return err
+	}
+	if !force && order.Status != models.StatusUnpaid {
+		return fmt.Errorf("order %s is %s, only %s orders can be cancelled, use force-transition instead",
+			orderId, statusName(order.Status), statusName(models.StatusUnpaid))
+	}
+	if order.Status == status {
+		return e.printer.Order(order)
+	}
+	update := &models.Order{
+		OrderId:      order.OrderId,
+		Status:       status,
+		OrderVersion: order.OrderVersion + 1,
+	}
+	ctx := dao.WithAudit(e.ctx, e.actor, reason)
+	if _, err := e.service.UpdateOrder(ctx, update, order.OrderVersion); err != nil {
+		return err
+	}
+	order, err = e.service.GetOrderById(e.ctx, orderId)
+	if err != nil {
+		return err
+	}
+	return e.printer.Order(order)
+}
+
+// republishCommand 没有传退款ID 时补发订单全部未完成的退款事件
+func republishCommand(e *env, args []string) error {
+	flags := flag.NewFlagSet("republish", flag.ContinueOnError)
+	positional, err := parseArgs(flags, args, 1, 2)
+	if err != nil {
+		return err
+	}
+	orderId := positional[0]
+	refundIds := positional[1:]
+	if len(refundIds) == 0 {
+		refunds, err := e.service.ListRefunds(e.ctx, orderId)
+		if err != nil {
+			return err
+		}
+		for _, refund := range refunds {
+			if refund.Status == models.RefundPending {
+				refundIds = append(refundIds, refund.RefundId)
+			}
+		}
+	}
+	published := []*models.OrderRefund{}
+	for _, refundId := range refundIds {
+		refund, err := e.service.RepublishRefund(e.ctx, orderId, refundId)
+		if err != nil {
+			return fmt.Errorf("refund %s: %v", refundId, err)
+		}
+		published = append(published, refund)
+	}
+	return e.printer.Refunds(published)
+}
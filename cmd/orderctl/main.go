@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/encryption"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/proto/order"
+	"github.com/micro/go-micro/v2"
+	"github.com/micro/go-micro/v2/config"
+	"github.com/micro/go-micro/v2/registry"
+	"github.com/micro/go-plugins/registry/consul/v2"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// 订单运维工具：直接通过DAO 读写订单，不经过RPC 的认证，修改都会以 -actor 写入版本记录
+//
+//	orderctl [全局参数] get <订单号> [-deleted]
+//	orderctl [全局参数] list [-user 用户ID] [-status 状态] [-limit 条数] [-offset 偏移] [-deleted]
+//	orderctl [全局参数] history <订单号>
+//	orderctl [全局参数] cancel <订单号> -reason 原因
+//	orderctl [全局参数] force-transition <订单号> <状态> -reason 原因
+//	orderctl [全局参数] republish <订单号> [退款ID]
+//	orderctl [全局参数] export [-format 格式] [-file 文件] [-from 时间] [-to 时间] [-user 用户ID] [-status 状态] [-deleted]
+//	orderctl [全局参数] import <文件> [-format 格式] [-batch 条数] [-resume 条数] [-checkpoint 进度文件]
+//
+// 全局参数 -o 指定输出格式 table、json 或 yaml，-tenant 指定租户，状态使用proto 中的名字，比如 CANCELLED
+const usage = `usage: orderctl [-consul host] [-port port] [-tenant id] [-actor name] [-o table|json|yaml] <command> [args]
+
+commands:
+  get <order-id> [-deleted]                    show one order with its items
+  list [-user id] [-status name] [-limit n]    list orders, newest first
+  history <order-id>                           show every version of an order
+  cancel <order-id> -reason text               cancel an unpaid order
+  force-transition <order-id> <status> -reason text
+                                               set any status, bypassing the state machine
+  republish <order-id> [refund-id]             publish the refund event again
+  export [-format csv|jsonl|parquet] [-file path] [-from date] [-to date] [-user id] [-status name]
+                                               export orders with their items
+  import <path> [-format csv|jsonl|parquet] [-batch n] [-resume n] [-checkpoint path]
+                                               import orders, skipping existing ones
+`
+
+func main() {
+	flags := flag.NewFlagSet("orderctl", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	consulHost := flags.String("consul", "127.0.0.1", "consul host")
+	consulPort := flags.Int64("port", 8500, "consul port")
+	tenant := flags.String("tenant", "", "只读写这个租户的订单，为空时可以读写全部租户")
+	actor := flags.String("actor", "", "写入版本记录的操作人，默认是当前系统用户")
+	output := flags.String("o", "table", "输出格式 table、json 或 yaml")
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	printer, err := newPrinter(*output, os.Stdout)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	command, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Printf("unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	consulCof, err := conf.GetConfig(*consulHost, *consulPort, "/micro/config")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	orderService, orderDAO, err := openOrderService(consulCof, *consulHost, *consulPort)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	bulk, _ := services.NewBulkService(orderDAO)
+
+	ctx := context.Background()
+	if *tenant != "" {
+		ctx = dao.WithTenant(ctx, *tenant)
+	}
+	if *actor == "" {
+		*actor = os.Getenv("USER")
+	}
+	env := &env{
+		ctx:     ctx,
+		actor:   "orderctl:" + *actor,
+		service: orderService,
+		bulk:    bulk,
+		printer: printer,
+	}
+	if err := command(env, flags.Args()[1:]); err != nil {
+		fmt.Println(err)
+		if errors.Is(err, errUsage) {
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// openOrderService 按订单服务的配置连接数据库，选择和订单服务一样的DAO
+// 不使用缓存，修改后订单服务中的缓存按TTL 过期
+func openOrderService(consulCof config.Config, consulHost string, consulPort int64) (services.OrderServiceInterface, dao.OrderDAOInterface, error) {
+	mysqlConf := conf.GetMysqlFromConsul(consulCof, "mysql")
+	db, err := gorm.Open(mysql.Open(mysqlConf.DSN()), &gorm.Config{})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := db.Use(dao.TenantPlugin{}); err != nil {
+		return nil, nil, err
+	}
+
+	encryptionConf := conf.GetEncryptionFromConsul(consulCof, "encryption")
+	if encryptionConf.Enabled {
+		kms, err := encryption.NewLocalKMS(encryptionConf.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		keyring, err := encryption.NewKeyring(context.Background(), kms)
+		if err != nil {
+			return nil, nil, err
+		}
+		encryption.SetDefault(keyring)
+	}
+
+	orderDAO := dao.NewOrderDAO(db)
+	shardConf := conf.GetShardFromConsul(consulCof, "shard")
+	persistenceConf := conf.GetPersistenceFromConsul(consulCof, "persistence")
+	switch {
+	case persistenceConf.Mode == conf.PersistenceEvent:
+		orderDAO = dao.NewEventSourcedOrderDAO(db, persistenceConf.SnapshotEvery)
+	case len(shardConf.Databases) > 0:
+		layout, err := dao.OpenShardLayout(shardConf)
+		if err != nil {
+			return nil, nil, err
+		}
+		orderDAO = dao.NewShardedOrderDAO(layout)
+	}
+
+	// 取消订单时和订单服务一样释放预占的库存，补发事件通过broker 发布
+	consulRegistry := consul.NewRegistry(func(options *registry.Options) {
+		options.Addrs = []string{fmt.Sprintf("%s:%d", consulHost, consulPort)}
+	})
+	service := micro.NewService(micro.Name("go.micro.cli.orderctl"), micro.Registry(consulRegistry))
+	inventoryConf := conf.GetInventoryFromConsul(consulCof, "inventory")
+	var inventory services.InventoryClient
+	if inventoryConf.Enabled {
+		inventory = services.NewInventoryClient(service.Client(), inventoryConf.Service)
+	}
+	publisher := services.NewMicroPublisher(service.Client())
+	orderService := services.NewOrderService(orderDAO, publisher, inventory, nil, nil)
+	// 修改通过broker 通知订单服务，WatchOrders 的监听者可以立即收到
+	return services.NewNotifyingOrderService(orderService, nil, publisher), orderDAO, nil
+}
+
+// parseStatus 按proto 中的名字解析订单状态，不区分大小写
+func parseStatus(name string) (int8, error) {
+	value, ok := order.OrderStatus_value[strings.ToUpper(name)]
+	if !ok {
+		return 0, fmt.Errorf("%w: unknown status %q", errUsage, name)
+	}
+	return int8(value), nil
+}
+
+func statusName(status int8) string {
+	return order.OrderStatus(status).String()
+}
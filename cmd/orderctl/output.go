@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+)
+
+// printer 按 -o 指定的格式输出，json 和yaml 输出完整的记录，table 只输出常用的字段
+type printer struct {
+	format string
+	w      io.Writer
+}
+
+func newPrinter(format string, w io.Writer) (*printer, error) {
+	switch format {
+	case "table", "json", "yaml":
+		return &printer{format: format, w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", format)
+}
+
+// print json 和yaml 直接编码 value，table 交给 table 按列输出
+func (p *printer) print(value interface{}, table func(w io.Writer)) error {
+	switch p.format {
+	case "json":
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(p.w, string(data))
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(value)
+		if err != nil {
+			return err
+		}
+		_, err = p.w.Write(data)
+		return err
+	}
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+func (p *printer) Order(order *models.Order) error {
+	return p.print(order, func(w io.Writer) {
+		orderTable(w, []*models.Order{order})
+		if len(order.Items) == 0 {
+			return
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "SKU\tCOUNT\tPRICE\tDISCOUNT\tREFUNDED")
+		for _, item := range order.Items {
+			fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\n", item.SKUId, item.Count, item.Price, item.Discount, item.RefundedCount)
+		}
+	})
+}
+
+func (p *printer) Orders(orders []*models.Order) error {
+	return p.print(orders, func(w io.Writer) {
+		orderTable(w, orders)
+	})
+}
+
+func orderTable(w io.Writer, orders []*models.Order) {
+	fmt.Fprintln(w, "ORDER_ID\tTENANT\tUSER\tSTATUS\tVERSION\tTOTAL\tPAID\tCURRENCY\tCREATED_AT\tDELETED_AT")
+	for _, order := range orders {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%d\t%d\t%s\t%s\t%s\n",
+			order.OrderId, order.TenantId, order.UserId, statusName(order.Status), order.OrderVersion,
+			order.TotalAmount, order.PaidAmount, order.Currency, formatTime(order.CreatedAt), formatTime(order.DeletedAt.Time))
+	}
+}
+
+func (p *printer) History(history []*models.OrderHistory) error {
+	return p.print(history, func(w io.Writer) {
+		fmt.Fprintln(w, "VERSION\tSTATUS\tACTOR\tREASON\tCREATED_AT\tDIFF")
+		for _, entry := range history {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				entry.OrderVersion, statusName(entry.Status), entry.Actor, entry.Reason, formatTime(entry.CreatedAt), entry.Diff)
+		}
+	})
+}
+
+func (p *printer) Refunds(refunds []*models.OrderRefund) error {
+	return p.print(refunds, func(w io.Writer) {
+		fmt.Fprintln(w, "REFUND_ID\tORDER_ID\tSTATUS\tAMOUNT\tCURRENCY\tREASON\tCREATED_AT")
+		for _, refund := range refunds {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+				refund.RefundId, refund.OrderId, refund.Status, refund.Amount, refund.Currency, refund.Reason, formatTime(refund.CreatedAt))
+		}
+	})
+}
+
+// ImportReport table 先输出汇总，再逐行输出拒绝的订单和原因
+func (p *printer) ImportReport(report *services.ImportReport) error {
+	return p.print(report, func(w io.Writer) {
+		fmt.Fprintln(w, "READ\tIMPORTED\tEXISTING\tREJECTED\tCHECKPOINT")
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\n", report.Read, report.Imported, report.Existing, len(report.Rejected), report.Checkpoint)
+		if len(report.Rejected) == 0 {
+			return
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "POSITION\tORDER_ID\tREASON")
+		for _, rejected := range report.Rejected {
+			fmt.Fprintf(w, "%d\t%s\t%s\n", rejected.Position, rejected.OrderId, rejected.Reason)
+		}
+	})
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
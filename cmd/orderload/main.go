@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lenny-mo/order/auth"
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/handler"
+	"github.com/lenny-mo/order/proto/order"
+	ordertesting "github.com/lenny-mo/order/testing"
+	"github.com/micro/go-micro/v2"
+	"github.com/micro/go-micro/v2/client"
+	"github.com/micro/go-micro/v2/metadata"
+	"github.com/micro/go-micro/v2/registry"
+	"github.com/micro/go-plugins/registry/consul/v2"
+)
+
+// 订单服务压测工具：通过生成的客户端按权重发出 Insert、Get 和Update，统计延迟分位数、错误和版本冲突
+//
+//	orderload -mix insert=1,get=8,update=1 -c 50 -duration 30s                 闭合模型，50 个worker
+//	orderload -model open -rate 2000 -c 500 -duration 30s                      开放模型，平均每秒2000 个请求
+//	orderload -mix get=1,update=1 -hot-keys 10 -hot-ratio 0.9 -o json          热点订单上的乐观锁冲突
+//	orderload -inproc -persistence event                                       进程内启动订单服务，不需要部署
+//
+// update 先读出订单再按读到的版本号修改，和真实调用方一样；失败不重试，409 计为冲突
+func main() {
+	consulHost := flag.String("consul", "127.0.0.1", "consul host")
+	consulPort := flag.Int64("port", 8500, "consul port")
+	serviceName := flag.String("service", "go.micro.service.order", "订单服务在注册中心的名字")
+	inproc := flag.Bool("inproc", false, "在进程内启动订单服务和SQLite 内存库，不连接consul")
+	persistence := flag.String("persistence", conf.PersistenceState, "-inproc 时的持久化模式 state 或 event")
+	mixFlag := flag.String("mix", "insert=1,get=8,update=1", "操作权重")
+	model := flag.String("model", modelClosed, "负载模型 closed 或 open")
+	concurrency := flag.Int("c", 50, "闭合模型的worker 数，开放模型的在途请求上限")
+	rate := flag.Float64("rate", 1000, "开放模型每秒平均到达的请求数")
+	duration := flag.Duration("duration", 30*time.Second, "压测时长")
+	requests := flag.Int64("n", 0, "最多发出的请求数，0 表示只按时长结束")
+	keys := flag.Int("keys", 1000, "压测前创建的订单数，get 和update 在这些订单和压测中创建的订单中选择")
+	hotKeys := flag.Int("hot-keys", 0, "热点订单数，取预先创建的前 N 个订单")
+	hotRatio := flag.Float64("hot-ratio", 0.8, "get 和update 落在热点订单上的比例")
+	users := flag.Int64("users", 1000, "insert 时随机选择的用户数")
+	timeout := flag.Duration("timeout", 5*time.Second, "每个操作的超时")
+	seed := flag.Int64("seed", time.Now().UnixNano(), "随机数种子，相同种子得到相同的操作序列")
+	tenant := flag.String("tenant", "", "通过metadata 传入的租户")
+	token := flag.String("token", "", "开启认证时使用的JWT")
+	output := flag.String("o", "text", "输出格式 text 或 json")
+	out := flag.String("out", "", "报告写入这个文件，默认输出到标准输出；-inproc 时服务的日志也在标准输出")
+	flag.Parse()
+
+	mix, err := parseMix(*mixFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	if *model != modelClosed && *model != modelOpen {
+		fmt.Printf("unknown model %q\n", *model)
+		os.Exit(2)
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Printf("unknown output format %q\n", *output)
+		os.Exit(2)
+	}
+	if *concurrency <= 0 || *rate <= 0 || *users <= 0 {
+		fmt.Println("-c, -rate and -users must be positive")
+		os.Exit(2)
+	}
+
+	var rpc order.OrderService
+	if *inproc {
+		fixture, err := ordertesting.New(ordertesting.WithPersistence(*persistence))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer fixture.Close()
+		rpc = fixture.RPC
+	} else {
+		consulRegistry := consul.NewRegistry(func(options *registry.Options) {
+			options.Addrs = []string{fmt.Sprintf("%s:%d", *consulHost, *consulPort)}
+		})
+		service := micro.NewService(micro.Name("go.micro.cli.orderload"), micro.Registry(consulRegistry))
+		// go-micro 的protobuf 编码写不出没有响应体的错误响应，用json 编码才能区分错误码和超时
+		if err := service.Client().Init(client.ContentType("application/json"), client.PoolSize(*concurrency)); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		rpc = order.NewOrderService(*serviceName, service.Client())
+	}
+
+	w, err := newWorkload(rpc, mix, *seed)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	w.hotKeys, w.hotRatio, w.users, w.timeout = *hotKeys, *hotRatio, *users, *timeout
+
+	ctx := context.Background()
+	md := metadata.Metadata{}
+	if *tenant != "" {
+		md[handler.TenantKey] = *tenant
+	}
+	if *token != "" {
+		md[auth.AuthorizationKey] = "Bearer " + *token
+	}
+	ctx = metadata.NewContext(ctx, md)
+
+	if *keys > 0 {
+		fmt.Fprintf(os.Stderr, "creating %d orders\n", *keys)
+		if err := w.seed(ctx, *keys, *concurrency); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "running %s model for %s\n", *model, *duration)
+	rec := newRecorder()
+	runCtx, cancel := context.WithTimeout(ctx, *duration)
+	start := time.Now()
+	if *model == modelOpen {
+		w.runOpen(runCtx, *rate, *concurrency, *requests, rec)
+	} else {
+		w.runClosed(runCtx, *concurrency, *requests, rec)
+	}
+	// 结束时在途的请求被取消，不计入结果，也不计入时长
+	elapsed := time.Since(start)
+	if elapsed > *duration {
+		elapsed = *duration
+	}
+	cancel()
+
+	report := rec.report(elapsed)
+	report.Model, report.Mix, report.Concurrency = *model, mix, *concurrency
+	report.HotKeys, report.HotRatio = *hotKeys, *hotRatio
+	if *model == modelOpen {
+		report.Rate = *rate
+	}
+	writer := os.Stdout
+	if *out != "" {
+		if writer, err = os.Create(*out); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer writer.Close()
+	}
+	if *output == "json" {
+		err = report.writeJSON(writer)
+	} else {
+		err = report.writeText(writer)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+// recorder 记录每个操作的延迟和错误，可以并发调用
+type recorder struct {
+	mu      sync.Mutex
+	ops     map[string]*opStats
+	dropped int64
+}
+
+type opStats struct {
+	latencies []time.Duration
+	errors    map[string]int64
+	conflicts int64
+}
+
+func newRecorder() *recorder {
+	return &recorder{ops: map[string]*opStats{}}
+}
+
+func (r *recorder) record(op string, latency time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats, ok := r.ops[op]
+	if !ok {
+		stats = &opStats{errors: map[string]int64{}}
+		r.ops[op] = stats
+	}
+	stats.latencies = append(stats.latencies, latency)
+	if err == nil {
+		return
+	}
+	kind := errorKind(err)
+	stats.errors[kind]++
+	if kind == conflictKind {
+		stats.conflicts++
+	}
+}
+
+// drop 开放模型下在途请求达到上限，这次到达没有发出
+func (r *recorder) drop() {
+	r.mu.Lock()
+	r.dropped++
+	r.mu.Unlock()
+}
+
+var conflictKind = kindOf(http.StatusConflict)
+
+// errorKind 按go-micro 错误码分类，不是go-micro 错误时归为 other
+func errorKind(err error) string {
+	parsed := microerrors.Parse(err.Error())
+	if parsed.Code == 0 {
+		return "other"
+	}
+	return kindOf(int(parsed.Code))
+}
+
+func kindOf(code int) string {
+	return fmt.Sprintf("%d %s", code, http.StatusText(code))
+}
+
+// Report 一次压测的结果，延迟单位是毫秒
+type Report struct {
+	Model       string         `json:"model"`
+	Mix         map[string]int `json:"mix"`
+	Concurrency int            `json:"concurrency"`
+	Rate        float64        `json:"rate,omitempty"`
+	HotKeys     int            `json:"hot_keys"`
+	HotRatio    float64        `json:"hot_ratio"`
+	Elapsed     float64        `json:"elapsed_seconds"`
+	Requests    int64          `json:"requests"`
+	Errors      int64          `json:"errors"`
+	Dropped     int64          `json:"dropped"`
+	Throughput  float64        `json:"throughput"`
+	Ops         []OpReport     `json:"ops"`
+}
+
+type OpReport struct {
+	Op           string           `json:"op"`
+	Count        int64            `json:"count"`
+	Throughput   float64          `json:"throughput"`
+	Errors       int64            `json:"errors"`
+	ErrorRate    float64          `json:"error_rate"`
+	Conflicts    int64            `json:"conflicts"`
+	ConflictRate float64          `json:"conflict_rate"`
+	ErrorsByKind map[string]int64 `json:"errors_by_kind,omitempty"`
+	Latency      LatencySummary   `json:"latency_ms"`
+}
+
+type LatencySummary struct {
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+// report 汇总记录，操作按名字排序
+func (r *recorder) report(elapsed time.Duration) *Report {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &Report{Elapsed: elapsed.Seconds(), Dropped: r.dropped}
+	names := make([]string, 0, len(r.ops))
+	for name := range r.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		stats := r.ops[name]
+		op := OpReport{
+			Op:           name,
+			Count:        int64(len(stats.latencies)),
+			Conflicts:    stats.conflicts,
+			ErrorsByKind: stats.errors,
+			Latency:      summarize(stats.latencies),
+		}
+		for _, count := range stats.errors {
+			op.Errors += count
+		}
+		if op.Count > 0 {
+			op.ErrorRate = float64(op.Errors) / float64(op.Count)
+			op.ConflictRate = float64(op.Conflicts) / float64(op.Count)
+		}
+		if elapsed > 0 {
+			op.Throughput = float64(op.Count) / elapsed.Seconds()
+		}
+		report.Requests += op.Count
+		report.Errors += op.Errors
+		report.Ops = append(report.Ops, op)
+	}
+	if elapsed > 0 {
+		report.Throughput = float64(report.Requests) / elapsed.Seconds()
+	}
+	return report
+}
+
+func summarize(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, latency := range sorted {
+		total += latency
+	}
+	return LatencySummary{
+		Mean: millis(total / time.Duration(len(sorted))),
+		P50:  millis(percentile(sorted, 0.5)),
+		P90:  millis(percentile(sorted, 0.9)),
+		P99:  millis(percentile(sorted, 0.99)),
+		P999: millis(percentile(sorted, 0.999)),
+		Max:  millis(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile 最近秩法，sorted 必须已经升序
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(p*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (report *Report) writeJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func (report *Report) writeText(w io.Writer) error {
+	fmt.Fprintf(w, "model %s, concurrency %d", report.Model, report.Concurrency)
+	if report.Model == modelOpen {
+		fmt.Fprintf(w, ", rate %.0f/s", report.Rate)
+	}
+	fmt.Fprintf(w, ", hot keys %d (%.0f%%)\n", report.HotKeys, report.HotRatio*100)
+	fmt.Fprintf(w, "%d requests in %.1fs, %.1f req/s, %d errors, %d dropped\n\n", report.Requests, report.Elapsed, report.Throughput, report.Errors, report.Dropped)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "OP\tCOUNT\tREQ/S\tERRORS\tCONFLICTS\tMEAN\tP50\tP90\tP99\tP99.9\tMAX\t")
+	for _, op := range report.Ops {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f%%\t%.2f%%\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t%.2f\t\n",
+			op.Op, op.Count, op.Throughput, op.ErrorRate*100, op.ConflictRate*100,
+			op.Latency.Mean, op.Latency.P50, op.Latency.P90, op.Latency.P99, op.Latency.P999, op.Latency.Max)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "\nlatency in ms")
+	for _, op := range report.Ops {
+		if len(op.ErrorsByKind) == 0 {
+			continue
+		}
+		kinds := make([]string, 0, len(op.ErrorsByKind))
+		for kind := range op.ErrorsByKind {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		fmt.Fprintf(w, "\n%s errors:\n", op.Op)
+		for _, kind := range kinds {
+			fmt.Fprintf(w, "  %-28s %d\n", kind, op.ErrorsByKind[kind])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lenny-mo/order/proto/order"
+	"github.com/micro/go-micro/v2/client"
+)
+
+// 负载模型
+const (
+	// modelClosed 固定数量的worker，每个请求返回后再发下一个，吞吐量受服务延迟限制
+	modelClosed = "closed"
+	// modelOpen 按平均速率随机到达，不等待之前的请求返回，延迟从计划发出的时间算起
+	modelOpen = "open"
+)
+
+// 操作
+const (
+	opInsert = "insert"
+	opGet    = "get"
+	opUpdate = "update"
+)
+
+// parseMix 解析 insert=1,get=8,update=1 形式的权重
+func parseMix(value string) (map[string]int, error) {
+	mix := map[string]int{}
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("mix entry %q should be op=weight", part)
+		}
+		switch kv[0] {
+		case opInsert, opGet, opUpdate:
+		default:
+			return nil, fmt.Errorf("unknown op %q in mix", kv[0])
+		}
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("weight of %s should be a non-negative integer", kv[0])
+		}
+		mix[kv[0]] = weight
+	}
+	return mix, nil
+}
+
+// workload 按权重选择操作，按热点比例选择订单
+type workload struct {
+	rpc      order.OrderService
+	mix      []weightedOp
+	total    int
+	hotKeys  int
+	hotRatio float64
+	users    int64
+	timeout  time.Duration
+
+	mu   sync.Mutex
+	rand *rand.Rand
+	keys []string
+}
+
+type weightedOp struct {
+	op     string
+	weight int
+}
+
+func newWorkload(rpc order.OrderService, mix map[string]int, seed int64) (*workload, error) {
+	w := &workload{rpc: rpc, rand: rand.New(rand.NewSource(seed))}
+	for op, weight := range mix {
+		if weight > 0 {
+			w.mix = append(w.mix, weightedOp{op, weight})
+			w.total += weight
+		}
+	}
+	if w.total == 0 {
+		return nil, errors.New("mix has no op with a positive weight")
+	}
+	// 按名字排序，同一个种子得到同样的操作序列
+	sort.Slice(w.mix, func(i, j int) bool { return w.mix[i].op < w.mix[j].op })
+	return w, nil
+}
+
+func (w *workload) intn(n int) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rand.Intn(n)
+}
+
+func (w *workload) nextOp() string {
+	n := w.intn(w.total)
+	for _, op := range w.mix {
+		if n < op.weight {
+			return op.op
+		}
+		n -= op.weight
+	}
+	return w.mix[len(w.mix)-1].op
+}
+
+// pickKey 按 hotRatio 的概率选择前 hotKeys 个订单，否则在全部订单中均匀选择
+func (w *workload) pickKey() (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.keys) == 0 {
+		return "", false
+	}
+	n := len(w.keys)
+	if w.hotKeys > 0 && w.rand.Float64() < w.hotRatio {
+		if w.hotKeys < n {
+			n = w.hotKeys
+		}
+	}
+	return w.keys[w.rand.Intn(n)], true
+}
+
+func (w *workload) addKey(orderId string) {
+	w.mu.Lock()
+	w.keys = append(w.keys, orderId)
+	w.mu.Unlock()
+}
+
+var callOptions = []client.CallOption{client.WithRetries(0)}
+
+// do 执行一个操作，没有可用订单时 get 和update 改为 insert
+func (w *workload) do(ctx context.Context, op string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+	orderId, ok := w.pickKey()
+	if !ok {
+		op = opInsert
+	}
+	switch op {
+	case opGet:
+		_, err := w.rpc.GetOrder(ctx, &order.GetRequest{OrderId: orderId}, callOptions...)
+		return op, err
+	case opUpdate:
+		return op, w.update(ctx, orderId)
+	}
+	return opInsert, w.insert(ctx)
+}
+
+// insert 和真实的调用方一样先生成订单号，分片部署时订单号带有用户的槽位
+func (w *workload) insert(ctx context.Context) error {
+	userId := int64(w.intn(int(w.users))) + 1
+	generated, err := w.rpc.GenerateUUID(ctx, &order.GenerateUUIDRequest{UserId: userId}, callOptions...)
+	if err != nil {
+		return err
+	}
+	_, err = w.rpc.InsertOrder(ctx, &order.InserRequest{OrderData: &order.OrderInfo{
+		OrderId:      generated.Uuid,
+		OrderVersion: 1,
+		UserId:       userId,
+		OrderData:    "orderload",
+		Currency:     "CNY",
+		Items:        []*order.OrderItemInfo{{SKUId: int64(w.intn(100)) + 1, Count: 1, Price: 100}},
+	}}, callOptions...)
+	if err == nil {
+		w.addKey(generated.Uuid)
+	}
+	return err
+}
+
+// update 读出订单后按读到的版本号修改，两次读取之间其他worker 修改了同一个订单时返回409
+func (w *workload) update(ctx context.Context, orderId string) error {
+	res, err := w.rpc.GetOrder(ctx, &order.GetRequest{OrderId: orderId}, callOptions...)
+	if err != nil {
+		return err
+	}
+	current := res.OrderData
+	_, err = w.rpc.UpdateOrder(ctx, &order.UpdateRequest{
+		OrderData: &order.OrderInfo{
+			OrderId:      current.OrderId,
+			OrderVersion: current.OrderVersion + 1,
+			UserId:       current.UserId,
+			Status:       current.Status,
+			OrderData:    "orderload " + strconv.FormatInt(current.OrderVersion+1, 10),
+		},
+		Oldversion: current.OrderVersion,
+		Reason:     "orderload",
+	}, callOptions...)
+	return err
+}
+
+// seed 压测前创建 n 个订单作为 get 和update 的目标，前 hotKeys 个是热点
+func (w *workload) seed(ctx context.Context, n, concurrency int) error {
+	var wg sync.WaitGroup
+	var failed int32
+	var firstErr error
+	var once sync.Once
+	jobs := make(chan struct{})
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				insertCtx, cancel := context.WithTimeout(ctx, w.timeout)
+				err := w.insert(insertCtx)
+				cancel()
+				if err != nil {
+					atomic.AddInt32(&failed, 1)
+					once.Do(func() { firstErr = err })
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d seed orders failed: %v", failed, n, firstErr)
+	}
+	return nil
+}
+
+// runClosed concurrency 个worker 循环发请求，直到 ctx 结束或发出 requests 个请求，requests 为0 时不限
+func (w *workload) runClosed(ctx context.Context, concurrency int, requests int64, rec *recorder) {
+	var sent int64
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if requests > 0 && atomic.AddInt64(&sent, 1) > requests {
+					return
+				}
+				start := time.Now()
+				op, err := w.do(ctx, w.nextOp())
+				if ctx.Err() != nil {
+					// 压测结束时被取消的请求不计入结果
+					return
+				}
+				rec.record(op, time.Since(start), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runOpen 按泊松过程以 rate 的平均速率发请求，在途请求达到 maxInFlight 时丢弃这次到达并计数
+// 延迟从计划发出的时间算起，服务变慢时排队的时间也计入延迟，避免协调遗漏
+func (w *workload) runOpen(ctx context.Context, rate float64, maxInFlight int, requests int64, rec *recorder) {
+	inFlight := make(chan struct{}, maxInFlight)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	next := time.Now()
+	for sent := int64(0); requests == 0 || sent < requests; sent++ {
+		next = next.Add(time.Duration(w.exponential() / rate * float64(time.Second)))
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+		select {
+		case inFlight <- struct{}{}:
+		default:
+			rec.drop()
+			continue
+		}
+		wg.Add(1)
+		go func(scheduled time.Time) {
+			defer wg.Done()
+			defer func() { <-inFlight }()
+			op, err := w.do(ctx, w.nextOp())
+			if ctx.Err() != nil {
+				return
+			}
+			rec.record(op, time.Since(scheduled), err)
+		}(next)
+	}
+}
+
+func (w *workload) exponential() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.rand.ExpFloat64()
+}
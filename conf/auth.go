@@ -0,0 +1,31 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// AuthConfig 调用方认证配置，验签密钥来自JWKS 文件或者静态配置，两者可以同时使用
+type AuthConfig struct {
+	Enabled  bool            `json:"enabled" yaml:"enabled"`
+	JWKSFile string          `json:"jwks_file" yaml:"jwks_file"`
+	Keys     []AuthKeyConfig `json:"keys" yaml:"keys"`
+	Issuer   string          `json:"issuer" yaml:"issuer"`     // 为空时不校验签发方
+	Audience string          `json:"audience" yaml:"audience"` // 为空时不校验受众
+	// TrustMetadataRole 没有启用认证时信任metadata 中的 Role，只用于内网测试环境；默认不信任，管理员接口全部拒绝
+	TrustMetadataRole bool `json:"trust_metadata_role" yaml:"trust_metadata_role"`
+	// Permissions 覆盖默认权限表中的RPC，key 是 Order.GetOrder 这样的endpoint，value 是允许的角色
+	Permissions map[string][]string `json:"permissions" yaml:"permissions"`
+}
+
+// AuthKeyConfig 静态密钥，HS 开头的算法使用 Secret，其他算法使用PEM 格式的 PublicKey
+type AuthKeyConfig struct {
+	Kid       string `json:"kid" yaml:"kid"`
+	Alg       string `json:"alg" yaml:"alg"`
+	Secret    string `json:"secret" yaml:"secret"`
+	PublicKey string `json:"public_key" yaml:"public_key"`
+}
+
+// GetAuthFromConsul 从 Consul 配置中心获取认证配置，未配置时不校验调用方
+func GetAuthFromConsul(config config.Config, path ...string) *AuthConfig {
+	authConfig := &AuthConfig{}
+	config.Get(path...).Scan(authConfig)
+	return authConfig
+}
@@ -0,0 +1,22 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// CacheConfig 订单缓存配置，RedisAddr 为空时只使用进程内LRU
+type CacheConfig struct {
+	Capacity      int    `json:"capacity" yaml:"capacity"`
+	TTLSeconds    int64  `json:"ttl_seconds" yaml:"ttl_seconds"`
+	RedisAddr     string `json:"redis_addr" yaml:"redis_addr"`
+	RedisPassword string `json:"redis_password" yaml:"redis_password"`
+	RedisDB       int    `json:"redis_db" yaml:"redis_db"`
+}
+
+// GetCacheFromConsul 从 Consul 配置中心获取缓存配置，未配置时使用默认值
+func GetCacheFromConsul(config config.Config, path ...string) *CacheConfig {
+	cacheConfig := &CacheConfig{
+		Capacity:   10000,
+		TTLSeconds: 300,
+	}
+	config.Get(path...).Scan(cacheConfig)
+	return cacheConfig
+}
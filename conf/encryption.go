@@ -0,0 +1,19 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// EncryptionConfig 敏感字段加密配置，KeyFile 是本地KMS 的密钥文件
+type EncryptionConfig struct {
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	KeyFile string `json:"key_file" yaml:"key_file"`
+	// Reencrypt 启动时把不是当前主密钥加密的数据重新加密，轮换主密钥或第一次启用加密后打开
+	Reencrypt      bool `json:"reencrypt" yaml:"reencrypt"`
+	ReencryptBatch int  `json:"reencrypt_batch" yaml:"reencrypt_batch"`
+}
+
+// GetEncryptionFromConsul 从 Consul 配置中心获取加密配置，未配置时不加密
+func GetEncryptionFromConsul(config config.Config, path ...string) *EncryptionConfig {
+	encryptionConfig := &EncryptionConfig{}
+	config.Get(path...).Scan(encryptionConfig)
+	return encryptionConfig
+}
@@ -0,0 +1,19 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// InventoryConfig 库存服务配置
+type InventoryConfig struct {
+	// Enabled 是否在创建订单时预占库存，没有部署库存服务的环境需要关闭
+	Enabled bool   `json:"enabled" yaml:"enabled"`
+	Service string `json:"service" yaml:"service"` // 为空时使用默认的服务名
+	// AutoCancelSeconds 启用库存服务时未支付订单超过这个时间自动取消并释放库存，默认为0 不自动取消
+	AutoCancelSeconds int64 `json:"auto_cancel_seconds" yaml:"auto_cancel_seconds"`
+}
+
+// GetInventoryFromConsul 从 Consul 配置中心获取库存服务配置，未配置时不预占库存
+func GetInventoryFromConsul(config config.Config, path ...string) *InventoryConfig {
+	inventoryConfig := &InventoryConfig{}
+	config.Get(path...).Scan(inventoryConfig)
+	return inventoryConfig
+}
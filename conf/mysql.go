@@ -1,6 +1,10 @@
 package conf
 
-import "github.com/micro/go-micro/v2/config"
+import (
+	"strconv"
+
+	"github.com/micro/go-micro/v2/config"
+)
 
 type MysqlConfig struct {
 	Host     string `json:"host" yaml:"host"`
@@ -26,3 +30,8 @@ func GetMysqlFromConsul(config config.Config, path ...string) *MysqlConfig {
 	// 返回填充好的 mysqlConfig 对象。
 	return mysqlConfig
 }
+
+// DSN 拼接gorm mysql 驱动使用的连接串
+func (m *MysqlConfig) DSN() string {
+	return m.User + ":" + m.Password + "@tcp(" + m.Host + ":" + strconv.FormatInt(m.Port, 10) + ")/" + m.DB + "?charset=utf8mb4&parseTime=True&loc=Local"
+}
@@ -0,0 +1,16 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// PaymentConfig 支付回调配置，Secret 是和支付服务约定的HMAC 密钥
+type PaymentConfig struct {
+	Secret      string `json:"secret" yaml:"secret"`
+	WebhookAddr string `json:"webhook_addr" yaml:"webhook_addr"` // 支付回调的HTTP 监听地址，为空时不启动
+}
+
+// GetPaymentFromConsul 从 Consul 配置中心获取支付回调配置
+func GetPaymentFromConsul(config config.Config, path ...string) *PaymentConfig {
+	paymentConfig := &PaymentConfig{}
+	config.Get(path...).Scan(paymentConfig)
+	return paymentConfig
+}
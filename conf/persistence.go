@@ -0,0 +1,24 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// 订单的持久化模式
+const (
+	PersistenceState = "state" // 直接读写 orders 表，默认模式
+	PersistenceEvent = "event" // 事件溯源，orders 表作为查询投影
+)
+
+// PersistenceConfig 持久化模式配置
+type PersistenceConfig struct {
+	Mode          string `json:"mode" yaml:"mode"`
+	SnapshotEvery int    `json:"snapshot_every" yaml:"snapshot_every"` // 事件溯源模式下每多少个事件保存一次快照
+}
+
+// GetPersistenceFromConsul 从 Consul 配置中心获取持久化模式，未配置时使用状态模式
+func GetPersistenceFromConsul(config config.Config, path ...string) *PersistenceConfig {
+	persistenceConfig := &PersistenceConfig{
+		Mode: PersistenceState,
+	}
+	config.Get(path...).Scan(persistenceConfig)
+	return persistenceConfig
+}
@@ -0,0 +1,38 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// PromotionRuleConfig 一个促销活动，Kind 决定使用哪些参数
+//
+//	percentage: 指定sku（为空表示全部）打 Percent 折扣，Percent 为 10 表示减 10%
+//	fixed:      指定sku 合计减 Amount
+//	threshold:  指定sku 合计满 Threshold 减 Amount
+//	bundle:     每买 BuyCount 件 BuySKU 送 GetCount 件 GetSKU
+type PromotionRuleConfig struct {
+	Id        string  `json:"id" yaml:"id"`
+	Name      string  `json:"name" yaml:"name"`
+	Kind      string  `json:"kind" yaml:"kind"`
+	SKUs      []int64 `json:"skus" yaml:"skus"`
+	Percent   int64   `json:"percent" yaml:"percent"`
+	Amount    int64   `json:"amount" yaml:"amount"` // 单位为分
+	Threshold int64   `json:"threshold" yaml:"threshold"`
+	BuySKU    int64   `json:"buy_sku" yaml:"buy_sku"`
+	BuyCount  int32   `json:"buy_count" yaml:"buy_count"`
+	GetSKU    int64   `json:"get_sku" yaml:"get_sku"`
+	GetCount  int32   `json:"get_count" yaml:"get_count"`
+	// 生效时间，unix 秒，0 表示不限制
+	StartsAt int64 `json:"starts_at" yaml:"starts_at"`
+	EndsAt   int64 `json:"ends_at" yaml:"ends_at"`
+}
+
+// PromotionConfig 促销活动配置，按顺序叠加，后面的活动按前面优惠后的金额计算
+type PromotionConfig struct {
+	Rules []PromotionRuleConfig `json:"rules" yaml:"rules"`
+}
+
+// GetPromotionFromConsul 从 Consul 配置中心获取促销活动配置
+func GetPromotionFromConsul(config config.Config, path ...string) *PromotionConfig {
+	promotionConfig := &PromotionConfig{}
+	config.Get(path...).Scan(promotionConfig)
+	return promotionConfig
+}
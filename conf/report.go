@@ -0,0 +1,26 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// ReportConfig 销售报表配置
+type ReportConfig struct {
+	// Replica 报表读取的从库，Host 为空时读主库
+	Replica MysqlConfig `json:"replica" yaml:"replica"`
+	// RefreshSeconds 后台多久重新计算一次最近的汇总，为0 时不计算
+	RefreshSeconds int64 `json:"refresh_seconds" yaml:"refresh_seconds"`
+	// RefreshDays 每次重新计算今天和之前共多少天，覆盖延迟的支付和退款
+	RefreshDays int `json:"refresh_days" yaml:"refresh_days"`
+	// BackfillDays 服务启动后第一次计算多少天
+	BackfillDays int `json:"backfill_days" yaml:"backfill_days"`
+}
+
+// GetReportFromConsul 从 Consul 配置中心获取报表配置，未配置时使用默认值
+func GetReportFromConsul(config config.Config, path ...string) *ReportConfig {
+	reportConfig := &ReportConfig{
+		RefreshSeconds: 300,
+		RefreshDays:    3,
+		BackfillDays:   31,
+	}
+	config.Get(path...).Scan(reportConfig)
+	return reportConfig
+}
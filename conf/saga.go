@@ -0,0 +1,23 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// SagaConfig 下单流程配置
+type SagaConfig struct {
+	PaymentService string `json:"payment_service" yaml:"payment_service"` // 为空时使用默认的服务名，库存服务见 InventoryConfig
+	MaxAttempts    int    `json:"max_attempts" yaml:"max_attempts"`       // 单个步骤最多执行的次数
+	BackoffMs      int64  `json:"backoff_ms" yaml:"backoff_ms"`           // 第一次重试的间隔，之后每次翻倍
+	// RecoverSeconds 多久没有进展的流程会被认为执行它的进程已经退出，由后台任务继续执行
+	RecoverSeconds int64 `json:"recover_seconds" yaml:"recover_seconds"`
+}
+
+// GetSagaFromConsul 从 Consul 配置中心获取下单流程配置，未配置时使用默认值
+func GetSagaFromConsul(config config.Config, path ...string) *SagaConfig {
+	sagaConfig := &SagaConfig{
+		MaxAttempts:    3,
+		BackoffMs:      200,
+		RecoverSeconds: 60,
+	}
+	config.Get(path...).Scan(sagaConfig)
+	return sagaConfig
+}
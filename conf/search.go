@@ -0,0 +1,21 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// SearchConfig SearchOrders 的索引配置
+type SearchConfig struct {
+	// Backend 为 sql 时直接在订单表上搜索，为 bleve 时使用本地的全文索引，由订单变更事件同步
+	Backend string `json:"backend" yaml:"backend"`
+	// IndexPath bleve 索引的目录，不存在时创建并从数据库重建
+	IndexPath string `json:"index_path" yaml:"index_path"`
+}
+
+// GetSearchFromConsul 从 Consul 配置中心获取搜索配置，未配置时使用数据库搜索
+func GetSearchFromConsul(config config.Config, path ...string) *SearchConfig {
+	searchConfig := &SearchConfig{
+		Backend:   "sql",
+		IndexPath: "order.bleve",
+	}
+	config.Get(path...).Scan(searchConfig)
+	return searchConfig
+}
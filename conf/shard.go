@@ -0,0 +1,16 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// ShardConfig 订单分片配置，Databases 为空时使用单表模式
+type ShardConfig struct {
+	Databases   []MysqlConfig `json:"databases" yaml:"databases"`
+	TablesPerDB int           `json:"tables_per_db" yaml:"tables_per_db"`
+}
+
+// GetShardFromConsul 从 Consul 配置中心获取分片配置
+func GetShardFromConsul(config config.Config, path ...string) *ShardConfig {
+	shardConfig := &ShardConfig{}
+	config.Get(path...).Scan(shardConfig)
+	return shardConfig
+}
@@ -0,0 +1,28 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// TenantSettings 一个租户的配置，零值字段使用全局配置
+type TenantSettings struct {
+	// Currency 订单币种，创建订单时没有传币种使用这个币种，传了其他币种的订单会被拒绝
+	Currency string `json:"currency" yaml:"currency"`
+	// AutoCancelSeconds 未支付订单超过这个时间自动取消，0 表示使用库存服务配置中的时间
+	AutoCancelSeconds int64 `json:"auto_cancel_seconds" yaml:"auto_cancel_seconds"`
+	// OrderIdPrefix 订单号前缀，不能是4位数字，否则会和分片槽位混淆
+	OrderIdPrefix string `json:"order_id_prefix" yaml:"order_id_prefix"`
+}
+
+// TenantConfig 多租户配置，调用方在metadata 中传入租户
+type TenantConfig struct {
+	// Default 没有传租户时使用的租户
+	Default string `json:"default" yaml:"default"`
+	// Tenants 配置了租户时只接受列出的租户，为空时接受任意租户并使用全局配置
+	Tenants map[string]TenantSettings `json:"tenants" yaml:"tenants"`
+}
+
+// GetTenantFromConsul 从 Consul 配置中心获取多租户配置
+func GetTenantFromConsul(config config.Config, path ...string) *TenantConfig {
+	tenantConfig := &TenantConfig{}
+	config.Get(path...).Scan(tenantConfig)
+	return tenantConfig
+}
@@ -0,0 +1,21 @@
+package conf
+
+import "github.com/micro/go-micro/v2/config"
+
+// WatchConfig WatchOrders 推送配置
+type WatchConfig struct {
+	// PollSeconds 没有收到变更通知时多久重新检查一次，兜底丢失的通知和不经过订单服务的写入
+	PollSeconds int64 `json:"poll_seconds" yaml:"poll_seconds"`
+	// MaxPending 一个监听者最多积压的待推送订单数，超过后丢弃积压并重新检查全部订单
+	MaxPending int `json:"max_pending" yaml:"max_pending"`
+}
+
+// GetWatchFromConsul 从 Consul 配置中心获取推送配置，未配置时使用默认值
+func GetWatchFromConsul(config config.Config, path ...string) *WatchConfig {
+	watchConfig := &WatchConfig{
+		PollSeconds: 10,
+		MaxPending:  1000,
+	}
+	config.Get(path...).Scan(watchConfig)
+	return watchConfig
+}
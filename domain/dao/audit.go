@@ -0,0 +1,25 @@
+package dao
+
+import "context"
+
+type auditKey struct{}
+
+// AuditInfo 写入版本记录时使用的操作人和原因，由handler 从请求中解析后放入context
+type AuditInfo struct {
+	Actor  string
+	Reason string
+}
+
+// WithAudit 把操作人和原因放入context
+func WithAudit(ctx context.Context, actor, reason string) context.Context {
+	return context.WithValue(ctx, auditKey{}, AuditInfo{Actor: actor, Reason: reason})
+}
+
+// AuditFrom 取出context 中的操作人和原因，没有时操作人记为system
+func AuditFrom(ctx context.Context) AuditInfo {
+	audit, _ := ctx.Value(auditKey{}).(AuditInfo)
+	if audit.Actor == "" {
+		audit.Actor = "system"
+	}
+	return audit
+}
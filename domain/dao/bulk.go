@@ -0,0 +1,230 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// BulkOrderDAO 批量导出和导入订单，用于迁移旧系统的订单和同步数据仓库
+type BulkOrderDAO interface {
+	// ExportOrders 按主键顺序分批读出符合条件的订单和订单项，每批调用一次 fn，分页参数不生效
+	ExportOrders(ctx context.Context, query *ListOrderQuery, batch int, fn func(orders []*models.Order) error) error
+	// ImportOrders 在一个事务里批量写入订单、订单项和第一条版本记录，订单号已经存在的订单跳过，
+	// 所以同一批订单重复导入不会重复写入
+	ImportOrders(ctx context.Context, orders []*models.Order) (*ImportResult, error)
+}
+
+// ImportResult 一批订单的导入结果
+type ImportResult struct {
+	Imported int
+	// Existing 已经存在而跳过的订单号
+	Existing []string
+	// Rejected 不能写入的订单号和原因，比如分片部署时订单号的槽位和用户不一致
+	Rejected map[string]string
+}
+
+// DefaultBulkBatch 每批导出或导入的订单数
+const DefaultBulkBatch = 500
+
+func (o *OrderDAO) ExportOrders(ctx context.Context, query *ListOrderQuery, batch int, fn func(orders []*models.Order) error) error {
+	return o.exportOrders(ctx, query, batch, func(orders []*models.Order) error {
+		ids := make([]string, len(orders))
+		for i, order := range orders {
+			ids[i] = order.OrderId
+		}
+		items := []models.OrderItem{}
+		if err := o.db.WithContext(ctx).Table(o.tables.Items).Where("order_id IN ?", ids).Order("id").Find(&items).Error; err != nil {
+			return err
+		}
+		byOrder := map[string][]models.OrderItem{}
+		for _, item := range items {
+			byOrder[item.OrderId] = append(byOrder[item.OrderId], item)
+		}
+		for _, order := range orders {
+			order.Items = byOrder[order.OrderId]
+		}
+		return fn(orders)
+	})
+}
+
+// exportOrders 只读 orders 表，事件溯源的投影没有订单项表
+func (o *OrderDAO) exportOrders(ctx context.Context, query *ListOrderQuery, batch int, fn func(orders []*models.Order) error) error {
+	if batch <= 0 {
+		batch = DefaultBulkBatch
+	}
+	orders := []*models.Order{}
+	return query.apply(o.db.WithContext(ctx).Table(o.tables.Orders).Scopes(readScope(ctx))).
+		FindInBatches(&orders, batch, func(tx *gorm.DB, _ int) error {
+			return fn(orders)
+		}).Error
+}
+
+// existingOrders 已经存在的订单号，包括已删除的订单和其他租户的订单
+func existingOrders(tx *gorm.DB, table string, orders []*models.Order) (map[string]bool, error) {
+	ids := make([]string, len(orders))
+	for i, order := range orders {
+		ids[i] = order.OrderId
+	}
+	found := []string{}
+	if err := tx.Table(table).Where("order_id IN ?", ids).Distinct().Pluck("order_id", &found).Error; err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, id := range found {
+		existing[id] = true
+	}
+	return existing, nil
+}
+
+// splitExisting 把已经存在的订单从这一批中去掉
+func splitExisting(tx *gorm.DB, table string, orders []*models.Order, result *ImportResult) ([]*models.Order, error) {
+	existing, err := existingOrders(tx, table, orders)
+	if err != nil {
+		return nil, err
+	}
+	fresh := []*models.Order{}
+	for _, order := range orders {
+		if existing[order.OrderId] {
+			result.Existing = append(result.Existing, order.OrderId)
+			continue
+		}
+		fresh = append(fresh, order)
+	}
+	return fresh, nil
+}
+
+func (o *OrderDAO) ImportOrders(ctx context.Context, orders []*models.Order) (*ImportResult, error) {
+	audit := AuditFrom(ctx)
+	result := &ImportResult{Rejected: map[string]string{}}
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		fresh, err := splitExisting(tx, o.tables.Orders, orders, result)
+		if err != nil || len(fresh) == 0 {
+			return err
+		}
+		items := []models.OrderItem{}
+		history := []*models.OrderHistory{}
+		for _, order := range fresh {
+			stampTenant(ctx, order)
+			for _, item := range order.Items {
+				item.OrderId, item.UserId, item.TenantId = order.OrderId, order.UserId, order.TenantId
+				if item.Timestamp.IsZero() {
+					item.Timestamp = order.CreatedAt
+				}
+				items = append(items, item)
+			}
+			entry := models.NewOrderHistory(nil, order, audit.Actor, audit.Reason)
+			entry.CreatedAt = order.CreatedAt
+			history = append(history, entry)
+		}
+		if err := tx.Table(o.tables.Orders).CreateInBatches(fresh, len(fresh)).Error; err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			if err := tx.Table(o.tables.Items).Omit("Order").CreateInBatches(items, len(items)).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Table(o.tables.History).CreateInBatches(history, len(history)).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	result.Imported = len(orders) - len(result.Existing)
+	return result, nil
+}
+
+// ExportOrders 指定用户时只读一个分片，否则逐个分片导出
+func (o *ShardedOrderDAO) ExportOrders(ctx context.Context, query *ListOrderQuery, batch int, fn func(orders []*models.Order) error) error {
+	shards := o.layout.Shards()
+	if query.UserId != 0 {
+		shards = []*Shard{o.layout.Locate(SlotOfUser(query.UserId))}
+	}
+	for _, shard := range shards {
+		if err := shard.dao.ExportOrders(ctx, query, batch, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportOrders 按用户分组写入各自的分片，每个分片一个事务
+func (o *ShardedOrderDAO) ImportOrders(ctx context.Context, orders []*models.Order) (*ImportResult, error) {
+	result := &ImportResult{Rejected: map[string]string{}}
+	groups := map[*Shard][]*models.Order{}
+	shards := []*Shard{}
+	for _, order := range orders {
+		slot := SlotOfUser(order.UserId)
+		if orderSlot, ok := SlotOfOrderId(order.OrderId); ok && orderSlot != slot {
+			result.Rejected[order.OrderId] = fmt.Sprintf("order id belongs to slot %d, but user %d is in slot %d", orderSlot, order.UserId, slot)
+			continue
+		}
+		shard := o.layout.Locate(slot)
+		if _, ok := groups[shard]; !ok {
+			shards = append(shards, shard)
+		}
+		groups[shard] = append(groups[shard], order)
+	}
+	for _, shard := range shards {
+		shardResult, err := shard.dao.ImportOrders(ctx, groups[shard])
+		if err != nil {
+			return result, err
+		}
+		result.Imported += shardResult.Imported
+		result.Existing = append(result.Existing, shardResult.Existing...)
+	}
+	return result, nil
+}
+
+// ExportOrders 订单来自 orders 表中的投影，订单项只在事件中，从事件折叠出来
+func (e *EventSourcedOrderDAO) ExportOrders(ctx context.Context, query *ListOrderQuery, batch int, fn func(orders []*models.Order) error) error {
+	return e.projection.exportOrders(ctx, query, batch, func(orders []*models.Order) error {
+		for _, order := range orders {
+			aggregate, err := e.Load(ctx, order.OrderId)
+			if err != nil {
+				return err
+			}
+			order.Items = aggregate.Items
+		}
+		return fn(orders)
+	})
+}
+
+// ImportOrders 和 CreateOrder 一样为每个订单追加 Created 和 ItemAdded 事件，事件时间使用订单的创建时间
+func (e *EventSourcedOrderDAO) ImportOrders(ctx context.Context, orders []*models.Order) (*ImportResult, error) {
+	audit := AuditFrom(ctx)
+	result := &ImportResult{Rejected: map[string]string{}}
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		fresh, err := splitExisting(tx, models.OrderEvent{}.TableName(), orders, result)
+		if err != nil || len(fresh) == 0 {
+			return err
+		}
+		events := []*models.OrderEvent{}
+		for _, order := range fresh {
+			stampTenant(ctx, order)
+			orderEvents, err := createdEvents(order)
+			if err != nil {
+				return err
+			}
+			for i, event := range orderEvents {
+				event.Sequence = int64(i + 1)
+				event.Actor, event.Reason = audit.Actor, audit.Reason
+				event.CreatedAt = order.CreatedAt
+			}
+			events = append(events, orderEvents...)
+		}
+		if err := tx.CreateInBatches(events, len(events)).Error; err != nil {
+			return err
+		}
+		return tx.Table(e.projection.tables.Orders).CreateInBatches(fresh, len(fresh)).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	result.Imported = len(orders) - len(result.Existing)
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/lenny-mo/order/domain/encryption"
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// Reencryptor 轮换主密钥后把已有的数据用新的主密钥重新加密，启用加密之前写入的明文也会被加密
+// 返回重新写入的行数，可以重复执行，已经是当前主密钥加密的行会跳过
+type Reencryptor interface {
+	Reencrypt(ctx context.Context, batch int) (int64, error)
+}
+
+// 默认每批处理的行数
+const DefaultReencryptBatch = 500
+
+// 加密字段对应的盲索引字段和计算方法
+var blindIndexes = map[string]struct {
+	column string
+	index  func(string) string
+}{
+	"contact_email": {"contact_email_bidx", models.ContactEmailIndex},
+	"contact_phone": {"contact_phone_bidx", models.ContactPhoneIndex},
+}
+
+// encryptedColumns 模型中使用 serializer:encrypt 的字段，包括按前缀展开的地址和联系方式
+func encryptedColumns(db *gorm.DB, model interface{}) ([]string, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	columns := []string{}
+	for _, field := range stmt.Schema.Fields {
+		if field.TagSettings["SERIALIZER"] == "encrypt" && field.DBName != "" {
+			columns = append(columns, field.DBName)
+		}
+	}
+	return columns, nil
+}
+
+// reencryptTable 按主键分批读取原始的密文，不是当前主密钥加密的字段解密后重新加密，同时补齐盲索引
+// 更新时带上旧的密文作为条件，期间被修改过的行会跳过，下一次执行时再处理
+func reencryptTable(ctx context.Context, db *gorm.DB, table, key string, model interface{}, batch int) (int64, error) {
+	keyring := encryption.Default()
+	if keyring == nil {
+		return 0, fmt.Errorf("reencrypt %s: encryption is not configured", table)
+	}
+	if batch <= 0 {
+		batch = DefaultReencryptBatch
+	}
+	columns, err := encryptedColumns(db, model)
+	if err != nil {
+		return 0, err
+	}
+	selects := append([]string{key}, columns...)
+	for _, column := range columns {
+		if index, ok := blindIndexes[column]; ok {
+			selects = append(selects, index.column)
+		}
+	}
+
+	var rewritten int64
+	var after interface{} = ""
+	if key == "id" {
+		after = 0
+	}
+	for {
+		rows := []map[string]interface{}{}
+		if err := db.WithContext(ctx).Table(table).Select(selects).Where(key+" > ?", after).
+			Order(key).Limit(batch).Find(&rows).Error; err != nil {
+			return rewritten, err
+		}
+		if len(rows) == 0 {
+			return rewritten, nil
+		}
+		for _, row := range rows {
+			updates := map[string]interface{}{}
+			query := db.WithContext(ctx).Table(table).Where(key+" = ?", row[key])
+			for _, column := range columns {
+				raw := rawString(row[column])
+				if raw == "" {
+					continue
+				}
+				plain, err := keyring.Decrypt(ctx, raw)
+				if err != nil {
+					return rewritten, fmt.Errorf("reencrypt %s %v %s: %v", table, row[key], column, err)
+				}
+				if keyring.Stale(raw) {
+					if updates[column], err = keyring.Encrypt(ctx, plain); err != nil {
+						return rewritten, err
+					}
+					query = query.Where(column+" = ?", raw)
+				}
+				if index, ok := blindIndexes[column]; ok {
+					if value := index.index(plain); value != rawString(row[index.column]) {
+						updates[index.column] = value
+					}
+				}
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			result := query.Updates(updates)
+			if result.Error != nil {
+				return rewritten, result.Error
+			}
+			rewritten += result.RowsAffected
+		}
+		after = rows[len(rows)-1][key]
+	}
+}
+
+func rawString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	default:
+		return ""
+	}
+}
+
+// Reencrypt 重新加密订单和订单版本记录
+func (o *OrderDAO) Reencrypt(ctx context.Context, batch int) (int64, error) {
+	orders, err := reencryptTable(ctx, o.db, o.tables.Orders, "id", &models.Order{}, batch)
+	if err != nil {
+		return orders, err
+	}
+	history, err := reencryptTable(ctx, o.db, o.tables.History, "id", &models.OrderHistory{}, batch)
+	return orders + history, err
+}
+
+// Reencrypt 逐个分片重新加密
+func (o *ShardedOrderDAO) Reencrypt(ctx context.Context, batch int) (int64, error) {
+	var total int64
+	for _, shard := range o.layout.Shards() {
+		rewritten, err := shard.dao.Reencrypt(ctx, batch)
+		total += rewritten
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Reencrypt 重新加密投影、事件和快照，版本记录由事件生成没有单独的表，事件只替换密文，内容不变
+func (e *EventSourcedOrderDAO) Reencrypt(ctx context.Context, batch int) (int64, error) {
+	total, err := reencryptTable(ctx, e.db, e.projection.tables.Orders, "id", &models.Order{}, batch)
+	if err != nil {
+		return total, err
+	}
+	events, err := reencryptTable(ctx, e.db, models.OrderEvent{}.TableName(), "id", &models.OrderEvent{}, batch)
+	total += events
+	if err != nil {
+		return total, err
+	}
+	snapshots, err := reencryptTable(ctx, e.db, models.OrderSnapshot{}.TableName(), "order_id", &models.OrderSnapshot{}, batch)
+	return total + snapshots, err
+}
+
+// Reencrypt 重新加密下单流程中保存的订单
+func (s *SagaDAO) Reencrypt(ctx context.Context, batch int) (int64, error) {
+	return reencryptTable(ctx, s.db, models.OrderSaga{}.TableName(), "id", &models.OrderSaga{}, batch)
+}
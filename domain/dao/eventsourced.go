@@ -0,0 +1,743 @@
+package dao
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// EventSourcedOrderDAO 事件溯源模式的订单DAO
+// 订单以事件流的形式保存在 order_events 中，状态由事件折叠得到；
+// 每次追加事件时在同一个事务里更新 orders 表作为查询用的投影，所以读接口和状态模式完全一致
+type EventSourcedOrderDAO struct {
+	db            *gorm.DB
+	snapshotEvery int64
+	projection    *OrderDAO
+}
+
+// 默认每50个事件保存一次快照
+const DefaultSnapshotEvery = 50
+
+func NewEventSourcedOrderDAO(db *gorm.DB, snapshotEvery int) OrderDAOInterface {
+	if snapshotEvery <= 0 {
+		snapshotEvery = DefaultSnapshotEvery
+	}
+	return &EventSourcedOrderDAO{
+		db:            db,
+		snapshotEvery: int64(snapshotEvery),
+		projection: &OrderDAO{
+			db:     db,
+			tables: defaultTables,
+		},
+	}
+}
+
+// Migrate 如果没有表则创建，已有的表会补上新增的字段
+func (e *EventSourcedOrderDAO) Migrate() error {
+	return e.db.AutoMigrate(&models.Order{}, &models.OrderEvent{}, &models.OrderSnapshot{}, &models.OrderRefund{}, &models.OrderPayment{}, &models.OrderFulfillment{})
+}
+
+// Load 从最近的快照开始回放事件，得到订单当前的聚合状态
+func (e *EventSourcedOrderDAO) Load(ctx context.Context, orderId string) (*models.OrderAggregate, error) {
+	return e.load(e.db.WithContext(ctx), orderId)
+}
+
+func (e *EventSourcedOrderDAO) load(tx *gorm.DB, orderId string) (*models.OrderAggregate, error) {
+	aggregate := &models.OrderAggregate{}
+	snapshots := []*models.OrderSnapshot{}
+	if err := tx.Where("order_id = ?", orderId).Limit(1).Find(&snapshots).Error; err != nil {
+		return nil, err
+	}
+	if len(snapshots) > 0 {
+		if err := json.Unmarshal([]byte(snapshots[0].State), aggregate); err != nil {
+			return nil, err
+		}
+	}
+
+	events := []*models.OrderEvent{}
+	if err := tx.Where("order_id = ? AND sequence > ?", orderId, aggregate.Sequence).Order("sequence").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if err := aggregate.Apply(event); err != nil {
+			return nil, err
+		}
+	}
+	if aggregate.Sequence == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return aggregate, nil
+}
+
+// append 依次追加事件并折叠到聚合上，序号冲突说明有并发写入，按乐观锁冲突处理
+func (e *EventSourcedOrderDAO) append(tx *gorm.DB, aggregate *models.OrderAggregate, events ...*models.OrderEvent) error {
+	audit := AuditFrom(tx.Statement.Context)
+	before := aggregate.Sequence
+	for _, event := range events {
+		event.Sequence = aggregate.Sequence + 1
+		event.Actor, event.Reason = audit.Actor, audit.Reason
+		if err := aggregate.Apply(event); err != nil {
+			return err
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(event)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+	}
+	// 跨过快照间隔时保存一次快照
+	if aggregate.Sequence/e.snapshotEvery == before/e.snapshotEvery {
+		return nil
+	}
+	state, err := json.Marshal(aggregate)
+	if err != nil {
+		return err
+	}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&models.OrderSnapshot{
+		OrderId:   aggregate.Order.OrderId,
+		TenantId:  aggregate.Order.TenantId,
+		Sequence:  aggregate.Sequence,
+		State:     string(state),
+		CreatedAt: aggregate.Order.UpdatedAt,
+	}).Error
+}
+
+// project 把聚合状态的全部字段写入 orders 表，oldversion 用于防止覆盖并发写入的投影
+func (e *EventSourcedOrderDAO) project(tx *gorm.DB, aggregate *models.OrderAggregate, oldversion int64) error {
+	result := tx.Table(e.projection.tables.Orders).Unscoped().
+		Where("order_id = ? AND order_version = ?", aggregate.Order.OrderId, oldversion).
+		Select("*").Omit("id", "created_at").
+		Updates(&aggregate.Order)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// eventsForUpdate 把一次 UpdateOrder 转换成领域事件
+// 和状态模式的 Updates 一样，传入订单中的零值字段表示不修改
+func eventsForUpdate(current *models.Order, update *models.Order) ([]*models.OrderEvent, error) {
+	next := *current
+	if update.UserId != 0 {
+		next.UserId = update.UserId
+	}
+	if update.OrderData != "" {
+		next.OrderData = update.OrderData
+	}
+	if update.Status != 0 {
+		next.Status = update.Status
+	}
+	if update.OrderVersion != 0 {
+		next.OrderVersion = update.OrderVersion
+	}
+	if !update.ShippingAddress.IsZero() {
+		next.ShippingAddress = update.ShippingAddress
+	}
+	if !update.BillingAddress.IsZero() {
+		next.BillingAddress = update.BillingAddress
+	}
+	if !update.Contact.IsZero() {
+		next.Contact = update.Contact
+	}
+
+	statusEvents := map[int8]string{
+		models.StatusPaid:      models.EventPaid,
+		models.StatusCancelled: models.EventCancelled,
+		models.StatusRefunded:  models.EventRefunded,
+	}
+	events := []*models.OrderEvent{}
+	statusEvent, isStatusEvent := statusEvents[next.Status]
+	dataChanged := next.UserId != current.UserId || next.OrderData != current.OrderData ||
+		next.ShippingAddress != current.ShippingAddress || next.BillingAddress != current.BillingAddress || next.Contact != current.Contact
+	if dataChanged || (next.Status != current.Status && !isStatusEvent) {
+		// 有对应状态事件的状态由后面的状态事件修改，其余状态（发货、签收、部分退款）直接写在 Updated 中
+		status := next.Status
+		if isStatusEvent {
+			status = current.Status
+		}
+		event, err := models.NewOrderEvent(&next, models.EventUpdated, &models.OrderDataPayload{
+			UserId:          next.UserId,
+			OrderData:       next.OrderData,
+			Status:          status,
+			ShippingAddress: next.ShippingAddress,
+			BillingAddress:  next.BillingAddress,
+			Contact:         next.Contact,
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if next.Status != current.Status && isStatusEvent {
+		event, err := models.NewOrderEvent(&next, statusEvent, nil)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		// 只修改了版本号
+		event, err := models.NewOrderEvent(&next, models.EventUpdated, &models.OrderDataPayload{
+			UserId:    next.UserId,
+			OrderData: next.OrderData,
+			Status:    next.Status,
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// createdEvents 新订单的 Created 事件，订单项各一个 ItemAdded 事件
+func createdEvents(order *models.Order) ([]*models.OrderEvent, error) {
+	event, err := models.NewOrderEvent(order, models.EventCreated, &models.OrderDataPayload{
+		UserId:          order.UserId,
+		OrderData:       order.OrderData,
+		Status:          order.Status,
+		TotalAmount:     order.TotalAmount,
+		DiscountAmount:  order.DiscountAmount,
+		Currency:        order.Currency,
+		ReservationId:   order.ReservationId,
+		PaidAmount:      order.PaidAmount,
+		ParentOrderId:   order.ParentOrderId,
+		ShippingAddress: order.ShippingAddress,
+		BillingAddress:  order.BillingAddress,
+		Contact:         order.Contact,
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := []*models.OrderEvent{event}
+	for _, item := range order.Items {
+		itemEvent, err := models.NewOrderEvent(order, models.EventItemAdded, &models.ItemPayload{
+			SKUId:     item.SKUId,
+			Count:     item.Count,
+			Price:     item.Price,
+			Discount:  item.Discount,
+			Discounts: item.Discounts,
+		})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, itemEvent)
+	}
+	return events, nil
+}
+
+// CreateOrder 追加 Created 事件，订单项各追加一个 ItemAdded 事件
+func (e *EventSourcedOrderDAO) CreateOrder(ctx context.Context, order *models.Order) (int64, error) {
+	stampTenant(ctx, order)
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return e.insert(tx, order)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	return 1, nil
+}
+
+// insert 在调用方的事务里追加新订单的事件并写入投影
+func (e *EventSourcedOrderDAO) insert(tx *gorm.DB, order *models.Order) error {
+	events, err := createdEvents(order)
+	if err != nil {
+		return err
+	}
+	if err := e.append(tx, &models.OrderAggregate{}, events...); err != nil {
+		return err
+	}
+	return tx.Table(e.projection.tables.Orders).Create(order).Error
+}
+
+func (e *EventSourcedOrderDAO) UpdateOrder(ctx context.Context, order *models.Order, oldversion int64) (int64, error) {
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		aggregate, err := e.load(tx, order.OrderId)
+		if err != nil {
+			return err
+		}
+		if aggregate.Order.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		if aggregate.Order.OrderVersion != oldversion {
+			return ErrVersionConflict
+		}
+		// 拆单后的父订单的状态由子订单汇总，不能直接修改
+		if aggregate.Order.SubOrderCount > 0 && order.Status != 0 && order.Status != aggregate.Order.Status {
+			return models.ErrInvalidTransition
+		}
+		events, err := eventsForUpdate(&aggregate.Order, order)
+		if err != nil {
+			return err
+		}
+		if err := e.append(tx, aggregate, events...); err != nil {
+			return err
+		}
+		return e.project(tx, aggregate, oldversion)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (e *EventSourcedOrderDAO) GetOrderById(ctx context.Context, orderId string) (*models.Order, error) {
+	return e.projection.GetOrderById(ctx, orderId)
+}
+
+func (e *EventSourcedOrderDAO) GetOrdersByIds(ctx context.Context, orderIds []string) ([]*models.Order, error) {
+	return e.projection.GetOrdersByIds(ctx, orderIds)
+}
+
+// CreateOrderItem 追加 ItemAdded 事件，订单项不改变订单版本号
+func (e *EventSourcedOrderDAO) CreateOrderItem(ctx context.Context, orderItem *models.OrderItem) (int64, error) {
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		aggregate, err := e.load(tx, orderItem.OrderId)
+		if err != nil {
+			return err
+		}
+		if aggregate.Order.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		event, err := models.NewOrderEvent(&aggregate.Order, models.EventItemAdded, &models.ItemPayload{
+			SKUId: orderItem.SKUId,
+			Count: orderItem.Count,
+			Price: orderItem.Price,
+		})
+		if err != nil {
+			return err
+		}
+		return e.append(tx, aggregate, event)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (e *EventSourcedOrderDAO) ListOrders(ctx context.Context, query *ListOrderQuery) ([]*models.Order, error) {
+	return e.projection.ListOrders(ctx, query)
+}
+
+// GetOrderHistory 从头回放事件，每个事件生成一条版本记录
+func (e *EventSourcedOrderDAO) GetOrderHistory(ctx context.Context, orderId string) ([]*models.OrderHistory, error) {
+	events := []*models.OrderEvent{}
+	if err := e.db.WithContext(ctx).Where("order_id = ?", orderId).Order("sequence").Find(&events).Error; err != nil {
+		return nil, err
+	}
+	history := make([]*models.OrderHistory, 0, len(events))
+	aggregate := &models.OrderAggregate{}
+	// before 上一个版本的订单，last 最后一个事件之后的订单
+	var before, last *models.Order
+	for _, event := range events {
+		if err := aggregate.Apply(event); err != nil {
+			return nil, err
+		}
+		current := aggregate.Order
+		// 订单项事件不改变版本号，和状态模式一样每个版本只有一条记录
+		if n := len(history); n > 0 && history[n-1].OrderVersion == current.OrderVersion {
+			history = history[:n-1]
+		} else {
+			before = last
+		}
+		entry := models.NewOrderHistory(before, &current, event.Actor, event.Reason)
+		entry.ID = event.ID
+		entry.CreatedAt = event.CreatedAt
+		history = append(history, entry)
+		last = &current
+	}
+	return history, nil
+}
+
+// RebuildProjection 用事件流重建某个订单在 orders 表中的投影，用于修复投影数据
+func (e *EventSourcedOrderDAO) RebuildProjection(ctx context.Context, orderId string) error {
+	return e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		aggregate, err := e.load(tx, orderId)
+		if err != nil {
+			return err
+		}
+		current := &models.Order{}
+		result := tx.Table(e.projection.tables.Orders).Unscoped().Where("order_id = ?", orderId).Limit(1).Find(current)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			order := aggregate.Order
+			return tx.Table(e.projection.tables.Orders).Create(&order).Error
+		}
+		return e.project(tx, aggregate, current.OrderVersion)
+	})
+}
+
+// appendVersioned 追加一个版本号加1的事件并更新投影
+func (e *EventSourcedOrderDAO) appendVersioned(ctx context.Context, orderId string, eventType string, check func(aggregate *models.OrderAggregate) error) (int64, error) {
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		aggregate, err := e.load(tx, orderId)
+		if err != nil {
+			return err
+		}
+		if err := check(aggregate); err != nil {
+			return err
+		}
+		oldversion := aggregate.Order.OrderVersion
+		next := aggregate.Order
+		next.OrderVersion++
+		event, err := models.NewOrderEvent(&next, eventType, nil)
+		if err != nil {
+			return err
+		}
+		if err := e.append(tx, aggregate, event); err != nil {
+			return err
+		}
+		return e.project(tx, aggregate, oldversion)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	return 1, nil
+}
+
+func (e *EventSourcedOrderDAO) DeleteOrder(ctx context.Context, orderId string, oldversion int64) (int64, error) {
+	return e.appendVersioned(ctx, orderId, models.EventDeleted, func(aggregate *models.OrderAggregate) error {
+		if aggregate.Order.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		if aggregate.Order.OrderVersion != oldversion {
+			return ErrVersionConflict
+		}
+		return nil
+	})
+}
+
+func (e *EventSourcedOrderDAO) RestoreOrder(ctx context.Context, orderId string) (int64, error) {
+	return e.appendVersioned(ctx, orderId, models.EventRestored, func(aggregate *models.OrderAggregate) error {
+		if !aggregate.Order.DeletedAt.Valid {
+			return ErrNotDeleted
+		}
+		return nil
+	})
+}
+
+// PurgeOrder 事件溯源模式下物理删除会删掉整个事件流、快照和投影
+func (e *EventSourcedOrderDAO) PurgeOrder(ctx context.Context, orderId string) (rowAffected int64, err error) {
+	err = e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("order_id = ?", orderId).Delete(&models.OrderEvent{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("order_id = ?", orderId).Delete(&models.OrderSnapshot{}).Error; err != nil {
+			return err
+		}
+		result := tx.Table(e.projection.tables.Orders).Unscoped().Where("order_id = ?", orderId).Delete(&models.Order{})
+		rowAffected = result.RowsAffected
+		if result.Error == nil && rowAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	return rowAffected, nil
+}
+
+// GetOrderItems 事件溯源模式下订单项只保存在事件里，从聚合中取出
+func (e *EventSourcedOrderDAO) GetOrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error) {
+	aggregate, err := e.Load(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	return aggregate.Items, nil
+}
+
+// RefundOrder 追加一个携带退款订单项的 Refunded 事件，退款记录和状态模式一样写入 order_refunds
+func (e *EventSourcedOrderDAO) RefundOrder(ctx context.Context, refund *models.OrderRefund, lines []models.RefundLine) (*models.Order, error) {
+	var order *models.Order
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		aggregate, err := e.load(tx, refund.OrderId)
+		if err != nil {
+			return err
+		}
+		if aggregate.Order.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		existing, err := findRefund(tx, e.projection.tables.Refunds, refund.RefundId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			if existing.OrderId != refund.OrderId {
+				return fmt.Errorf("refund %s belongs to order %s", refund.RefundId, existing.OrderId)
+			}
+			*refund, order = *existing, &aggregate.Order
+			return nil
+		}
+
+		refunds := []*models.OrderRefund{}
+		if err := tx.Table(e.projection.tables.Refunds).Where("order_id = ?", refund.OrderId).Find(&refunds).Error; err != nil {
+			return err
+		}
+		resolved, amount, status, err := models.PrepareRefund(&aggregate.Order, aggregate.Items, models.RefundedAmount(refunds), lines)
+		if err != nil {
+			return err
+		}
+		oldversion := aggregate.Order.OrderVersion
+		next := aggregate.Order
+		next.OrderVersion++
+		event, err := models.NewOrderEvent(&next, models.EventRefunded, &models.RefundPayload{
+			RefundId: refund.RefundId,
+			Amount:   amount,
+			Status:   status,
+			Lines:    resolved,
+		})
+		if err != nil {
+			return err
+		}
+		// 序号冲突说明有并发的退款，事务回滚后不会写入退款记录
+		if err := e.append(tx, aggregate, event); err != nil {
+			return err
+		}
+		if err := e.project(tx, aggregate, oldversion); err != nil {
+			return err
+		}
+
+		linesJSON, err := json.Marshal(resolved)
+		if err != nil {
+			return err
+		}
+		refund.UserId, refund.TenantId = aggregate.Order.UserId, aggregate.Order.TenantId
+		refund.Amount, refund.Currency = amount, aggregate.Order.Currency
+		refund.Status, refund.Lines = models.RefundPending, string(linesJSON)
+		order = &aggregate.Order
+		return tx.Table(e.projection.tables.Refunds).Create(refund).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return order, nil
+}
+
+func (e *EventSourcedOrderDAO) ListRefunds(ctx context.Context, orderId string) ([]*models.OrderRefund, error) {
+	return e.projection.ListRefunds(ctx, orderId)
+}
+
+// ConfirmPayment 金额一致时追加携带支付金额的 Paid 事件，支付记录和状态模式一样写入 order_payments
+func (e *EventSourcedOrderDAO) ConfirmPayment(ctx context.Context, payment *models.OrderPayment) (*models.Order, error) {
+	var order *models.Order
+	var mismatch error
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		aggregate, err := e.load(tx, payment.OrderId)
+		if err != nil {
+			return err
+		}
+		if aggregate.Order.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		order = &aggregate.Order
+		existing, err := findPayment(tx, e.projection.tables.Payments, payment.PaymentId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			mismatch = replayPayment(payment, existing)
+			return nil
+		}
+
+		payment.UserId, payment.TenantId = aggregate.Order.UserId, aggregate.Order.TenantId
+		if detail := models.CheckPayment(&aggregate.Order, payment); detail != "" {
+			payment.Status, payment.Detail = models.PaymentMismatch, detail
+			mismatch = models.ErrPaymentMismatch
+			return tx.Table(e.projection.tables.Payments).Create(payment).Error
+		}
+		oldversion := aggregate.Order.OrderVersion
+		next := aggregate.Order
+		next.OrderVersion++
+		event, err := models.NewOrderEvent(&next, models.EventPaid, &models.PaidPayload{
+			PaymentId: payment.PaymentId,
+			Amount:    payment.Amount,
+		})
+		if err != nil {
+			return err
+		}
+		// 序号冲突说明有并发写入，事务回滚后支付服务重试回调即可
+		if err := e.append(tx, aggregate, event); err != nil {
+			return err
+		}
+		if err := e.project(tx, aggregate, oldversion); err != nil {
+			return err
+		}
+		payment.Status = models.PaymentConfirmed
+		return tx.Table(e.projection.tables.Payments).Create(payment).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return order, mismatch
+}
+
+// ShipOrder 追加 Shipped 事件，履约信息和状态模式一样写入 order_fulfillments
+func (e *EventSourcedOrderDAO) ShipOrder(ctx context.Context, fulfillment *models.OrderFulfillment) (*models.Order, error) {
+	return e.transition(ctx, fulfillment.OrderId, func(tx *gorm.DB, aggregate *models.OrderAggregate) (string, interface{}, error) {
+		if !models.CanShip(&aggregate.Order) {
+			return "", nil, models.ErrInvalidTransition
+		}
+		fulfillment.UserId, fulfillment.TenantId = aggregate.Order.UserId, aggregate.Order.TenantId
+		if err := tx.Table(e.projection.tables.Fulfillments).Create(fulfillment).Error; err != nil {
+			return "", nil, err
+		}
+		return models.EventShipped, &models.ShippedPayload{
+			Carrier:        fulfillment.Carrier,
+			TrackingNumber: fulfillment.TrackingNumber,
+			ShippedAt:      *fulfillment.ShippedAt,
+		}, nil
+	})
+}
+
+func (e *EventSourcedOrderDAO) MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*models.Order, error) {
+	return e.transition(ctx, orderId, func(tx *gorm.DB, aggregate *models.OrderAggregate) (string, interface{}, error) {
+		if !models.CanDeliver(&aggregate.Order) {
+			return "", nil, models.ErrInvalidTransition
+		}
+		if err := tx.Table(e.projection.tables.Fulfillments).Where("order_id = ?", orderId).
+			Update("delivered_at", deliveredAt).Error; err != nil {
+			return "", nil, err
+		}
+		return models.EventDelivered, &models.DeliveredPayload{DeliveredAt: deliveredAt}, nil
+	})
+}
+
+func (e *EventSourcedOrderDAO) GetFulfillment(ctx context.Context, orderId string) (*models.OrderFulfillment, error) {
+	return e.projection.GetFulfillment(ctx, orderId)
+}
+
+// transition 加载未删除的订单，apply 检查状态并写入子资源后返回需要追加的事件，版本号加1并更新投影
+// apply 返回空的事件类型时订单不变
+func (e *EventSourcedOrderDAO) transition(ctx context.Context, orderId string, apply func(tx *gorm.DB, aggregate *models.OrderAggregate) (string, interface{}, error)) (*models.Order, error) {
+	var order *models.Order
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		aggregate, err := e.load(tx, orderId)
+		if err != nil {
+			return err
+		}
+		if aggregate.Order.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		eventType, payload, err := apply(tx, aggregate)
+		if err != nil {
+			return err
+		}
+		if eventType == "" {
+			order = &aggregate.Order
+			return nil
+		}
+		oldversion := aggregate.Order.OrderVersion
+		next := aggregate.Order
+		next.OrderVersion++
+		event, err := models.NewOrderEvent(&next, eventType, payload)
+		if err != nil {
+			return err
+		}
+		if err := e.append(tx, aggregate, event); err != nil {
+			return err
+		}
+		order = &aggregate.Order
+		return e.project(tx, aggregate, oldversion)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return order, nil
+}
+
+// AnonymizeOrder 事件只追加不修改，删除用户数据是唯一的例外：清除事件中的个人信息并删除快照，
+// 再追加 Anonymized 事件并更新投影
+func (e *EventSourcedOrderDAO) AnonymizeOrder(ctx context.Context, orderId string) (*models.Order, error) {
+	var order *models.Order
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		events := []*models.OrderEvent{}
+		if err := tx.Where("order_id = ?", orderId).Order("sequence").Find(&events).Error; err != nil {
+			return err
+		}
+		for _, event := range events {
+			payload, err := models.AnonymizePayload(event.Type, event.Payload)
+			if err != nil {
+				return err
+			}
+			if payload == event.Payload {
+				continue
+			}
+			event.Payload = payload
+			if err := tx.Select("payload").Updates(event).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("order_id = ?", orderId).Delete(&models.OrderSnapshot{}).Error; err != nil {
+			return err
+		}
+
+		aggregate, err := e.load(tx, orderId)
+		if err != nil {
+			return err
+		}
+		oldversion := aggregate.Order.OrderVersion
+		next := aggregate.Order
+		next.OrderVersion++
+		event, err := models.NewOrderEvent(&next, models.EventAnonymized, nil)
+		if err != nil {
+			return err
+		}
+		if err := e.append(tx, aggregate, event); err != nil {
+			return err
+		}
+		order = &aggregate.Order
+		return e.project(tx, aggregate, oldversion)
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return order, nil
+}
+
+// EraseOrder 删除事件、快照、投影和默认表中的子记录
+func (e *EventSourcedOrderDAO) EraseOrder(ctx context.Context, orderId string) (int64, error) {
+	rowAffected, err := e.PurgeOrder(ctx, orderId)
+	if err != nil {
+		return 0, err
+	}
+	if err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, sub := range []struct {
+			table string
+			model interface{}
+		}{
+			{e.projection.tables.Refunds, &models.OrderRefund{}},
+			{e.projection.tables.Payments, &models.OrderPayment{}},
+			{e.projection.tables.Fulfillments, &models.OrderFulfillment{}},
+		} {
+			if err := tx.Table(sub.table).Where("order_id = ?", orderId).Delete(sub.model).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	return rowAffected, nil
+}
@@ -1,67 +1,737 @@
 package dao
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/lenny-mo/order/domain/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type OrderDAOInterface interface {
-	CreateOrder(order *models.Order) (int64, error)
-	UpdateOrder(order *models.Order, oldversion int64) (int64, error)
-	GetOrderById(orderId string) (*models.Order, error)
-	CreateOrderItem(orderItem *models.OrderItem) (int64, error)
+	CreateOrder(ctx context.Context, order *models.Order) (int64, error)
+	UpdateOrder(ctx context.Context, order *models.Order, oldversion int64) (int64, error)
+	GetOrderById(ctx context.Context, orderId string) (*models.Order, error)
+	// 按订单号批量查询，每个库只有一次IN 查询，不存在的订单不返回，返回的顺序不确定
+	GetOrdersByIds(ctx context.Context, orderIds []string) ([]*models.Order, error)
+	CreateOrderItem(ctx context.Context, orderItem *models.OrderItem) (int64, error)
+	// 管理后台使用的订单列表查询，分片部署时会跨分片合并结果
+	ListOrders(ctx context.Context, query *ListOrderQuery) ([]*models.Order, error)
+	// 订单的全部版本记录，按版本号升序
+	GetOrderHistory(ctx context.Context, orderId string) ([]*models.OrderHistory, error)
+	// 软删除订单，需要传入当前版本号，删除后版本号加1
+	DeleteOrder(ctx context.Context, orderId string, oldversion int64) (int64, error)
+	// 恢复软删除的订单，恢复后版本号加1
+	RestoreOrder(ctx context.Context, orderId string) (int64, error)
+	// 物理删除订单和订单项，版本记录和退款记录保留
+	PurgeOrder(ctx context.Context, orderId string) (int64, error)
+	// 订单的全部订单项
+	GetOrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error)
+	// 退款，lines 为空表示整单退款；refund 中需要传入 OrderId、RefundId 和 Reason，其余字段由DAO 填充
+	// RefundId 已经存在时不会重复退款，refund 会被填充为已有的记录，返回退款后的订单
+	RefundOrder(ctx context.Context, refund *models.OrderRefund, lines []models.RefundLine) (*models.Order, error)
+	// 订单的全部退款记录，按创建时间升序
+	ListRefunds(ctx context.Context, orderId string) ([]*models.OrderRefund, error)
+	// 支付确认，金额一致时订单改为已支付，版本号加1；同一个 PaymentId 只会生效一次
+	// 不一致时写入 MISMATCH 的支付记录并返回 models.ErrPaymentMismatch，订单不变
+	ConfirmPayment(ctx context.Context, payment *models.OrderPayment) (*models.Order, error)
+	// 发货，写入履约信息并把订单改为已发货，状态不允许时返回 models.ErrInvalidTransition
+	ShipOrder(ctx context.Context, fulfillment *models.OrderFulfillment) (*models.Order, error)
+	// 签收，记录签收时间并把订单改为已签收
+	MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*models.Order, error)
+	// 订单的履约信息，还没有发货时返回 gorm.ErrRecordNotFound
+	GetFulfillment(ctx context.Context, orderId string) (*models.OrderFulfillment, error)
+	// 清除订单和版本记录中的个人信息，包括已删除的订单，返回清除后的订单
+	AnonymizeOrder(ctx context.Context, orderId string) (*models.Order, error)
+	// 删除订单和全部相关的数据，包括版本记录，不再留下任何痕迹
+	EraseOrder(ctx context.Context, orderId string) (int64, error)
+	// 拆单，按 groups 把已支付的订单拆成子订单，subOrderIds 是子订单号，分片部署时需要和原订单在同一个槽位
+	// 同一个事务里创建子订单并把原订单标记为父订单，原订单版本号加1，返回子订单
+	SplitOrder(ctx context.Context, orderId string, oldversion int64, subOrderIds []string, groups [][]models.SplitLine) ([]*models.Order, error)
+	// 父订单的全部子订单，按创建的顺序
+	ListSubOrders(ctx context.Context, parentId string) ([]*models.Order, error)
+	// 按子订单重新汇总父订单的状态，状态变化时版本号加1，返回父订单
+	SyncParentStatus(ctx context.Context, parentId string) (*models.Order, error)
+	// 合单，versions 是被合并的订单号和调用方读到的版本号
+	// 同一个事务里创建 merged 并取消被合并的订单，订单不能合并时返回 models.ErrInvalidMerge
+	MergeOrders(ctx context.Context, merged *models.Order, versions map[string]int64) error
 	// 不是很清楚是否需要更新OrderItem，因为有外键约束，当Order表的OrderId字段更新时，OrderItem表的OrderId字段也更新
 }
 
+// ErrVersionConflict 乐观锁冲突，调用方需要重新读取订单后再更新
+var ErrVersionConflict = errors.New("update order failed, found confliction while on order version while update")
+
+// ErrNotDeleted 恢复一个没有被删除的订单
+var ErrNotDeleted = errors.New("restore order failed, order is not deleted")
+
+// ListOrderQuery 订单列表的查询条件，零值字段表示不过滤
+type ListOrderQuery struct {
+	UserId int64
+	Status *int8
+	Offset int
+	Limit  int
+	// CreatedBefore 只返回在此之前创建的订单，用于超时取消
+	CreatedBefore time.Time
+	// CreatedAfter 只返回在此时间及之后创建的订单，和 CreatedBefore 一起用于按时间段导出
+	CreatedAfter time.Time
+	// 按收件人邮箱或手机号查询，联系方式加密存储，通过盲索引等值匹配
+	ContactEmail string
+	ContactPhone string
+	// ExcludeTenants 不返回这些租户的订单，用于按租户配置的超时时间分别取消
+	ExcludeTenants []string
+	// 跨分片归并时每个分片需要取 offset+limit 条，不受单次上限限制
+	unbounded bool
+}
+
+// 单次列表查询最多返回的订单数
+const MaxListLimit = 500
+
+func (q *ListOrderQuery) limit() int {
+	if q.unbounded {
+		return q.Limit
+	}
+	if q.Limit <= 0 || q.Limit > MaxListLimit {
+		return MaxListLimit
+	}
+	return q.Limit
+}
+
+// apply 把过滤条件拼接到查询上，排序和分页交给调用方
+func (q *ListOrderQuery) apply(db *gorm.DB) *gorm.DB {
+	if q.UserId != 0 {
+		db = db.Where("user_id = ?", q.UserId)
+	}
+	if q.Status != nil {
+		db = db.Where("status = ?", *q.Status)
+	}
+	if !q.CreatedBefore.IsZero() {
+		db = db.Where("created_at < ?", q.CreatedBefore)
+	}
+	if !q.CreatedAfter.IsZero() {
+		db = db.Where("created_at >= ?", q.CreatedAfter)
+	}
+	if q.ContactEmail != "" {
+		db = db.Where("contact_email_bidx = ?", models.ContactEmailIndex(q.ContactEmail))
+	}
+	if q.ContactPhone != "" {
+		db = db.Where("contact_phone_bidx = ?", models.ContactPhoneIndex(q.ContactPhone))
+	}
+	if len(q.ExcludeTenants) > 0 {
+		db = db.Where("tenant_id NOT IN ?", q.ExcludeTenants)
+	}
+	return db
+}
+
+// tableSet 一组订单相关的表，单表模式使用默认表名，分片模式每个分片一组
+type tableSet struct {
+	Orders       string
+	Items        string
+	History      string
+	Refunds      string
+	Payments     string
+	Fulfillments string
+}
+
+var defaultTables = tableSet{
+	Orders:       "orders",
+	Items:        "order_items",
+	History:      models.OrderHistory{}.TableName(),
+	Refunds:      models.OrderRefund{}.TableName(),
+	Payments:     models.OrderPayment{}.TableName(),
+	Fulfillments: models.OrderFulfillment{}.TableName(),
+}
+
 type OrderDAO struct {
-	db *gorm.DB
+	db     *gorm.DB
+	tables tableSet
 }
 
 func NewOrderDAO(db *gorm.DB) OrderDAOInterface {
 	return &OrderDAO{
-		db: db,
+		db:     db,
+		tables: defaultTables,
 	}
 }
 
-// CreateOrder 创建订单
-func (o *OrderDAO) CreateOrder(order *models.Order) (rowAffected int64, err error) {
+// Migrate 如果没有表则创建，已有的表会补上新增的字段
+func (o *OrderDAO) Migrate() error {
+	// 订单项的外键约束引用的是默认的orders 表，分片表建表时不创建外键
+	itemDB := o.db
+	if o.tables != defaultTables {
+		itemDB = o.db.Session(&gorm.Session{NewDB: true})
+		config := *itemDB.Config
+		config.DisableForeignKeyConstraintWhenMigrating = true
+		itemDB.Config = &config
+	}
+	targets := []struct {
+		db    *gorm.DB
+		table string
+		model interface{}
+	}{
+		{o.db, o.tables.Orders, &models.Order{}},
+		{o.db, o.tables.History, &models.OrderHistory{}},
+		{itemDB, o.tables.Items, &models.OrderItem{}},
+		{o.db, o.tables.Refunds, &models.OrderRefund{}},
+		{o.db, o.tables.Payments, &models.OrderPayment{}},
+		{o.db, o.tables.Fulfillments, &models.OrderFulfillment{}},
+	}
+	for _, target := range targets {
+		if err := target.db.Table(target.table).AutoMigrate(target.model); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	result := o.db.Create(order)
-	if result.Error != nil {
-		fmt.Println(result.Error)
-		return result.RowsAffected, err
+// CreateOrder 创建订单，同一个事务里写入订单项和第一条版本记录
+func (o *OrderDAO) CreateOrder(ctx context.Context, order *models.Order) (rowAffected int64, err error) {
+	stampTenant(ctx, order)
+	err = o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rowAffected, err = o.insert(tx, order)
+		return err
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
 	}
+	return rowAffected, nil
+}
 
-	return result.RowsAffected, nil
+// insert 在调用方的事务里写入订单、订单项和第一条版本记录
+func (o *OrderDAO) insert(tx *gorm.DB, order *models.Order) (int64, error) {
+	audit := AuditFrom(tx.Statement.Context)
+	result := tx.Table(o.tables.Orders).Create(order)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if len(order.Items) > 0 {
+		for i := range order.Items {
+			order.Items[i].OrderId, order.Items[i].UserId, order.Items[i].TenantId = order.OrderId, order.UserId, order.TenantId
+			if order.Items[i].Timestamp.IsZero() {
+				order.Items[i].Timestamp = order.CreatedAt
+			}
+		}
+		if err := tx.Table(o.tables.Items).Omit("Order").Create(&order.Items).Error; err != nil {
+			return 0, err
+		}
+	}
+	return result.RowsAffected, tx.Table(o.tables.History).Create(models.NewOrderHistory(nil, order, audit.Actor, audit.Reason)).Error
 }
 
 // UpdateOrder 更新订单
-func (o *OrderDAO) UpdateOrder(order *models.Order, oldversion int64) (int64, error) {
-	// 1. 获取当前orderid的记录，检查version 是否被修改, 如果被修改，则返回0, err 表示没有更新，并且告诉上游发现数据库更新冲突
-	oldData, err := o.GetOrderById(order.OrderId)
+func (o *OrderDAO) UpdateOrder(ctx context.Context, order *models.Order, oldversion int64) (rowAffected int64, err error) {
+	audit := AuditFrom(ctx)
+	err = o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// 1. 锁住当前orderid的记录，检查version 是否被修改, 如果被修改，则返回0, err 表示没有更新，并且告诉上游发现数据库更新冲突
+		oldData := &models.Order{}
+		if err := tx.Table(o.tables.Orders).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ?", order.OrderId).First(oldData).Error; err != nil {
+			return err
+		}
+		if oldData.OrderVersion != oldversion {
+			return ErrVersionConflict
+		}
+		// 拆单后的父订单的状态由子订单汇总，不能直接修改
+		if oldData.SubOrderCount > 0 && order.Status != 0 && order.Status != oldData.Status {
+			return models.ErrInvalidTransition
+		}
+		// 2. 正常更新
+		result := tx.Table(o.tables.Orders).Where("order_id = ? AND order_version = ?", order.OrderId, oldversion).Updates(order)
+		if result.Error != nil {
+			return result.Error
+		}
+		rowAffected = result.RowsAffected
+		if rowAffected == 0 {
+			return nil
+		}
+		// 3. 读出更新后的完整记录，和旧记录比较后写入版本记录
+		newData := &models.Order{}
+		if err := tx.Table(o.tables.Orders).Where("order_id = ?", order.OrderId).First(newData).Error; err != nil {
+			return err
+		}
+		return tx.Table(o.tables.History).Create(models.NewOrderHistory(oldData, newData, audit.Actor, audit.Reason)).Error
+	})
 	if err != nil {
 		fmt.Println(err)
 		return 0, err
 	}
-	if oldData.OrderVersion != oldversion {
-		fmt.Println(err)
-		return 0, errors.New("update order failed, found confliction while on order version while update")
-	}
-	// 2. 正常更新
-	result := o.db.Model(&models.Order{}).Where("order_id= ?", order.OrderId).Updates(order)
-	return result.RowsAffected, result.Error
+	return rowAffected, nil
 }
 
-func (o *OrderDAO) GetOrderById(orderId string) (*models.Order, error) {
+func (o *OrderDAO) GetOrderById(ctx context.Context, orderId string) (*models.Order, error) {
 	order := &models.Order{}
-	result := o.db.Where("order_id = ?", orderId).First(order)
+	result := o.db.WithContext(ctx).Table(o.tables.Orders).Scopes(readScope(ctx)).Where("order_id = ?", orderId).First(order)
 	return order, result.Error
 }
 
-func (o *OrderDAO) CreateOrderItem(orderItem *models.OrderItem) (int64, error) {
-	result := o.db.Create(orderItem)
+func (o *OrderDAO) GetOrdersByIds(ctx context.Context, orderIds []string) ([]*models.Order, error) {
+	orders := []*models.Order{}
+	if len(orderIds) == 0 {
+		return orders, nil
+	}
+	result := o.db.WithContext(ctx).Table(o.tables.Orders).Scopes(readScope(ctx)).Where("order_id IN ?", orderIds).Find(&orders)
+	return orders, result.Error
+}
+
+func (o *OrderDAO) CreateOrderItem(ctx context.Context, orderItem *models.OrderItem) (int64, error) {
+	result := o.db.WithContext(ctx).Table(o.tables.Items).Omit("Order").Create(orderItem)
 	return result.RowsAffected, result.Error
 }
+
+func (o *OrderDAO) ListOrders(ctx context.Context, query *ListOrderQuery) ([]*models.Order, error) {
+	orders := []*models.Order{}
+	result := query.apply(o.db.WithContext(ctx).Table(o.tables.Orders).Scopes(readScope(ctx))).
+		Order("created_at desc, id desc").
+		Offset(query.Offset).
+		Limit(query.limit()).
+		Find(&orders)
+	return orders, result.Error
+}
+
+func (o *OrderDAO) GetOrderHistory(ctx context.Context, orderId string) ([]*models.OrderHistory, error) {
+	history := []*models.OrderHistory{}
+	result := o.db.WithContext(ctx).Table(o.tables.History).
+		Where("order_id = ?", orderId).
+		Order("order_version, id").
+		Find(&history)
+	return history, result.Error
+}
+
+// changeOrder 锁住订单并检查后按 updates 修改，版本号加1，同一个事务里写入版本记录
+func (o *OrderDAO) changeOrder(ctx context.Context, orderId string, check func(old *models.Order) error, updates map[string]interface{}) (rowAffected int64, err error) {
+	audit := AuditFrom(ctx)
+	err = o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		oldData := &models.Order{}
+		if err := tx.Table(o.tables.Orders).Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ?", orderId).First(oldData).Error; err != nil {
+			return err
+		}
+		if err := check(oldData); err != nil {
+			return err
+		}
+		updates["order_version"] = oldData.OrderVersion + 1
+		result := tx.Table(o.tables.Orders).Unscoped().
+			Where("order_id = ? AND order_version = ?", orderId, oldData.OrderVersion).Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		rowAffected = result.RowsAffected
+		if rowAffected == 0 {
+			return ErrVersionConflict
+		}
+		newData := &models.Order{}
+		if err := tx.Table(o.tables.Orders).Unscoped().Where("order_id = ?", orderId).First(newData).Error; err != nil {
+			return err
+		}
+		return tx.Table(o.tables.History).Create(models.NewOrderHistory(oldData, newData, audit.Actor, audit.Reason)).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	return rowAffected, nil
+}
+
+// DeleteOrder 软删除订单
+func (o *OrderDAO) DeleteOrder(ctx context.Context, orderId string, oldversion int64) (int64, error) {
+	return o.changeOrder(ctx, orderId, func(old *models.Order) error {
+		if old.DeletedAt.Valid {
+			return gorm.ErrRecordNotFound
+		}
+		if old.OrderVersion != oldversion {
+			return ErrVersionConflict
+		}
+		return nil
+	}, map[string]interface{}{"deleted_at": time.Now()})
+}
+
+// RestoreOrder 恢复软删除的订单
+func (o *OrderDAO) RestoreOrder(ctx context.Context, orderId string) (int64, error) {
+	return o.changeOrder(ctx, orderId, func(old *models.Order) error {
+		if !old.DeletedAt.Valid {
+			return ErrNotDeleted
+		}
+		return nil
+	}, map[string]interface{}{"deleted_at": nil})
+}
+
+// PurgeOrder 物理删除订单和订单项，版本记录和退款记录保留；单表模式下外键也会级联删除订单项，这里显式删除是为了兼容没有外键的分片表
+func (o *OrderDAO) PurgeOrder(ctx context.Context, orderId string) (rowAffected int64, err error) {
+	audit := AuditFrom(ctx)
+	err = o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		oldData := &models.Order{}
+		if err := tx.Table(o.tables.Orders).Unscoped().Where("order_id = ?", orderId).First(oldData).Error; err != nil {
+			return err
+		}
+		if err := tx.Table(o.tables.Items).Unscoped().Where("order_id = ?", orderId).Delete(&models.OrderItem{}).Error; err != nil {
+			return err
+		}
+		result := tx.Table(o.tables.Orders).Unscoped().Where("order_id = ?", orderId).Delete(&models.Order{})
+		if result.Error != nil {
+			return result.Error
+		}
+		rowAffected = result.RowsAffected
+		purged := *oldData
+		purged.OrderVersion++
+		purged.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		return tx.Table(o.tables.History).Create(models.NewOrderHistory(oldData, &purged, audit.Actor, "purge: "+audit.Reason)).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	return rowAffected, nil
+}
+
+func (o *OrderDAO) GetOrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error) {
+	items := []models.OrderItem{}
+	result := o.db.WithContext(ctx).Table(o.tables.Items).Where("order_id = ?", orderId).Order("id").Find(&items)
+	return items, result.Error
+}
+
+func (o *OrderDAO) ListRefunds(ctx context.Context, orderId string) ([]*models.OrderRefund, error) {
+	refunds := []*models.OrderRefund{}
+	result := o.db.WithContext(ctx).Table(o.tables.Refunds).Where("order_id = ?", orderId).Order("id").Find(&refunds)
+	return refunds, result.Error
+}
+
+// findRefund 按幂等键查找已有的退款记录，不存在时返回nil
+func findRefund(tx *gorm.DB, table string, refundId string) (*models.OrderRefund, error) {
+	refunds := []*models.OrderRefund{}
+	if err := tx.Table(table).Where("refund_id = ?", refundId).Limit(1).Find(&refunds).Error; err != nil {
+		return nil, err
+	}
+	if len(refunds) == 0 {
+		return nil, nil
+	}
+	return refunds[0], nil
+}
+
+// RefundOrder 锁住订单后校验退款金额，同一个事务里写入退款记录、订单项的退款数量、订单状态和版本记录
+func (o *OrderDAO) RefundOrder(ctx context.Context, refund *models.OrderRefund, lines []models.RefundLine) (*models.Order, error) {
+	audit := AuditFrom(ctx)
+	newData := &models.Order{}
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		oldData := &models.Order{}
+		if err := tx.Table(o.tables.Orders).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ?", refund.OrderId).First(oldData).Error; err != nil {
+			return err
+		}
+		// 订单已经锁住，同一个幂等键的并发请求会在这里看到先提交的退款记录
+		existing, err := findRefund(tx, o.tables.Refunds, refund.RefundId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			if existing.OrderId != refund.OrderId {
+				return fmt.Errorf("refund %s belongs to order %s", refund.RefundId, existing.OrderId)
+			}
+			*refund, *newData = *existing, *oldData
+			return nil
+		}
+
+		items := []models.OrderItem{}
+		if err := tx.Table(o.tables.Items).Where("order_id = ?", refund.OrderId).Order("id").Find(&items).Error; err != nil {
+			return err
+		}
+		refunds := []*models.OrderRefund{}
+		if err := tx.Table(o.tables.Refunds).Where("order_id = ?", refund.OrderId).Find(&refunds).Error; err != nil {
+			return err
+		}
+		resolved, amount, status, err := models.PrepareRefund(oldData, items, models.RefundedAmount(refunds), lines)
+		if err != nil {
+			return err
+		}
+
+		before := make([]int32, len(items))
+		for i := range items {
+			before[i] = items[i].RefundedCount
+		}
+		models.ApplyRefundLines(items, resolved)
+		for i := range items {
+			if items[i].RefundedCount == before[i] {
+				continue
+			}
+			if err := tx.Table(o.tables.Items).Where("id = ?", items[i].ID).
+				Update("refunded_count", items[i].RefundedCount).Error; err != nil {
+				return err
+			}
+		}
+
+		linesJSON, err := json.Marshal(resolved)
+		if err != nil {
+			return err
+		}
+		refund.UserId, refund.TenantId = oldData.UserId, oldData.TenantId
+		refund.Amount, refund.Currency = amount, oldData.Currency
+		refund.Status, refund.Lines = models.RefundPending, string(linesJSON)
+		if err := tx.Table(o.tables.Refunds).Create(refund).Error; err != nil {
+			return err
+		}
+
+		result := tx.Table(o.tables.Orders).Where("order_id = ? AND order_version = ?", refund.OrderId, oldData.OrderVersion).
+			Updates(map[string]interface{}{"status": status, "order_version": oldData.OrderVersion + 1})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		if err := tx.Table(o.tables.Orders).Where("order_id = ?", refund.OrderId).First(newData).Error; err != nil {
+			return err
+		}
+		return tx.Table(o.tables.History).Create(models.NewOrderHistory(oldData, newData, audit.Actor, audit.Reason)).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return newData, nil
+}
+
+// findPayment 按支付单号查找已有的支付记录，不存在时返回nil
+func findPayment(tx *gorm.DB, table string, paymentId string) (*models.OrderPayment, error) {
+	payments := []*models.OrderPayment{}
+	if err := tx.Table(table).Where("payment_id = ?", paymentId).Limit(1).Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, nil
+	}
+	return payments[0], nil
+}
+
+// replayPayment 重复回调时返回第一次处理的结果
+func replayPayment(payment, existing *models.OrderPayment) error {
+	if existing.OrderId != payment.OrderId {
+		return fmt.Errorf("payment %s belongs to order %s", payment.PaymentId, existing.OrderId)
+	}
+	*payment = *existing
+	if existing.Status == models.PaymentMismatch {
+		return models.ErrPaymentMismatch
+	}
+	return nil
+}
+
+// ConfirmPayment 锁住订单后检查支付记录和金额，同一个事务里写入支付记录、订单状态和版本记录
+func (o *OrderDAO) ConfirmPayment(ctx context.Context, payment *models.OrderPayment) (*models.Order, error) {
+	audit := AuditFrom(ctx)
+	newData := &models.Order{}
+	var mismatch error
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		oldData := &models.Order{}
+		if err := tx.Table(o.tables.Orders).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ?", payment.OrderId).First(oldData).Error; err != nil {
+			return err
+		}
+		*newData = *oldData
+		existing, err := findPayment(tx, o.tables.Payments, payment.PaymentId)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			mismatch = replayPayment(payment, existing)
+			return nil
+		}
+
+		payment.UserId, payment.TenantId = oldData.UserId, oldData.TenantId
+		if detail := models.CheckPayment(oldData, payment); detail != "" {
+			// 不一致的支付记录需要提交，所以不能通过返回错误回滚事务
+			payment.Status, payment.Detail = models.PaymentMismatch, detail
+			mismatch = models.ErrPaymentMismatch
+			return tx.Table(o.tables.Payments).Create(payment).Error
+		}
+		payment.Status = models.PaymentConfirmed
+		if err := tx.Table(o.tables.Payments).Create(payment).Error; err != nil {
+			return err
+		}
+
+		result := tx.Table(o.tables.Orders).Where("order_id = ? AND order_version = ?", payment.OrderId, oldData.OrderVersion).
+			Updates(map[string]interface{}{
+				"status":        models.StatusPaid,
+				"paid_amount":   payment.Amount,
+				"order_version": oldData.OrderVersion + 1,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		if err := tx.Table(o.tables.Orders).Where("order_id = ?", payment.OrderId).First(newData).Error; err != nil {
+			return err
+		}
+		return tx.Table(o.tables.History).Create(models.NewOrderHistory(oldData, newData, audit.Actor, audit.Reason)).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return newData, mismatch
+}
+
+// transition 锁住未删除的订单，apply 检查状态并在同一个事务里写入子资源，返回需要修改的字段
+// 版本号加1，写入版本记录，返回修改后的订单；apply 返回 nil 时订单不变
+func (o *OrderDAO) transition(ctx context.Context, orderId string, apply func(tx *gorm.DB, old *models.Order) (map[string]interface{}, error)) (*models.Order, error) {
+	var newData *models.Order
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		newData, err = o.transitionTx(tx, orderId, apply)
+		return err
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return newData, nil
+}
+
+// transitionTx 在调用方的事务里执行 transition
+func (o *OrderDAO) transitionTx(tx *gorm.DB, orderId string, apply func(tx *gorm.DB, old *models.Order) (map[string]interface{}, error)) (*models.Order, error) {
+	audit := AuditFrom(tx.Statement.Context)
+	oldData := &models.Order{}
+	if err := tx.Table(o.tables.Orders).Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_id = ?", orderId).First(oldData).Error; err != nil {
+		return nil, err
+	}
+	updates, err := apply(tx, oldData)
+	if err != nil {
+		return nil, err
+	}
+	if updates == nil {
+		return oldData, nil
+	}
+	updates["order_version"] = oldData.OrderVersion + 1
+	result := tx.Table(o.tables.Orders).Where("order_id = ? AND order_version = ?", orderId, oldData.OrderVersion).Updates(updates)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrVersionConflict
+	}
+	newData := &models.Order{}
+	if err := tx.Table(o.tables.Orders).Where("order_id = ?", orderId).First(newData).Error; err != nil {
+		return nil, err
+	}
+	return newData, tx.Table(o.tables.History).Create(models.NewOrderHistory(oldData, newData, audit.Actor, audit.Reason)).Error
+}
+
+func (o *OrderDAO) ShipOrder(ctx context.Context, fulfillment *models.OrderFulfillment) (*models.Order, error) {
+	return o.transition(ctx, fulfillment.OrderId, func(tx *gorm.DB, old *models.Order) (map[string]interface{}, error) {
+		if !models.CanShip(old) {
+			return nil, models.ErrInvalidTransition
+		}
+		fulfillment.UserId, fulfillment.TenantId = old.UserId, old.TenantId
+		if err := tx.Table(o.tables.Fulfillments).Create(fulfillment).Error; err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"status": models.StatusShipped}, nil
+	})
+}
+
+func (o *OrderDAO) MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*models.Order, error) {
+	return o.transition(ctx, orderId, func(tx *gorm.DB, old *models.Order) (map[string]interface{}, error) {
+		if !models.CanDeliver(old) {
+			return nil, models.ErrInvalidTransition
+		}
+		if err := tx.Table(o.tables.Fulfillments).Where("order_id = ?", orderId).
+			Update("delivered_at", deliveredAt).Error; err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"status": models.StatusDelivered}, nil
+	})
+}
+
+func (o *OrderDAO) GetFulfillment(ctx context.Context, orderId string) (*models.OrderFulfillment, error) {
+	fulfillment := &models.OrderFulfillment{}
+	result := o.db.WithContext(ctx).Table(o.tables.Fulfillments).Where("order_id = ?", orderId).First(fulfillment)
+	return fulfillment, result.Error
+}
+
+// AnonymizeOrder 清除订单上的个人信息，版本记录中的个人信息字段一并删除，再写入一条不包含个人信息的版本记录
+func (o *OrderDAO) AnonymizeOrder(ctx context.Context, orderId string) (*models.Order, error) {
+	audit := AuditFrom(ctx)
+	order := &models.Order{}
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table(o.tables.Orders).Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id = ?", orderId).First(order).Error; err != nil {
+			return err
+		}
+		history := []*models.OrderHistory{}
+		if err := tx.Table(o.tables.History).Where("order_id = ?", orderId).Find(&history).Error; err != nil {
+			return err
+		}
+		for _, entry := range history {
+			diff, err := models.AnonymizeDiff(entry.Diff)
+			if err != nil {
+				return err
+			}
+			if diff == entry.Diff {
+				continue
+			}
+			entry.Diff = diff
+			if err := tx.Table(o.tables.History).Select("diff").Updates(entry).Error; err != nil {
+				return err
+			}
+		}
+
+		order.Anonymize()
+		order.OrderVersion++
+		// Select 全部字段，清空的个人信息也要写入
+		if err := tx.Table(o.tables.Orders).Unscoped().Select("*").Omit("id", "created_at").
+			Where("order_id = ?", orderId).Updates(order).Error; err != nil {
+			return err
+		}
+		return tx.Table(o.tables.History).Create(&models.OrderHistory{
+			OrderId:      order.OrderId,
+			UserId:       order.UserId,
+			TenantId:     order.TenantId,
+			OrderVersion: order.OrderVersion,
+			Status:       order.Status,
+			Diff:         `{"anonymized":[false,true]}`,
+			Actor:        audit.Actor,
+			Reason:       audit.Reason,
+			CreatedAt:    time.Now(),
+		}).Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return nil, err
+	}
+	return order, nil
+}
+
+// EraseOrder 和 PurgeOrder 不同，版本记录也会被删除，删除记录保存在 privacy_requests 中
+func (o *OrderDAO) EraseOrder(ctx context.Context, orderId string) (rowAffected int64, err error) {
+	err = o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, sub := range []struct {
+			table string
+			model interface{}
+		}{
+			{o.tables.Items, &models.OrderItem{}},
+			{o.tables.History, &models.OrderHistory{}},
+			{o.tables.Refunds, &models.OrderRefund{}},
+			{o.tables.Payments, &models.OrderPayment{}},
+			{o.tables.Fulfillments, &models.OrderFulfillment{}},
+		} {
+			if err := tx.Table(sub.table).Unscoped().Where("order_id = ?", orderId).Delete(sub.model).Error; err != nil {
+				return err
+			}
+		}
+		result := tx.Table(o.tables.Orders).Unscoped().Where("order_id = ?", orderId).Delete(&models.Order{})
+		rowAffected = result.RowsAffected
+		if result.Error == nil && rowAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	return rowAffected, nil
+}
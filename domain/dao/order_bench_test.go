@@ -0,0 +1,62 @@
+package dao_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lenny-mo/order/domain/models"
+)
+
+func BenchmarkCreateOrder(b *testing.B) {
+	for _, mode := range persistenceModes {
+		b.Run(mode, func(b *testing.B) {
+			orderDAO := newOrderDAO(b, mode)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				createOrder(b, orderDAO, int64(i))
+			}
+		})
+	}
+}
+
+func BenchmarkGetOrderById(b *testing.B) {
+	for _, mode := range persistenceModes {
+		b.Run(mode, func(b *testing.B) {
+			orderDAO := newOrderDAO(b, mode)
+			created := createOrder(b, orderDAO, 1)
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := orderDAO.GetOrderById(ctx, created.OrderId); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkUpdateOrder 同一个订单连续修改，事件溯源模式下包括回放快照之后的事件
+func BenchmarkUpdateOrder(b *testing.B) {
+	for _, mode := range persistenceModes {
+		b.Run(mode, func(b *testing.B) {
+			orderDAO := newOrderDAO(b, mode)
+			created := createOrder(b, orderDAO, 1)
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				version := int64(i) + 1
+				if _, err := orderDAO.UpdateOrder(ctx, &models.Order{
+					OrderId:      created.OrderId,
+					OrderVersion: version + 1,
+					UserId:       created.UserId,
+					OrderData:    "updated",
+				}, version); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
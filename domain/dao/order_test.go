@@ -0,0 +1,182 @@
+package dao_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	ordertesting "github.com/lenny-mo/order/testing"
+	"github.com/lenny-mo/order/utils"
+	"gorm.io/gorm"
+)
+
+var persistenceModes = []string{conf.PersistenceState, conf.PersistenceEvent}
+
+func newOrderDAO(t testing.TB, mode string) dao.OrderDAOInterface {
+	t.Helper()
+	db, err := ordertesting.OpenSQLite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderDAO, err := ordertesting.NewOrderDAO(db, mode, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return orderDAO
+}
+
+func createOrder(t testing.TB, orderDAO dao.OrderDAOInterface, userId int64) *models.Order {
+	t.Helper()
+	order := &models.Order{
+		OrderId:      utils.UUID(),
+		OrderVersion: 1,
+		UserId:       userId,
+		OrderData:    "created",
+		TotalAmount:  300,
+		Currency:     "CNY",
+		Items:        []models.OrderItem{{SKUId: 1, Count: 3, Price: 100}},
+	}
+	if _, err := orderDAO.CreateOrder(context.Background(), order); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
+
+func TestUpdateOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		oldVersion int64
+		newVersion int64
+		status     int8
+		wantErr    error
+	}{
+		{name: "next version", oldVersion: 1, newVersion: 2, status: models.StatusUnpaid},
+		{name: "cancel", oldVersion: 1, newVersion: 2, status: models.StatusCancelled},
+		{name: "status without its own event", oldVersion: 1, newVersion: 2, status: models.StatusShipped},
+		{name: "stale version", oldVersion: 0, newVersion: 1, status: models.StatusUnpaid, wantErr: dao.ErrVersionConflict},
+		{name: "future version", oldVersion: 5, newVersion: 6, status: models.StatusUnpaid, wantErr: dao.ErrVersionConflict},
+	}
+	for _, mode := range persistenceModes {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				orderDAO := newOrderDAO(t, mode)
+				ctx := context.Background()
+				created := createOrder(t, orderDAO, 1)
+
+				_, err := orderDAO.UpdateOrder(ctx, &models.Order{
+					OrderId:      created.OrderId,
+					OrderVersion: tt.newVersion,
+					UserId:       created.UserId,
+					OrderData:    "updated",
+					Status:       tt.status,
+				}, tt.oldVersion)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				got, err := orderDAO.GetOrderById(ctx, created.OrderId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				wantVersion, wantData, wantStatus := tt.newVersion, "updated", tt.status
+				if tt.wantErr != nil {
+					wantVersion, wantData, wantStatus = 1, "created", models.StatusUnpaid
+				}
+				if got.OrderVersion != wantVersion || got.OrderData != wantData || got.Status != wantStatus {
+					t.Fatalf("got version %d data %q status %d, want %d %q %d", got.OrderVersion, got.OrderData, got.Status, wantVersion, wantData, wantStatus)
+				}
+			})
+		}
+	}
+}
+
+func TestDeleteAndRestore(t *testing.T) {
+	for _, mode := range persistenceModes {
+		t.Run(mode, func(t *testing.T) {
+			orderDAO := newOrderDAO(t, mode)
+			ctx := context.Background()
+			created := createOrder(t, orderDAO, 1)
+
+			if _, err := orderDAO.DeleteOrder(ctx, created.OrderId, 0); !errors.Is(err, dao.ErrVersionConflict) {
+				t.Fatalf("delete with a stale version returned %v", err)
+			}
+			if _, err := orderDAO.DeleteOrder(ctx, created.OrderId, 1); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := orderDAO.GetOrderById(ctx, created.OrderId); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Fatalf("deleted order returned %v", err)
+			}
+			deleted, err := orderDAO.GetOrderById(dao.WithDeleted(ctx), created.OrderId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted.OrderVersion != 2 {
+				t.Fatalf("deleted order has version %d, want 2", deleted.OrderVersion)
+			}
+
+			if _, err := orderDAO.RestoreOrder(ctx, created.OrderId); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := orderDAO.RestoreOrder(ctx, created.OrderId); !errors.Is(err, dao.ErrNotDeleted) {
+				t.Fatalf("restoring twice returned %v", err)
+			}
+			restored, err := orderDAO.GetOrderById(ctx, created.OrderId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restored.OrderVersion != 3 {
+				t.Fatalf("restored order has version %d, want 3", restored.OrderVersion)
+			}
+		})
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	for _, mode := range persistenceModes {
+		t.Run(mode, func(t *testing.T) {
+			orderDAO := newOrderDAO(t, mode)
+			ctx := dao.WithTenant(context.Background(), "a")
+			order := &models.Order{OrderId: utils.UUID(), OrderVersion: 1, UserId: 1, Currency: "CNY", TotalAmount: 100}
+			if _, err := orderDAO.CreateOrder(ctx, order); err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name    string
+				ctx     context.Context
+				wantErr error
+			}{
+				{name: "same tenant", ctx: ctx},
+				{name: "other tenant", ctx: dao.WithTenant(context.Background(), "b"), wantErr: gorm.ErrRecordNotFound},
+				{name: "system", ctx: context.Background()},
+			}
+			for _, tt := range tests {
+				if _, err := orderDAO.GetOrderById(tt.ctx, order.OrderId); !errors.Is(err, tt.wantErr) {
+					t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestSlotOfUser(t *testing.T) {
+	tests := []struct {
+		userId int64
+		want   int
+	}{
+		{userId: 0, want: 0},
+		{userId: 42, want: 42},
+		{userId: dao.ShardSlots + 1, want: 1},
+		{userId: -1, want: dao.ShardSlots - 1},
+		{userId: math.MinInt64, want: 0},
+		{userId: math.MaxInt64, want: dao.ShardSlots - 1},
+	}
+	for _, tt := range tests {
+		if got := dao.SlotOfUser(tt.userId); got != tt.want {
+			t.Errorf("SlotOfUser(%d) = %d, want %d", tt.userId, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package dao
+
+import (
+	"context"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// PrivacyDAOInterface 用户数据导出和删除请求的审计记录，保存在默认库中
+type PrivacyDAOInterface interface {
+	CreateRequest(ctx context.Context, request *models.PrivacyRequest) error
+	// 保存请求的处理结果，整行覆盖
+	SaveRequest(ctx context.Context, request *models.PrivacyRequest) error
+	// 用户的全部请求记录，按时间倒序
+	ListRequests(ctx context.Context, userId int64) ([]*models.PrivacyRequest, error)
+}
+
+type PrivacyDAO struct {
+	db *gorm.DB
+}
+
+func NewPrivacyDAO(db *gorm.DB) PrivacyDAOInterface {
+	return &PrivacyDAO{db: db}
+}
+
+// Migrate 如果没有表则创建，已有的表会补上新增的字段
+func (p *PrivacyDAO) Migrate() error {
+	return p.db.AutoMigrate(&models.PrivacyRequest{})
+}
+
+func (p *PrivacyDAO) CreateRequest(ctx context.Context, request *models.PrivacyRequest) error {
+	return p.db.WithContext(ctx).Create(request).Error
+}
+
+func (p *PrivacyDAO) SaveRequest(ctx context.Context, request *models.PrivacyRequest) error {
+	return p.db.WithContext(ctx).Save(request).Error
+}
+
+func (p *PrivacyDAO) ListRequests(ctx context.Context, userId int64) ([]*models.PrivacyRequest, error) {
+	requests := []*models.PrivacyRequest{}
+	result := p.db.WithContext(ctx).Where("user_id = ?", userId).Order("id desc").Find(&requests)
+	return requests, result.Error
+}
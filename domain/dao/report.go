@@ -0,0 +1,93 @@
+package dao
+
+import (
+	"context"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// ReportQuery 报表的查询条件，日期形如 2024-01-02，包含 FromDay 不包含 ToDay，Currency 为空时不过滤
+type ReportQuery struct {
+	FromDay  string
+	ToDay    string
+	Currency string
+}
+
+func (q *ReportQuery) apply(db *gorm.DB) *gorm.DB {
+	db = db.Where("day >= ? AND day < ?", q.FromDay, q.ToDay)
+	if q.Currency != "" {
+		db = db.Where("currency = ?", q.Currency)
+	}
+	return db
+}
+
+// ReportDAOInterface 每天汇总的销售报表，写入主库，查询只读从库
+type ReportDAOInterface interface {
+	// ReplaceDay 在一个事务中删除一天的汇总再写入新的汇总，context 中没有租户时替换全部租户
+	ReplaceDay(ctx context.Context, day string, sales []*models.DailySales, products []*models.DailyProductSales) error
+	// ListDailySales 按日期升序返回每天的汇总
+	ListDailySales(ctx context.Context, query *ReportQuery) ([]*models.DailySales, error)
+	// TopProducts 按租户、币种和商品合计销量，按优惠后的金额倒序
+	TopProducts(ctx context.Context, query *ReportQuery, limit int) ([]*models.DailyProductSales, error)
+}
+
+type ReportDAO struct {
+	db *gorm.DB
+	// replica 只读从库，没有配置时和 db 相同
+	replica *gorm.DB
+}
+
+func NewReportDAO(db *gorm.DB, replica *gorm.DB) ReportDAOInterface {
+	if replica == nil {
+		replica = db
+	}
+	return &ReportDAO{db: db, replica: replica}
+}
+
+// Migrate 如果没有表则创建，已有的表会补上新增的字段
+func (r *ReportDAO) Migrate() error {
+	return r.db.AutoMigrate(&models.DailySales{}, &models.DailyProductSales{})
+}
+
+func (r *ReportDAO) ReplaceDay(ctx context.Context, day string, sales []*models.DailySales, products []*models.DailyProductSales) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("day = ?", day).Delete(&models.DailySales{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("day = ?", day).Delete(&models.DailyProductSales{}).Error; err != nil {
+			return err
+		}
+		if len(sales) > 0 {
+			if err := tx.CreateInBatches(sales, DefaultBulkBatch).Error; err != nil {
+				return err
+			}
+		}
+		if len(products) > 0 {
+			if err := tx.CreateInBatches(products, DefaultBulkBatch).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *ReportDAO) ListDailySales(ctx context.Context, query *ReportQuery) ([]*models.DailySales, error) {
+	sales := []*models.DailySales{}
+	result := query.apply(r.replica.WithContext(ctx)).Order("day, tenant_id, currency, status").Find(&sales)
+	return sales, result.Error
+}
+
+func (r *ReportDAO) TopProducts(ctx context.Context, query *ReportQuery, limit int) ([]*models.DailyProductSales, error) {
+	if limit <= 0 || limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	products := []*models.DailyProductSales{}
+	result := query.apply(r.replica.WithContext(ctx).Model(&models.DailyProductSales{})).
+		Select("tenant_id, currency, sku_id, SUM(quantity) AS quantity, SUM(revenue) AS revenue, SUM(order_count) AS order_count").
+		Group("tenant_id, currency, sku_id").
+		Order("revenue desc, quantity desc, sku_id").
+		Limit(limit).
+		Scan(&products)
+	return products, result.Error
+}
@@ -0,0 +1,159 @@
+package dao
+
+import (
+	"fmt"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// ReshardReport 重新分片的结果
+type ReshardReport struct {
+	Slots      int      // 发生迁移的槽位数
+	Orders     int64    // 复制的订单数
+	Items      int64    // 复制的订单项数
+	Mismatches []string // 校验不一致的描述，为空表示校验通过
+}
+
+// slotCond 槽位过滤条件，和 SlotOfUser 的计算方式保持一致：ShardSlots 是2的幂，
+// 按位与等于无符号取模，MySQL 和SQLite 中负数和最小的 BIGINT 都不会溢出
+const slotCond = "user_id & ? = ?"
+
+// slotMask 和 slotCond 一起使用
+const slotMask = ShardSlots - 1
+
+// Reshard 把 src 布局中的数据按槽位复制到 dst 布局并校验
+// 目标分片上该槽位已有的数据会先被清理，所以可以在失败后重复执行
+// 迁移期间需要停止写入，校验通过后再切换服务使用的布局
+func Reshard(src, dst *ShardLayout, batch int, verifyOnly bool) (*ReshardReport, error) {
+	if batch <= 0 {
+		batch = 500
+	}
+	report := &ReshardReport{}
+	for slot := 0; slot < ShardSlots; slot++ {
+		from, to := src.Locate(slot), dst.Locate(slot)
+		if from.SameTable(to) {
+			continue
+		}
+		report.Slots++
+		if !verifyOnly {
+			orders, items, err := copySlot(from, to, slot, batch)
+			if err != nil {
+				return report, fmt.Errorf("copy slot %d: %w", slot, err)
+			}
+			report.Orders += orders
+			report.Items += items
+		}
+		mismatches, err := verifySlot(from, to, slot, batch)
+		if err != nil {
+			return report, fmt.Errorf("verify slot %d: %w", slot, err)
+		}
+		report.Mismatches = append(report.Mismatches, mismatches...)
+	}
+	return report, nil
+}
+
+func copySlot(from, to *Shard, slot, batch int) (orders int64, items int64, err error) {
+	if orders, err = copyTable(from.DB, to.DB, from.dao.tables.Orders, to.dao.tables.Orders, slot, batch); err != nil {
+		return
+	}
+	if items, err = copyTable(from.DB, to.DB, from.dao.tables.Items, to.dao.tables.Items, slot, batch); err != nil {
+		return
+	}
+	if _, err = copyTable(from.DB, to.DB, from.dao.tables.History, to.dao.tables.History, slot, batch); err != nil {
+		return
+	}
+	if _, err = copyTable(from.DB, to.DB, from.dao.tables.Refunds, to.dao.tables.Refunds, slot, batch); err != nil {
+		return
+	}
+	if _, err = copyTable(from.DB, to.DB, from.dao.tables.Payments, to.dao.tables.Payments, slot, batch); err != nil {
+		return
+	}
+	_, err = copyTable(from.DB, to.DB, from.dao.tables.Fulfillments, to.dao.tables.Fulfillments, slot, batch)
+	return
+}
+
+// copyTable 按主键分批复制一张表里某个槽位的数据，包括软删除的行
+// 先清理目标表里该槽位的数据，保证重复执行的结果一致
+func copyTable(src, dst *gorm.DB, srcTable, dstTable string, slot, batch int) (int64, error) {
+	if !src.Migrator().HasTable(srcTable) {
+		return 0, nil
+	}
+	if !dst.Migrator().HasTable(dstTable) {
+		return 0, fmt.Errorf("target table %s does not exist", dstTable)
+	}
+	if err := dst.Exec("DELETE FROM "+dstTable+" WHERE "+slotCond, slotMask, slot).Error; err != nil {
+		return 0, err
+	}
+	var copied int64
+	var lastId interface{} = 0
+	for {
+		rows := []map[string]interface{}{}
+		err := src.Table(srcTable).Where(slotCond, slotMask, slot).
+			Where("id > ?", lastId).Order("id").Limit(batch).Find(&rows).Error
+		if err != nil {
+			return copied, err
+		}
+		if len(rows) == 0 {
+			return copied, nil
+		}
+		lastId = rows[len(rows)-1]["id"]
+		// 自增主键在不同的表之间会冲突，复制时交给目标表重新生成
+		for _, row := range rows {
+			delete(row, "id")
+		}
+		result := dst.Table(dstTable).Create(&rows)
+		if result.Error != nil {
+			return copied, result.Error
+		}
+		copied += result.RowsAffected
+	}
+}
+
+// verifySlot 按订单号顺序逐条比较两边的订单版本、状态和数据
+func verifySlot(from, to *Shard, slot, batch int) ([]string, error) {
+	mismatches := []string{}
+	var srcCount, dstCount int64
+	if err := from.Orders().Unscoped().Where(slotCond, slotMask, slot).Count(&srcCount).Error; err != nil {
+		return nil, err
+	}
+	if err := to.Orders().Unscoped().Where(slotCond, slotMask, slot).Count(&dstCount).Error; err != nil {
+		return nil, err
+	}
+	if srcCount != dstCount {
+		return append(mismatches, fmt.Sprintf("slot %d: source has %d orders, target has %d", slot, srcCount, dstCount)), nil
+	}
+
+	page := func(db *gorm.DB, after string) ([]*models.Order, error) {
+		rows := []*models.Order{}
+		err := db.Unscoped().Select("order_id", "order_version", "status", "order_data").
+			Where(slotCond, slotMask, slot).Where("order_id > ?", after).
+			Order("order_id").Limit(batch).Find(&rows).Error
+		return rows, err
+	}
+	after := ""
+	for {
+		srcRows, err := page(from.Orders(), after)
+		if err != nil {
+			return nil, err
+		}
+		dstRows, err := page(to.Orders(), after)
+		if err != nil {
+			return nil, err
+		}
+		if len(srcRows) == 0 {
+			return mismatches, nil
+		}
+		for i, s := range srcRows {
+			if i >= len(dstRows) {
+				mismatches = append(mismatches, fmt.Sprintf("slot %d: order %s missing in target", slot, s.OrderId))
+				continue
+			}
+			d := dstRows[i]
+			if s.OrderId != d.OrderId || s.OrderVersion != d.OrderVersion || s.Status != d.Status || s.OrderData != d.OrderData {
+				mismatches = append(mismatches, fmt.Sprintf("slot %d: order %s differs from target row %s", slot, s.OrderId, d.OrderId))
+			}
+		}
+		after = srcRows[len(srcRows)-1].OrderId
+	}
+}
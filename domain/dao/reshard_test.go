@@ -0,0 +1,72 @@
+package dao_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	ordertesting "github.com/lenny-mo/order/testing"
+	"github.com/lenny-mo/order/utils"
+	"gorm.io/gorm"
+)
+
+func TestReshardNegativeUserIds(t *testing.T) {
+	db, err := ordertesting.OpenSQLite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 一张表拆成同一个库里的四张表，槽位0 留在原表，其余槽位迁移
+	src, err := dao.NewShardLayout([]*gorm.DB{db}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst, err := dao.NewShardLayout([]*gorm.DB{db}, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layout := range []*dao.ShardLayout{src, dst} {
+		if err := layout.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := context.Background()
+	srcDAO := dao.NewShardedOrderDAO(src)
+	orderIds := map[int64]string{}
+	// -1 在槽位1023，按绝对值会算成槽位1，落到另一张表
+	for _, userId := range []int64{1, -1, math.MinInt64} {
+		order := &models.Order{
+			OrderId:      dao.ShardOrderId(dao.SlotOfUser(userId), utils.UUID()),
+			OrderVersion: 1,
+			UserId:       userId,
+			OrderData:    "created",
+			Currency:     "CNY",
+			Items:        []models.OrderItem{{SKUId: 1, Count: 1, Price: 100}},
+		}
+		if _, err := srcDAO.CreateOrder(ctx, order); err != nil {
+			t.Fatal(err)
+		}
+		orderIds[userId] = order.OrderId
+	}
+
+	report, err := dao.Reshard(src, dst, 10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Mismatches) > 0 || report.Orders != 2 {
+		t.Fatalf("copied %d orders with mismatches %v", report.Orders, report.Mismatches)
+	}
+	dstDAO := dao.NewShardedOrderDAO(dst)
+	for userId, orderId := range orderIds {
+		got, err := dstDAO.GetOrderById(ctx, orderId)
+		if err != nil {
+			t.Fatalf("order of user %d: %v", userId, err)
+		}
+		listed, err := dstDAO.ListOrders(ctx, &dao.ListOrderQuery{UserId: userId})
+		if err != nil || len(listed) != 1 || listed[0].OrderId != got.OrderId {
+			t.Fatalf("listed %d orders of user %d: %v", len(listed), userId, err)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// ErrSagaLeased 流程正在被另一个调用执行，或者租约已经被其他调用取得
+var ErrSagaLeased = errors.New("saga is being run by another caller")
+
+// SagaDAOInterface 下单流程状态的读写，流程状态不分片，保存在默认库中
+type SagaDAOInterface interface {
+	CreateSaga(ctx context.Context, saga *models.OrderSaga) error
+	// 保存流程的当前状态，整行覆盖，租约已经不属于 saga.LeaseOwner 时返回 ErrSagaLeased
+	SaveSaga(ctx context.Context, saga *models.OrderSaga) error
+	GetSaga(ctx context.Context, sagaId string) (*models.OrderSaga, error)
+	// 没有租约或租约过期时为 owner 取得未结束流程的租约，返回最新的流程状态；
+	// 流程正在被其他调用执行时返回 ErrSagaLeased，已经结束的流程不取得租约直接返回
+	AcquireSaga(ctx context.Context, sagaId, owner string, lease time.Duration) (*models.OrderSaga, error)
+	// before 之前就没有再更新过且没有租约的未结束流程，说明执行流程的进程已经退出，需要继续执行
+	ListUnfinishedSagas(ctx context.Context, before time.Time, limit int) ([]*models.OrderSaga, error)
+	// 删除用户已经结束的流程，流程中保存了下单请求，删除用户数据时调用
+	DeleteUserSagas(ctx context.Context, userId int64) (int64, error)
+}
+
+type SagaDAO struct {
+	db *gorm.DB
+}
+
+func NewSagaDAO(db *gorm.DB) SagaDAOInterface {
+	return &SagaDAO{db: db}
+}
+
+// Migrate 如果没有表则创建，已有的表会补上新增的字段
+func (s *SagaDAO) Migrate() error {
+	return s.db.AutoMigrate(&models.OrderSaga{})
+}
+
+func (s *SagaDAO) CreateSaga(ctx context.Context, saga *models.OrderSaga) error {
+	return s.db.WithContext(ctx).Create(saga).Error
+}
+
+// SaveSaga 按租约的持有者条件更新，租约被其他调用取得后这次调用的写入不会生效
+func (s *SagaDAO) SaveSaga(ctx context.Context, saga *models.OrderSaga) error {
+	result := s.db.WithContext(ctx).Model(saga).Where("lease_owner = ?", saga.LeaseOwner).Select("*").Updates(saga)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSagaLeased
+	}
+	return nil
+}
+
+func (s *SagaDAO) GetSaga(ctx context.Context, sagaId string) (*models.OrderSaga, error) {
+	saga := &models.OrderSaga{}
+	result := s.db.WithContext(ctx).Where("saga_id = ?", sagaId).First(saga)
+	return saga, result.Error
+}
+
+func (s *SagaDAO) AcquireSaga(ctx context.Context, sagaId, owner string, lease time.Duration) (*models.OrderSaga, error) {
+	now := time.Now()
+	result := s.db.WithContext(ctx).Model(&models.OrderSaga{}).
+		Where("saga_id = ? AND status IN ? AND (lease_until IS NULL OR lease_until < ?)", sagaId, []string{models.SagaRunning, models.SagaCompensating}, now).
+		Updates(map[string]interface{}{"lease_owner": owner, "lease_until": now.Add(lease)})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	saga, err := s.GetSaga(ctx, sagaId)
+	if err != nil {
+		return nil, err
+	}
+	if result.RowsAffected == 0 && !models.SagaFinished(saga.Status) {
+		return saga, ErrSagaLeased
+	}
+	return saga, nil
+}
+
+func (s *SagaDAO) ListUnfinishedSagas(ctx context.Context, before time.Time, limit int) ([]*models.OrderSaga, error) {
+	sagas := []*models.OrderSaga{}
+	result := s.db.WithContext(ctx).
+		Where("status IN ? AND updated_at < ?", []string{models.SagaRunning, models.SagaCompensating}, before).
+		Where("lease_until IS NULL OR lease_until < ?", time.Now()).
+		Order("id").
+		Limit(limit).
+		Find(&sagas)
+	return sagas, result.Error
+}
+
+func (s *SagaDAO) DeleteUserSagas(ctx context.Context, userId int64) (int64, error) {
+	result := s.db.WithContext(ctx).
+		Where("user_id = ? AND status IN ?", userId, []string{models.SagaCompleted, models.SagaCompensated}).
+		Delete(&models.OrderSaga{})
+	return result.RowsAffected, result.Error
+}
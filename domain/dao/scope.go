@@ -0,0 +1,30 @@
+package dao
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type withDeletedKey struct{}
+
+// WithDeleted 查询时包含已软删除的订单，默认不包含
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withDeletedKey{}, true)
+}
+
+// IncludeDeleted context 中是否要求包含已软删除的订单
+func IncludeDeleted(ctx context.Context) bool {
+	include, _ := ctx.Value(withDeletedKey{}).(bool)
+	return include
+}
+
+// readScope 读订单时按context 决定是否包含软删除的记录
+func readScope(ctx context.Context) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if IncludeDeleted(ctx) {
+			return db.Unscoped()
+		}
+		return db
+	}
+}
@@ -0,0 +1,207 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// OrderSearchQuery 客服查询订单的条件，全部条件同时满足，结果按创建时间倒序
+type OrderSearchQuery struct {
+	// Text 订单号的片段
+	Text   string
+	UserId int64
+	// SKUId 包含这个商品的订单
+	SKUId         int64
+	Status        *int8
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Offset        int
+	Limit         int
+}
+
+func (q *OrderSearchQuery) limit() int {
+	if q.Limit <= 0 || q.Limit > MaxListLimit {
+		return MaxListLimit
+	}
+	return q.Limit
+}
+
+// DayCount 一天创建的订单数，Day 形如 2024-01-02
+type DayCount struct {
+	Day   string
+	Count int64
+}
+
+// OrderSearchResult 当前页的订单号和全部符合条件的订单的分面统计
+type OrderSearchResult struct {
+	Total    int64
+	OrderIds []string
+	// StatusCounts 每个状态的订单数
+	StatusCounts map[int8]int64
+	// DayCounts 每天创建的订单数，按日期升序
+	DayCounts []DayCount
+}
+
+// ErrSearchNotSupported 当前的存储不支持这个搜索条件
+var ErrSearchNotSupported = errors.New("search condition is not supported by this storage")
+
+// SearchOrderDAO 直接在订单表上搜索，MySQL 按订单号的 FULLTEXT 索引匹配，其他数据库使用 LIKE
+type SearchOrderDAO interface {
+	SearchOrders(ctx context.Context, query *OrderSearchQuery) (*OrderSearchResult, error)
+	// MigrateSearch 创建搜索使用的索引
+	MigrateSearch() error
+}
+
+// fulltextIndex orders 表上订单号的全文索引
+const fulltextIndex = "idx_order_id_fulltext"
+
+// fulltextTerm 可以交给 FULLTEXT 匹配的订单号片段：只包含字母、数字和连字符，每段不少于 innodb_ft_min_token_size 默认的3个字符
+var fulltextTerm = regexp.MustCompile(`^[0-9A-Za-z]{3,}(-[0-9A-Za-z]{3,})*$`)
+
+func (o *OrderDAO) MigrateSearch() error {
+	if o.db.Dialector.Name() != "mysql" || o.db.Migrator().HasIndex(o.tables.Orders, fulltextIndex) {
+		return nil
+	}
+	return o.db.Exec(fmt.Sprintf("CREATE FULLTEXT INDEX %s ON %s (order_id)", fulltextIndex, o.tables.Orders)).Error
+}
+
+// matchText 全文索引按连字符把订单号切成多段，片段的每一段都作为前缀匹配；
+// 不能使用全文索引时按子串匹配
+func (o *OrderDAO) matchText(db *gorm.DB, text string) *gorm.DB {
+	if o.db.Dialector.Name() == "mysql" && fulltextTerm.MatchString(text) {
+		terms := []string{}
+		for _, segment := range strings.Split(text, "-") {
+			terms = append(terms, "+"+segment+"*")
+		}
+		return db.Where("MATCH(order_id) AGAINST (? IN BOOLEAN MODE)", strings.Join(terms, " "))
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(text)
+	return db.Where(`order_id LIKE ? ESCAPE '\'`, "%"+escaped+"%")
+}
+
+// searchScope 按条件过滤订单表，每次调用返回新的查询
+func (o *OrderDAO) searchScope(ctx context.Context, query *OrderSearchQuery) *gorm.DB {
+	db := o.db.WithContext(ctx).Model(&models.Order{}).Table(o.tables.Orders).Scopes(readScope(ctx))
+	if query.Text != "" {
+		db = o.matchText(db, query.Text)
+	}
+	if query.SKUId != 0 {
+		db = db.Where(fmt.Sprintf("order_id IN (SELECT order_id FROM %s WHERE sku_id = ?)", o.tables.Items), query.SKUId)
+	}
+	return (&ListOrderQuery{
+		UserId:        query.UserId,
+		Status:        query.Status,
+		CreatedAfter:  query.CreatedAfter,
+		CreatedBefore: query.CreatedBefore,
+	}).apply(db)
+}
+
+// SearchOrders 一次查询当前页，两次分组查询得到按状态和按天的分面，分面的和就是总数
+func (o *OrderDAO) SearchOrders(ctx context.Context, query *OrderSearchQuery) (*OrderSearchResult, error) {
+	return o.search(ctx, query, query.Offset, query.limit())
+}
+
+func (o *OrderDAO) search(ctx context.Context, query *OrderSearchQuery, offset, limit int) (*OrderSearchResult, error) {
+	result := &OrderSearchResult{OrderIds: []string{}, StatusCounts: map[int8]int64{}, DayCounts: []DayCount{}}
+	if err := o.searchScope(ctx, query).Order("created_at desc, id desc").
+		Offset(offset).Limit(limit).Pluck("order_id", &result.OrderIds).Error; err != nil {
+		return nil, err
+	}
+
+	statuses := []struct {
+		Status int8
+		Count  int64
+	}{}
+	if err := o.searchScope(ctx, query).Select("status, COUNT(*) AS count").Group("status").Scan(&statuses).Error; err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		result.StatusCounts[status.Status] = status.Count
+		result.Total += status.Count
+	}
+
+	days := []DayCount{}
+	if err := o.searchScope(ctx, query).Select("DATE(created_at) AS day, COUNT(*) AS count").Group("day").Order("day").Scan(&days).Error; err != nil {
+		return nil, err
+	}
+	for _, day := range days {
+		// MySQL 开启 parseTime 时日期会被格式化成完整的时间
+		if len(day.Day) > 10 {
+			day.Day = day.Day[:10]
+		}
+		result.DayCounts = append(result.DayCounts, day)
+	}
+	return result, nil
+}
+
+func (o *ShardedOrderDAO) MigrateSearch() error {
+	for _, shard := range o.layout.Shards() {
+		if err := shard.dao.MigrateSearch(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchOrders 指定用户时只查一个分片，否则每个分片取 offset+limit 条后按创建时间归并，分面按分片累加
+func (o *ShardedOrderDAO) SearchOrders(ctx context.Context, query *OrderSearchQuery) (*OrderSearchResult, error) {
+	if query.UserId != 0 {
+		return o.layout.Locate(SlotOfUser(query.UserId)).dao.SearchOrders(ctx, query)
+	}
+	window := query.Offset + query.limit()
+	merged := &OrderSearchResult{OrderIds: []string{}, StatusCounts: map[int8]int64{}, DayCounts: []DayCount{}}
+	orders := []*models.Order{}
+	days := map[string]int64{}
+	for _, shard := range o.layout.Shards() {
+		result, err := shard.dao.search(ctx, query, 0, window)
+		if err != nil {
+			return nil, err
+		}
+		merged.Total += result.Total
+		for status, count := range result.StatusCounts {
+			merged.StatusCounts[status] += count
+		}
+		for _, day := range result.DayCounts {
+			days[day.Day] += day.Count
+		}
+		page, err := shard.dao.GetOrdersByIds(ctx, result.OrderIds)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, page...)
+	}
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].OrderId > orders[j].OrderId
+		}
+		return orders[i].CreatedAt.After(orders[j].CreatedAt)
+	})
+	for i := query.Offset; i < len(orders) && i < window; i++ {
+		merged.OrderIds = append(merged.OrderIds, orders[i].OrderId)
+	}
+	for day, count := range days {
+		merged.DayCounts = append(merged.DayCounts, DayCount{Day: day, Count: count})
+	}
+	sort.Slice(merged.DayCounts, func(i, j int) bool { return merged.DayCounts[i].Day < merged.DayCounts[j].Day })
+	return merged, nil
+}
+
+func (e *EventSourcedOrderDAO) MigrateSearch() error {
+	return e.projection.MigrateSearch()
+}
+
+// SearchOrders 在投影上搜索，订单项只在事件中，不支持按商品搜索
+func (e *EventSourcedOrderDAO) SearchOrders(ctx context.Context, query *OrderSearchQuery) (*OrderSearchResult, error) {
+	if query.SKUId != 0 {
+		return nil, ErrSearchNotSupported
+	}
+	return e.projection.SearchOrders(ctx, query)
+}
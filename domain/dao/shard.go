@@ -0,0 +1,400 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/utils"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+// ShardSlots 逻辑槽位数量，固定不变，必须是2的幂，重新分片按位与计算槽位
+// 订单号里编码的是槽位而不是物理表，扩容时只需要把槽位迁移到新的物理表，订单号不用改
+const ShardSlots = 1024
+
+// SlotOfUser 用户所在的槽位，按无符号数取模，负数和 math.MinInt64 也落在 [0, ShardSlots) 内
+func SlotOfUser(userId int64) int {
+	return int(uint64(userId) % ShardSlots)
+}
+
+// ShardOrderId 生成携带槽位的订单号，格式为 "0042-<uuid>"
+func ShardOrderId(slot int, uuid string) string {
+	return fmt.Sprintf("%04d-%s", slot, uuid)
+}
+
+// SlotOfOrderId 从订单号中解析槽位，历史的纯uuid订单号返回false
+func SlotOfOrderId(orderId string) (int, bool) {
+	// uuid 的第一段是8位，所以第5位是'-'的一定是分片订单号
+	if len(orderId) < 6 || orderId[4] != '-' {
+		return 0, false
+	}
+	slot, err := strconv.Atoi(orderId[:4])
+	if err != nil || slot < 0 || slot >= ShardSlots {
+		return 0, false
+	}
+	return slot, true
+}
+
+// PrefixOrderId 给订单号加上租户前缀，分片订单号的槽位仍然在最前面，形如 0042-acme-<uuid>
+func PrefixOrderId(orderId, prefix string) string {
+	if _, ok := SlotOfOrderId(orderId); ok {
+		return orderId[:5] + prefix + "-" + orderId[5:]
+	}
+	return prefix + "-" + orderId
+}
+
+// Shard 一个物理分片：某个库里的一组订单相关的表
+type Shard struct {
+	Index    int
+	DB       *gorm.DB
+	Location string // host:port/db，用于判断两个布局是否指向同一个物理库
+	dao      *OrderDAO
+}
+
+func (s *Shard) OrderTable() string {
+	return s.dao.tables.Orders
+}
+
+func (s *Shard) Orders() *gorm.DB {
+	return s.DB.Table(s.dao.tables.Orders)
+}
+
+func (s *Shard) Items() *gorm.DB {
+	return s.DB.Table(s.dao.tables.Items)
+}
+
+func (s *Shard) History() *gorm.DB {
+	return s.DB.Table(s.dao.tables.History)
+}
+
+func (s *Shard) Refunds() *gorm.DB {
+	return s.DB.Table(s.dao.tables.Refunds)
+}
+
+// SameTable 两个分片是否是同一张物理表
+func (s *Shard) SameTable(other *Shard) bool {
+	if s.OrderTable() != other.OrderTable() {
+		return false
+	}
+	return s.DB == other.DB || (s.Location != "" && s.Location == other.Location)
+}
+
+// ShardLayout 分片布局：N个库，每个库 TablesPerDB 组表，槽位按取模分配到表
+type ShardLayout struct {
+	shards      []*Shard
+	tablesPerDB int
+}
+
+func NewShardLayout(dbs []*gorm.DB, tablesPerDB int) (*ShardLayout, error) {
+	if len(dbs) == 0 {
+		return nil, errors.New("shard layout needs at least one database")
+	}
+	if tablesPerDB <= 0 {
+		tablesPerDB = 1
+	}
+	if len(dbs)*tablesPerDB > ShardSlots {
+		return nil, fmt.Errorf("shard layout has more tables than slots(%d)", ShardSlots)
+	}
+	layout := &ShardLayout{tablesPerDB: tablesPerDB}
+	for i, db := range dbs {
+		for j := 0; j < tablesPerDB; j++ {
+			index := i*tablesPerDB + j
+			layout.shards = append(layout.shards, &Shard{
+				Index: index,
+				DB:    db,
+				dao: &OrderDAO{
+					db: db,
+					tables: tableSet{
+						Orders:       fmt.Sprintf("orders_%04d", index),
+						Items:        fmt.Sprintf("order_items_%04d", index),
+						History:      fmt.Sprintf("order_history_%04d", index),
+						Refunds:      fmt.Sprintf("order_refunds_%04d", index),
+						Payments:     fmt.Sprintf("order_payments_%04d", index),
+						Fulfillments: fmt.Sprintf("order_fulfillments_%04d", index),
+					},
+				},
+			})
+		}
+	}
+	return layout, nil
+}
+
+// Shards 返回全部物理分片
+func (l *ShardLayout) Shards() []*Shard {
+	return l.shards
+}
+
+// Locate 槽位对应的物理分片
+func (l *ShardLayout) Locate(slot int) *Shard {
+	return l.shards[slot%len(l.shards)]
+}
+
+// Migrate 为每个分片建表，和单表模式一样只在表不存在时创建
+func (l *ShardLayout) Migrate() error {
+	for _, shard := range l.shards {
+		if err := shard.dao.Migrate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ShardedOrderDAO 按UserId分片的订单DAO，路由到分片后交给分片上的OrderDAO 处理
+type ShardedOrderDAO struct {
+	layout *ShardLayout
+}
+
+func NewShardedOrderDAO(layout *ShardLayout) OrderDAOInterface {
+	return &ShardedOrderDAO{
+		layout: layout,
+	}
+}
+
+// NewOrderId 生成携带用户槽位的订单号
+func (o *ShardedOrderDAO) NewOrderId(userId int64) string {
+	return ShardOrderId(SlotOfUser(userId), utils.UUID())
+}
+
+// shardOfOrder 先按订单号里的槽位路由，历史订单号没有槽位时按用户路由
+func (o *ShardedOrderDAO) shardOfOrder(orderId string, userId int64) *Shard {
+	if slot, ok := SlotOfOrderId(orderId); ok {
+		return o.layout.Locate(slot)
+	}
+	return o.layout.Locate(SlotOfUser(userId))
+}
+
+// findShard 历史订单号没有槽位信息，只能逐个分片查找
+func (o *ShardedOrderDAO) findShard(ctx context.Context, orderId string) (*Shard, error) {
+	if slot, ok := SlotOfOrderId(orderId); ok {
+		return o.layout.Locate(slot), nil
+	}
+	for _, shard := range o.layout.Shards() {
+		var count int64
+		if err := shard.Orders().WithContext(ctx).Unscoped().Where("order_id = ?", orderId).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return shard, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (o *ShardedOrderDAO) CreateOrder(ctx context.Context, order *models.Order) (int64, error) {
+	slot := SlotOfUser(order.UserId)
+	if orderSlot, ok := SlotOfOrderId(order.OrderId); ok && orderSlot != slot {
+		return 0, fmt.Errorf("order id %s belongs to slot %d, but user %d is in slot %d", order.OrderId, orderSlot, order.UserId, slot)
+	}
+	return o.layout.Locate(slot).dao.CreateOrder(ctx, order)
+}
+
+func (o *ShardedOrderDAO) UpdateOrder(ctx context.Context, order *models.Order, oldversion int64) (int64, error) {
+	return o.shardOfOrder(order.OrderId, order.UserId).dao.UpdateOrder(ctx, order, oldversion)
+}
+
+func (o *ShardedOrderDAO) GetOrderById(ctx context.Context, orderId string) (*models.Order, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return &models.Order{}, err
+	}
+	return shard.dao.GetOrderById(ctx, orderId)
+}
+
+// GetOrdersByIds 按订单号中的槽位分组后每个分片查询一次，历史订单号没有槽位信息，在每个分片都查一次
+func (o *ShardedOrderDAO) GetOrdersByIds(ctx context.Context, orderIds []string) ([]*models.Order, error) {
+	groups := map[*Shard][]string{}
+	legacy := []string{}
+	for _, orderId := range orderIds {
+		if slot, ok := SlotOfOrderId(orderId); ok {
+			shard := o.layout.Locate(slot)
+			groups[shard] = append(groups[shard], orderId)
+			continue
+		}
+		legacy = append(legacy, orderId)
+	}
+	orders := []*models.Order{}
+	for _, shard := range o.layout.Shards() {
+		ids := append(groups[shard], legacy...)
+		if len(ids) == 0 {
+			continue
+		}
+		found, err := shard.dao.GetOrdersByIds(ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, found...)
+	}
+	return orders, nil
+}
+
+func (o *ShardedOrderDAO) CreateOrderItem(ctx context.Context, orderItem *models.OrderItem) (int64, error) {
+	return o.layout.Locate(SlotOfUser(orderItem.UserId)).dao.CreateOrderItem(ctx, orderItem)
+}
+
+// ListOrders 指定用户时只查一个分片，否则每个分片取 offset+limit 条后归并
+func (o *ShardedOrderDAO) ListOrders(ctx context.Context, query *ListOrderQuery) ([]*models.Order, error) {
+	if query.UserId != 0 {
+		return o.layout.Locate(SlotOfUser(query.UserId)).dao.ListOrders(ctx, query)
+	}
+
+	window := query.Offset + query.limit()
+	shardQuery := *query
+	shardQuery.Offset, shardQuery.Limit, shardQuery.unbounded = 0, window, true
+	merged := []*models.Order{}
+	for _, shard := range o.layout.Shards() {
+		orders, err := shard.dao.ListOrders(ctx, &shardQuery)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, orders...)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].CreatedAt.Equal(merged[j].CreatedAt) {
+			return merged[i].OrderId > merged[j].OrderId
+		}
+		return merged[i].CreatedAt.After(merged[j].CreatedAt)
+	})
+	if query.Offset >= len(merged) {
+		return []*models.Order{}, nil
+	}
+	end := window
+	if end > len(merged) {
+		end = len(merged)
+	}
+	return merged[query.Offset:end], nil
+}
+
+func (o *ShardedOrderDAO) GetOrderHistory(ctx context.Context, orderId string) ([]*models.OrderHistory, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.GetOrderHistory(ctx, orderId)
+}
+
+func (o *ShardedOrderDAO) DeleteOrder(ctx context.Context, orderId string, oldversion int64) (int64, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return 0, err
+	}
+	return shard.dao.DeleteOrder(ctx, orderId, oldversion)
+}
+
+func (o *ShardedOrderDAO) RestoreOrder(ctx context.Context, orderId string) (int64, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return 0, err
+	}
+	return shard.dao.RestoreOrder(ctx, orderId)
+}
+
+func (o *ShardedOrderDAO) PurgeOrder(ctx context.Context, orderId string) (int64, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return 0, err
+	}
+	return shard.dao.PurgeOrder(ctx, orderId)
+}
+
+func (o *ShardedOrderDAO) GetOrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.GetOrderItems(ctx, orderId)
+}
+
+func (o *ShardedOrderDAO) RefundOrder(ctx context.Context, refund *models.OrderRefund, lines []models.RefundLine) (*models.Order, error) {
+	shard, err := o.findShard(ctx, refund.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.RefundOrder(ctx, refund, lines)
+}
+
+func (o *ShardedOrderDAO) ListRefunds(ctx context.Context, orderId string) ([]*models.OrderRefund, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.ListRefunds(ctx, orderId)
+}
+
+func (o *ShardedOrderDAO) ConfirmPayment(ctx context.Context, payment *models.OrderPayment) (*models.Order, error) {
+	shard, err := o.findShard(ctx, payment.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.ConfirmPayment(ctx, payment)
+}
+
+func (o *ShardedOrderDAO) ShipOrder(ctx context.Context, fulfillment *models.OrderFulfillment) (*models.Order, error) {
+	shard, err := o.findShard(ctx, fulfillment.OrderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.ShipOrder(ctx, fulfillment)
+}
+
+func (o *ShardedOrderDAO) MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*models.Order, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.MarkDelivered(ctx, orderId, deliveredAt)
+}
+
+func (o *ShardedOrderDAO) GetFulfillment(ctx context.Context, orderId string) (*models.OrderFulfillment, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.GetFulfillment(ctx, orderId)
+}
+
+func (o *ShardedOrderDAO) AnonymizeOrder(ctx context.Context, orderId string) (*models.Order, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.AnonymizeOrder(ctx, orderId)
+}
+
+func (o *ShardedOrderDAO) EraseOrder(ctx context.Context, orderId string) (int64, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return 0, err
+	}
+	return shard.dao.EraseOrder(ctx, orderId)
+}
+
+// OpenShardLayout 按配置连接分片库并建表
+func OpenShardLayout(cfg *conf.ShardConfig) (*ShardLayout, error) {
+	dbs := []*gorm.DB{}
+	for i := range cfg.Databases {
+		db, err := gorm.Open(mysql.Open(cfg.Databases[i].DSN()), &gorm.Config{})
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Use(TenantPlugin{}); err != nil {
+			return nil, err
+		}
+		dbs = append(dbs, db)
+	}
+	layout, err := NewShardLayout(dbs, cfg.TablesPerDB)
+	if err != nil {
+		return nil, err
+	}
+	for _, shard := range layout.Shards() {
+		db := cfg.Databases[shard.Index/layout.tablesPerDB]
+		shard.Location = fmt.Sprintf("%s:%d/%s", db.Host, db.Port, db.DB)
+	}
+	return layout, layout.Migrate()
+}
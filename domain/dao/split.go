@@ -0,0 +1,264 @@
+package dao
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sortedIds 被合并的订单按订单号的顺序加锁，并发的合单不会互相死锁
+func sortedIds(versions map[string]int64) []string {
+	ids := make([]string, 0, len(versions))
+	for orderId := range versions {
+		ids = append(ids, orderId)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (o *OrderDAO) SplitOrder(ctx context.Context, orderId string, oldversion int64, subOrderIds []string, groups [][]models.SplitLine) ([]*models.Order, error) {
+	var children []*models.Order
+	_, err := o.transition(ctx, orderId, func(tx *gorm.DB, old *models.Order) (map[string]interface{}, error) {
+		if old.OrderVersion != oldversion {
+			return nil, ErrVersionConflict
+		}
+		items := []models.OrderItem{}
+		if err := tx.Table(o.tables.Items).Where("order_id = ?", orderId).Order("id").Find(&items).Error; err != nil {
+			return nil, err
+		}
+		var err error
+		if children, err = models.SplitOrder(old, items, subOrderIds, groups); err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			if _, err := o.insert(tx, child); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{"sub_order_count": len(children)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+func (o *OrderDAO) ListSubOrders(ctx context.Context, parentId string) ([]*models.Order, error) {
+	orders := []*models.Order{}
+	result := o.db.WithContext(ctx).Table(o.tables.Orders).Scopes(readScope(ctx)).
+		Where("parent_order_id = ?", parentId).Order("id").Find(&orders)
+	return orders, result.Error
+}
+
+// SyncParentStatus 锁住父订单后读出未删除的子订单，汇总的状态和父订单相同时不修改
+func (o *OrderDAO) SyncParentStatus(ctx context.Context, parentId string) (*models.Order, error) {
+	return o.transition(ctx, parentId, func(tx *gorm.DB, old *models.Order) (map[string]interface{}, error) {
+		if old.SubOrderCount == 0 {
+			return nil, models.ErrInvalidTransition
+		}
+		children := []*models.Order{}
+		if err := tx.Table(o.tables.Orders).Where("parent_order_id = ?", parentId).Find(&children).Error; err != nil {
+			return nil, err
+		}
+		status := models.ParentStatus(children)
+		if status == old.Status {
+			return nil, nil
+		}
+		return map[string]interface{}{"status": status}, nil
+	})
+}
+
+// MergeOrders 按订单号的顺序锁住被合并的订单，检查版本号和状态后创建合并后的订单，
+// 被合并的订单改为已取消并指向合并后的订单，各自写入版本记录
+func (o *OrderDAO) MergeOrders(ctx context.Context, merged *models.Order, versions map[string]int64) error {
+	stampTenant(ctx, merged)
+	ids := sortedIds(versions)
+	err := o.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		sources := []*models.Order{}
+		if err := tx.Table(o.tables.Orders).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("order_id IN ?", ids).Order("order_id").Find(&sources).Error; err != nil {
+			return err
+		}
+		if len(sources) != len(ids) {
+			return gorm.ErrRecordNotFound
+		}
+		for _, source := range sources {
+			if source.OrderVersion != versions[source.OrderId] {
+				return ErrVersionConflict
+			}
+		}
+		if err := models.CheckMerge(merged, sources); err != nil {
+			return err
+		}
+		if _, err := o.insert(tx, merged); err != nil {
+			return err
+		}
+		for _, orderId := range ids {
+			if _, err := o.transitionTx(tx, orderId, func(tx *gorm.DB, old *models.Order) (map[string]interface{}, error) {
+				return map[string]interface{}{"status": models.StatusCancelled, "merged_into": merged.OrderId}, nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
+
+// checkShard 新的订单号需要带有槽位并且落在 shard 上，之后才能按订单号路由到同一个分片
+func (o *ShardedOrderDAO) checkShard(shard *Shard, orderIds ...string) error {
+	for _, orderId := range orderIds {
+		slot, ok := SlotOfOrderId(orderId)
+		if !ok || o.layout.Locate(slot) != shard {
+			return fmt.Errorf("order id %s is not on shard %d", orderId, shard.Index)
+		}
+	}
+	return nil
+}
+
+// SplitOrder 子订单和原订单写在同一个分片的同一个事务里，子订单号需要是原订单用户的槽位
+func (o *ShardedOrderDAO) SplitOrder(ctx context.Context, orderId string, oldversion int64, subOrderIds []string, groups [][]models.SplitLine) ([]*models.Order, error) {
+	shard, err := o.findShard(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkShard(shard, subOrderIds...); err != nil {
+		return nil, err
+	}
+	return shard.dao.SplitOrder(ctx, orderId, oldversion, subOrderIds, groups)
+}
+
+func (o *ShardedOrderDAO) ListSubOrders(ctx context.Context, parentId string) ([]*models.Order, error) {
+	shard, err := o.findShard(ctx, parentId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.ListSubOrders(ctx, parentId)
+}
+
+func (o *ShardedOrderDAO) SyncParentStatus(ctx context.Context, parentId string) (*models.Order, error) {
+	shard, err := o.findShard(ctx, parentId)
+	if err != nil {
+		return nil, err
+	}
+	return shard.dao.SyncParentStatus(ctx, parentId)
+}
+
+// MergeOrders 同一个用户的订单都在用户的槽位所在的分片上，历史订单号不在这个分片时不能合并
+func (o *ShardedOrderDAO) MergeOrders(ctx context.Context, merged *models.Order, versions map[string]int64) error {
+	shard := o.layout.Locate(SlotOfUser(merged.UserId))
+	if err := o.checkShard(shard, merged.OrderId); err != nil {
+		return err
+	}
+	for orderId := range versions {
+		found, err := o.findShard(ctx, orderId)
+		if err != nil {
+			return err
+		}
+		if found != shard {
+			return fmt.Errorf("%w: order %s is on another shard", models.ErrInvalidMerge, orderId)
+		}
+	}
+	return shard.dao.MergeOrders(ctx, merged, versions)
+}
+
+// SplitOrder 父订单追加 Split 事件，子订单各自追加 Created 和 ItemAdded 事件，都在同一个事务里
+func (e *EventSourcedOrderDAO) SplitOrder(ctx context.Context, orderId string, oldversion int64, subOrderIds []string, groups [][]models.SplitLine) ([]*models.Order, error) {
+	var children []*models.Order
+	_, err := e.transition(ctx, orderId, func(tx *gorm.DB, aggregate *models.OrderAggregate) (string, interface{}, error) {
+		if aggregate.Order.OrderVersion != oldversion {
+			return "", nil, ErrVersionConflict
+		}
+		var err error
+		if children, err = models.SplitOrder(&aggregate.Order, aggregate.Items, subOrderIds, groups); err != nil {
+			return "", nil, err
+		}
+		for _, child := range children {
+			if err := e.insert(tx, child); err != nil {
+				return "", nil, err
+			}
+		}
+		return models.EventSplit, &models.SplitPayload{SubOrderIds: subOrderIds}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return children, nil
+}
+
+func (e *EventSourcedOrderDAO) ListSubOrders(ctx context.Context, parentId string) ([]*models.Order, error) {
+	return e.projection.ListSubOrders(ctx, parentId)
+}
+
+// SyncParentStatus 子订单的状态从投影中读出，汇总的状态变化时追加 StatusDerived 事件
+func (e *EventSourcedOrderDAO) SyncParentStatus(ctx context.Context, parentId string) (*models.Order, error) {
+	return e.transition(ctx, parentId, func(tx *gorm.DB, aggregate *models.OrderAggregate) (string, interface{}, error) {
+		if aggregate.Order.SubOrderCount == 0 {
+			return "", nil, models.ErrInvalidTransition
+		}
+		children := []*models.Order{}
+		if err := tx.Table(e.projection.tables.Orders).Where("parent_order_id = ?", parentId).Find(&children).Error; err != nil {
+			return "", nil, err
+		}
+		status := models.ParentStatus(children)
+		if status == aggregate.Order.Status {
+			return "", nil, nil
+		}
+		return models.EventStatusDerived, &models.StatusPayload{Status: status}, nil
+	})
+}
+
+// MergeOrders 合并后的订单追加 Created 和 ItemAdded 事件，被合并的订单各自追加 Merged 事件
+func (e *EventSourcedOrderDAO) MergeOrders(ctx context.Context, merged *models.Order, versions map[string]int64) error {
+	stampTenant(ctx, merged)
+	err := e.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		aggregates := []*models.OrderAggregate{}
+		sources := []*models.Order{}
+		for _, orderId := range sortedIds(versions) {
+			aggregate, err := e.load(tx, orderId)
+			if err != nil {
+				return err
+			}
+			if aggregate.Order.OrderVersion != versions[orderId] {
+				return ErrVersionConflict
+			}
+			aggregates = append(aggregates, aggregate)
+			sources = append(sources, &aggregate.Order)
+		}
+		if err := models.CheckMerge(merged, sources); err != nil {
+			return err
+		}
+		if err := e.insert(tx, merged); err != nil {
+			return err
+		}
+		for _, aggregate := range aggregates {
+			oldversion := aggregate.Order.OrderVersion
+			next := aggregate.Order
+			next.OrderVersion++
+			event, err := models.NewOrderEvent(&next, models.EventMerged, &models.MergedPayload{MergedInto: merged.OrderId})
+			if err != nil {
+				return err
+			}
+			if err := e.append(tx, aggregate, event); err != nil {
+				return err
+			}
+			if err := e.project(tx, aggregate, oldversion); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	return nil
+}
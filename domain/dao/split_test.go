@@ -0,0 +1,167 @@
+package dao_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/utils"
+)
+
+// createPaidOrder 两种商品共500分的已支付订单
+func createPaidOrder(t testing.TB, orderDAO dao.OrderDAOInterface, userId int64) *models.Order {
+	t.Helper()
+	ctx := context.Background()
+	order := &models.Order{
+		OrderId:      utils.UUID(),
+		OrderVersion: 1,
+		UserId:       userId,
+		TotalAmount:  500,
+		Currency:     "CNY",
+		Items:        []models.OrderItem{{SKUId: 1, Count: 3, Price: 100}, {SKUId: 2, Count: 1, Price: 200}},
+	}
+	if _, err := orderDAO.CreateOrder(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	paid, err := orderDAO.ConfirmPayment(ctx, &models.OrderPayment{PaymentId: utils.UUID(), OrderId: order.OrderId, Amount: 500, Currency: "CNY"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paid
+}
+
+func TestSplitOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		unpaid  bool
+		groups  [][]models.SplitLine
+		wantErr error
+	}{
+		{name: "two warehouses", groups: [][]models.SplitLine{{{SKUId: 1, Count: 2}}, {{SKUId: 1, Count: 1}, {SKUId: 2, Count: 1}}}},
+		{name: "missing items", groups: [][]models.SplitLine{{{SKUId: 1, Count: 2}}, {{SKUId: 2, Count: 1}}}, wantErr: models.ErrInvalidSplit},
+		{name: "too many items", groups: [][]models.SplitLine{{{SKUId: 1, Count: 4}}, {{SKUId: 2, Count: 1}}}, wantErr: models.ErrInvalidSplit},
+		{name: "one sub order", groups: [][]models.SplitLine{{{SKUId: 1, Count: 3}, {SKUId: 2, Count: 1}}}, wantErr: models.ErrInvalidSplit},
+		{name: "unpaid", unpaid: true, groups: [][]models.SplitLine{{{SKUId: 1, Count: 3}}, {{SKUId: 2, Count: 1}}}, wantErr: models.ErrInvalidTransition},
+	}
+	for _, mode := range persistenceModes {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				orderDAO := newOrderDAO(t, mode)
+				ctx := context.Background()
+				parent := createPaidOrder(t, orderDAO, 1)
+				if tt.unpaid {
+					parent = createOrder(t, orderDAO, 1)
+				}
+				subOrderIds := []string{}
+				for range tt.groups {
+					subOrderIds = append(subOrderIds, utils.UUID())
+				}
+
+				children, err := orderDAO.SplitOrder(ctx, parent.OrderId, parent.OrderVersion, subOrderIds, tt.groups)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if tt.wantErr != nil {
+					return
+				}
+				if children[0].TotalAmount != 200 || children[1].TotalAmount != 300 || children[1].PaidAmount != 300 {
+					t.Fatalf("sub order totals %d and %d", children[0].TotalAmount, children[1].TotalAmount)
+				}
+				listed, err := orderDAO.ListSubOrders(ctx, parent.OrderId)
+				if err != nil || len(listed) != 2 {
+					t.Fatalf("listed %d sub orders: %v", len(listed), err)
+				}
+				items, err := orderDAO.GetOrderItems(ctx, subOrderIds[1])
+				if err != nil || len(items) != 2 {
+					t.Fatalf("sub order has %d items: %v", len(items), err)
+				}
+				if _, err := orderDAO.SplitOrder(ctx, parent.OrderId, parent.OrderVersion, subOrderIds, tt.groups); !errors.Is(err, dao.ErrVersionConflict) {
+					t.Fatalf("splitting twice returned %v", err)
+				}
+
+				// 父订单不能直接发货，子订单全部发货后父订单才是已发货
+				now := time.Now()
+				if _, err := orderDAO.ShipOrder(ctx, &models.OrderFulfillment{OrderId: parent.OrderId, Carrier: "sf", TrackingNumber: "0", ShippedAt: &now}); !errors.Is(err, models.ErrInvalidTransition) {
+					t.Fatalf("shipping the parent returned %v", err)
+				}
+				for i, wantStatus := range []int8{models.StatusPaid, models.StatusShipped} {
+					if _, err := orderDAO.ShipOrder(ctx, &models.OrderFulfillment{OrderId: subOrderIds[i], Carrier: "sf", TrackingNumber: subOrderIds[i], ShippedAt: &now}); err != nil {
+						t.Fatal(err)
+					}
+					synced, err := orderDAO.SyncParentStatus(ctx, parent.OrderId)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if synced.Status != wantStatus || synced.SubOrderCount != 2 {
+						t.Fatalf("parent status %d with %d sub orders after shipping %d, want %d", synced.Status, synced.SubOrderCount, i+1, wantStatus)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestMergeOrders(t *testing.T) {
+	tests := []struct {
+		name    string
+		paid    bool
+		userId  int64
+		stale   bool
+		wantErr error
+	}{
+		{name: "same user", userId: 1},
+		{name: "paid order", userId: 1, paid: true, wantErr: models.ErrInvalidMerge},
+		{name: "other user", userId: 2, wantErr: models.ErrInvalidMerge},
+		{name: "stale version", userId: 1, stale: true, wantErr: dao.ErrVersionConflict},
+	}
+	for _, mode := range persistenceModes {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				orderDAO := newOrderDAO(t, mode)
+				ctx := context.Background()
+				first := createOrder(t, orderDAO, 1)
+				second := createOrder(t, orderDAO, tt.userId)
+				if tt.paid {
+					second = createPaidOrder(t, orderDAO, 1)
+				}
+				versions := map[string]int64{first.OrderId: first.OrderVersion, second.OrderId: second.OrderVersion}
+				if tt.stale {
+					versions[second.OrderId]--
+				}
+				merged := &models.Order{
+					OrderId:      utils.UUID(),
+					OrderVersion: 1,
+					UserId:       1,
+					TotalAmount:  600,
+					Currency:     "CNY",
+					Items:        []models.OrderItem{{SKUId: 1, Count: 6, Price: 100}},
+				}
+
+				err := orderDAO.MergeOrders(ctx, merged, versions)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				got, getErr := orderDAO.GetOrderById(ctx, first.OrderId)
+				if getErr != nil {
+					t.Fatal(getErr)
+				}
+				if tt.wantErr != nil {
+					if got.Status != models.StatusUnpaid || got.OrderVersion != 1 {
+						t.Fatalf("failed merge changed the order to status %d version %d", got.Status, got.OrderVersion)
+					}
+					return
+				}
+				if got.Status != models.StatusCancelled || got.MergedInto != merged.OrderId || got.OrderVersion != 2 {
+					t.Fatalf("merged order has status %d merged into %q version %d", got.Status, got.MergedInto, got.OrderVersion)
+				}
+				items, err := orderDAO.GetOrderItems(ctx, merged.OrderId)
+				if err != nil || len(items) != 1 || items[0].Count != 6 {
+					t.Fatalf("merged order items %v: %v", items, err)
+				}
+			})
+		}
+	}
+}
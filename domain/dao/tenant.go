@@ -0,0 +1,128 @@
+package dao
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 租户字段，带有这个字段的表都按租户隔离
+const tenantColumn = "tenant_id"
+
+// ErrTenantMismatch 写入的记录属于其他租户
+var ErrTenantMismatch = errors.New("record belongs to another tenant")
+
+type tenantKey struct{}
+
+// WithTenant 把租户放入context，之后的读写都只作用于这个租户的数据
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantId)
+}
+
+// TenantFrom 取出context 中的租户，没有时表示系统任务，可以读写全部租户的数据
+func TenantFrom(ctx context.Context) (string, bool) {
+	tenantId, ok := ctx.Value(tenantKey{}).(string)
+	return tenantId, ok
+}
+
+// TenantAllows context 中的租户是否可以读写这个租户的数据，不经过数据库的读取（比如缓存）需要自己检查
+func TenantAllows(ctx context.Context, tenantId string) bool {
+	current, ok := TenantFrom(ctx)
+	return !ok || current == tenantId
+}
+
+// stampTenant 新订单属于context 中的租户，订单项、版本记录和事件从订单上复制租户
+func stampTenant(ctx context.Context, order *models.Order) {
+	if tenantId, ok := TenantFrom(ctx); ok && order.TenantId == "" {
+		order.TenantId = tenantId
+	}
+}
+
+// TenantScope 只读写某个租户的数据
+func TenantScope(tenantId string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: tenantColumn}, Value: tenantId})
+	}
+}
+
+// TenantPlugin 在每条语句执行前按context 中的租户加上 TenantScope，创建时写入租户，
+// 更新时不允许修改租户，DAO 里的查询不需要逐个处理租户
+type TenantPlugin struct{}
+
+func (TenantPlugin) Name() string {
+	return "tenant"
+}
+
+func (TenantPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	if err := callback.Create().Before("gorm:create").Register("tenant:create", assignTenant); err != nil {
+		return err
+	}
+	if err := callback.Query().Before("gorm:query").Register("tenant:query", scopeTenant); err != nil {
+		return err
+	}
+	if err := callback.Update().Before("gorm:update").Register("tenant:update", scopeTenantUpdate); err != nil {
+		return err
+	}
+	if err := callback.Delete().Before("gorm:delete").Register("tenant:delete", scopeTenant); err != nil {
+		return err
+	}
+	return callback.Row().Before("gorm:row").Register("tenant:row", scopeTenant)
+}
+
+// hasTenant 语句对应的模型是否有租户字段
+// 按表名和map 读写的语句没有模型，不按租户隔离，DAO 中这类更新之前都会在同一个事务里按模型读出订单
+func hasTenant(db *gorm.DB) bool {
+	if db.Statement.Schema == nil {
+		return false
+	}
+	_, ok := db.Statement.Schema.FieldsByDBName[tenantColumn]
+	return ok
+}
+
+// scopeTenantUpdate 租户创建后不能修改，按结构体更新全部字段时也不会清空租户
+func scopeTenantUpdate(db *gorm.DB) {
+	if db.Error != nil || !hasTenant(db) {
+		return
+	}
+	db.Statement.Omits = append(db.Statement.Omits, tenantColumn)
+	scopeTenant(db)
+}
+
+func scopeTenant(db *gorm.DB) {
+	if db.Error != nil || !hasTenant(db) {
+		return
+	}
+	if tenantId, ok := TenantFrom(db.Statement.Context); ok {
+		TenantScope(tenantId)(db)
+	}
+}
+
+// assignTenant 没有指定租户的记录写入context 中的租户，指定了其他租户时报错
+func assignTenant(db *gorm.DB) {
+	tenantId, ok := TenantFrom(db.Statement.Context)
+	if db.Error != nil || !ok || !hasTenant(db) {
+		return
+	}
+	field := db.Statement.Schema.FieldsByDBName[tenantColumn]
+	assign := func(value reflect.Value) {
+		current, isZero := field.ValueOf(db.Statement.Context, value)
+		if isZero {
+			db.AddError(field.Set(db.Statement.Context, value, tenantId))
+		} else if current != tenantId {
+			db.AddError(ErrTenantMismatch)
+		}
+	}
+	switch value := db.Statement.ReflectValue; value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			assign(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		assign(value)
+	}
+}
@@ -0,0 +1,234 @@
+package encryption
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// 密文格式：enc:v1:<主密钥ID>:<加密后的数据密钥>:<nonce+密文>，后两段是base64
+// 没有前缀的值是启用加密之前写入的明文，读取时原样返回，重新加密时会被加密
+const prefix = "enc:v1:"
+
+// 一个数据密钥最多加密的次数，超过后生成新的数据密钥，避免随机nonce 重复
+const maxDataKeyUses = 1 << 20
+
+// 解密时缓存的数据密钥个数上限，超过时清空重新缓存
+const maxCachedKeys = 4096
+
+var ErrNoKeyring = errors.New("encrypted value but encryption is not configured")
+
+// Keyring 信封加密：每个进程生成随机的数据密钥加密字段，数据密钥由KMS 的主密钥加密后和密文存在一起
+type Keyring struct {
+	kms      KMS
+	indexKey []byte
+
+	mu      sync.Mutex
+	current *dataKey
+	// 解密过的数据密钥，key 为 主密钥ID:加密后的数据密钥
+	unwrapped map[string]cipher.AEAD
+}
+
+type dataKey struct {
+	keyId   string
+	wrapped string
+	aead    cipher.AEAD
+	uses    int
+}
+
+func NewKeyring(ctx context.Context, kms KMS) (*Keyring, error) {
+	indexKey, err := kms.IndexKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{
+		kms:       kms,
+		indexKey:  indexKey,
+		unwrapped: map[string]cipher.AEAD{},
+	}, nil
+}
+
+// dataKey 返回当前主密钥下的数据密钥，主密钥变化或者使用次数用完时重新生成
+func (k *Keyring) dataKey(ctx context.Context) (*dataKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	keyId := k.kms.PrimaryKeyId()
+	if k.current != nil && k.current.keyId == keyId && k.current.uses < maxDataKeyUses {
+		k.current.uses++
+		return k.current, nil
+	}
+	plain := make([]byte, 32)
+	if _, err := rand.Read(plain); err != nil {
+		return nil, err
+	}
+	wrapped, err := k.kms.WrapKey(ctx, keyId, plain)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(plain)
+	if err != nil {
+		return nil, err
+	}
+	k.current = &dataKey{
+		keyId:   keyId,
+		wrapped: base64.StdEncoding.EncodeToString(wrapped),
+		aead:    aead,
+		uses:    1,
+	}
+	return k.current, nil
+}
+
+// unwrap 解密数据密钥，同一个数据密钥只请求一次KMS
+func (k *Keyring) unwrap(ctx context.Context, keyId, wrapped string) (cipher.AEAD, error) {
+	cacheKey := keyId + ":" + wrapped
+	k.mu.Lock()
+	aead, ok := k.unwrapped[cacheKey]
+	k.mu.Unlock()
+	if ok {
+		return aead, nil
+	}
+	encrypted, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := k.kms.UnwrapKey(ctx, keyId, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if aead, err = newAEAD(plain); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	if len(k.unwrapped) >= maxCachedKeys {
+		k.unwrapped = map[string]cipher.AEAD{}
+	}
+	k.unwrapped[cacheKey] = aead
+	k.mu.Unlock()
+	return aead, nil
+}
+
+// Encrypt 加密一个字段，空字符串不加密
+func (k *Keyring) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	key, err := k.dataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key.aead, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return prefix + key.keyId + ":" + key.wrapped + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt 解密一个字段，明文原样返回
+func (k *Keyring) Decrypt(ctx context.Context, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 3)
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	aead, err := k.unwrap(ctx, parts[0], parts[1])
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(aead, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Stale 字段是否需要重新加密：还是明文，或者不是用当前的主密钥加密的
+func (k *Keyring) Stale(value string) bool {
+	if value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, prefix+k.kms.PrimaryKeyId()+":")
+}
+
+// BlindIndex 字段的盲索引，相同的值得到相同的索引，可以用来等值查询加密的字段
+// 不同字段使用不同的前缀，相同的值在不同字段上的索引不同
+func (k *Keyring) BlindIndex(field, value string) string {
+	return blindIndex(k.indexKey, field, value)
+}
+
+func blindIndex(key []byte, field, value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// IsEncrypted 值是否是密文
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+var (
+	defaultMu      sync.RWMutex
+	defaultKeyring *Keyring
+)
+
+// SetDefault 设置gorm 序列化器使用的Keyring，服务启动时调用一次，为空时不加密
+func SetDefault(keyring *Keyring) {
+	defaultMu.Lock()
+	defaultKeyring = keyring
+	defaultMu.Unlock()
+}
+
+func Default() *Keyring {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultKeyring
+}
+
+// Encrypt 使用默认的Keyring 加密，没有配置加密时返回明文
+func Encrypt(ctx context.Context, plaintext string) (string, error) {
+	keyring := Default()
+	if keyring == nil {
+		return plaintext, nil
+	}
+	return keyring.Encrypt(ctx, plaintext)
+}
+
+// Decrypt 使用默认的Keyring 解密，没有配置加密时遇到密文返回 ErrNoKeyring
+func Decrypt(ctx context.Context, value string) (string, error) {
+	keyring := Default()
+	if keyring == nil {
+		if IsEncrypted(value) {
+			return "", ErrNoKeyring
+		}
+		return value, nil
+	}
+	return keyring.Decrypt(ctx, value)
+}
+
+// BlindIndex 使用默认的Keyring 计算盲索引，没有配置加密时使用空的HMAC 密钥，
+// 启用加密后重新加密会按新的索引密钥重建盲索引
+func BlindIndex(field, value string) string {
+	keyring := Default()
+	if keyring == nil {
+		return blindIndex(nil, field, value)
+	}
+	return keyring.BlindIndex(field, value)
+}
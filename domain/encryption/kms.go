@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// KMS 管理主密钥，只用来加解密数据密钥，业务数据不会离开本服务
+type KMS interface {
+	// PrimaryKeyId 当前用于加密新数据密钥的主密钥
+	PrimaryKeyId() string
+	// WrapKey 用指定的主密钥加密数据密钥
+	WrapKey(ctx context.Context, keyId string, dataKey []byte) ([]byte, error)
+	// UnwrapKey 用指定的主密钥解密数据密钥，主密钥轮换后旧的主密钥仍然需要能解密
+	UnwrapKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error)
+	// IndexKey 盲索引使用的HMAC 密钥，不随主密钥轮换，更换后需要重建全部盲索引
+	IndexKey(ctx context.Context) ([]byte, error)
+}
+
+var ErrUnknownKey = errors.New("unknown master key")
+
+// KeyFile 本地密钥文件的格式，密钥都是base64 编码的32 字节
+//
+//	{"primary": "k2", "keys": {"k1": "...", "k2": "..."}, "index_key": "..."}
+type KeyFile struct {
+	Primary  string            `json:"primary"`
+	Keys     map[string]string `json:"keys"`
+	IndexKey string            `json:"index_key"`
+}
+
+// LocalKMS 从本地密钥文件读取主密钥，用于开发和测试环境，生产环境应该接入云厂商的KMS
+type LocalKMS struct {
+	primary  string
+	keys     map[string]cipher.AEAD
+	indexKey []byte
+}
+
+// NewLocalKMS 读取密钥文件，轮换主密钥时在文件中加入新的密钥并修改 primary，旧的密钥要保留到重新加密完成
+func NewLocalKMS(path string) (KMS, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keyFile := &KeyFile{}
+	if err := json.Unmarshal(data, keyFile); err != nil {
+		return nil, err
+	}
+	return NewLocalKMSFromKeyFile(keyFile)
+}
+
+func NewLocalKMSFromKeyFile(keyFile *KeyFile) (KMS, error) {
+	kms := &LocalKMS{
+		primary: keyFile.Primary,
+		keys:    map[string]cipher.AEAD{},
+	}
+	for keyId, encoded := range keyFile.Keys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %s: %v", keyId, err)
+		}
+		if kms.keys[keyId], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := kms.keys[kms.primary]; !ok {
+		return nil, fmt.Errorf("primary key %s: %v", kms.primary, ErrUnknownKey)
+	}
+	indexKey, err := decodeKey(keyFile.IndexKey)
+	if err != nil {
+		return nil, fmt.Errorf("index key: %v", err)
+	}
+	kms.indexKey = indexKey
+	return kms, nil
+}
+
+// GenerateKey 生成一个base64 编码的随机密钥，用于生成密钥文件
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func (l *LocalKMS) PrimaryKeyId() string {
+	return l.primary
+}
+
+func (l *LocalKMS) WrapKey(ctx context.Context, keyId string, dataKey []byte) ([]byte, error) {
+	aead, ok := l.keys[keyId]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return seal(aead, dataKey)
+}
+
+func (l *LocalKMS) UnwrapKey(ctx context.Context, keyId string, wrapped []byte) ([]byte, error) {
+	aead, ok := l.keys[keyId]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return open(aead, wrapped)
+}
+
+func (l *LocalKMS) IndexKey(ctx context.Context) ([]byte, error) {
+	return l.indexKey, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("key must be 32 bytes")
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal AES-GCM 加密，随机的nonce 放在密文前面
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/lenny-mo/order/domain/encryption"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// 敏感字段在结构体上加 serializer:encrypt，写入时信封加密，读取时解密
+func init() {
+	schema.RegisterSerializer("encrypt", EncryptSerializer{})
+}
+
+// EncryptSerializer 只支持string 字段，空字符串不加密，保证按结构体更新时空值仍然表示不修改
+type EncryptSerializer struct{}
+
+func (EncryptSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case []byte:
+		value = string(v)
+	case string:
+		value = v
+	default:
+		return fmt.Errorf("failed to decrypt value: %#v", dbValue)
+	}
+	plain, err := encryption.Decrypt(ctx, value)
+	if err != nil {
+		return err
+	}
+	field.ReflectValueOf(ctx, dst).SetString(plain)
+	return nil
+}
+
+func (EncryptSerializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("encrypt serializer only supports string, got %T", fieldValue)
+	}
+	return encryption.Encrypt(ctx, value)
+}
+
+// 盲索引的字段名，也是 encryption.BlindIndex 的前缀
+const (
+	IndexContactEmail = "contact_email"
+	IndexContactPhone = "contact_phone"
+)
+
+// NormalizeEmail 邮箱不区分大小写
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// NormalizePhone 手机号只保留数字和开头的+
+func NormalizePhone(phone string) string {
+	phone = strings.TrimSpace(phone)
+	normalized := make([]byte, 0, len(phone))
+	for i := 0; i < len(phone); i++ {
+		if (phone[i] >= '0' && phone[i] <= '9') || (phone[i] == '+' && len(normalized) == 0) {
+			normalized = append(normalized, phone[i])
+		}
+	}
+	return string(normalized)
+}
+
+// ContactEmailIndex 收件人邮箱的盲索引，按邮箱查询订单时使用
+func ContactEmailIndex(email string) string {
+	return encryption.BlindIndex(IndexContactEmail, NormalizeEmail(email))
+}
+
+func ContactPhoneIndex(phone string) string {
+	return encryption.BlindIndex(IndexContactPhone, NormalizePhone(phone))
+}
+
+// BeforeSave 写入前根据明文计算盲索引，按结构体更新时没有传的联系方式索引为空，不会被修改
+func (o *Order) BeforeSave(tx *gorm.DB) error {
+	o.ContactEmailIndex = ContactEmailIndex(o.Contact.Email)
+	o.ContactPhoneIndex = ContactPhoneIndex(o.Contact.Phone)
+	return nil
+}
@@ -1,6 +1,10 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
 
 // 按照proto文件定义的结构体
 type Order struct {
@@ -8,7 +12,115 @@ type Order struct {
 	OrderId      string `gorm:"column:order_id;unique" json:"order_id"`
 	OrderVersion int64  `gorm:"column:order_version" json:"order_version"`
 	UserId       int64  `gorm:"column:user_id" json:"user_id"`
-	// 用于存储订单数据的json字符串 默认使用varchar 类型，是OrderInfo slice的json字符串
-	OrderData string `gorm:"column:order_data" json:"order_data"`
+	TenantId     string `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	// 用于存储订单数据的json字符串 默认使用varchar 类型，是OrderInfo slice的json字符串，加密存储
+	OrderData string `gorm:"column:order_data;serializer:encrypt" json:"order_data"`
 	Status    int8   `gorm:"column:status" json:"status"` // 是否支付
+	// 金额都以分为单位
+	// TotalAmount 是优惠后应付的金额，DiscountAmount 是全部订单项优惠的合计
+	TotalAmount    int64  `gorm:"column:total_amount;not null;default:0" json:"total_amount"`
+	DiscountAmount int64  `gorm:"column:discount_amount;not null;default:0" json:"discount_amount"`
+	PaidAmount     int64  `gorm:"column:paid_amount;not null;default:0" json:"paid_amount"`
+	Currency       string `gorm:"column:currency;size:8" json:"currency"`
+	// 创建订单时在库存服务预占库存的ID，用于取消时释放、支付后确认以及和库存服务对账
+	ReservationId string `gorm:"column:reservation_id;size:64" json:"reservation_id"`
+	// 收货地址、账单地址和收件人联系方式，按前缀展开成orders 表的字段
+	ShippingAddress Address `gorm:"embedded;embeddedPrefix:shipping_" json:"shipping_address"`
+	BillingAddress  Address `gorm:"embedded;embeddedPrefix:billing_" json:"billing_address"`
+	Contact         Contact `gorm:"embedded;embeddedPrefix:contact_" json:"contact"`
+	// 收件人邮箱和手机号的盲索引，联系方式加密后通过盲索引等值查询
+	ContactEmailIndex string `gorm:"column:contact_email_bidx;size:32;index" json:"-"`
+	ContactPhoneIndex string `gorm:"column:contact_phone_bidx;size:32;index" json:"-"`
+	// 拆单后子订单指向原来的订单，SubOrderCount 大于0 的是父订单，状态由子订单汇总，见 ordersplit.go
+	ParentOrderId string `gorm:"column:parent_order_id;size:191;index" json:"parent_order_id,omitempty"`
+	SubOrderCount int32  `gorm:"column:sub_order_count;not null;default:0" json:"sub_order_count,omitempty"`
+	// 合单后被合并的订单取消，指向合并后的订单
+	MergedInto string `gorm:"column:merged_into;size:191" json:"merged_into,omitempty"`
+	// 创建订单时一起写入的订单项，不是orders 表的字段
+	Items []OrderItem `gorm:"-" json:"items,omitempty"`
+}
+
+// Paid 订单实际支付的金额，没有记录支付金额的历史订单按总金额计算
+func (o *Order) Paid() int64 {
+	if o.PaidAmount > 0 {
+		return o.PaidAmount
+	}
+	return o.TotalAmount
+}
+
+// ItemsTotal 订单项优惠后的金额合计
+func ItemsTotal(items []OrderItem) int64 {
+	var total int64
+	for _, item := range items {
+		total += item.Subtotal() - item.Discount
+	}
+	return total
+}
+
+// 订单状态，和proto 中的 OrderStatus 保持一致
+const (
+	StatusUnpaid    int8 = 0
+	StatusPaid      int8 = 1
+	StatusCancelled int8 = 2
+	StatusRefunded  int8 = 3
+	// StatusPartiallyRefunded 部分退款，可以继续退款直到退完支付金额
+	StatusPartiallyRefunded int8 = 4
+	// StatusShipped 已发货，只能从已支付或部分退款变为已发货
+	StatusShipped int8 = 5
+	// StatusDelivered 已签收，只能从已发货变为已签收
+	StatusDelivered int8 = 6
+)
+
+// ErrInvalidTransition 订单当前的状态不允许这个操作
+var ErrInvalidTransition = errors.New("order status does not allow this transition")
+
+// Address 地址，省份和国家用于统计和计算运费，不加密
+type Address struct {
+	Line1      string `gorm:"column:line1;type:text;serializer:encrypt" json:"line1"`
+	Line2      string `gorm:"column:line2;type:text;serializer:encrypt" json:"line2"`
+	City       string `gorm:"column:city;type:text;serializer:encrypt" json:"city"`
+	State      string `gorm:"column:state" json:"state"`
+	PostalCode string `gorm:"column:postal_code;type:text;serializer:encrypt" json:"postal_code"`
+	Country    string `gorm:"column:country;size:8" json:"country"`
+}
+
+// IsZero 地址是否为空，更新订单时空地址表示不修改
+func (a Address) IsZero() bool {
+	return a == Address{}
+}
+
+// Merge 用 update 中不为空的字段覆盖，和按结构体更新订单的语义一致
+func (a Address) Merge(update Address) Address {
+	a.Line1 = mergeString(a.Line1, update.Line1)
+	a.Line2 = mergeString(a.Line2, update.Line2)
+	a.City = mergeString(a.City, update.City)
+	a.State = mergeString(a.State, update.State)
+	a.PostalCode = mergeString(a.PostalCode, update.PostalCode)
+	a.Country = mergeString(a.Country, update.Country)
+	return a
+}
+
+// Contact 收件人联系方式，全部加密存储
+type Contact struct {
+	Name  string `gorm:"column:name;type:text;serializer:encrypt" json:"name"`
+	Phone string `gorm:"column:phone;type:text;serializer:encrypt" json:"phone"`
+	Email string `gorm:"column:email;type:text;serializer:encrypt" json:"email"`
+}
+
+func (c Contact) IsZero() bool {
+	return c == Contact{}
+}
+
+func (c Contact) Merge(update Contact) Contact {
+	c.Name = mergeString(c.Name, update.Name)
+	c.Phone = mergeString(c.Phone, update.Phone)
+	c.Email = mergeString(c.Email, update.Email)
+	return c
+}
+
+func mergeString(old, update string) string {
+	if update == "" {
+		return old
+	}
+	return update
 }
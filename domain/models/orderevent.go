@@ -0,0 +1,235 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 订单领域事件类型
+const (
+	EventCreated   = "Created"
+	EventItemAdded = "ItemAdded"
+	EventPaid      = "Paid"
+	EventCancelled = "Cancelled"
+	EventRefunded  = "Refunded"
+	// EventUpdated 修改订单数据等不属于上面几种的变更
+	EventUpdated   = "Updated"
+	EventDeleted   = "Deleted"
+	EventRestored  = "Restored"
+	EventShipped   = "Shipped"
+	EventDelivered = "Delivered"
+	// EventAnonymized 删除用户数据时清除了订单上的个人信息，之前事件中的个人信息也会被清除
+	EventAnonymized = "Anonymized"
+	// EventSplit 订单拆成了子订单，子订单各自有 Created 事件
+	EventSplit = "Split"
+	// EventMerged 订单合并到了其他订单，同时取消
+	EventMerged = "Merged"
+	// EventStatusDerived 拆单后的父订单按子订单汇总了状态
+	EventStatusDerived = "StatusDerived"
+)
+
+// OrderEvent 事件溯源模式下订单的一条事件，(order_id, sequence) 唯一，只追加不修改
+type OrderEvent struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	OrderId      string    `gorm:"column:order_id;uniqueIndex:idx_order_sequence" json:"order_id"`
+	Sequence     int64     `gorm:"column:sequence;uniqueIndex:idx_order_sequence" json:"sequence"` // 从1开始连续递增
+	UserId       int64     `gorm:"column:user_id" json:"user_id"`
+	TenantId     string    `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	Type         string    `gorm:"column:type" json:"type"`
+	OrderVersion int64     `gorm:"column:order_version" json:"order_version"`                  // 事件发生后的订单版本号
+	Payload      string    `gorm:"column:payload;type:text;serializer:encrypt" json:"payload"` // 包含订单数据和地址，加密存储
+	Actor        string    `gorm:"column:actor" json:"actor"`
+	Reason       string    `gorm:"column:reason" json:"reason"`
+	CreatedAt    time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (OrderEvent) TableName() string {
+	return "order_events"
+}
+
+// OrderSnapshot 聚合的快照，重建时从快照开始回放之后的事件
+type OrderSnapshot struct {
+	OrderId   string    `gorm:"column:order_id;primarykey" json:"order_id"`
+	TenantId  string    `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	Sequence  int64     `gorm:"column:sequence" json:"sequence"`
+	State     string    `gorm:"column:state;type:text;serializer:encrypt" json:"state"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (OrderSnapshot) TableName() string {
+	return "order_snapshots"
+}
+
+// OrderDataPayload Created 和 Updated 事件的数据
+type OrderDataPayload struct {
+	UserId    int64  `json:"user_id"`
+	OrderData string `json:"order_data"`
+	Status    int8   `json:"status"`
+	// 金额单位为分
+	TotalAmount    int64  `json:"total_amount,omitempty"`
+	DiscountAmount int64  `json:"discount_amount,omitempty"`
+	Currency       string `json:"currency,omitempty"`
+	// 预占库存的ID，只在 Created 事件中
+	ReservationId string `json:"reservation_id,omitempty"`
+	// 已支付的金额，只在导入的订单和子订单的 Created 事件中
+	PaidAmount int64 `json:"paid_amount,omitempty"`
+	// 拆单产生的子订单的父订单号，只在 Created 事件中
+	ParentOrderId string `json:"parent_order_id,omitempty"`
+	// 地址和联系方式，Updated 事件中为空的字段表示不修改
+	ShippingAddress Address `json:"shipping_address"`
+	BillingAddress  Address `json:"billing_address"`
+	Contact         Contact `json:"contact"`
+}
+
+// ItemPayload ItemAdded 事件的数据
+type ItemPayload struct {
+	SKUId int64 `json:"sku_id"`
+	Count int32 `json:"count"`
+	Price int64 `json:"price,omitempty"`
+	// 优惠金额和每个促销活动的优惠，格式和 OrderItem 中的相同
+	Discount  int64  `json:"discount,omitempty"`
+	Discounts string `json:"discounts,omitempty"`
+}
+
+// RefundPayload Refunded 事件的数据，通过 UpdateOrder 直接改成已退款的旧事件没有数据
+type RefundPayload struct {
+	RefundId string       `json:"refund_id"`
+	Amount   int64        `json:"amount"`
+	Status   int8         `json:"status"` // 退款后的状态，部分退款或已退款
+	Lines    []RefundLine `json:"lines"`
+}
+
+// NewOrderEvent 生成一条事件，payload 为空时不写入数据
+func NewOrderEvent(order *Order, eventType string, payload interface{}) (*OrderEvent, error) {
+	event := &OrderEvent{
+		OrderId:      order.OrderId,
+		UserId:       order.UserId,
+		TenantId:     order.TenantId,
+		Type:         eventType,
+		OrderVersion: order.OrderVersion,
+		CreatedAt:    time.Now(),
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = string(data)
+	}
+	return event, nil
+}
+
+// OrderAggregate 由事件折叠出来的订单状态
+type OrderAggregate struct {
+	Order    Order       `json:"order"`
+	Items    []OrderItem `json:"items"`
+	Sequence int64       `json:"sequence"` // 已经应用的最后一个事件序号
+}
+
+// Apply 把一个事件折叠到聚合上
+func (a *OrderAggregate) Apply(event *OrderEvent) error {
+	if event.Sequence != a.Sequence+1 {
+		return fmt.Errorf("order %s: event sequence %d does not follow %d", event.OrderId, event.Sequence, a.Sequence)
+	}
+	switch event.Type {
+	case EventCreated, EventUpdated:
+		payload := OrderDataPayload{}
+		if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+			return err
+		}
+		if event.Type == EventCreated {
+			a.Order.OrderId = event.OrderId
+			a.Order.TenantId = event.TenantId
+			a.Order.CreatedAt = event.CreatedAt
+		}
+		a.Order.UserId = payload.UserId
+		a.Order.OrderData = payload.OrderData
+		a.Order.Status = payload.Status
+		a.Order.ShippingAddress = a.Order.ShippingAddress.Merge(payload.ShippingAddress)
+		a.Order.BillingAddress = a.Order.BillingAddress.Merge(payload.BillingAddress)
+		a.Order.Contact = a.Order.Contact.Merge(payload.Contact)
+		if event.Type == EventCreated {
+			a.Order.TotalAmount = payload.TotalAmount
+			a.Order.DiscountAmount = payload.DiscountAmount
+			a.Order.Currency = payload.Currency
+			a.Order.ReservationId = payload.ReservationId
+			a.Order.PaidAmount = payload.PaidAmount
+			a.Order.ParentOrderId = payload.ParentOrderId
+		}
+	case EventItemAdded:
+		payload := ItemPayload{}
+		if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+			return err
+		}
+		a.Items = append(a.Items, OrderItem{
+			OrderId:   event.OrderId,
+			UserId:    event.UserId,
+			TenantId:  event.TenantId,
+			SKUId:     payload.SKUId,
+			Count:     payload.Count,
+			Price:     payload.Price,
+			Discount:  payload.Discount,
+			Discounts: payload.Discounts,
+			Timestamp: event.CreatedAt,
+		})
+	case EventPaid:
+		a.Order.Status = StatusPaid
+		if event.Payload != "" {
+			payload := PaidPayload{}
+			if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+				return err
+			}
+			a.Order.PaidAmount = payload.Amount
+		}
+	case EventCancelled:
+		a.Order.Status = StatusCancelled
+	case EventRefunded:
+		a.Order.Status = StatusRefunded
+		if event.Payload != "" {
+			payload := RefundPayload{}
+			if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+				return err
+			}
+			a.Order.Status = payload.Status
+			ApplyRefundLines(a.Items, payload.Lines)
+		}
+	case EventShipped:
+		a.Order.Status = StatusShipped
+	case EventDelivered:
+		a.Order.Status = StatusDelivered
+	case EventSplit:
+		payload := SplitPayload{}
+		if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+			return err
+		}
+		a.Order.SubOrderCount = int32(len(payload.SubOrderIds))
+	case EventMerged:
+		payload := MergedPayload{}
+		if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+			return err
+		}
+		a.Order.Status = StatusCancelled
+		a.Order.MergedInto = payload.MergedInto
+	case EventStatusDerived:
+		payload := StatusPayload{}
+		if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+			return err
+		}
+		a.Order.Status = payload.Status
+	case EventAnonymized:
+		a.Order.Anonymize()
+	case EventDeleted:
+		a.Order.DeletedAt = gorm.DeletedAt{Time: event.CreatedAt, Valid: true}
+	case EventRestored:
+		a.Order.DeletedAt = gorm.DeletedAt{}
+	default:
+		return fmt.Errorf("order %s: unknown event type %s", event.OrderId, event.Type)
+	}
+	a.Order.OrderVersion = event.OrderVersion
+	a.Order.UpdatedAt = event.CreatedAt
+	a.Sequence = event.Sequence
+	return nil
+}
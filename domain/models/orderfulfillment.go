@@ -0,0 +1,46 @@
+package models
+
+import "time"
+
+// OrderFulfillment 订单的履约信息，发货时创建，签收时更新
+type OrderFulfillment struct {
+	ID             uint       `gorm:"primarykey" json:"id"`
+	OrderId        string     `gorm:"column:order_id;size:191;uniqueIndex" json:"order_id"`
+	UserId         int64      `gorm:"column:user_id" json:"user_id"`
+	TenantId       string     `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	Carrier        string     `gorm:"column:carrier" json:"carrier"`
+	TrackingNumber string     `gorm:"column:tracking_number" json:"tracking_number"`
+	ShippedAt      *time.Time `gorm:"column:shipped_at" json:"shipped_at"`
+	DeliveredAt    *time.Time `gorm:"column:delivered_at" json:"delivered_at"`
+	CreatedAt      time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (OrderFulfillment) TableName() string {
+	return "order_fulfillments"
+}
+
+// ShippedPayload Shipped 事件的数据
+type ShippedPayload struct {
+	Carrier        string    `json:"carrier"`
+	TrackingNumber string    `json:"tracking_number"`
+	ShippedAt      time.Time `json:"shipped_at"`
+}
+
+// DeliveredPayload Delivered 事件的数据
+type DeliveredPayload struct {
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// CanShip 已支付和部分退款的订单可以发货，拆单后的父订单由子订单分别发货
+func CanShip(order *Order) bool {
+	if order.SubOrderCount > 0 {
+		return false
+	}
+	return order.Status == StatusPaid || order.Status == StatusPartiallyRefunded
+}
+
+// CanDeliver 已发货的订单可以签收
+func CanDeliver(order *Order) bool {
+	return order.SubOrderCount == 0 && order.Status == StatusShipped
+}
@@ -0,0 +1,98 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OrderHistory 订单的版本记录，只追加不修改
+// 每次创建或更新订单都会在同一个事务里写入一条，记录变更后的版本号和字段差异
+type OrderHistory struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	OrderId      string    `gorm:"column:order_id;index" json:"order_id"`
+	UserId       int64     `gorm:"column:user_id" json:"user_id"`
+	TenantId     string    `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	OrderVersion int64     `gorm:"column:order_version" json:"order_version"`            // 变更后的版本号
+	Status       int8      `gorm:"column:status" json:"status"`                          // 变更后的状态
+	Diff         string    `gorm:"column:diff;type:text;serializer:encrypt" json:"diff"` // 字段差异的json，形如 {"status":[0,1]}，包含订单数据和地址，加密存储
+	Actor        string    `gorm:"column:actor" json:"actor"`                            // 操作人，来自请求的metadata
+	Reason       string    `gorm:"column:reason" json:"reason"`
+	CreatedAt    time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (OrderHistory) TableName() string {
+	return "order_history"
+}
+
+// DiffOrder 比较两个版本的订单，返回发生变化的字段，old 为空表示新建
+func DiffOrder(old, new *Order) map[string][2]interface{} {
+	if old == nil {
+		old = &Order{}
+	}
+	diff := map[string][2]interface{}{}
+	if old.UserId != new.UserId {
+		diff["user_id"] = [2]interface{}{old.UserId, new.UserId}
+	}
+	if old.OrderVersion != new.OrderVersion {
+		diff["order_version"] = [2]interface{}{old.OrderVersion, new.OrderVersion}
+	}
+	if old.Status != new.Status {
+		diff["status"] = [2]interface{}{old.Status, new.Status}
+	}
+	if old.OrderData != new.OrderData {
+		diff["order_data"] = [2]interface{}{old.OrderData, new.OrderData}
+	}
+	if old.TotalAmount != new.TotalAmount {
+		diff["total_amount"] = [2]interface{}{old.TotalAmount, new.TotalAmount}
+	}
+	if old.DiscountAmount != new.DiscountAmount {
+		diff["discount_amount"] = [2]interface{}{old.DiscountAmount, new.DiscountAmount}
+	}
+	if old.PaidAmount != new.PaidAmount {
+		diff["paid_amount"] = [2]interface{}{old.PaidAmount, new.PaidAmount}
+	}
+	if old.Currency != new.Currency {
+		diff["currency"] = [2]interface{}{old.Currency, new.Currency}
+	}
+	if old.ReservationId != new.ReservationId {
+		diff["reservation_id"] = [2]interface{}{old.ReservationId, new.ReservationId}
+	}
+	if old.ShippingAddress != new.ShippingAddress {
+		diff["shipping_address"] = [2]interface{}{old.ShippingAddress, new.ShippingAddress}
+	}
+	if old.BillingAddress != new.BillingAddress {
+		diff["billing_address"] = [2]interface{}{old.BillingAddress, new.BillingAddress}
+	}
+	if old.Contact != new.Contact {
+		diff["contact"] = [2]interface{}{old.Contact, new.Contact}
+	}
+	if old.ParentOrderId != new.ParentOrderId {
+		diff["parent_order_id"] = [2]interface{}{old.ParentOrderId, new.ParentOrderId}
+	}
+	if old.SubOrderCount != new.SubOrderCount {
+		diff["sub_order_count"] = [2]interface{}{old.SubOrderCount, new.SubOrderCount}
+	}
+	if old.MergedInto != new.MergedInto {
+		diff["merged_into"] = [2]interface{}{old.MergedInto, new.MergedInto}
+	}
+	if old.DeletedAt.Valid != new.DeletedAt.Valid {
+		diff["deleted"] = [2]interface{}{old.DeletedAt.Valid, new.DeletedAt.Valid}
+	}
+	return diff
+}
+
+// NewOrderHistory 根据变更前后的订单生成一条版本记录
+func NewOrderHistory(old, new *Order, actor, reason string) *OrderHistory {
+	diff, _ := json.Marshal(DiffOrder(old, new))
+	return &OrderHistory{
+		OrderId:      new.OrderId,
+		UserId:       new.UserId,
+		TenantId:     new.TenantId,
+		OrderVersion: new.OrderVersion,
+		Status:       new.Status,
+		Diff:         string(diff),
+		Actor:        actor,
+		Reason:       reason,
+		CreatedAt:    time.Now(),
+	}
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/gorm"
@@ -9,13 +10,62 @@ import (
 // OrderItem 订单信息
 type OrderItem struct {
 	gorm.Model
-	// OrderId 是Order表的外键
-	OrderId   int64     `json:"order_id" gorm:"order_id;not_null"`
-	UserId    int64     `json:"user_id" gorm:"user_id;not_null"`
-	SKUId     int64     `json:"sku_id" gorm:"sku_id;not_null"`
-	Count     int32     `json:"count" gorm:"count;not_null"`
-	Timestamp time.Time `json:"timestamp" gorm:"timestamp;not_null"`
+	// OrderId 是Order表的外键，和Order.OrderId 一样是字符串订单号
+	OrderId  string `json:"order_id" gorm:"column:order_id;size:191;index;not null"`
+	UserId   int64  `json:"user_id" gorm:"column:user_id;not null"`
+	TenantId string `json:"tenant_id" gorm:"column:tenant_id;size:64;index;not null;default:''"`
+	SKUId    int64  `json:"sku_id" gorm:"column:sku_id;not null"`
+	Count    int32  `json:"count" gorm:"column:count;not null"`
+	Price    int64  `json:"price" gorm:"column:price;not null;default:0"` // 单价，单位为分
+	// Discount 这一行的优惠金额合计，Discounts 是每个促销活动优惠金额的json，形如 [{"promotion_id":"p1","amount":100}]
+	Discount  int64  `json:"discount" gorm:"column:discount;not null;default:0"`
+	Discounts string `json:"discounts" gorm:"column:discounts;type:text"`
+	// RefundedCount 已退款的数量，部分退款时不能超过 Count
+	RefundedCount int32     `json:"refunded_count" gorm:"column:refunded_count;not null;default:0"`
+	Timestamp     time.Time `json:"timestamp" gorm:"column:timestamp;not null"`
 	// 外键策略：当Order表的OrderId字段更新时，OrderItem表的OrderId字段也更新
-	// 当Order表的OrderId字段删除时，OrderItem表的OrderId字段也删除
-	Order Order `json:"order" gorm:"foreignkey:OrderId;references:OrderId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	// 当Order表的OrderId字段删除时，OrderItem表的OrderId字段也删除，只用于建表，不参与json 编码
+	Order Order `json:"-" gorm:"foreignkey:OrderId;references:OrderId;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+}
+
+// AppliedDiscount 某个促销活动在一个订单项上的优惠
+type AppliedDiscount struct {
+	PromotionId string `json:"promotion_id"`
+	Name        string `json:"name"`
+	Amount      int64  `json:"amount"`
+}
+
+// AppliedDiscounts 解析订单项上记录的优惠
+func (i *OrderItem) AppliedDiscounts() []AppliedDiscount {
+	discounts := []AppliedDiscount{}
+	if i.Discounts != "" {
+		json.Unmarshal([]byte(i.Discounts), &discounts)
+	}
+	return discounts
+}
+
+// SetAppliedDiscounts 记录订单项上的优惠，同时更新优惠金额合计
+func (i *OrderItem) SetAppliedDiscounts(discounts []AppliedDiscount) {
+	i.Discount, i.Discounts = 0, ""
+	if len(discounts) == 0 {
+		return
+	}
+	for _, discount := range discounts {
+		i.Discount += discount.Amount
+	}
+	data, _ := json.Marshal(discounts)
+	i.Discounts = string(data)
+}
+
+// Subtotal 优惠前的金额
+func (i *OrderItem) Subtotal() int64 {
+	return i.Price * int64(i.Count)
+}
+
+// RefundAmount 退 count 件的金额，按优惠后的金额平摊到每件
+func (i *OrderItem) RefundAmount(count int32) int64 {
+	if i.Count == 0 {
+		return 0
+	}
+	return (i.Subtotal() - i.Discount) * int64(count) / int64(i.Count)
 }
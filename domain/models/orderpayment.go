@@ -0,0 +1,57 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 支付记录的状态
+const (
+	// PaymentConfirmed 金额一致，订单已经改为已支付
+	PaymentConfirmed = "CONFIRMED"
+	// PaymentMismatch 金额、币种或订单状态不符，订单没有修改，需要人工处理
+	PaymentMismatch = "MISMATCH"
+)
+
+// OrderPayment 支付服务回调的一次支付确认，PaymentId 唯一，重复回调不会重复修改订单
+type OrderPayment struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	PaymentId string    `gorm:"column:payment_id;size:64;uniqueIndex" json:"payment_id"`
+	OrderId   string    `gorm:"column:order_id;size:191;index" json:"order_id"`
+	UserId    int64     `gorm:"column:user_id" json:"user_id"`
+	TenantId  string    `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	Amount    int64     `gorm:"column:amount" json:"amount"` // 单位为分
+	Currency  string    `gorm:"column:currency;size:8" json:"currency"`
+	Status    string    `gorm:"column:status;size:16;index" json:"status"`
+	Detail    string    `gorm:"column:detail" json:"detail"` // 不一致的原因
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+func (OrderPayment) TableName() string {
+	return "order_payments"
+}
+
+// PaidPayload Paid 事件的数据，通过 UpdateOrder 直接改成已支付的旧事件没有数据
+type PaidPayload struct {
+	PaymentId string `json:"payment_id"`
+	Amount    int64  `json:"amount"`
+}
+
+// ErrPaymentMismatch 支付确认和订单不一致，已经记录下来等待人工处理
+var ErrPaymentMismatch = errors.New("confirm payment failed, payment does not match order")
+
+// CheckPayment 检查支付确认能否让订单变为已支付，返回不一致的原因，空字符串表示一致
+func CheckPayment(order *Order, payment *OrderPayment) string {
+	if order.Status != StatusUnpaid {
+		return fmt.Sprintf("order status is %d", order.Status)
+	}
+	if payment.Amount != order.TotalAmount {
+		return fmt.Sprintf("amount %d does not match order total %d", payment.Amount, order.TotalAmount)
+	}
+	if order.Currency != "" && !strings.EqualFold(order.Currency, payment.Currency) {
+		return fmt.Sprintf("currency %s does not match order currency %s", payment.Currency, order.Currency)
+	}
+	return ""
+}
@@ -0,0 +1,177 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 退款状态，退款记录创建后是 PENDING，由支付服务完成打款
+const (
+	RefundPending   = "PENDING"
+	RefundSucceeded = "SUCCEEDED"
+	RefundFailed    = "FAILED"
+)
+
+// RefundLine 按订单项退款的一行，Amount 由服务端按单价计算
+type RefundLine struct {
+	SKUId  int64 `json:"sku_id"`
+	Count  int32 `json:"count"`
+	Amount int64 `json:"amount"`
+}
+
+// OrderRefund 订单的一次退款，同一个订单可以有多次部分退款
+type OrderRefund struct {
+	ID       uint   `gorm:"primarykey" json:"id"`
+	RefundId string `gorm:"column:refund_id;size:64;uniqueIndex" json:"refund_id"`
+	OrderId  string `gorm:"column:order_id;size:191;index" json:"order_id"`
+	UserId   int64  `gorm:"column:user_id" json:"user_id"`
+	TenantId string `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	Amount   int64  `gorm:"column:amount" json:"amount"` // 单位为分
+	Currency string `gorm:"column:currency;size:8" json:"currency"`
+	Reason   string `gorm:"column:reason" json:"reason"`
+	Status   string `gorm:"column:status;size:16" json:"status"`
+	// Lines 退款的订单项，[]RefundLine 的json，整单退款时是剩余的全部订单项
+	Lines     string    `gorm:"column:lines;type:text" json:"lines"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (OrderRefund) TableName() string {
+	return "order_refunds"
+}
+
+// RefundLines 解析退款记录中的订单项
+func (r *OrderRefund) RefundLines() []RefundLine {
+	lines := []RefundLine{}
+	if r.Lines != "" {
+		json.Unmarshal([]byte(r.Lines), &lines)
+	}
+	return lines
+}
+
+// RefundEvent 退款记录创建后发布给支付服务的事件
+type RefundEvent struct {
+	RefundId  string       `json:"refund_id"`
+	OrderId   string       `json:"order_id"`
+	UserId    int64        `json:"user_id"`
+	Amount    int64        `json:"amount"`
+	Currency  string       `json:"currency"`
+	Reason    string       `json:"reason"`
+	Lines     []RefundLine `json:"lines"`
+	Timestamp int64        `json:"timestamp"`
+}
+
+// ErrRefundNotAllowed 订单还没有支付或者已经全部退款
+var ErrRefundNotAllowed = errors.New("refund order failed, order is not paid")
+
+// ErrRefundExceeded 退款金额超过了支付金额
+var ErrRefundExceeded = errors.New("refund order failed, refund amount exceeds paid amount")
+
+// RefundedAmount 有效退款的金额合计，失败的退款不计入
+func RefundedAmount(refunds []*OrderRefund) int64 {
+	var total int64
+	for _, refund := range refunds {
+		if refund.Status != RefundFailed {
+			total += refund.Amount
+		}
+	}
+	return total
+}
+
+// PrepareRefund 校验一次退款并计算退款金额和退款后的订单状态
+// lines 为空表示退还剩余的全部金额；refunded 是之前的有效退款合计
+// 返回的订单项行已经计算好金额，调用方负责把数量累加到订单项的 RefundedCount 上
+func PrepareRefund(order *Order, items []OrderItem, refunded int64, lines []RefundLine) ([]RefundLine, int64, int8, error) {
+	if order.SubOrderCount > 0 {
+		// 拆单后的父订单只汇总状态，退款在子订单上进行
+		return nil, 0, 0, ErrRefundNotAllowed
+	}
+	switch order.Status {
+	case StatusPaid, StatusPartiallyRefunded, StatusShipped, StatusDelivered:
+	default:
+		return nil, 0, 0, ErrRefundNotAllowed
+	}
+	paid := order.Paid()
+	var amount int64
+	resolved := []RefundLine{}
+	if len(lines) == 0 {
+		// 整单退款：剩余未退的订单项全部退掉，金额为剩余的支付金额
+		for _, item := range items {
+			if remaining := item.Count - item.RefundedCount; remaining > 0 {
+				resolved = append(resolved, RefundLine{SKUId: item.SKUId, Count: remaining, Amount: item.RefundAmount(remaining)})
+			}
+		}
+		amount = paid - refunded
+	} else {
+		requested := map[int64]int32{}
+		for _, line := range lines {
+			if line.Count <= 0 {
+				return nil, 0, 0, fmt.Errorf("refund order failed, invalid count %d for sku %d", line.Count, line.SKUId)
+			}
+			requested[line.SKUId] += line.Count
+		}
+		for _, line := range lines {
+			count, ok := requested[line.SKUId]
+			if !ok {
+				continue
+			}
+			delete(requested, line.SKUId)
+			var remaining int32
+			var matched *OrderItem
+			for i := range items {
+				if items[i].SKUId == line.SKUId {
+					remaining += items[i].Count - items[i].RefundedCount
+					if matched == nil {
+						matched = &items[i]
+					}
+				}
+			}
+			if matched == nil {
+				return nil, 0, 0, fmt.Errorf("refund order failed, sku %d is not in order %s", line.SKUId, order.OrderId)
+			}
+			if count > remaining {
+				return nil, 0, 0, fmt.Errorf("refund order failed, sku %d has %d refundable, requested %d", line.SKUId, remaining, count)
+			}
+			// 按优惠后的单价退款
+			lineAmount := matched.RefundAmount(count)
+			resolved = append(resolved, RefundLine{SKUId: line.SKUId, Count: count, Amount: lineAmount})
+			amount += lineAmount
+		}
+	}
+	if amount <= 0 {
+		return nil, 0, 0, ErrRefundExceeded
+	}
+	if refunded+amount > paid {
+		return nil, 0, 0, ErrRefundExceeded
+	}
+	status := StatusPartiallyRefunded
+	if refunded+amount == paid {
+		status = StatusRefunded
+	}
+	return resolved, amount, status, nil
+}
+
+// ApplyRefundLines 把退款的数量累加到订单项上，同一个sku 有多行时按顺序扣减
+func ApplyRefundLines(items []OrderItem, lines []RefundLine) {
+	for _, line := range lines {
+		count := line.Count
+		for i := range items {
+			if count == 0 {
+				break
+			}
+			if items[i].SKUId != line.SKUId {
+				continue
+			}
+			n := items[i].Count - items[i].RefundedCount
+			if n > count {
+				n = count
+			}
+			if n > 0 {
+				items[i].RefundedCount += n
+				count -= n
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SplitLine 拆到一个子订单里的商品和数量，同一个商品可以拆到多个子订单
+type SplitLine struct {
+	SKUId int64 `json:"sku_id"`
+	Count int32 `json:"count"`
+}
+
+// SplitPayload Split 事件的数据
+type SplitPayload struct {
+	SubOrderIds []string `json:"sub_order_ids"`
+}
+
+// MergedPayload Merged 事件的数据，被合并的订单取消
+type MergedPayload struct {
+	MergedInto string `json:"merged_into"`
+}
+
+// StatusPayload StatusDerived 事件的数据，父订单按子订单汇总后的状态
+type StatusPayload struct {
+	Status int8 `json:"status"`
+}
+
+// ErrInvalidSplit 拆单的订单项和原订单对不上
+var ErrInvalidSplit = errors.New("split order failed, sub orders must cover every item of the order exactly once")
+
+// ErrInvalidMerge 这些订单不能合并
+var ErrInvalidMerge = errors.New("merge orders failed, only unpaid orders of the same user and currency can be merged")
+
+// splitItem 拆分中的一个订单项，记录还没有分出去的数量和优惠
+type splitItem struct {
+	item      OrderItem
+	left      int32
+	discount  int64
+	discounts []AppliedDiscount
+}
+
+// take 分出 count 件，最后一次分出剩余的优惠，保证子订单项的优惠合计和原订单项相同
+func (s *splitItem) take(count int32) OrderItem {
+	part := OrderItem{
+		SKUId:     s.item.SKUId,
+		Count:     count,
+		Price:     s.item.Price,
+		Timestamp: s.item.Timestamp,
+	}
+	s.left -= count
+	if len(s.discounts) == 0 {
+		discount := s.item.Discount * int64(count) / int64(s.item.Count)
+		if s.left == 0 {
+			discount = s.discount
+		}
+		s.discount -= discount
+		part.Discount = discount
+		return part
+	}
+	applied := make([]AppliedDiscount, len(s.discounts))
+	for i, d := range s.discounts {
+		applied[i] = d
+		if s.left > 0 {
+			applied[i].Amount = d.Amount * int64(count) / int64(s.left+count)
+		}
+		s.discounts[i].Amount -= applied[i].Amount
+	}
+	part.SetAppliedDiscounts(applied)
+	return part
+}
+
+// prorate 按 weights 的比例分摊 amount，最后一份取余数，权重全为0 时全部给最后一份
+func prorate(amount int64, weights []int64) []int64 {
+	var total int64
+	for _, weight := range weights {
+		total += weight
+	}
+	shares := make([]int64, len(weights))
+	var assigned int64
+	for i := range weights[:len(weights)-1] {
+		if total > 0 {
+			shares[i] = amount * weights[i] / total
+		}
+		assigned += shares[i]
+	}
+	shares[len(shares)-1] = amount - assigned
+	return shares
+}
+
+// SplitOrder 按 groups 把已支付的订单拆成子订单，每组一个子订单，subOrderIds 是子订单号
+// 子订单复制原订单的用户、地址和币种，应付金额和支付金额按订单项的金额分摊，状态和原订单相同；
+// 原订单的订单项保持不变，作为下单时的记录
+func SplitOrder(parent *Order, items []OrderItem, subOrderIds []string, groups [][]SplitLine) ([]*Order, error) {
+	if parent.Status != StatusPaid || parent.ParentOrderId != "" || parent.SubOrderCount > 0 {
+		return nil, ErrInvalidTransition
+	}
+	if len(groups) < 2 || len(subOrderIds) != len(groups) {
+		return nil, fmt.Errorf("%w: need at least two sub orders", ErrInvalidSplit)
+	}
+	pending := make([]*splitItem, 0, len(items))
+	for _, item := range items {
+		pending = append(pending, &splitItem{item: item, left: item.Count, discount: item.Discount, discounts: item.AppliedDiscounts()})
+	}
+
+	children := make([]*Order, 0, len(groups))
+	weights := make([]int64, 0, len(groups))
+	for i, group := range groups {
+		if len(group) == 0 {
+			return nil, fmt.Errorf("%w: sub order %d has no items", ErrInvalidSplit, i)
+		}
+		child := &Order{
+			OrderId:         subOrderIds[i],
+			OrderVersion:    1,
+			UserId:          parent.UserId,
+			TenantId:        parent.TenantId,
+			OrderData:       parent.OrderData,
+			Status:          parent.Status,
+			Currency:        parent.Currency,
+			ShippingAddress: parent.ShippingAddress,
+			BillingAddress:  parent.BillingAddress,
+			Contact:         parent.Contact,
+			ParentOrderId:   parent.OrderId,
+		}
+		// 子订单的创建时间沿用父订单，报表按下单的日期统计
+		child.CreatedAt = parent.CreatedAt
+		for _, line := range group {
+			if line.Count <= 0 {
+				return nil, fmt.Errorf("%w: invalid count %d for sku %d", ErrInvalidSplit, line.Count, line.SKUId)
+			}
+			wanted := line.Count
+			for _, item := range pending {
+				if wanted == 0 {
+					break
+				}
+				if item.item.SKUId != line.SKUId || item.left == 0 {
+					continue
+				}
+				count := wanted
+				if count > item.left {
+					count = item.left
+				}
+				child.Items = append(child.Items, item.take(count))
+				wanted -= count
+			}
+			if wanted > 0 {
+				return nil, fmt.Errorf("%w: sku %d has not enough items left", ErrInvalidSplit, line.SKUId)
+			}
+		}
+		for _, item := range child.Items {
+			child.DiscountAmount += item.Discount
+		}
+		children = append(children, child)
+		weights = append(weights, ItemsTotal(child.Items))
+	}
+	for _, item := range pending {
+		if item.left > 0 {
+			return nil, fmt.Errorf("%w: %d of sku %d are not in any sub order", ErrInvalidSplit, item.left, item.item.SKUId)
+		}
+	}
+
+	totals := prorate(parent.TotalAmount, weights)
+	paid := prorate(parent.PaidAmount, weights)
+	for i, child := range children {
+		child.TotalAmount, child.PaidAmount = totals[i], paid[i]
+	}
+	return children, nil
+}
+
+// ParentStatus 按子订单的状态汇总父订单的状态
+// 已取消和已退款的子订单不参与发货状态的汇总：其余子订单全部签收时父订单为已签收，全部发货时为已发货；
+// 子订单全部取消或退款时，有退款的为已退款，否则为已取消；其余情况下有退款的为部分退款
+func ParentStatus(children []*Order) int8 {
+	var active, unpaid, shipped, delivered, refunded int
+	for _, child := range children {
+		switch child.Status {
+		case StatusCancelled:
+			continue
+		case StatusRefunded:
+			refunded++
+			continue
+		case StatusPartiallyRefunded:
+			refunded++
+		case StatusUnpaid:
+			unpaid++
+		case StatusShipped:
+			shipped++
+		case StatusDelivered:
+			delivered++
+		}
+		active++
+	}
+	switch {
+	case active == 0 && refunded > 0:
+		return StatusRefunded
+	case active == 0:
+		return StatusCancelled
+	case unpaid == active:
+		return StatusUnpaid
+	case delivered == active:
+		return StatusDelivered
+	case shipped+delivered == active:
+		return StatusShipped
+	case refunded > 0:
+		return StatusPartiallyRefunded
+	}
+	return StatusPaid
+}
+
+// CheckMerge 检查 sources 能否合并到 merged：都是未支付且没有拆单或合单的订单，用户、租户和币种都和合并后的订单相同
+func CheckMerge(merged *Order, sources []*Order) error {
+	if len(sources) < 2 {
+		return fmt.Errorf("%w: need at least two orders", ErrInvalidMerge)
+	}
+	for _, source := range sources {
+		switch {
+		case source.DeletedAt.Valid:
+			return fmt.Errorf("%w: order %s is deleted", ErrInvalidMerge, source.OrderId)
+		case source.Status != StatusUnpaid:
+			return fmt.Errorf("%w: order %s is not unpaid", ErrInvalidMerge, source.OrderId)
+		case source.ParentOrderId != "" || source.SubOrderCount > 0 || source.MergedInto != "":
+			return fmt.Errorf("%w: order %s is split or merged", ErrInvalidMerge, source.OrderId)
+		case source.UserId != merged.UserId || source.TenantId != merged.TenantId:
+			return fmt.Errorf("%w: order %s belongs to another user", ErrInvalidMerge, source.OrderId)
+		case !strings.EqualFold(source.Currency, merged.Currency):
+			return fmt.Errorf("%w: order %s is in %s", ErrInvalidMerge, source.OrderId, source.Currency)
+		}
+	}
+	return nil
+}
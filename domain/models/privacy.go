@@ -0,0 +1,113 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// 用户数据请求的类型和状态
+const (
+	PrivacyExport = "EXPORT"
+	PrivacyErase  = "ERASE"
+
+	PrivacyRunning   = "RUNNING"
+	PrivacySucceeded = "SUCCEEDED"
+	PrivacyFailed    = "FAILED"
+)
+
+// PrivacyRequest 导出和删除用户数据的请求记录，供审计使用，只追加不删除，不包含用户的个人信息
+type PrivacyRequest struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	RequestId string    `gorm:"column:request_id;size:64;uniqueIndex" json:"request_id"`
+	UserId    int64     `gorm:"column:user_id;index" json:"user_id"`
+	TenantId  string    `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	Kind      string    `gorm:"column:kind;size:16" json:"kind"`
+	Status    string    `gorm:"column:status;size:16" json:"status"`
+	Actor     string    `gorm:"column:actor" json:"actor"`
+	Reason    string    `gorm:"column:reason" json:"reason"`
+	Detail    string    `gorm:"column:detail;type:text" json:"detail"` // 处理结果，形如 {"orders":3,"anonymized":2,"deleted":1}
+	Error     string    `gorm:"column:error;type:text" json:"error"`
+	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (PrivacyRequest) TableName() string {
+	return "privacy_requests"
+}
+
+// UserDataArchive 导出的用户数据，订单中带有订单项
+type UserDataArchive struct {
+	UserId       int64               `json:"user_id"`
+	ExportedAt   time.Time           `json:"exported_at"`
+	Orders       []*Order            `json:"orders"`
+	History      []*OrderHistory     `json:"history"`
+	Refunds      []*OrderRefund      `json:"refunds"`
+	Fulfillments []*OrderFulfillment `json:"fulfillments"`
+}
+
+// ErasureResult 删除用户数据的结果
+type ErasureResult struct {
+	Orders     int   `json:"orders"`
+	Anonymized int   `json:"anonymized"` // 需要保留做账的订单，只清除个人信息
+	Deleted    int   `json:"deleted"`
+	Sagas      int64 `json:"sagas"`
+}
+
+// RetainedForAccounting 发生过支付的订单需要保留做账，删除用户数据时只能匿名化
+func RetainedForAccounting(order *Order) bool {
+	if order.PaidAmount > 0 {
+		return true
+	}
+	switch order.Status {
+	case StatusPaid, StatusRefunded, StatusPartiallyRefunded, StatusShipped, StatusDelivered:
+		return true
+	}
+	return false
+}
+
+// Anonymize 清除订单上的个人信息，地址只保留省份和国家用于统计
+func (o *Order) Anonymize() {
+	o.OrderData = ""
+	o.ShippingAddress = o.ShippingAddress.anonymize()
+	o.BillingAddress = o.BillingAddress.anonymize()
+	o.Contact = Contact{}
+	o.ContactEmailIndex = ""
+	o.ContactPhoneIndex = ""
+}
+
+func (a Address) anonymize() Address {
+	return Address{State: a.State, Country: a.Country}
+}
+
+// 版本记录中包含个人信息的字段
+var personalDiffFields = []string{"order_data", "shipping_address", "billing_address", "contact"}
+
+// AnonymizeDiff 删除版本记录差异中包含个人信息的字段，其他字段不变
+func AnonymizeDiff(diff string) (string, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(diff), &fields); err != nil {
+		return "", err
+	}
+	for _, field := range personalDiffFields {
+		delete(fields, field)
+	}
+	data, err := json.Marshal(fields)
+	return string(data), err
+}
+
+// AnonymizePayload 清除 Created 和 Updated 事件数据中的个人信息，其他事件原样返回
+func AnonymizePayload(eventType, payload string) (string, error) {
+	if eventType != EventCreated && eventType != EventUpdated {
+		return payload, nil
+	}
+	data := OrderDataPayload{}
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		return "", err
+	}
+	data.OrderData = ""
+	data.ShippingAddress = data.ShippingAddress.anonymize()
+	data.BillingAddress = data.BillingAddress.anonymize()
+	data.Contact = Contact{}
+	encoded, err := json.Marshal(data)
+	return string(encoded), err
+}
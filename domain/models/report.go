@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// DayLayout 报表中日期的格式，按服务所在时区划分
+const DayLayout = "2006-01-02"
+
+// IsSale 计入销售额的订单：已支付、部分退款、已发货和已签收，全额退款和未支付、已取消的订单不计入
+func IsSale(status int8) bool {
+	switch status {
+	case StatusPaid, StatusPartiallyRefunded, StatusShipped, StatusDelivered:
+		return true
+	}
+	return false
+}
+
+// DailySales 每天按租户、币种和状态汇总的订单，由报表任务从订单表计算后整天替换
+// 金额都以分为单位，Day 是订单的创建日期
+type DailySales struct {
+	ID             uint      `gorm:"primarykey" json:"-"`
+	TenantId       string    `gorm:"column:tenant_id;size:64;uniqueIndex:idx_daily_sales;not null;default:''" json:"tenant_id"`
+	Day            string    `gorm:"column:day;size:10;uniqueIndex:idx_daily_sales;not null" json:"day"`
+	Currency       string    `gorm:"column:currency;size:8;uniqueIndex:idx_daily_sales;not null;default:''" json:"currency"`
+	Status         int8      `gorm:"column:status;uniqueIndex:idx_daily_sales;not null" json:"status"`
+	OrderCount     int64     `gorm:"column:order_count;not null;default:0" json:"order_count"`
+	TotalAmount    int64     `gorm:"column:total_amount;not null;default:0" json:"total_amount"`
+	DiscountAmount int64     `gorm:"column:discount_amount;not null;default:0" json:"discount_amount"`
+	PaidAmount     int64     `gorm:"column:paid_amount;not null;default:0" json:"paid_amount"`
+	RefreshedAt    time.Time `gorm:"column:refreshed_at" json:"refreshed_at"`
+}
+
+func (DailySales) TableName() string {
+	return "order_daily_sales"
+}
+
+// DailyProductSales 每天按租户、币种和商品汇总的销量，只统计计入销售额的订单，已退款的数量不计入
+type DailyProductSales struct {
+	ID       uint   `gorm:"primarykey" json:"-"`
+	TenantId string `gorm:"column:tenant_id;size:64;uniqueIndex:idx_daily_products;not null;default:''" json:"tenant_id"`
+	Day      string `gorm:"column:day;size:10;uniqueIndex:idx_daily_products;not null" json:"day"`
+	Currency string `gorm:"column:currency;size:8;uniqueIndex:idx_daily_products;not null;default:''" json:"currency"`
+	SKUId    int64  `gorm:"column:sku_id;uniqueIndex:idx_daily_products;not null" json:"sku_id"`
+	Quantity int64  `gorm:"column:quantity;not null;default:0" json:"quantity"`
+	// Revenue 优惠后的金额，部分退款的订单项按未退款的数量折算
+	Revenue     int64     `gorm:"column:revenue;not null;default:0" json:"revenue"`
+	OrderCount  int64     `gorm:"column:order_count;not null;default:0" json:"order_count"`
+	RefreshedAt time.Time `gorm:"column:refreshed_at" json:"refreshed_at"`
+}
+
+func (DailyProductSales) TableName() string {
+	return "order_daily_products"
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// 下单流程的状态
+const (
+	SagaRunning      = "RUNNING"
+	SagaCompleted    = "COMPLETED"
+	SagaCompensating = "COMPENSATING" // 某一步失败，正在倒序执行补偿
+	SagaCompensated  = "COMPENSATED"  // 补偿完成，下单失败
+)
+
+// SagaFinished 流程是否已经结束，结束的流程不会再执行
+func SagaFinished(status string) bool {
+	return status == SagaCompleted || status == SagaCompensated
+}
+
+// OrderSaga 一次下单流程的持久化状态：预占库存、创建订单、发起支付
+// 每完成一步都会落库，服务重启后可以从中断的位置继续执行或补偿
+type OrderSaga struct {
+	ID       uint   `gorm:"primarykey" json:"id"`
+	SagaId   string `gorm:"column:saga_id;size:64;uniqueIndex" json:"saga_id"`
+	OrderId  string `gorm:"column:order_id;size:191;index" json:"order_id"`
+	UserId   int64  `gorm:"column:user_id" json:"user_id"`
+	TenantId string `gorm:"column:tenant_id;size:64;index;not null;default:''" json:"tenant_id"`
+	Status   string `gorm:"column:status;size:16;index" json:"status"`
+	// Step 已经完成的步骤数，补偿时从 Step-1 倒序执行
+	Step          int    `gorm:"column:step" json:"step"`
+	Payload       string `gorm:"column:payload;type:text;serializer:encrypt" json:"payload"` // 下单请求中的订单和订单项的json，加密存储
+	ReservationId string `gorm:"column:reservation_id" json:"reservation_id"`
+	PaymentId     string `gorm:"column:payment_id" json:"payment_id"`
+	Attempts      int    `gorm:"column:attempts" json:"attempts"` // 所有步骤累计的重试次数
+	LastError     string `gorm:"column:last_error;type:text" json:"last_error"`
+	// LeaseOwner 正在执行流程的调用，LeaseUntil 之前其他调用和 Recover 不能执行同一个流程，为空表示没有在执行
+	LeaseOwner string     `gorm:"column:lease_owner;size:64" json:"lease_owner"`
+	LeaseUntil *time.Time `gorm:"column:lease_until" json:"lease_until"`
+	CreatedAt  time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (OrderSaga) TableName() string {
+	return "order_sagas"
+}
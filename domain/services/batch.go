@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+)
+
+// MaxBatchSize 批量查询和批量修改一次最多处理的订单数
+const MaxBatchSize = 100
+
+// ErrBatchTooLarge 一次请求的订单数超过 MaxBatchSize
+var ErrBatchTooLarge = fmt.Errorf("batch can not contain more than %d orders", MaxBatchSize)
+
+// StatusUpdate 批量修改中的一个订单，Version 是调用方读到的当前版本号
+type StatusUpdate struct {
+	OrderId string
+	Status  int8
+	Version int64
+	// 为空时使用context 中的修改原因
+	Reason string
+}
+
+// StatusUpdateResult 一个订单的修改结果，成功时 Version 是修改后的版本号
+type StatusUpdateResult struct {
+	OrderId string
+	Version int64
+	Err     error
+	// 修改的是子订单时为父订单号，父订单的状态随之重新汇总
+	ParentOrderId string
+}
+
+// uniqueIds 去掉重复的订单号，保持原来的顺序
+func uniqueIds(orderIds []string) []string {
+	seen := map[string]bool{}
+	unique := make([]string, 0, len(orderIds))
+	for _, orderId := range orderIds {
+		if !seen[orderId] {
+			seen[orderId] = true
+			unique = append(unique, orderId)
+		}
+	}
+	return unique
+}
+
+// orderedResult 按请求的顺序排列找到的订单，没有找到的订单号放入 missing
+func orderedResult(orderIds []string, found map[string]*models.Order) ([]*models.Order, []string) {
+	orders := []*models.Order{}
+	missing := []string{}
+	for _, orderId := range orderIds {
+		if order, ok := found[orderId]; ok {
+			orders = append(orders, order)
+		} else {
+			missing = append(missing, orderId)
+		}
+	}
+	return orders, missing
+}
+
+// BatchGetOrders 一次查询多个订单，按请求的顺序返回找到的订单，以及不存在的订单号
+func (o *OrderService) BatchGetOrders(ctx context.Context, orderIds []string) ([]*models.Order, []string, error) {
+	orderIds = uniqueIds(orderIds)
+	if len(orderIds) > MaxBatchSize {
+		return nil, nil, ErrBatchTooLarge
+	}
+	orders, err := o.OrderDAO.GetOrdersByIds(ctx, orderIds)
+	if err != nil {
+		return nil, nil, err
+	}
+	found := map[string]*models.Order{}
+	for _, order := range orders {
+		found[order.OrderId] = order
+	}
+	orders, missing := orderedResult(orderIds, found)
+	return orders, missing, nil
+}
+
+// BatchUpdateStatus 逐个按版本号修改订单状态，每个订单单独提交，一个订单失败不影响其他订单
+// 按结构体更新时零值字段不会写入，所以不能改回未支付
+func (o *OrderService) BatchUpdateStatus(ctx context.Context, updates []*StatusUpdate) ([]*StatusUpdateResult, error) {
+	if len(updates) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	audit := dao.AuditFrom(ctx)
+	results := make([]*StatusUpdateResult, 0, len(updates))
+	for _, update := range updates {
+		result := &StatusUpdateResult{OrderId: update.OrderId}
+		results = append(results, result)
+		if update.Status == models.StatusUnpaid {
+			result.Err = models.ErrInvalidTransition
+			continue
+		}
+		reason := audit.Reason
+		if update.Reason != "" {
+			reason = update.Reason
+		}
+		order := &models.Order{
+			OrderId:      update.OrderId,
+			Status:       update.Status,
+			OrderVersion: update.Version + 1,
+		}
+		rowAffected, err := o.UpdateOrder(dao.WithAudit(ctx, audit.Actor, reason), order, update.Version)
+		if err == nil && rowAffected == 0 {
+			err = errors.New("update order failed, row affected is 0")
+		}
+		if err != nil {
+			result.Err = err
+			continue
+		}
+		result.Version = order.OrderVersion
+		result.ParentOrderId = order.ParentOrderId
+	}
+	return results, nil
+}
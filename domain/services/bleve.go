@@ -0,0 +1,189 @@
+package services
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/mapping"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+)
+
+// bleve 索引中的字段，除创建时间外都按关键字整体索引
+const (
+	searchFieldOrderId   = "order_id"
+	searchFieldTenant    = "tenant"
+	searchFieldUserId    = "user_id"
+	searchFieldStatus    = "status"
+	searchFieldSKU       = "sku"
+	searchFieldDay       = "day"
+	searchFieldCreatedAt = "created_at"
+)
+
+// maxDayFacets 按天的分面最多统计的天数
+const maxDayFacets = 10000
+
+// BleveIndexer 本地的bleve 全文索引，每个实例各自维护，由订单变更事件同步
+// 索引只保存搜索需要的字段，不包含地址和联系方式
+type BleveIndexer struct {
+	index bleve.Index
+}
+
+// NewBleveIndexer 打开 path 下的索引，不存在时新建，新建的索引需要用 IndexSyncer.Rebuild 写入已有的订单
+// path 为空时索引只在内存中
+func NewBleveIndexer(path string) (*BleveIndexer, bool, error) {
+	if path == "" {
+		index, err := bleve.NewMemOnly(newSearchMapping())
+		return &BleveIndexer{index: index}, true, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		index, err := bleve.Open(path)
+		return &BleveIndexer{index: index}, false, err
+	}
+	index, err := bleve.New(path, newSearchMapping())
+	return &BleveIndexer{index: index}, true, err
+}
+
+func newSearchMapping() mapping.IndexMapping {
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+	keywordField.Store = false
+	keywordField.IncludeInAll = false
+	keywordField.IncludeTermVectors = false
+	createdAtField := bleve.NewDateTimeFieldMapping()
+	createdAtField.Store = false
+	createdAtField.IncludeInAll = false
+
+	orderMapping := bleve.NewDocumentStaticMapping()
+	for _, field := range []string{searchFieldOrderId, searchFieldTenant, searchFieldUserId, searchFieldStatus, searchFieldSKU, searchFieldDay} {
+		orderMapping.AddFieldMappingsAt(field, keywordField)
+	}
+	orderMapping.AddFieldMappingsAt(searchFieldCreatedAt, createdAtField)
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = orderMapping
+	indexMapping.DefaultAnalyzer = keyword.Name
+	return indexMapping
+}
+
+// tenantTerm 空租户没有可以索引的词，用 "-" 代替
+func tenantTerm(tenantId string) string {
+	if tenantId == "" {
+		return "-"
+	}
+	return tenantId
+}
+
+func (b *BleveIndexer) Index(ctx context.Context, order *models.Order, items []models.OrderItem) error {
+	skus := []string{}
+	for _, item := range items {
+		skus = append(skus, strconv.FormatInt(item.SKUId, 10))
+	}
+	return b.index.Index(order.OrderId, map[string]interface{}{
+		// 订单号按小写索引，搜索时不区分大小写
+		searchFieldOrderId:   strings.ToLower(order.OrderId),
+		searchFieldTenant:    tenantTerm(order.TenantId),
+		searchFieldUserId:    strconv.FormatInt(order.UserId, 10),
+		searchFieldStatus:    strconv.Itoa(int(order.Status)),
+		searchFieldSKU:       skus,
+		searchFieldDay:       order.CreatedAt.Format("2006-01-02"),
+		searchFieldCreatedAt: order.CreatedAt,
+	})
+}
+
+func (b *BleveIndexer) Remove(ctx context.Context, orderId string) error {
+	return b.index.Delete(orderId)
+}
+
+// Empty 索引中是否还没有订单
+func (b *BleveIndexer) Empty() (bool, error) {
+	count, err := b.index.DocCount()
+	return count == 0, err
+}
+
+func (b *BleveIndexer) Close() error {
+	return b.index.Close()
+}
+
+func termQuery(field, term string) query.Query {
+	q := bleve.NewTermQuery(term)
+	q.SetField(field)
+	return q
+}
+
+func (b *BleveIndexer) Search(ctx context.Context, q *dao.OrderSearchQuery) (*dao.OrderSearchResult, error) {
+	conjuncts := []query.Query{}
+	if tenantId, ok := dao.TenantFrom(ctx); ok {
+		conjuncts = append(conjuncts, termQuery(searchFieldTenant, tenantTerm(tenantId)))
+	}
+	if q.Text != "" {
+		text := bleve.NewRegexpQuery(".*" + regexp.QuoteMeta(strings.ToLower(q.Text)) + ".*")
+		text.SetField(searchFieldOrderId)
+		conjuncts = append(conjuncts, text)
+	}
+	if q.UserId != 0 {
+		conjuncts = append(conjuncts, termQuery(searchFieldUserId, strconv.FormatInt(q.UserId, 10)))
+	}
+	if q.SKUId != 0 {
+		conjuncts = append(conjuncts, termQuery(searchFieldSKU, strconv.FormatInt(q.SKUId, 10)))
+	}
+	if q.Status != nil {
+		conjuncts = append(conjuncts, termQuery(searchFieldStatus, strconv.Itoa(int(*q.Status))))
+	}
+	// 和数据库搜索一样包含开始时间，不包含结束时间，只有一端时另一端不限
+	if !q.CreatedAfter.IsZero() || !q.CreatedBefore.IsZero() {
+		inclusive, exclusive := true, false
+		created := bleve.NewDateRangeInclusiveQuery(q.CreatedAfter, q.CreatedBefore, &inclusive, &exclusive)
+		created.SetField(searchFieldCreatedAt)
+		conjuncts = append(conjuncts, created)
+	}
+	var match query.Query = bleve.NewMatchAllQuery()
+	if len(conjuncts) > 0 {
+		match = bleve.NewConjunctionQuery(conjuncts...)
+	}
+
+	request := bleve.NewSearchRequestOptions(match, q.Limit, q.Offset, false)
+	if q.Limit <= 0 || q.Limit > dao.MaxListLimit {
+		request.Size = dao.MaxListLimit
+	}
+	request.SortBy([]string{"-" + searchFieldCreatedAt, "-_id"})
+	request.AddFacet(searchFieldStatus, bleve.NewFacetRequest(searchFieldStatus, int(models.StatusDelivered)+1))
+	request.AddFacet(searchFieldDay, bleve.NewFacetRequest(searchFieldDay, maxDayFacets))
+	res, err := b.index.SearchInContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &dao.OrderSearchResult{
+		Total:        int64(res.Total),
+		OrderIds:     []string{},
+		StatusCounts: map[int8]int64{},
+		DayCounts:    []dao.DayCount{},
+	}
+	for _, hit := range res.Hits {
+		result.OrderIds = append(result.OrderIds, hit.ID)
+	}
+	if facet, ok := res.Facets[searchFieldStatus]; ok {
+		for _, term := range facet.Terms {
+			status, err := strconv.Atoi(term.Term)
+			if err != nil {
+				continue
+			}
+			result.StatusCounts[int8(status)] = int64(term.Count)
+		}
+	}
+	if facet, ok := res.Facets[searchFieldDay]; ok {
+		for _, term := range facet.Terms {
+			result.DayCounts = append(result.DayCounts, dao.DayCount{Day: term.Term, Count: int64(term.Count)})
+		}
+	}
+	sort.Slice(result.DayCounts, func(i, j int) bool { return result.DayCounts[i].Day < result.DayCounts[j].Day })
+	return result, nil
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+)
+
+// ErrBulkNotSupported DAO 没有实现批量导入导出
+var ErrBulkNotSupported = errors.New("order dao does not support bulk import and export")
+
+// BulkService 批量导出订单到文件，以及从文件导入订单
+type BulkService struct {
+	orders dao.BulkOrderDAO
+}
+
+func NewBulkService(orderdao dao.OrderDAOInterface) (*BulkService, error) {
+	bulk, ok := orderdao.(dao.BulkOrderDAO)
+	if !ok {
+		return nil, ErrBulkNotSupported
+	}
+	return &BulkService{orders: bulk}, nil
+}
+
+// Export 流式导出符合条件的订单，返回导出的订单数，w 由调用方关闭
+func (b *BulkService) Export(ctx context.Context, query *dao.ListOrderQuery, w OrderWriter) (int, error) {
+	exported := 0
+	err := b.orders.ExportOrders(ctx, query, dao.DefaultBulkBatch, func(orders []*models.Order) error {
+		for _, order := range orders {
+			if err := w.Write(newOrderRecord(order)); err != nil {
+				return err
+			}
+		}
+		exported += len(orders)
+		return nil
+	})
+	return exported, err
+}
+
+// ImportOptions 导入参数
+type ImportOptions struct {
+	// Batch 每个事务写入的订单数，为0 时使用 dao.DefaultBulkBatch
+	Batch int
+	// Resume 跳过文件开头已经处理过的订单数，从上次导入报告的 Checkpoint 继续
+	Resume int
+	// OnCheckpoint 每批提交后调用，可以把进度写到文件里，导入中断后用来恢复
+	OnCheckpoint func(checkpoint int)
+}
+
+// RejectedRecord 不能导入的订单，Position 是它在文件中的位置
+type RejectedRecord struct {
+	Position int    `json:"position"`
+	OrderId  string `json:"order_id"`
+	Reason   string `json:"reason"`
+}
+
+// ImportReport 导入结果
+type ImportReport struct {
+	// Read 这次读到的订单数，不包括恢复时跳过的订单
+	Read     int              `json:"read"`
+	Imported int              `json:"imported"`
+	Existing int              `json:"existing"`
+	Rejected []RejectedRecord `json:"rejected"`
+	// Checkpoint 文件开头已经处理完的订单数，中断后以它作为 Resume 重新导入
+	Checkpoint int `json:"checkpoint"`
+}
+
+// Import 逐个读取订单，校验后按批写入，每批一个事务
+// 订单号已经存在的订单会跳过，所以即使不指定 Resume，重新导入整个文件也不会重复写入
+func (b *BulkService) Import(ctx context.Context, r OrderReader, options ImportOptions) (*ImportReport, error) {
+	batchSize := options.Batch
+	if batchSize <= 0 {
+		batchSize = dao.DefaultBulkBatch
+	}
+	report := &ImportReport{Rejected: []RejectedRecord{}, Checkpoint: options.Resume}
+	for skipped := 0; skipped < options.Resume; skipped++ {
+		if _, _, err := r.Read(); err == io.EOF {
+			return report, nil
+		}
+	}
+
+	batch := []*models.Order{}
+	positions := map[string]int{}
+	// consumed 上次提交后读到的订单数，包括校验失败的订单
+	consumed := 0
+	flush := func() error {
+		if len(batch) > 0 {
+			result, err := b.orders.ImportOrders(ctx, batch)
+			if err != nil {
+				return fmt.Errorf("import orders after checkpoint %d: %v", report.Checkpoint, err)
+			}
+			report.Imported += result.Imported
+			report.Existing += len(result.Existing)
+			for orderId, reason := range result.Rejected {
+				report.Rejected = append(report.Rejected, RejectedRecord{Position: positions[orderId], OrderId: orderId, Reason: reason})
+			}
+		}
+		report.Checkpoint += consumed
+		if options.OnCheckpoint != nil && consumed > 0 {
+			options.OnCheckpoint(report.Checkpoint)
+		}
+		batch, positions, consumed = []*models.Order{}, map[string]int{}, 0
+		return nil
+	}
+
+	for {
+		record, position, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		report.Read++
+		consumed++
+		if err == nil {
+			var order *models.Order
+			if order, err = record.toOrder(ctx); err == nil {
+				if _, ok := positions[order.OrderId]; ok {
+					err = errors.New("duplicate order id in the same batch")
+				} else {
+					batch = append(batch, order)
+					positions[order.OrderId] = position
+				}
+			}
+		}
+		if err != nil {
+			orderId := ""
+			if record != nil {
+				orderId = record.OrderId
+			}
+			report.Rejected = append(report.Rejected, RejectedRecord{Position: position, OrderId: orderId, Reason: err.Error()})
+		}
+		if len(batch) >= batchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	return report, flush()
+}
+
+// toOrder 校验一条记录并转换成订单，金额和数量不能为负数，状态必须是已知的状态
+func (r *OrderRecord) toOrder(ctx context.Context) (*models.Order, error) {
+	if r.OrderId == "" {
+		return nil, errors.New("order_id is required")
+	}
+	if len(r.OrderId) > 191 {
+		return nil, errors.New("order_id is too long")
+	}
+	if r.UserId <= 0 {
+		return nil, errors.New("user_id must be positive")
+	}
+	if r.Status < models.StatusUnpaid || r.Status > models.StatusDelivered {
+		return nil, fmt.Errorf("unknown status %d", r.Status)
+	}
+	if r.OrderVersion < 0 {
+		return nil, errors.New("order_version must not be negative")
+	}
+	if r.TotalAmount < 0 || r.DiscountAmount < 0 || r.PaidAmount < 0 {
+		return nil, errors.New("amounts must not be negative")
+	}
+	if len(r.Currency) > 8 {
+		return nil, fmt.Errorf("invalid currency %q", r.Currency)
+	}
+	if tenantId, ok := dao.TenantFrom(ctx); ok && r.TenantId != "" && r.TenantId != tenantId {
+		return nil, fmt.Errorf("order belongs to tenant %q", r.TenantId)
+	}
+	createdAt := time.Now()
+	if r.CreatedAt != "" {
+		var err error
+		if createdAt, err = time.Parse(time.RFC3339Nano, r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("invalid created_at: %v", err)
+		}
+	}
+	order := &models.Order{
+		OrderId:         r.OrderId,
+		TenantId:        r.TenantId,
+		UserId:          r.UserId,
+		Status:          r.Status,
+		OrderVersion:    r.OrderVersion,
+		OrderData:       r.OrderData,
+		TotalAmount:     r.TotalAmount,
+		DiscountAmount:  r.DiscountAmount,
+		PaidAmount:      r.PaidAmount,
+		Currency:        r.Currency,
+		ShippingAddress: r.ShippingAddress,
+		BillingAddress:  r.BillingAddress,
+		Contact:         r.Contact,
+	}
+	order.CreatedAt = createdAt
+	for i, item := range r.Items {
+		if item.SKUId <= 0 {
+			return nil, fmt.Errorf("item %d: sku_id must be positive", i+1)
+		}
+		if item.Count <= 0 {
+			return nil, fmt.Errorf("item %d: count must be positive", i+1)
+		}
+		if item.Price < 0 || item.Discount < 0 || item.Discount > item.Price*int64(item.Count) {
+			return nil, fmt.Errorf("item %d: invalid price or discount", i+1)
+		}
+		order.Items = append(order.Items, models.OrderItem{SKUId: item.SKUId, Count: item.Count, Price: item.Price, Discount: item.Discount})
+	}
+	return order, nil
+}
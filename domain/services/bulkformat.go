@@ -0,0 +1,466 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// 批量导入导出支持的文件格式
+const (
+	FormatCSV     = "csv"
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// OrderRecord 导入导出文件中的一个订单，JSON Lines 每行一个，时间使用RFC3339
+type OrderRecord struct {
+	OrderId         string         `json:"order_id"`
+	TenantId        string         `json:"tenant_id"`
+	UserId          int64          `json:"user_id"`
+	Status          int8           `json:"status"`
+	OrderVersion    int64          `json:"order_version"`
+	OrderData       string         `json:"order_data"`
+	TotalAmount     int64          `json:"total_amount"`
+	DiscountAmount  int64          `json:"discount_amount"`
+	PaidAmount      int64          `json:"paid_amount"`
+	Currency        string         `json:"currency"`
+	CreatedAt       string         `json:"created_at"`
+	ShippingAddress models.Address `json:"shipping_address"`
+	BillingAddress  models.Address `json:"billing_address"`
+	Contact         models.Contact `json:"contact"`
+	Items           []ItemRecord   `json:"items"`
+}
+
+type ItemRecord struct {
+	SKUId    int64 `json:"sku_id"`
+	Count    int32 `json:"count"`
+	Price    int64 `json:"price"`
+	Discount int64 `json:"discount"`
+}
+
+func newOrderRecord(order *models.Order) *OrderRecord {
+	record := &OrderRecord{
+		OrderId:         order.OrderId,
+		TenantId:        order.TenantId,
+		UserId:          order.UserId,
+		Status:          order.Status,
+		OrderVersion:    order.OrderVersion,
+		OrderData:       order.OrderData,
+		TotalAmount:     order.TotalAmount,
+		DiscountAmount:  order.DiscountAmount,
+		PaidAmount:      order.PaidAmount,
+		Currency:        order.Currency,
+		CreatedAt:       order.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ShippingAddress: order.ShippingAddress,
+		BillingAddress:  order.BillingAddress,
+		Contact:         order.Contact,
+		Items:           []ItemRecord{},
+	}
+	for _, item := range order.Items {
+		record.Items = append(record.Items, ItemRecord{SKUId: item.SKUId, Count: item.Count, Price: item.Price, Discount: item.Discount})
+	}
+	return record
+}
+
+// OrderRow CSV 和Parquet 中的一行，每个订单项一行，订单的字段在每行重复，没有订单项的订单只有一行且订单项字段为零值
+// 连续的订单号相同的行属于同一个订单
+type OrderRow struct {
+	OrderId            string `json:"order_id" parquet:"name=order_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TenantId           string `json:"tenant_id" parquet:"name=tenant_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	UserId             int64  `json:"user_id" parquet:"name=user_id, type=INT64"`
+	Status             int32  `json:"status" parquet:"name=status, type=INT32"`
+	OrderVersion       int64  `json:"order_version" parquet:"name=order_version, type=INT64"`
+	OrderData          string `json:"order_data" parquet:"name=order_data, type=BYTE_ARRAY, convertedtype=UTF8"`
+	TotalAmount        int64  `json:"total_amount" parquet:"name=total_amount, type=INT64"`
+	DiscountAmount     int64  `json:"discount_amount" parquet:"name=discount_amount, type=INT64"`
+	PaidAmount         int64  `json:"paid_amount" parquet:"name=paid_amount, type=INT64"`
+	Currency           string `json:"currency" parquet:"name=currency, type=BYTE_ARRAY, convertedtype=UTF8"`
+	CreatedAt          string `json:"created_at" parquet:"name=created_at, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ShippingLine1      string `json:"shipping_line1" parquet:"name=shipping_line1, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ShippingLine2      string `json:"shipping_line2" parquet:"name=shipping_line2, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ShippingCity       string `json:"shipping_city" parquet:"name=shipping_city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ShippingState      string `json:"shipping_state" parquet:"name=shipping_state, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ShippingPostalCode string `json:"shipping_postal_code" parquet:"name=shipping_postal_code, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ShippingCountry    string `json:"shipping_country" parquet:"name=shipping_country, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BillingLine1       string `json:"billing_line1" parquet:"name=billing_line1, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BillingLine2       string `json:"billing_line2" parquet:"name=billing_line2, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BillingCity        string `json:"billing_city" parquet:"name=billing_city, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BillingState       string `json:"billing_state" parquet:"name=billing_state, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BillingPostalCode  string `json:"billing_postal_code" parquet:"name=billing_postal_code, type=BYTE_ARRAY, convertedtype=UTF8"`
+	BillingCountry     string `json:"billing_country" parquet:"name=billing_country, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContactName        string `json:"contact_name" parquet:"name=contact_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContactPhone       string `json:"contact_phone" parquet:"name=contact_phone, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContactEmail       string `json:"contact_email" parquet:"name=contact_email, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ItemSKUId          int64  `json:"item_sku_id" parquet:"name=item_sku_id, type=INT64"`
+	ItemCount          int32  `json:"item_count" parquet:"name=item_count, type=INT32"`
+	ItemPrice          int64  `json:"item_price" parquet:"name=item_price, type=INT64"`
+	ItemDiscount       int64  `json:"item_discount" parquet:"name=item_discount, type=INT64"`
+}
+
+// rows 把订单展开成每个订单项一行
+func (r *OrderRecord) rows() []OrderRow {
+	row := OrderRow{
+		OrderId:            r.OrderId,
+		TenantId:           r.TenantId,
+		UserId:             r.UserId,
+		Status:             int32(r.Status),
+		OrderVersion:       r.OrderVersion,
+		OrderData:          r.OrderData,
+		TotalAmount:        r.TotalAmount,
+		DiscountAmount:     r.DiscountAmount,
+		PaidAmount:         r.PaidAmount,
+		Currency:           r.Currency,
+		CreatedAt:          r.CreatedAt,
+		ShippingLine1:      r.ShippingAddress.Line1,
+		ShippingLine2:      r.ShippingAddress.Line2,
+		ShippingCity:       r.ShippingAddress.City,
+		ShippingState:      r.ShippingAddress.State,
+		ShippingPostalCode: r.ShippingAddress.PostalCode,
+		ShippingCountry:    r.ShippingAddress.Country,
+		BillingLine1:       r.BillingAddress.Line1,
+		BillingLine2:       r.BillingAddress.Line2,
+		BillingCity:        r.BillingAddress.City,
+		BillingState:       r.BillingAddress.State,
+		BillingPostalCode:  r.BillingAddress.PostalCode,
+		BillingCountry:     r.BillingAddress.Country,
+		ContactName:        r.Contact.Name,
+		ContactPhone:       r.Contact.Phone,
+		ContactEmail:       r.Contact.Email,
+	}
+	if len(r.Items) == 0 {
+		return []OrderRow{row}
+	}
+	rows := make([]OrderRow, len(r.Items))
+	for i, item := range r.Items {
+		rows[i] = row
+		rows[i].ItemSKUId, rows[i].ItemCount, rows[i].ItemPrice, rows[i].ItemDiscount = item.SKUId, item.Count, item.Price, item.Discount
+	}
+	return rows
+}
+
+// record 第一行的订单字段，订单项字段为零值的行不是订单项
+func (row *OrderRow) record() *OrderRecord {
+	return &OrderRecord{
+		OrderId:        row.OrderId,
+		TenantId:       row.TenantId,
+		UserId:         row.UserId,
+		Status:         int8(row.Status),
+		OrderVersion:   row.OrderVersion,
+		OrderData:      row.OrderData,
+		TotalAmount:    row.TotalAmount,
+		DiscountAmount: row.DiscountAmount,
+		PaidAmount:     row.PaidAmount,
+		Currency:       row.Currency,
+		CreatedAt:      row.CreatedAt,
+		ShippingAddress: models.Address{
+			Line1: row.ShippingLine1, Line2: row.ShippingLine2, City: row.ShippingCity,
+			State: row.ShippingState, PostalCode: row.ShippingPostalCode, Country: row.ShippingCountry,
+		},
+		BillingAddress: models.Address{
+			Line1: row.BillingLine1, Line2: row.BillingLine2, City: row.BillingCity,
+			State: row.BillingState, PostalCode: row.BillingPostalCode, Country: row.BillingCountry,
+		},
+		Contact: models.Contact{Name: row.ContactName, Phone: row.ContactPhone, Email: row.ContactEmail},
+	}
+}
+
+func (row *OrderRow) item() (ItemRecord, bool) {
+	item := ItemRecord{SKUId: row.ItemSKUId, Count: row.ItemCount, Price: row.ItemPrice, Discount: row.ItemDiscount}
+	return item, item != ItemRecord{}
+}
+
+// OrderWriter 流式写入导出文件，Close 之后文件才完整
+type OrderWriter interface {
+	Write(record *OrderRecord) error
+	Close() error
+}
+
+// OrderReader 流式读取导入文件
+type OrderReader interface {
+	// Read 返回下一个订单和它在文件中的位置：CSV 和JSON Lines 是行号，Parquet 是第几行数据，都从1开始
+	// 没有更多订单时返回 io.EOF；返回其他错误时这个位置的数据无法解析，可以继续读下一个订单
+	Read() (*OrderRecord, int, error)
+	Close() error
+}
+
+// NewOrderWriter 按格式创建导出文件的写入器，不会关闭 w
+func NewOrderWriter(format string, w io.Writer) (OrderWriter, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlWriter{w: bufio.NewWriter(w)}, nil
+	case FormatCSV:
+		writer := &csvWriter{w: csv.NewWriter(w)}
+		return writer, writer.w.Write(csvHeader())
+	case FormatParquet:
+		pw, err := writer.NewParquetWriterFromWriter(w, new(OrderRow), 1)
+		if err != nil {
+			return nil, err
+		}
+		return &parquetWriter{w: pw}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// OpenOrderReader 按格式打开导入文件，Parquet 需要随机读取，所以只支持文件
+func OpenOrderReader(format string, path string) (OrderReader, error) {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatJSONL:
+		return &jsonlReader{r: bufio.NewReader(file), file: file}, nil
+	case FormatCSV:
+		r := csv.NewReader(file)
+		r.FieldsPerRecord = len(csvHeader())
+		if _, err := r.Read(); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("read csv header: %v", err)
+		}
+		return &rowReader{next: csvRows(r), file: file}, nil
+	case FormatParquet:
+		pr, err := reader.NewParquetReader(file, new(OrderRow), 1)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &rowReader{next: parquetRows(pr), file: file}, nil
+	}
+	file.Close()
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+type jsonlWriter struct {
+	w *bufio.Writer
+}
+
+func (j *jsonlWriter) Write(record *OrderRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	j.w.Write(data)
+	return j.w.WriteByte('\n')
+}
+
+func (j *jsonlWriter) Close() error {
+	return j.w.Flush()
+}
+
+type jsonlReader struct {
+	r    *bufio.Reader
+	file source.ParquetFile
+	line int
+}
+
+func (j *jsonlReader) Read() (*OrderRecord, int, error) {
+	for {
+		data, err := j.r.ReadBytes('\n')
+		if len(data) == 0 && err != nil {
+			return nil, j.line, err
+		}
+		j.line++
+		if len(data) == 0 || (len(data) == 1 && data[0] == '\n') {
+			continue
+		}
+		record := &OrderRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, j.line, err
+		}
+		return record, j.line, nil
+	}
+}
+
+func (j *jsonlReader) Close() error {
+	return j.file.Close()
+}
+
+// csvColumns OrderRow 的字段，按json tag 作为CSV 的列名
+func csvColumns() []reflect.StructField {
+	t := reflect.TypeOf(OrderRow{})
+	fields := make([]reflect.StructField, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i)
+	}
+	return fields
+}
+
+func csvHeader() []string {
+	header := []string{}
+	for _, field := range csvColumns() {
+		header = append(header, field.Tag.Get("json"))
+	}
+	return header
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(record *OrderRecord) error {
+	for _, row := range record.rows() {
+		value := reflect.ValueOf(row)
+		line := make([]string, value.NumField())
+		for i := range line {
+			field := value.Field(i)
+			if field.Kind() == reflect.String {
+				line[i] = field.String()
+			} else {
+				line[i] = strconv.FormatInt(field.Int(), 10)
+			}
+		}
+		if err := c.w.Write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type parquetWriter struct {
+	w *writer.ParquetWriter
+}
+
+func (p *parquetWriter) Write(record *OrderRecord) error {
+	for _, row := range record.rows() {
+		if err := p.w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *parquetWriter) Close() error {
+	return p.w.WriteStop()
+}
+
+// csvRows 逐行解析CSV，返回行号，表头是第1行
+func csvRows(r *csv.Reader) func() (*OrderRow, int, error) {
+	return func() (*OrderRow, int, error) {
+		values, err := r.Read()
+		if err != nil {
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				return nil, parseErr.StartLine, err
+			}
+			return nil, 0, err
+		}
+		line, _ := r.FieldPos(0)
+		row := &OrderRow{}
+		value := reflect.ValueOf(row).Elem()
+		for i, field := range csvColumns() {
+			target := value.Field(i)
+			if target.Kind() == reflect.String {
+				target.SetString(values[i])
+				continue
+			}
+			if values[i] == "" {
+				continue
+			}
+			n, err := strconv.ParseInt(values[i], 10, target.Type().Bits())
+			if err != nil {
+				return nil, line, fmt.Errorf("column %s: %v", field.Tag.Get("json"), err)
+			}
+			target.SetInt(n)
+		}
+		return row, line, nil
+	}
+}
+
+// parquetRows 按批读出Parquet 中的行
+func parquetRows(pr *reader.ParquetReader) func() (*OrderRow, int, error) {
+	total := int(pr.GetNumRows())
+	buffer := []OrderRow{}
+	read := 0
+	return func() (*OrderRow, int, error) {
+		if len(buffer) == 0 {
+			if read >= total {
+				pr.ReadStop()
+				return nil, read, io.EOF
+			}
+			n := total - read
+			if n > 256 {
+				n = 256
+			}
+			buffer = make([]OrderRow, n)
+			if err := pr.Read(&buffer); err != nil {
+				return nil, read, err
+			}
+		}
+		row := buffer[0]
+		buffer = buffer[1:]
+		read++
+		return &row, read, nil
+	}
+}
+
+// rowReader 把连续的订单号相同的行合并成一个订单
+type rowReader struct {
+	next    func() (*OrderRow, int, error)
+	file    source.ParquetFile
+	pending *OrderRow
+	line    int
+}
+
+func (r *rowReader) Read() (*OrderRecord, int, error) {
+	first, line := r.pending, r.line
+	r.pending = nil
+	if first == nil {
+		var err error
+		if first, line, err = r.next(); err != nil {
+			return nil, line, err
+		}
+	}
+	record := first.record()
+	if item, ok := first.item(); ok {
+		record.Items = append(record.Items, item)
+	}
+	for {
+		row, next, err := r.next()
+		if err != nil {
+			// 下一行的错误留给下一次 Read 返回
+			if err != io.EOF {
+				r.next = failOnce(r.next, next, err)
+			}
+			return record, line, nil
+		}
+		if row.OrderId != first.OrderId {
+			r.pending, r.line = row, next
+			return record, line, nil
+		}
+		if item, ok := row.item(); ok {
+			record.Items = append(record.Items, item)
+		}
+	}
+}
+
+func (r *rowReader) Close() error {
+	return r.file.Close()
+}
+
+// failOnce 先返回一次 err，之后继续读 next
+func failOnce(next func() (*OrderRow, int, error), line int, err error) func() (*OrderRow, int, error) {
+	failed := false
+	return func() (*OrderRow, int, error) {
+		if !failed {
+			failed = true
+			return nil, line, err
+		}
+		return next()
+	}
+}
@@ -0,0 +1,478 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+// 缓存命中和未命中次数，layer 区分本地LRU和redis
+var cacheRequests = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "order_cache_requests_total",
+		Help: "Total number of order cache lookups",
+	},
+	[]string{"layer", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequests)
+}
+
+// OrderCache 订单缓存
+// Invalidate 记录订单的最新版本号，之后版本号更小的数据不会再被写入缓存，
+// 避免并发回源时把更新前读到的旧数据重新放进缓存
+type OrderCache interface {
+	Get(orderId string) (*models.Order, bool)
+	Set(order *models.Order)
+	Invalidate(orderId string, version int64)
+}
+
+// CachedOrderService 在 OrderServiceInterface 外面包一层读穿缓存
+type CachedOrderService struct {
+	OrderServiceInterface
+	cache OrderCache
+	group singleflight.Group
+}
+
+func NewCachedOrderService(next OrderServiceInterface, cache OrderCache) OrderServiceInterface {
+	return &CachedOrderService{
+		OrderServiceInterface: next,
+		cache:                 cache,
+	}
+}
+
+func (c *CachedOrderService) GetOrderById(ctx context.Context, orderId string) (*models.Order, error) {
+	// 缓存里只有未删除的订单
+	if dao.IncludeDeleted(ctx) {
+		return c.OrderServiceInterface.GetOrderById(ctx, orderId)
+	}
+	// 缓存按订单号共享，其他租户的订单按不存在处理
+	if order, ok := c.cache.Get(orderId); ok {
+		if !dao.TenantAllows(ctx, order.TenantId) {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return order, nil
+	}
+	// 同一个租户对同一个订单的并发回源合并成一次数据库查询
+	tenantId, _ := dao.TenantFrom(ctx)
+	value, err, _ := c.group.Do(tenantId+"/"+orderId, func() (interface{}, error) {
+		order, err := c.OrderServiceInterface.GetOrderById(ctx, orderId)
+		if err != nil {
+			return nil, err
+		}
+		c.cache.Set(order)
+		return order, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 返回副本，调用方修改结果不会污染其他等待者拿到的数据
+	order := *value.(*models.Order)
+	return &order, nil
+}
+
+// BatchGetOrders 先查缓存，未命中的订单一次回源后写入缓存
+func (c *CachedOrderService) BatchGetOrders(ctx context.Context, orderIds []string) ([]*models.Order, []string, error) {
+	if dao.IncludeDeleted(ctx) {
+		return c.OrderServiceInterface.BatchGetOrders(ctx, orderIds)
+	}
+	orderIds = uniqueIds(orderIds)
+	if len(orderIds) > MaxBatchSize {
+		return nil, nil, ErrBatchTooLarge
+	}
+	found := map[string]*models.Order{}
+	misses := []string{}
+	for _, orderId := range orderIds {
+		order, ok := c.cache.Get(orderId)
+		if !ok {
+			misses = append(misses, orderId)
+			continue
+		}
+		if dao.TenantAllows(ctx, order.TenantId) {
+			found[orderId] = order
+		}
+	}
+	if len(misses) > 0 {
+		orders, _, err := c.OrderServiceInterface.BatchGetOrders(ctx, misses)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, order := range orders {
+			c.cache.Set(order)
+			found[order.OrderId] = order
+		}
+	}
+	orders, missing := orderedResult(orderIds, found)
+	return orders, missing, nil
+}
+
+func (c *CachedOrderService) BatchUpdateStatus(ctx context.Context, updates []*StatusUpdate) ([]*StatusUpdateResult, error) {
+	results, err := c.OrderServiceInterface.BatchUpdateStatus(ctx, updates)
+	for _, result := range results {
+		if result.Err == nil {
+			c.cache.Invalidate(result.OrderId, result.Version)
+			c.invalidateParent(result.ParentOrderId)
+		}
+	}
+	return results, err
+}
+
+func (c *CachedOrderService) UpdateOrder(ctx context.Context, order *models.Order, oldversion int64) (int64, error) {
+	rowAffected, err := c.OrderServiceInterface.UpdateOrder(ctx, order, oldversion)
+	if err == nil && rowAffected > 0 {
+		c.cache.Invalidate(order.OrderId, order.OrderVersion)
+		c.invalidateParent(order.ParentOrderId)
+	}
+	return rowAffected, err
+}
+
+func (c *CachedOrderService) DeleteOrder(ctx context.Context, orderId string, oldversion int64) (int64, error) {
+	rowAffected, err := c.OrderServiceInterface.DeleteOrder(ctx, orderId, oldversion)
+	if err == nil && rowAffected > 0 {
+		c.cache.Invalidate(orderId, oldversion+1)
+	}
+	return rowAffected, err
+}
+
+func (c *CachedOrderService) RestoreOrder(ctx context.Context, orderId string) (int64, error) {
+	rowAffected, err := c.OrderServiceInterface.RestoreOrder(ctx, orderId)
+	if err == nil && rowAffected > 0 {
+		c.cache.Invalidate(orderId, 0)
+	}
+	return rowAffected, err
+}
+
+func (c *CachedOrderService) PurgeOrder(ctx context.Context, orderId string) (int64, error) {
+	rowAffected, err := c.OrderServiceInterface.PurgeOrder(ctx, orderId)
+	if err == nil && rowAffected > 0 {
+		// 物理删除后不允许任何旧数据再写入缓存
+		c.cache.Invalidate(orderId, math.MaxInt64)
+	}
+	return rowAffected, err
+}
+
+func (c *CachedOrderService) RefundOrder(ctx context.Context, refund *models.OrderRefund, lines []models.RefundLine) (*models.Order, error) {
+	order, err := c.OrderServiceInterface.RefundOrder(ctx, refund, lines)
+	if err == nil {
+		c.cache.Invalidate(order.OrderId, order.OrderVersion)
+		c.invalidateParent(order.ParentOrderId)
+	}
+	return order, err
+}
+
+func (c *CachedOrderService) ConfirmPayment(ctx context.Context, payment *models.OrderPayment) (*models.Order, error) {
+	order, err := c.OrderServiceInterface.ConfirmPayment(ctx, payment)
+	if order != nil {
+		c.cache.Invalidate(order.OrderId, order.OrderVersion)
+	}
+	return order, err
+}
+
+func (c *CachedOrderService) ShipOrder(ctx context.Context, fulfillment *models.OrderFulfillment) (*models.Order, error) {
+	order, err := c.OrderServiceInterface.ShipOrder(ctx, fulfillment)
+	if err == nil {
+		c.cache.Invalidate(order.OrderId, order.OrderVersion)
+		c.invalidateParent(order.ParentOrderId)
+	}
+	return order, err
+}
+
+func (c *CachedOrderService) MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*models.Order, error) {
+	order, err := c.OrderServiceInterface.MarkDelivered(ctx, orderId, deliveredAt)
+	if err == nil {
+		c.cache.Invalidate(order.OrderId, order.OrderVersion)
+		c.invalidateParent(order.ParentOrderId)
+	}
+	return order, err
+}
+
+func (c *CachedOrderService) AnonymizeOrder(ctx context.Context, orderId string) (*models.Order, error) {
+	order, err := c.OrderServiceInterface.AnonymizeOrder(ctx, orderId)
+	if err == nil {
+		c.cache.Invalidate(order.OrderId, order.OrderVersion)
+	}
+	return order, err
+}
+
+func (c *CachedOrderService) EraseOrder(ctx context.Context, orderId string) (int64, error) {
+	rowAffected, err := c.OrderServiceInterface.EraseOrder(ctx, orderId)
+	if err == nil {
+		c.cache.Invalidate(orderId, math.MaxInt64)
+	}
+	return rowAffected, err
+}
+
+func (c *CachedOrderService) CancelExpiredOrders(ctx context.Context, before time.Time, limit int) ([]*models.Order, error) {
+	cancelled, err := c.OrderServiceInterface.CancelExpiredOrders(ctx, before, limit)
+	for _, order := range cancelled {
+		c.cache.Invalidate(order.OrderId, order.OrderVersion)
+	}
+	return cancelled, err
+}
+
+// invalidateParent 子订单变化后父订单的状态重新汇总过，不知道父订单的版本号，直接删除缓存
+func (c *CachedOrderService) invalidateParent(parentId string) {
+	if parentId != "" {
+		c.cache.Invalidate(parentId, 0)
+	}
+}
+
+func (c *CachedOrderService) SplitOrder(ctx context.Context, orderId string, oldversion int64, groups [][]models.SplitLine) ([]*models.Order, error) {
+	children, err := c.OrderServiceInterface.SplitOrder(ctx, orderId, oldversion, groups)
+	if err == nil {
+		c.cache.Invalidate(orderId, oldversion+1)
+	}
+	return children, err
+}
+
+func (c *CachedOrderService) MergeOrders(ctx context.Context, versions map[string]int64) (*models.Order, error) {
+	merged, err := c.OrderServiceInterface.MergeOrders(ctx, versions)
+	if err == nil {
+		for orderId, version := range versions {
+			c.cache.Invalidate(orderId, version+1)
+		}
+	}
+	return merged, err
+}
+
+// lruEntry order 为空时表示失效标记，只保留 floor 版本号
+type lruEntry struct {
+	orderId  string
+	order    *models.Order
+	floor    int64
+	expireAt time.Time
+}
+
+// LRUCache 进程内的LRU缓存
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	items    map[string]*list.Element
+	ll       *list.List
+}
+
+func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		ttl:      ttl,
+		items:    make(map[string]*list.Element),
+		ll:       list.New(),
+	}
+}
+
+func (l *LRUCache) Get(orderId string) (*models.Order, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	elem, ok := l.items[orderId]
+	if !ok {
+		cacheRequests.WithLabelValues("lru", "miss").Inc()
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if entry.order == nil || (l.ttl > 0 && time.Now().After(entry.expireAt)) {
+		cacheRequests.WithLabelValues("lru", "miss").Inc()
+		return nil, false
+	}
+	l.ll.MoveToFront(elem)
+	cacheRequests.WithLabelValues("lru", "hit").Inc()
+	order := *entry.order
+	return &order, true
+}
+
+func (l *LRUCache) Set(order *models.Order) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.items[order.OrderId]; ok {
+		entry := elem.Value.(*lruEntry)
+		if order.OrderVersion < entry.floor {
+			return
+		}
+		copied := *order
+		entry.order = &copied
+		entry.expireAt = time.Now().Add(l.ttl)
+		l.ll.MoveToFront(elem)
+		return
+	}
+	copied := *order
+	l.add(&lruEntry{orderId: order.OrderId, order: &copied, expireAt: time.Now().Add(l.ttl)})
+}
+
+func (l *LRUCache) Invalidate(orderId string, version int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.items[orderId]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.order = nil
+		if version > entry.floor {
+			entry.floor = version
+		}
+		l.ll.MoveToFront(elem)
+		return
+	}
+	l.add(&lruEntry{orderId: orderId, floor: version})
+}
+
+func (l *LRUCache) add(entry *lruEntry) {
+	l.items[entry.orderId] = l.ll.PushFront(entry)
+	for l.capacity > 0 && l.ll.Len() > l.capacity {
+		oldest := l.ll.Back()
+		l.ll.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry).orderId)
+	}
+}
+
+// RedisClient 缓存用到的redis 命令，测试时可以换成本地实现
+// Eval 执行lua 脚本，失效标记的比较和写入需要在redis 中原子执行
+type RedisClient interface {
+	Get(ctx context.Context, key string) (string, bool, error)
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) error
+}
+
+// redisFloor 从已有的数据中取出失效标记的版本号，直接匹配数字，避免cjson 把大整数转成浮点数
+const redisFloor = `
+local function floor(key)
+	local current = redis.call('GET', key)
+	if not current then
+		return nil, nil
+	end
+	local raw = string.match(current, '"floor":(%-?%d+)')
+	if not raw then
+		return nil, nil
+	end
+	return tonumber(raw), raw
+end
+local function store(key, value, ttl)
+	if ttl > 0 then
+		redis.call('SET', key, value, 'PX', ttl)
+	else
+		redis.call('SET', key, value)
+	end
+end
+`
+
+// redisSetScript 订单的版本号不小于失效标记时才写入，ARGV 为数据、版本号和过期毫秒数
+const redisSetScript = redisFloor + `
+local current = floor(KEYS[1])
+if current and tonumber(ARGV[2]) < current then
+	return 0
+end
+store(KEYS[1], ARGV[1], tonumber(ARGV[3]))
+return 1
+`
+
+// redisInvalidateScript 写入失效标记，已有更大的版本号时保留，ARGV 为失效标记、版本号和过期毫秒数
+const redisInvalidateScript = redisFloor + `
+local current, raw = floor(KEYS[1])
+local value = ARGV[1]
+if current and current > tonumber(ARGV[2]) then
+	value = '{"floor":' .. raw .. '}'
+end
+store(KEYS[1], value, tonumber(ARGV[3]))
+return 1
+`
+
+// redisEntry 存入redis 的数据，Order 为空时表示失效标记
+type redisEntry struct {
+	Order *models.Order `json:"order,omitempty"`
+	Floor int64         `json:"floor"`
+}
+
+// RedisCache 基于redis 的共享缓存，多个实例之间共享失效标记
+type RedisCache struct {
+	client RedisClient
+	prefix string
+	ttl    time.Duration
+}
+
+func NewRedisCache(client RedisClient, ttl time.Duration) *RedisCache {
+	return &RedisCache{
+		client: client,
+		prefix: "order:cache:",
+		ttl:    ttl,
+	}
+}
+
+func (r *RedisCache) load(orderId string) (*redisEntry, bool) {
+	value, ok, err := r.client.Get(context.Background(), r.prefix+orderId)
+	if err != nil || !ok {
+		return nil, false
+	}
+	entry := &redisEntry{}
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// store 在redis 中按 script 比较失效标记后写入
+func (r *RedisCache) store(script, orderId string, entry *redisEntry) {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	// 缓存写失败只影响命中率，不影响正确性
+	_ = r.client.Eval(context.Background(), script, []string{r.prefix + orderId}, string(value), entry.Floor, r.ttl.Milliseconds())
+}
+
+func (r *RedisCache) Get(orderId string) (*models.Order, bool) {
+	entry, ok := r.load(orderId)
+	if !ok || entry.Order == nil {
+		cacheRequests.WithLabelValues("redis", "miss").Inc()
+		return nil, false
+	}
+	cacheRequests.WithLabelValues("redis", "hit").Inc()
+	return entry.Order, true
+}
+
+func (r *RedisCache) Set(order *models.Order) {
+	r.store(redisSetScript, order.OrderId, &redisEntry{Order: order, Floor: order.OrderVersion})
+}
+
+func (r *RedisCache) Invalidate(orderId string, version int64) {
+	r.store(redisInvalidateScript, orderId, &redisEntry{Floor: version})
+}
+
+// TieredCache 先查本地LRU，再查redis，redis 命中后回填本地
+type TieredCache struct {
+	local  OrderCache
+	remote OrderCache
+}
+
+func NewTieredCache(local, remote OrderCache) OrderCache {
+	return &TieredCache{
+		local:  local,
+		remote: remote,
+	}
+}
+
+func (t *TieredCache) Get(orderId string) (*models.Order, bool) {
+	if order, ok := t.local.Get(orderId); ok {
+		return order, true
+	}
+	order, ok := t.remote.Get(orderId)
+	if ok {
+		t.local.Set(order)
+	}
+	return order, ok
+}
+
+func (t *TieredCache) Set(order *models.Order) {
+	t.remote.Set(order)
+	t.local.Set(order)
+}
+
+func (t *TieredCache) Invalidate(orderId string, version int64) {
+	t.remote.Invalidate(orderId, version)
+	t.local.Invalidate(orderId, version)
+}
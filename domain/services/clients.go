@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/micro/go-micro/v2/client"
+)
+
+// 下游服务在注册中心的名字
+const (
+	InventoryServiceName = "go.micro.service.inventory"
+	PaymentServiceName   = "go.micro.service.payment"
+)
+
+// StockItem 需要预占的库存
+type StockItem struct {
+	SKUId int64 `json:"sku_id"`
+	Count int32 `json:"count"`
+}
+
+// StockItems 订单项对应的库存
+func StockItems(items []models.OrderItem) []StockItem {
+	stock := make([]StockItem, 0, len(items))
+	for _, item := range items {
+		stock = append(stock, StockItem{SKUId: item.SKUId, Count: item.Count})
+	}
+	return stock
+}
+
+// InventoryClient 库存服务
+type InventoryClient interface {
+	// Reserve 为订单预占库存，同一个订单重复调用返回同一个预占ID
+	Reserve(ctx context.Context, orderId string, items []StockItem) (string, error)
+	// Release 释放预占的库存，预占不存在或已经释放时不报错
+	Release(ctx context.Context, reservationId string) error
+	// Confirm 订单支付后确认预占，库存正式扣减，重复确认不报错
+	Confirm(ctx context.Context, reservationId string) error
+}
+
+// PaymentClient 支付服务
+type PaymentClient interface {
+	// RequestPayment 为订单发起支付，同一个订单重复调用返回同一个支付单号
+	RequestPayment(ctx context.Context, order *models.Order) (string, error)
+}
+
+// 库存服务和支付服务没有共享proto，请求和响应都用json 编码
+type reserveRequest struct {
+	OrderId string      `json:"order_id"`
+	Items   []StockItem `json:"items"`
+}
+
+type reserveResponse struct {
+	ReservationId string `json:"reservation_id"`
+}
+
+type reservationRequest struct {
+	ReservationId string `json:"reservation_id"`
+}
+
+type paymentRequest struct {
+	OrderId  string `json:"order_id"`
+	UserId   int64  `json:"user_id"`
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+type paymentResponse struct {
+	PaymentId string `json:"payment_id"`
+}
+
+// call 以json 编码调用下游服务
+func call(ctx context.Context, c client.Client, service, endpoint string, req, rsp interface{}) error {
+	return c.Call(ctx, c.NewRequest(service, endpoint, req, client.WithContentType("application/json")), rsp)
+}
+
+type microInventoryClient struct {
+	client  client.Client
+	service string
+}
+
+// NewInventoryClient 通过 go-micro 调用库存服务，service 为空时使用默认的服务名
+func NewInventoryClient(c client.Client, service string) InventoryClient {
+	if service == "" {
+		service = InventoryServiceName
+	}
+	return &microInventoryClient{client: c, service: service}
+}
+
+func (i *microInventoryClient) Reserve(ctx context.Context, orderId string, items []StockItem) (string, error) {
+	rsp := &reserveResponse{}
+	if err := call(ctx, i.client, i.service, "Inventory.Reserve", &reserveRequest{OrderId: orderId, Items: items}, rsp); err != nil {
+		return "", err
+	}
+	return rsp.ReservationId, nil
+}
+
+func (i *microInventoryClient) Release(ctx context.Context, reservationId string) error {
+	return call(ctx, i.client, i.service, "Inventory.Release", &reservationRequest{ReservationId: reservationId}, &struct{}{})
+}
+
+func (i *microInventoryClient) Confirm(ctx context.Context, reservationId string) error {
+	return call(ctx, i.client, i.service, "Inventory.Confirm", &reservationRequest{ReservationId: reservationId}, &struct{}{})
+}
+
+type microPaymentClient struct {
+	client  client.Client
+	service string
+}
+
+// NewPaymentClient 通过 go-micro 调用支付服务，service 为空时使用默认的服务名
+func NewPaymentClient(c client.Client, service string) PaymentClient {
+	if service == "" {
+		service = PaymentServiceName
+	}
+	return &microPaymentClient{client: c, service: service}
+}
+
+func (p *microPaymentClient) RequestPayment(ctx context.Context, order *models.Order) (string, error) {
+	rsp := &paymentResponse{}
+	req := &paymentRequest{
+		OrderId:  order.OrderId,
+		UserId:   order.UserId,
+		Amount:   order.TotalAmount,
+		Currency: order.Currency,
+	}
+	if err := call(ctx, p.client, p.service, "Payment.RequestPayment", req, rsp); err != nil {
+		return "", err
+	}
+	return rsp.PaymentId, nil
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/micro/go-micro/v2/client"
+)
+
+// 订单服务发布的事件主题
+const (
+	// TopicRefund 退款记录创建后通知支付服务打款
+	TopicRefund = "go.micro.topic.order.refund"
+)
+
+// ErrNoPublisher 没有配置事件发布，无法补发事件
+var ErrNoPublisher = errors.New("no event publisher configured")
+
+// EventPublisher 发布领域事件，事件以json 编码，订阅方不需要依赖订单服务的proto
+type EventPublisher interface {
+	Publish(ctx context.Context, topic string, event interface{}) error
+}
+
+type microPublisher struct {
+	client client.Client
+}
+
+// NewMicroPublisher 使用 go-micro 的 broker 发布事件
+func NewMicroPublisher(c client.Client) EventPublisher {
+	return &microPublisher{client: c}
+}
+
+func (p *microPublisher) Publish(ctx context.Context, topic string, event interface{}) error {
+	return p.client.Publish(ctx, p.client.NewMessage(topic, event, client.WithMessageContentType("application/json")))
+}
+
+// publish 发布失败只打印日志，事件对应的数据已经落库，可以根据记录补发
+func publish(ctx context.Context, publisher EventPublisher, topic string, event interface{}) {
+	if publisher == nil {
+		return
+	}
+	if err := publisher.Publish(ctx, topic, event); err != nil {
+		fmt.Println(err)
+	}
+}
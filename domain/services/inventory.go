@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lenny-mo/order/utils"
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+// 预占的状态
+const (
+	ReservationReserved  = "RESERVED"
+	ReservationReleased  = "RELEASED"
+	ReservationConfirmed = "CONFIRMED"
+)
+
+// Reservation 内存库存中的一次预占
+type Reservation struct {
+	Id      string
+	OrderId string
+	Items   []StockItem
+	Status  string
+}
+
+// MemoryInventory 进程内的库存，实现 InventoryClient，用于测试和没有库存服务的本地开发
+type MemoryInventory struct {
+	mu           sync.Mutex
+	available    map[int64]int32
+	reservations map[string]*Reservation
+	byOrder      map[string]string
+}
+
+// NewMemoryInventory stock 是每个sku 的初始可用库存
+func NewMemoryInventory(stock map[int64]int32) *MemoryInventory {
+	available := map[int64]int32{}
+	for sku, count := range stock {
+		available[sku] = count
+	}
+	return &MemoryInventory{
+		available:    available,
+		reservations: map[string]*Reservation{},
+		byOrder:      map[string]string{},
+	}
+}
+
+// Reserve 任何一个sku 库存不足时整单失败，不会部分预占
+func (m *MemoryInventory) Reserve(ctx context.Context, orderId string, items []StockItem) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := m.byOrder[orderId]; ok {
+		return id, nil
+	}
+	needed := map[int64]int32{}
+	for _, item := range items {
+		needed[item.SKUId] += item.Count
+	}
+	for sku, count := range needed {
+		if m.available[sku] < count {
+			return "", microerrors.Conflict("inventory", "sku %d has %d available, requested %d", sku, m.available[sku], count)
+		}
+	}
+	for sku, count := range needed {
+		m.available[sku] -= count
+	}
+	reservation := &Reservation{
+		Id:      utils.UUID(),
+		OrderId: orderId,
+		Items:   append([]StockItem(nil), items...),
+		Status:  ReservationReserved,
+	}
+	m.reservations[reservation.Id] = reservation
+	m.byOrder[orderId] = reservation.Id
+	return reservation.Id, nil
+}
+
+// Release 已经确认的预占不能再释放
+func (m *MemoryInventory) Release(ctx context.Context, reservationId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reservation, ok := m.reservations[reservationId]
+	if !ok || reservation.Status == ReservationReleased {
+		return nil
+	}
+	if reservation.Status == ReservationConfirmed {
+		return microerrors.Conflict("inventory", "reservation %s is confirmed", reservationId)
+	}
+	for _, item := range reservation.Items {
+		m.available[item.SKUId] += item.Count
+	}
+	reservation.Status = ReservationReleased
+	return nil
+}
+
+func (m *MemoryInventory) Confirm(ctx context.Context, reservationId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reservation, ok := m.reservations[reservationId]
+	if !ok {
+		return microerrors.NotFound("inventory", "reservation %s not found", reservationId)
+	}
+	if reservation.Status == ReservationReleased {
+		return microerrors.Conflict("inventory", "reservation %s is released", reservationId)
+	}
+	reservation.Status = ReservationConfirmed
+	return nil
+}
+
+// Available sku 当前的可用库存
+func (m *MemoryInventory) Available(sku int64) int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.available[sku]
+}
+
+// Reservation 按ID 查询预占，不存在时返回nil
+func (m *MemoryInventory) Reservation(reservationId string) *Reservation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reservation, ok := m.reservations[reservationId]
+	if !ok {
+		return nil
+	}
+	copied := *reservation
+	return &copied
+}
@@ -1,37 +1,355 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/lenny-mo/order/domain/dao"
 	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/utils"
+	"gorm.io/gorm"
 )
 
 type OrderServiceInterface interface {
 	// 创建订单
-	CreateOrder(order *models.Order) (int64, error)
+	CreateOrder(ctx context.Context, order *models.Order) (int64, error)
 	// 更新订单
-	UpdateOrder(order *models.Order, oldversion int64) (int64, error)
+	UpdateOrder(ctx context.Context, order *models.Order, oldversion int64) (int64, error)
 	// 获取订单
-	GetOrderById(orderId string) (*models.Order, error)
+	GetOrderById(ctx context.Context, orderId string) (*models.Order, error)
+	// 批量获取订单，返回找到的订单和不存在的订单号
+	BatchGetOrders(ctx context.Context, orderIds []string) ([]*models.Order, []string, error)
+	// 批量修改订单状态，每个订单单独返回结果
+	BatchUpdateStatus(ctx context.Context, updates []*StatusUpdate) ([]*StatusUpdateResult, error)
+	// 订单列表
+	ListOrders(ctx context.Context, query *dao.ListOrderQuery) ([]*models.Order, error)
+	// 订单版本记录
+	GetOrderHistory(ctx context.Context, orderId string) ([]*models.OrderHistory, error)
+	// 软删除订单
+	DeleteOrder(ctx context.Context, orderId string, oldversion int64) (int64, error)
+	// 恢复软删除的订单
+	RestoreOrder(ctx context.Context, orderId string) (int64, error)
+	// 物理删除订单
+	PurgeOrder(ctx context.Context, orderId string) (int64, error)
+	// 订单项
+	GetOrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error)
+	// 退款，lines 为空表示整单退款，返回退款记录和退款后的订单
+	RefundOrder(ctx context.Context, refund *models.OrderRefund, lines []models.RefundLine) (*models.Order, error)
+	// 退款记录
+	ListRefunds(ctx context.Context, orderId string) ([]*models.OrderRefund, error)
+	// 补发退款事件，用于事件丢失或支付服务需要重放时
+	RepublishRefund(ctx context.Context, orderId, refundId string) (*models.OrderRefund, error)
+	// 支付确认，重复回调只生效一次，金额不一致时返回 models.ErrPaymentMismatch
+	ConfirmPayment(ctx context.Context, payment *models.OrderPayment) (*models.Order, error)
+	// 发货，状态不允许时返回 models.ErrInvalidTransition
+	ShipOrder(ctx context.Context, fulfillment *models.OrderFulfillment) (*models.Order, error)
+	// 签收
+	MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*models.Order, error)
+	// 履约信息
+	GetFulfillment(ctx context.Context, orderId string) (*models.OrderFulfillment, error)
+	// 清除订单上的个人信息
+	AnonymizeOrder(ctx context.Context, orderId string) (*models.Order, error)
+	// 删除订单和全部相关的数据
+	EraseOrder(ctx context.Context, orderId string) (int64, error)
+	// 计算订单的优惠和应付金额，不落库
+	PreviewOrder(ctx context.Context, order *models.Order) (*models.Order, error)
+	// 取消创建时间早于 before 仍未支付的订单并释放库存，返回被取消的订单
+	CancelExpiredOrders(ctx context.Context, before time.Time, limit int) ([]*models.Order, error)
+	// 生成订单号，配置了租户前缀时带上context 中租户的前缀
+	GenerateOrderId(ctx context.Context, userId int64) string
+	// 把已支付的订单按商品拆成子订单，返回子订单
+	SplitOrder(ctx context.Context, orderId string, oldversion int64, groups [][]models.SplitLine) ([]*models.Order, error)
+	// 拆单产生的子订单
+	ListSubOrders(ctx context.Context, parentId string) ([]*models.Order, error)
+	// 合并同一个用户的未支付订单，返回合并后的订单
+	MergeOrders(ctx context.Context, versions map[string]int64) (*models.Order, error)
 }
 
 type OrderService struct {
-	OrderDAO dao.OrderDAO
+	// 使用接口而不是具体的DAO，单表和分片两种实现都可以注入
+	OrderDAO dao.OrderDAOInterface
+	// 为空时不发布事件
+	Publisher EventPublisher
+	// 为空时创建订单不预占库存
+	Inventory InventoryClient
+	// 为空时不计算优惠
+	Promotions *PromotionEngine
+	// 为空时全部租户使用全局配置
+	Tenants *Tenants
 }
 
-func NewOrderService(orderdao dao.OrderDAO) OrderServiceInterface {
+func NewOrderService(orderdao dao.OrderDAOInterface, publisher EventPublisher, inventory InventoryClient, promotions *PromotionEngine, tenants *Tenants) OrderServiceInterface {
 	return &OrderService{
-		OrderDAO: orderdao,
+		OrderDAO:   orderdao,
+		Publisher:  publisher,
+		Inventory:  inventory,
+		Promotions: promotions,
+		Tenants:    tenants,
+	}
+}
+
+// price 有订单项时按促销活动计算优惠和应付金额，没有配置促销活动且没有传总金额时按订单项计算
+func (o *OrderService) price(order *models.Order) {
+	if o.Promotions != nil && len(order.Items) > 0 {
+		o.Promotions.Price(order, time.Now())
+		return
+	}
+	if order.TotalAmount == 0 {
+		order.TotalAmount = models.ItemsTotal(order.Items)
+	}
+}
+
+// PreviewOrder 在订单的副本上计算，不修改传入的订单
+func (o *OrderService) PreviewOrder(ctx context.Context, order *models.Order) (*models.Order, error) {
+	preview := *order
+	preview.Items = append([]models.OrderItem(nil), order.Items...)
+	if err := o.Tenants.applyCurrency(ctx, &preview); err != nil {
+		return nil, err
+	}
+	o.price(&preview)
+	return &preview, nil
+}
+
+// CreateOrder 按租户配置检查币种，按促销活动计算每个订单项的优惠和应付金额
+// 配置了库存服务时先为全部订单项预占库存，预占失败不创建订单，创建失败时释放预占
+func (o *OrderService) CreateOrder(ctx context.Context, order *models.Order) (int64, error) {
+	if err := o.Tenants.applyCurrency(ctx, order); err != nil {
+		return 0, err
+	}
+	o.price(order)
+	if o.Inventory == nil || order.ReservationId != "" || len(order.Items) == 0 {
+		return o.OrderDAO.CreateOrder(ctx, order)
+	}
+	reservationId, err := o.Inventory.Reserve(ctx, order.OrderId, StockItems(order.Items))
+	if err != nil {
+		return 0, err
+	}
+	order.ReservationId = reservationId
+	rowAffected, err := o.OrderDAO.CreateOrder(ctx, order)
+	if err != nil {
+		if releaseErr := o.Inventory.Release(ctx, reservationId); releaseErr != nil {
+			fmt.Println(releaseErr)
+		}
+		return 0, err
+	}
+	return rowAffected, nil
+}
+
+// UpdateOrder 订单被取消时释放预占的库存，修改了子订单的状态时重新汇总父订单的状态，
+// 并把父订单号写回 order，缓存和监听据此更新父订单
+func (o *OrderService) UpdateOrder(ctx context.Context, order *models.Order, oldversion int64) (int64, error) {
+	rowAffected, err := o.OrderDAO.UpdateOrder(ctx, order, oldversion)
+	if err != nil || rowAffected == 0 || order.Status == models.StatusUnpaid {
+		return rowAffected, err
+	}
+	if order.Status == models.StatusCancelled {
+		o.releaseStock(ctx, order.OrderId)
+	}
+	updated, err := o.OrderDAO.GetOrderById(dao.WithDeleted(ctx), order.OrderId)
+	if err != nil {
+		fmt.Println(err)
+		return rowAffected, nil
+	}
+	order.ParentOrderId = updated.ParentOrderId
+	o.syncParent(ctx, updated)
+	return rowAffected, nil
+}
+
+// releaseStock 释放订单预占的库存，失败只打印日志，可以根据订单上的预占ID 和库存服务对账
+func (o *OrderService) releaseStock(ctx context.Context, orderId string) {
+	if o.Inventory == nil {
+		return
+	}
+	order, err := o.OrderDAO.GetOrderById(dao.WithDeleted(ctx), orderId)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if order.ReservationId == "" {
+		return
+	}
+	if err := o.Inventory.Release(ctx, order.ReservationId); err != nil {
+		fmt.Println(err)
+	}
+}
+
+func (o *OrderService) GetOrderById(ctx context.Context, orderId string) (*models.Order, error) {
+	return o.OrderDAO.GetOrderById(ctx, orderId)
+}
+
+func (o *OrderService) ListOrders(ctx context.Context, query *dao.ListOrderQuery) ([]*models.Order, error) {
+	return o.OrderDAO.ListOrders(ctx, query)
+}
+
+func (o *OrderService) GetOrderHistory(ctx context.Context, orderId string) ([]*models.OrderHistory, error) {
+	return o.OrderDAO.GetOrderHistory(ctx, orderId)
+}
+
+func (o *OrderService) DeleteOrder(ctx context.Context, orderId string, oldversion int64) (int64, error) {
+	return o.OrderDAO.DeleteOrder(ctx, orderId, oldversion)
+}
+
+func (o *OrderService) RestoreOrder(ctx context.Context, orderId string) (int64, error) {
+	return o.OrderDAO.RestoreOrder(ctx, orderId)
+}
+
+func (o *OrderService) PurgeOrder(ctx context.Context, orderId string) (int64, error) {
+	return o.OrderDAO.PurgeOrder(ctx, orderId)
+}
+
+func (o *OrderService) GetOrderItems(ctx context.Context, orderId string) ([]models.OrderItem, error) {
+	return o.OrderDAO.GetOrderItems(ctx, orderId)
+}
+
+// RefundOrder 退款记录落库后发布退款事件
+// 重复提交同一个 RefundId 时只要退款还没完成就会再次发布，支付服务按 RefundId 去重，发布失败时调用方重试即可补发
+func (o *OrderService) RefundOrder(ctx context.Context, refund *models.OrderRefund, lines []models.RefundLine) (*models.Order, error) {
+	if refund.RefundId == "" {
+		refund.RefundId = utils.UUID()
+	}
+	order, err := o.OrderDAO.RefundOrder(ctx, refund, lines)
+	if err != nil {
+		return nil, err
+	}
+	o.syncParent(ctx, order)
+	if refund.Status != models.RefundPending {
+		return order, nil
+	}
+	publish(ctx, o.Publisher, TopicRefund, newRefundEvent(refund))
+	return order, nil
+}
+
+func newRefundEvent(refund *models.OrderRefund) *models.RefundEvent {
+	return &models.RefundEvent{
+		RefundId:  refund.RefundId,
+		OrderId:   refund.OrderId,
+		UserId:    refund.UserId,
+		Amount:    refund.Amount,
+		Currency:  refund.Currency,
+		Reason:    refund.Reason,
+		Lines:     refund.RefundLines(),
+		Timestamp: refund.CreatedAt.Unix(),
+	}
+}
+
+func (o *OrderService) ListRefunds(ctx context.Context, orderId string) ([]*models.OrderRefund, error) {
+	return o.OrderDAO.ListRefunds(ctx, orderId)
+}
+
+// RepublishRefund 按退款记录补发退款事件，和 RefundOrder 不同，发布失败时返回错误
+func (o *OrderService) RepublishRefund(ctx context.Context, orderId, refundId string) (*models.OrderRefund, error) {
+	if o.Publisher == nil {
+		return nil, ErrNoPublisher
+	}
+	refunds, err := o.OrderDAO.ListRefunds(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	for _, refund := range refunds {
+		if refund.RefundId == refundId {
+			return refund, o.Publisher.Publish(ctx, TopicRefund, newRefundEvent(refund))
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// ConfirmPayment 支付确认后确认预占的库存，重复回调时库存服务按预占ID 去重
+func (o *OrderService) ConfirmPayment(ctx context.Context, payment *models.OrderPayment) (*models.Order, error) {
+	order, err := o.OrderDAO.ConfirmPayment(ctx, payment)
+	if err != nil || o.Inventory == nil || order.ReservationId == "" {
+		return order, err
 	}
+	if err := o.Inventory.Confirm(ctx, order.ReservationId); err != nil {
+		fmt.Println(err)
+	}
+	return order, nil
+}
+
+// ShipOrder 没有传发货时间时使用当前时间，子订单发货后重新汇总父订单的状态
+func (o *OrderService) ShipOrder(ctx context.Context, fulfillment *models.OrderFulfillment) (*models.Order, error) {
+	if fulfillment.ShippedAt == nil {
+		now := time.Now()
+		fulfillment.ShippedAt = &now
+	}
+	order, err := o.OrderDAO.ShipOrder(ctx, fulfillment)
+	if err == nil {
+		o.syncParent(ctx, order)
+	}
+	return order, err
 }
 
-func (o *OrderService) CreateOrder(order *models.Order) (int64, error) {
-	return o.OrderDAO.CreateOrder(order)
+// MarkDelivered 没有传签收时间时使用当前时间，子订单签收后重新汇总父订单的状态
+func (o *OrderService) MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*models.Order, error) {
+	if deliveredAt.IsZero() {
+		deliveredAt = time.Now()
+	}
+	order, err := o.OrderDAO.MarkDelivered(ctx, orderId, deliveredAt)
+	if err == nil {
+		o.syncParent(ctx, order)
+	}
+	return order, err
 }
 
-func (o *OrderService) UpdateOrder(order *models.Order, oldversion int64) (int64, error) {
-	return o.OrderDAO.UpdateOrder(order, oldversion)
+func (o *OrderService) GetFulfillment(ctx context.Context, orderId string) (*models.OrderFulfillment, error) {
+	return o.OrderDAO.GetFulfillment(ctx, orderId)
 }
 
-func (o *OrderService) GetOrderById(orderId string) (*models.Order, error) {
-	return o.OrderDAO.GetOrderById(orderId)
+func (o *OrderService) AnonymizeOrder(ctx context.Context, orderId string) (*models.Order, error) {
+	return o.OrderDAO.AnonymizeOrder(ctx, orderId)
+}
+
+func (o *OrderService) EraseOrder(ctx context.Context, orderId string) (int64, error) {
+	return o.OrderDAO.EraseOrder(ctx, orderId)
+}
+
+// CancelExpiredOrders 逐个按版本号取消，期间被支付或修改的订单会因为版本冲突跳过
+// context 中没有租户时跳过配置了自己的超时时间的租户，这些租户见 CancelExpiredOrdersByTenant
+func (o *OrderService) CancelExpiredOrders(ctx context.Context, before time.Time, limit int) ([]*models.Order, error) {
+	status := models.StatusUnpaid
+	query := &dao.ListOrderQuery{
+		Status:        &status,
+		CreatedBefore: before,
+		Limit:         limit,
+	}
+	if _, ok := dao.TenantFrom(ctx); !ok {
+		for tenantId := range o.Tenants.AutoCancelTimeouts() {
+			query.ExcludeTenants = append(query.ExcludeTenants, tenantId)
+		}
+	}
+	expired, err := o.OrderDAO.ListOrders(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	ctx = dao.WithAudit(ctx, "system", "payment timeout")
+	cancelled := []*models.Order{}
+	for _, order := range expired {
+		update := &models.Order{
+			OrderId:      order.OrderId,
+			UserId:       order.UserId,
+			Status:       models.StatusCancelled,
+			OrderVersion: order.OrderVersion + 1,
+		}
+		if _, err := o.UpdateOrder(ctx, update, order.OrderVersion); err != nil {
+			if !errors.Is(err, dao.ErrVersionConflict) {
+				fmt.Println(err)
+			}
+			continue
+		}
+		cancelled = append(cancelled, update)
+	}
+	return cancelled, nil
+}
+
+// GenerateOrderId 分片DAO会生成携带槽位的订单号，单表模式下就是普通的uuid
+func (o *OrderService) GenerateOrderId(ctx context.Context, userId int64) string {
+	orderId := utils.UUID()
+	if generator, ok := o.OrderDAO.(interface{ NewOrderId(int64) string }); ok {
+		orderId = generator.NewOrderId(userId)
+	}
+	if prefix := o.Tenants.Settings(ctx).OrderIdPrefix; prefix != "" {
+		orderId = dao.PrefixOrderId(orderId, prefix)
+	}
+	return orderId
 }
@@ -0,0 +1,66 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/utils"
+)
+
+// BenchmarkGetOrderById 读穿缓存命中和直接读库的对比
+func BenchmarkGetOrderById(b *testing.B) {
+	tests := []struct {
+		name   string
+		cached bool
+	}{
+		{name: "uncached"},
+		{name: "cached", cached: true},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			service := newOrderService(b, conf.PersistenceState, nil, nil)
+			if tt.cached {
+				service = services.NewCachedOrderService(service, services.NewLRUCache(1000, time.Minute))
+			}
+			ctx := context.Background()
+			order := &models.Order{OrderId: utils.UUID(), OrderVersion: 1, UserId: 1, TotalAmount: 100}
+			if _, err := service.CreateOrder(ctx, order); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := service.GetOrderById(ctx, order.OrderId); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCreateOrder 包括计算金额和预占库存
+func BenchmarkCreateOrder(b *testing.B) {
+	for _, mode := range []string{conf.PersistenceState, conf.PersistenceEvent} {
+		b.Run(mode, func(b *testing.B) {
+			inventory := services.NewMemoryInventory(map[int64]int32{1: int32(b.N) * 2, 2: int32(b.N)})
+			service := newOrderService(b, mode, inventory, nil)
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := service.CreateOrder(ctx, &models.Order{
+					OrderId:      utils.UUID(),
+					OrderVersion: 1,
+					UserId:       int64(i),
+					Items:        []models.OrderItem{{SKUId: 1, Count: 2, Price: 100}, {SKUId: 2, Count: 1, Price: 300}},
+				}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	ordertesting "github.com/lenny-mo/order/testing"
+	"github.com/lenny-mo/order/utils"
+	"gorm.io/gorm"
+)
+
+func newOrderService(t testing.TB, mode string, inventory services.InventoryClient, tenants *services.Tenants) services.OrderServiceInterface {
+	t.Helper()
+	db, err := ordertesting.OpenSQLite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderDAO, err := ordertesting.NewOrderDAO(db, mode, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return services.NewOrderService(orderDAO, nil, inventory, nil, tenants)
+}
+
+func TestCreateOrderReservesStock(t *testing.T) {
+	tests := []struct {
+		name          string
+		count         int32
+		wantErr       bool
+		wantAvailable int32
+	}{
+		{name: "enough stock", count: 3, wantAvailable: 2},
+		{name: "all stock", count: 5, wantAvailable: 0},
+		{name: "not enough stock", count: 6, wantErr: true, wantAvailable: 5},
+	}
+	for _, mode := range []string{conf.PersistenceState, conf.PersistenceEvent} {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				inventory := services.NewMemoryInventory(map[int64]int32{1: 5})
+				service := newOrderService(t, mode, inventory, nil)
+				ctx := context.Background()
+				order := &models.Order{
+					OrderId:      utils.UUID(),
+					OrderVersion: 1,
+					UserId:       1,
+					Items:        []models.OrderItem{{SKUId: 1, Count: tt.count, Price: 100}},
+				}
+
+				_, err := service.CreateOrder(ctx, order)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+				}
+				if available := inventory.Available(1); available != tt.wantAvailable {
+					t.Fatalf("%d available, want %d", available, tt.wantAvailable)
+				}
+				got, err := service.GetOrderById(ctx, order.OrderId)
+				if tt.wantErr {
+					if !errors.Is(err, gorm.ErrRecordNotFound) {
+						t.Fatalf("order was created without stock: %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.ReservationId == "" || got.TotalAmount != int64(tt.count)*100 {
+					t.Fatalf("reservation %q total %d", got.ReservationId, got.TotalAmount)
+				}
+
+				// 取消订单释放预占的库存
+				if _, err := service.UpdateOrder(ctx, &models.Order{OrderId: order.OrderId, OrderVersion: 2, UserId: 1, Status: models.StatusCancelled}, 1); err != nil {
+					t.Fatal(err)
+				}
+				if available := inventory.Available(1); available != 5 {
+					t.Fatalf("%d available after cancel, want 5", available)
+				}
+			})
+		}
+	}
+}
+
+func TestCreateOrderCurrency(t *testing.T) {
+	tenants, err := services.NewTenantsFromConfig(&conf.TenantConfig{
+		Tenants: map[string]conf.TenantSettings{
+			"cn": {Currency: "CNY"},
+			"us": {},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		tenant       string
+		currency     string
+		wantCurrency string
+		wantErr      error
+	}{
+		{name: "default currency", tenant: "cn", wantCurrency: "CNY"},
+		{name: "same currency", tenant: "cn", currency: "CNY", wantCurrency: "CNY"},
+		{name: "other currency", tenant: "cn", currency: "USD", wantErr: services.ErrCurrencyNotAllowed},
+		{name: "no tenant currency", tenant: "us", currency: "USD", wantCurrency: "USD"},
+	}
+	service := newOrderService(t, conf.PersistenceState, nil, tenants)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := dao.WithTenant(context.Background(), tt.tenant)
+			order := &models.Order{OrderId: utils.UUID(), OrderVersion: 1, UserId: 1, TotalAmount: 100, Currency: tt.currency}
+			_, err := service.CreateOrder(ctx, order)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && order.Currency != tt.wantCurrency {
+				t.Fatalf("currency %q, want %q", order.Currency, tt.wantCurrency)
+			}
+		})
+	}
+}
+
+// orderStep 订单生命周期中的一步，返回变更后的订单
+type orderStep func(ctx context.Context, service services.OrderServiceInterface, order *models.Order) (*models.Order, error)
+
+func payStep(ctx context.Context, service services.OrderServiceInterface, order *models.Order) (*models.Order, error) {
+	return service.ConfirmPayment(ctx, &models.OrderPayment{PaymentId: utils.UUID(), OrderId: order.OrderId, Amount: order.TotalAmount, Currency: order.Currency})
+}
+
+func shipStep(ctx context.Context, service services.OrderServiceInterface, order *models.Order) (*models.Order, error) {
+	now := time.Now()
+	return service.ShipOrder(ctx, &models.OrderFulfillment{OrderId: order.OrderId, Carrier: "sf", TrackingNumber: order.OrderId, ShippedAt: &now})
+}
+
+func deliverStep(ctx context.Context, service services.OrderServiceInterface, order *models.Order) (*models.Order, error) {
+	return service.MarkDelivered(ctx, order.OrderId, time.Now())
+}
+
+func cancelStep(ctx context.Context, service services.OrderServiceInterface, order *models.Order) (*models.Order, error) {
+	if _, err := service.UpdateOrder(ctx, &models.Order{OrderId: order.OrderId, OrderVersion: order.OrderVersion + 1, UserId: order.UserId, Status: models.StatusCancelled}, order.OrderVersion); err != nil {
+		return nil, err
+	}
+	return service.GetOrderById(ctx, order.OrderId)
+}
+
+// refundStep skuId 为0时整单退款，否则退一件该商品
+func refundStep(skuId int64) orderStep {
+	return func(ctx context.Context, service services.OrderServiceInterface, order *models.Order) (*models.Order, error) {
+		var lines []models.RefundLine
+		if skuId != 0 {
+			lines = []models.RefundLine{{SKUId: skuId, Count: 1}}
+		}
+		return service.RefundOrder(ctx, &models.OrderRefund{OrderId: order.OrderId}, lines)
+	}
+}
+
+func staleUpdateStep(ctx context.Context, service services.OrderServiceInterface, order *models.Order) (*models.Order, error) {
+	_, err := service.UpdateOrder(ctx, &models.Order{OrderId: order.OrderId, OrderVersion: order.OrderVersion, UserId: order.UserId, OrderData: "stale"}, order.OrderVersion-1)
+	return order, err
+}
+
+func deleteRestoreStep(ctx context.Context, service services.OrderServiceInterface, order *models.Order) (*models.Order, error) {
+	if _, err := service.DeleteOrder(ctx, order.OrderId, order.OrderVersion); err != nil {
+		return nil, err
+	}
+	if _, err := service.GetOrderById(ctx, order.OrderId); !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("deleted order is still visible: %v", err)
+	}
+	if _, err := service.RestoreOrder(ctx, order.OrderId); err != nil {
+		return nil, err
+	}
+	return service.GetOrderById(ctx, order.OrderId)
+}
+
+// TestOrderLifecycle 两种持久化模式执行同样的操作，订单状态、版本和版本记录都应该一致
+func TestOrderLifecycle(t *testing.T) {
+	tests := []struct {
+		name        string
+		steps       []orderStep
+		wantErr     error
+		wantStatus  int8
+		wantVersion int64
+		wantHistory int
+	}{
+		{name: "delivered", steps: []orderStep{payStep, shipStep, deliverStep}, wantStatus: models.StatusDelivered, wantVersion: 4, wantHistory: 4},
+		{name: "cancelled", steps: []orderStep{cancelStep}, wantStatus: models.StatusCancelled, wantVersion: 2, wantHistory: 2},
+		{name: "partially refunded", steps: []orderStep{payStep, refundStep(1)}, wantStatus: models.StatusPartiallyRefunded, wantVersion: 3, wantHistory: 3},
+		{name: "refunded", steps: []orderStep{payStep, refundStep(1), refundStep(2)}, wantStatus: models.StatusRefunded, wantVersion: 4, wantHistory: 4},
+		{name: "whole order refunded", steps: []orderStep{payStep, refundStep(0)}, wantStatus: models.StatusRefunded, wantVersion: 3, wantHistory: 3},
+		{name: "ship unpaid", steps: []orderStep{shipStep}, wantErr: models.ErrInvalidTransition, wantStatus: models.StatusUnpaid, wantVersion: 1, wantHistory: 1},
+		{name: "refund cancelled", steps: []orderStep{cancelStep, refundStep(0)}, wantErr: models.ErrRefundNotAllowed, wantStatus: models.StatusCancelled, wantVersion: 2, wantHistory: 2},
+		{name: "stale version", steps: []orderStep{payStep, staleUpdateStep}, wantErr: dao.ErrVersionConflict, wantStatus: models.StatusPaid, wantVersion: 2, wantHistory: 2},
+		{name: "deleted and restored", steps: []orderStep{payStep, deleteRestoreStep}, wantStatus: models.StatusPaid},
+	}
+	for _, mode := range []string{conf.PersistenceState, conf.PersistenceEvent} {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				service := newOrderService(t, mode, nil, nil)
+				ctx := context.Background()
+				order := &models.Order{
+					OrderId:      utils.UUID(),
+					OrderVersion: 1,
+					UserId:       1,
+					Currency:     "CNY",
+					Items:        []models.OrderItem{{SKUId: 1, Count: 1, Price: 100}, {SKUId: 2, Count: 1, Price: 200}},
+				}
+				if _, err := service.CreateOrder(ctx, order); err != nil {
+					t.Fatal(err)
+				}
+
+				var err error
+				current := order
+				for _, step := range tt.steps {
+					var next *models.Order
+					if next, err = step(ctx, service, current); err != nil {
+						break
+					}
+					current = next
+				}
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+
+				got, err := service.GetOrderById(ctx, order.OrderId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got.Status != tt.wantStatus {
+					t.Fatalf("status %d, want %d", got.Status, tt.wantStatus)
+				}
+				if tt.wantVersion == 0 {
+					return
+				}
+				if got.OrderVersion != tt.wantVersion {
+					t.Fatalf("version %d, want %d", got.OrderVersion, tt.wantVersion)
+				}
+				history, err := service.GetOrderHistory(ctx, order.OrderId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(history) != tt.wantHistory {
+					t.Fatalf("%d history records, want %d", len(history), tt.wantHistory)
+				}
+				items, err := service.GetOrderItems(ctx, order.OrderId)
+				if err != nil || len(items) != 2 {
+					t.Fatalf("%d items: %v", len(items), err)
+				}
+			})
+		}
+	}
+}
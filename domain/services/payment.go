@@ -0,0 +1,36 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/lenny-mo/order/domain/models"
+)
+
+// paymentMessage 参与签名的字段，支付服务按同样的格式拼接后计算 HMAC-SHA256
+func paymentMessage(payment *models.OrderPayment) string {
+	return fmt.Sprintf("%s\n%s\n%d\n%s", payment.PaymentId, payment.OrderId, payment.Amount, payment.Currency)
+}
+
+// SignPayment 计算支付确认的签名，结果是小写的十六进制字符串
+func SignPayment(secret []byte, payment *models.OrderPayment) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(paymentMessage(payment)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyPayment 校验支付确认的签名，没有配置密钥时一律校验失败
+func VerifyPayment(secret []byte, payment *models.OrderPayment, signature string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(paymentMessage(payment)))
+	return hmac.Equal(mac.Sum(nil), expected)
+}
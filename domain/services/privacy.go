@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/utils"
+	"gorm.io/gorm"
+)
+
+// PrivacyServiceInterface 按用户导出和删除数据，每次请求和结果都会写入审计记录
+type PrivacyServiceInterface interface {
+	// 导出用户的全部订单、订单项、版本记录、退款和履约信息，返回审计记录和导出的数据
+	ExportUserData(ctx context.Context, userId int64) (*models.PrivacyRequest, *models.UserDataArchive, error)
+	// 需要保留做账的订单匿名化，其余订单连同版本记录一起删除
+	EraseUserData(ctx context.Context, userId int64) (*models.PrivacyRequest, *models.ErasureResult, error)
+	// 用户的请求记录
+	ListPrivacyRequests(ctx context.Context, userId int64) ([]*models.PrivacyRequest, error)
+}
+
+type PrivacyService struct {
+	Orders   OrderServiceInterface
+	Requests dao.PrivacyDAOInterface
+	// 为空时不删除下单流程
+	Sagas dao.SagaDAOInterface
+}
+
+func NewPrivacyService(orders OrderServiceInterface, requests dao.PrivacyDAOInterface, sagas dao.SagaDAOInterface) PrivacyServiceInterface {
+	return &PrivacyService{
+		Orders:   orders,
+		Requests: requests,
+		Sagas:    sagas,
+	}
+}
+
+// track 先写入处理中的审计记录，处理结束后写入结果，审计记录写入失败时不处理请求
+func (p *PrivacyService) track(ctx context.Context, userId int64, kind string, handle func() (interface{}, error)) (*models.PrivacyRequest, error) {
+	audit := dao.AuditFrom(ctx)
+	request := &models.PrivacyRequest{
+		RequestId: utils.UUID(),
+		UserId:    userId,
+		Kind:      kind,
+		Status:    models.PrivacyRunning,
+		Actor:     audit.Actor,
+		Reason:    audit.Reason,
+	}
+	if err := p.Requests.CreateRequest(ctx, request); err != nil {
+		return nil, err
+	}
+	detail, err := handle()
+	request.Status = models.PrivacySucceeded
+	if err != nil {
+		request.Status, request.Error = models.PrivacyFailed, err.Error()
+	}
+	if detail != nil {
+		data, _ := json.Marshal(detail)
+		request.Detail = string(data)
+	}
+	if saveErr := p.Requests.SaveRequest(ctx, request); saveErr != nil {
+		fmt.Println(saveErr)
+	}
+	return request, err
+}
+
+// userOrders 用户的全部订单，包括已删除的
+func (p *PrivacyService) userOrders(ctx context.Context, userId int64) ([]*models.Order, error) {
+	ctx = dao.WithDeleted(ctx)
+	orders := []*models.Order{}
+	for {
+		page, err := p.Orders.ListOrders(ctx, &dao.ListOrderQuery{
+			UserId: userId,
+			Offset: len(orders),
+			Limit:  dao.MaxListLimit,
+		})
+		if err != nil {
+			return nil, err
+		}
+		orders = append(orders, page...)
+		if len(page) < dao.MaxListLimit {
+			return orders, nil
+		}
+	}
+}
+
+func (p *PrivacyService) ExportUserData(ctx context.Context, userId int64) (*models.PrivacyRequest, *models.UserDataArchive, error) {
+	archive := &models.UserDataArchive{
+		UserId:       userId,
+		ExportedAt:   time.Now(),
+		History:      []*models.OrderHistory{},
+		Refunds:      []*models.OrderRefund{},
+		Fulfillments: []*models.OrderFulfillment{},
+	}
+	request, err := p.track(ctx, userId, models.PrivacyExport, func() (interface{}, error) {
+		orders, err := p.userOrders(ctx, userId)
+		if err != nil {
+			return nil, err
+		}
+		for _, order := range orders {
+			if err := p.exportOrder(ctx, archive, order); err != nil {
+				return nil, err
+			}
+		}
+		archive.Orders = orders
+		return map[string]int{"orders": len(orders), "history": len(archive.History)}, nil
+	})
+	if err != nil {
+		return request, nil, err
+	}
+	return request, archive, nil
+}
+
+func (p *PrivacyService) exportOrder(ctx context.Context, archive *models.UserDataArchive, order *models.Order) error {
+	items, err := p.Orders.GetOrderItems(ctx, order.OrderId)
+	if err != nil {
+		return err
+	}
+	order.Items = items
+	history, err := p.Orders.GetOrderHistory(ctx, order.OrderId)
+	if err != nil {
+		return err
+	}
+	archive.History = append(archive.History, history...)
+	refunds, err := p.Orders.ListRefunds(ctx, order.OrderId)
+	if err != nil {
+		return err
+	}
+	archive.Refunds = append(archive.Refunds, refunds...)
+	fulfillment, err := p.Orders.GetFulfillment(ctx, order.OrderId)
+	if err == nil {
+		archive.Fulfillments = append(archive.Fulfillments, fulfillment)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// EraseUserData 逐个订单处理，中途失败时已经处理的订单不会回滚，重新提交请求会继续处理剩下的订单
+func (p *PrivacyService) EraseUserData(ctx context.Context, userId int64) (*models.PrivacyRequest, *models.ErasureResult, error) {
+	result := &models.ErasureResult{}
+	request, err := p.track(ctx, userId, models.PrivacyErase, func() (interface{}, error) {
+		orders, err := p.userOrders(ctx, userId)
+		if err != nil {
+			return result, err
+		}
+		result.Orders = len(orders)
+		for _, order := range orders {
+			if models.RetainedForAccounting(order) {
+				if _, err := p.Orders.AnonymizeOrder(ctx, order.OrderId); err != nil {
+					return result, err
+				}
+				result.Anonymized++
+				continue
+			}
+			if _, err := p.Orders.EraseOrder(ctx, order.OrderId); err != nil {
+				return result, err
+			}
+			result.Deleted++
+		}
+		if p.Sagas != nil {
+			if result.Sagas, err = p.Sagas.DeleteUserSagas(ctx, userId); err != nil {
+				return result, err
+			}
+		}
+		return result, nil
+	})
+	return request, result, err
+}
+
+func (p *PrivacyService) ListPrivacyRequests(ctx context.Context, userId int64) ([]*models.PrivacyRequest, error) {
+	return p.Requests.ListRequests(ctx, userId)
+}
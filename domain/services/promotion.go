@@ -0,0 +1,316 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/models"
+)
+
+// PromotionLine 参与计算的一个订单项，Amount 是之前的活动优惠后还需要支付的金额
+type PromotionLine struct {
+	SKUId  int64
+	Count  int32
+	Price  int64
+	Amount int64
+}
+
+// PromotionRule 促销规则，返回每个订单项的优惠金额，下标和 lines 一致
+// 引擎会把优惠金额限制在订单项剩余的金额以内
+type PromotionRule interface {
+	Apply(lines []PromotionLine) []int64
+}
+
+// Promotion 一个促销活动
+type Promotion struct {
+	Id       string
+	Name     string
+	Rule     PromotionRule
+	StartsAt time.Time // 零值表示不限制
+	EndsAt   time.Time
+}
+
+// Active 活动在 now 是否生效
+func (p *Promotion) Active(now time.Time) bool {
+	if !p.StartsAt.IsZero() && now.Before(p.StartsAt) {
+		return false
+	}
+	if !p.EndsAt.IsZero() && !now.Before(p.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// PromotionFactory 根据配置创建促销规则
+type PromotionFactory func(cfg *conf.PromotionRuleConfig) (PromotionRule, error)
+
+var (
+	promotionKindsMu sync.RWMutex
+	promotionKinds   = map[string]PromotionFactory{}
+)
+
+// RegisterPromotionKind 注册一种促销规则，之后可以在配置中通过 kind 使用
+func RegisterPromotionKind(kind string, factory PromotionFactory) {
+	promotionKindsMu.Lock()
+	defer promotionKindsMu.Unlock()
+	promotionKinds[kind] = factory
+}
+
+func init() {
+	RegisterPromotionKind("percentage", func(cfg *conf.PromotionRuleConfig) (PromotionRule, error) {
+		if cfg.Percent <= 0 || cfg.Percent > 100 {
+			return nil, fmt.Errorf("promotion %s: percent must be in (0, 100]", cfg.Id)
+		}
+		return &PercentageRule{Percent: cfg.Percent, SKUs: cfg.SKUs}, nil
+	})
+	RegisterPromotionKind("fixed", func(cfg *conf.PromotionRuleConfig) (PromotionRule, error) {
+		if cfg.Amount <= 0 {
+			return nil, fmt.Errorf("promotion %s: amount must be positive", cfg.Id)
+		}
+		return &FixedRule{Amount: cfg.Amount, SKUs: cfg.SKUs}, nil
+	})
+	RegisterPromotionKind("threshold", func(cfg *conf.PromotionRuleConfig) (PromotionRule, error) {
+		if cfg.Amount <= 0 || cfg.Threshold <= 0 {
+			return nil, fmt.Errorf("promotion %s: amount and threshold must be positive", cfg.Id)
+		}
+		return &ThresholdRule{Threshold: cfg.Threshold, Amount: cfg.Amount, SKUs: cfg.SKUs}, nil
+	})
+	RegisterPromotionKind("bundle", func(cfg *conf.PromotionRuleConfig) (PromotionRule, error) {
+		if cfg.BuyCount <= 0 || cfg.GetCount <= 0 {
+			return nil, fmt.Errorf("promotion %s: buy_count and get_count must be positive", cfg.Id)
+		}
+		return &BundleRule{BuySKU: cfg.BuySKU, BuyCount: cfg.BuyCount, GetSKU: cfg.GetSKU, GetCount: cfg.GetCount}, nil
+	})
+}
+
+// NewPromotion 根据配置创建促销活动
+func NewPromotion(cfg *conf.PromotionRuleConfig) (*Promotion, error) {
+	promotionKindsMu.RLock()
+	factory, ok := promotionKinds[cfg.Kind]
+	promotionKindsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("promotion %s: unknown kind %s", cfg.Id, cfg.Kind)
+	}
+	rule, err := factory(cfg)
+	if err != nil {
+		return nil, err
+	}
+	promotion := &Promotion{Id: cfg.Id, Name: cfg.Name, Rule: rule}
+	if cfg.StartsAt > 0 {
+		promotion.StartsAt = time.Unix(cfg.StartsAt, 0)
+	}
+	if cfg.EndsAt > 0 {
+		promotion.EndsAt = time.Unix(cfg.EndsAt, 0)
+	}
+	return promotion, nil
+}
+
+// PromotionEngine 按顺序叠加生效的促销活动，计算每个订单项的优惠
+type PromotionEngine struct {
+	mu         sync.RWMutex
+	promotions []*Promotion
+}
+
+func NewPromotionEngine(promotions ...*Promotion) *PromotionEngine {
+	return &PromotionEngine{promotions: promotions}
+}
+
+// NewPromotionEngineFromConfig 根据配置创建引擎，任何一个活动配置错误都会返回错误
+func NewPromotionEngineFromConfig(cfg *conf.PromotionConfig) (*PromotionEngine, error) {
+	promotions := []*Promotion{}
+	for i := range cfg.Rules {
+		promotion, err := NewPromotion(&cfg.Rules[i])
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, promotion)
+	}
+	return NewPromotionEngine(promotions...), nil
+}
+
+// SetPromotions 替换全部促销活动，用于配置变更后重新加载
+func (e *PromotionEngine) SetPromotions(promotions ...*Promotion) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.promotions = promotions
+}
+
+// Price 计算订单在 now 的优惠，结果写入每个订单项的 Discount 和 Discounts 以及订单的 DiscountAmount 和 TotalAmount
+// 之前计算过的优惠会被清空后重新计算
+func (e *PromotionEngine) Price(order *models.Order, now time.Time) {
+	e.mu.RLock()
+	promotions := e.promotions
+	e.mu.RUnlock()
+
+	lines := make([]PromotionLine, len(order.Items))
+	applied := make([][]models.AppliedDiscount, len(order.Items))
+	for i, item := range order.Items {
+		lines[i] = PromotionLine{SKUId: item.SKUId, Count: item.Count, Price: item.Price, Amount: item.Subtotal()}
+	}
+	for _, promotion := range promotions {
+		if !promotion.Active(now) {
+			continue
+		}
+		discounts := promotion.Rule.Apply(lines)
+		for i := range lines {
+			if i >= len(discounts) || discounts[i] <= 0 {
+				continue
+			}
+			discount := discounts[i]
+			if discount > lines[i].Amount {
+				discount = lines[i].Amount
+			}
+			if discount == 0 {
+				continue
+			}
+			lines[i].Amount -= discount
+			applied[i] = append(applied[i], models.AppliedDiscount{PromotionId: promotion.Id, Name: promotion.Name, Amount: discount})
+		}
+	}
+
+	order.DiscountAmount = 0
+	for i := range order.Items {
+		order.Items[i].SetAppliedDiscounts(applied[i])
+		order.DiscountAmount += order.Items[i].Discount
+	}
+	order.TotalAmount = models.ItemsTotal(order.Items)
+}
+
+// matchSKU skus 为空表示全部sku
+func matchSKU(skus []int64, sku int64) bool {
+	if len(skus) == 0 {
+		return true
+	}
+	for _, s := range skus {
+		if s == sku {
+			return true
+		}
+	}
+	return false
+}
+
+// distribute 把 amount 按剩余金额的比例分摊到匹配的订单项上，除不尽的部分算在最后一个订单项
+func distribute(lines []PromotionLine, skus []int64, amount int64) []int64 {
+	discounts := make([]int64, len(lines))
+	var total int64
+	last := -1
+	for i, line := range lines {
+		if matchSKU(skus, line.SKUId) && line.Amount > 0 {
+			total += line.Amount
+			last = i
+		}
+	}
+	if last < 0 {
+		return discounts
+	}
+	if amount > total {
+		amount = total
+	}
+	var assigned int64
+	for i, line := range lines {
+		if i == last {
+			discounts[i] = amount - assigned
+			break
+		}
+		if matchSKU(skus, line.SKUId) && line.Amount > 0 {
+			discounts[i] = amount * line.Amount / total
+			assigned += discounts[i]
+		}
+	}
+	return discounts
+}
+
+// PercentageRule 按比例折扣
+type PercentageRule struct {
+	Percent int64
+	SKUs    []int64
+}
+
+func (r *PercentageRule) Apply(lines []PromotionLine) []int64 {
+	discounts := make([]int64, len(lines))
+	for i, line := range lines {
+		if matchSKU(r.SKUs, line.SKUId) {
+			discounts[i] = line.Amount * r.Percent / 100
+		}
+	}
+	return discounts
+}
+
+// FixedRule 立减固定金额
+type FixedRule struct {
+	Amount int64
+	SKUs   []int64
+}
+
+func (r *FixedRule) Apply(lines []PromotionLine) []int64 {
+	return distribute(lines, r.SKUs, r.Amount)
+}
+
+// ThresholdRule 满减，匹配的订单项合计达到门槛时立减固定金额
+type ThresholdRule struct {
+	Threshold int64
+	Amount    int64
+	SKUs      []int64
+}
+
+func (r *ThresholdRule) Apply(lines []PromotionLine) []int64 {
+	var total int64
+	for _, line := range lines {
+		if matchSKU(r.SKUs, line.SKUId) {
+			total += line.Amount
+		}
+	}
+	if total < r.Threshold {
+		return make([]int64, len(lines))
+	}
+	return distribute(lines, r.SKUs, r.Amount)
+}
+
+// BundleRule 买赠，每买 BuyCount 件 BuySKU 送 GetCount 件 GetSKU，赠品需要在订单项中，优惠为赠品的金额
+// BuySKU 和 GetSKU 相同时表示买X送Y，每 BuyCount+GetCount 件中有 GetCount 件免费
+type BundleRule struct {
+	BuySKU   int64
+	BuyCount int32
+	GetSKU   int64
+	GetCount int32
+}
+
+func (r *BundleRule) Apply(lines []PromotionLine) []int64 {
+	discounts := make([]int64, len(lines))
+	var bought, got int32
+	for _, line := range lines {
+		if line.SKUId == r.BuySKU {
+			bought += line.Count
+		}
+		if line.SKUId == r.GetSKU && r.GetSKU != r.BuySKU {
+			got += line.Count
+		}
+	}
+	var free int32
+	if r.BuySKU == r.GetSKU {
+		free = bought / (r.BuyCount + r.GetCount) * r.GetCount
+	} else {
+		free = bought / r.BuyCount * r.GetCount
+		if free > got {
+			free = got
+		}
+	}
+	for i, line := range lines {
+		if free == 0 {
+			break
+		}
+		if line.SKUId != r.GetSKU || line.Count == 0 {
+			continue
+		}
+		n := line.Count
+		if n > free {
+			n = free
+		}
+		// 按当前剩余金额折算单价，叠加在其他活动之后也不会多减
+		discounts[i] = line.Amount * int64(n) / int64(line.Count)
+		free -= n
+	}
+	return discounts
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"context"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// goRedisClient 用 go-redis 实现 RedisClient
+type goRedisClient struct {
+	client *redis.Client
+}
+
+func NewRedisClient(addr, password string, db int) RedisClient {
+	return &goRedisClient{
+		client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+			DB:       db,
+		}),
+	}
+}
+
+func (g *goRedisClient) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := g.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// Eval 脚本总是返回数字，不会出现 redis.Nil
+func (g *goRedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) error {
+	return g.client.Eval(ctx, script, keys, args...).Err()
+}
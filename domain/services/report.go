@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+)
+
+// 销售汇总的时间粒度
+const (
+	BucketDay   = "day"
+	BucketWeek  = "week"
+	BucketMonth = "month"
+)
+
+var (
+	// ErrReportRange 报表的结束时间必须晚于开始时间
+	ErrReportRange = errors.New("report range must end after it starts")
+	// ErrReportBucket 不支持的时间粒度
+	ErrReportBucket = errors.New("report bucket must be day, week or month")
+)
+
+// SalesSummaryQuery 销售汇总的查询条件，按服务所在时区的日期统计，包含 From 所在的一天，To 为0点时不包含这一天
+type SalesSummaryQuery struct {
+	From     time.Time
+	To       time.Time
+	Bucket   string
+	Currency string
+}
+
+// SalesBucket 一个时间段内一个租户一种币种的销售汇总，金额都以分为单位
+type SalesBucket struct {
+	// Start 时间段的第一天，按周统计时是周一
+	Start    string
+	TenantId string
+	Currency string
+	// OrderCount 这段时间创建的全部订单，StatusCounts 是按当前状态的订单数
+	OrderCount   int64
+	StatusCounts map[int8]int64
+	// SalesCount 计入销售额的订单数，见 models.IsSale
+	SalesCount     int64
+	Revenue        int64
+	DiscountAmount int64
+	// AverageOrderValue 平均每个计入销售额的订单的金额
+	AverageOrderValue int64
+}
+
+// TopProductsQuery 商品销量排行的查询条件，时间范围和 SalesSummaryQuery 相同
+type TopProductsQuery struct {
+	From     time.Time
+	To       time.Time
+	Currency string
+	Limit    int
+}
+
+// ReportService 财务报表，查询只读每天的汇总表，汇总表由 Refresh 从订单重新计算
+type ReportService struct {
+	reports dao.ReportDAOInterface
+	// orders 读订单的DAO，配置了从库时连接从库
+	orders dao.BulkOrderDAO
+}
+
+func NewReportService(reports dao.ReportDAOInterface, orderdao dao.OrderDAOInterface) (*ReportService, error) {
+	bulk, ok := orderdao.(dao.BulkOrderDAO)
+	if !ok {
+		return nil, ErrBulkNotSupported
+	}
+	return &ReportService{reports: reports, orders: bulk}, nil
+}
+
+// startOfDay 服务所在时区的0点
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.In(time.Local).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+}
+
+// dayRange 把时间范围换算成报表的日期范围，结果包含 from 不包含 to
+func dayRange(from, to time.Time) (string, string, error) {
+	if !to.After(from) {
+		return "", "", ErrReportRange
+	}
+	end := startOfDay(to)
+	if end.Before(to) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return startOfDay(from).Format(models.DayLayout), end.Format(models.DayLayout), nil
+}
+
+// Refresh 重新计算今天和之前共 days 天的汇总，下单、支付和退款都会改变最近的汇总
+func (r *ReportService) Refresh(ctx context.Context, days int) error {
+	today := startOfDay(time.Now())
+	for i := days - 1; i >= 0; i-- {
+		if err := r.RefreshDay(ctx, today.AddDate(0, 0, -i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RefreshDay 读出这一天创建的全部订单和订单项，汇总后整天替换，重复执行结果相同
+// 拆单后的父订单不计入，子订单沿用父订单的创建时间，按下单的日期统计
+func (r *ReportService) RefreshDay(ctx context.Context, day time.Time) error {
+	start := startOfDay(day)
+	dayName := start.Format(models.DayLayout)
+	refreshedAt := time.Now()
+	type salesKey struct {
+		tenantId string
+		currency string
+		status   int8
+	}
+	type productKey struct {
+		tenantId string
+		currency string
+		skuId    int64
+	}
+	sales := map[salesKey]*models.DailySales{}
+	products := map[productKey]*models.DailyProductSales{}
+
+	query := &dao.ListOrderQuery{CreatedAfter: start, CreatedBefore: start.AddDate(0, 0, 1)}
+	err := r.orders.ExportOrders(ctx, query, dao.DefaultBulkBatch, func(orders []*models.Order) error {
+		for _, order := range orders {
+			if order.SubOrderCount > 0 {
+				continue
+			}
+			key := salesKey{order.TenantId, order.Currency, order.Status}
+			row, ok := sales[key]
+			if !ok {
+				row = &models.DailySales{TenantId: order.TenantId, Day: dayName, Currency: order.Currency, Status: order.Status, RefreshedAt: refreshedAt}
+				sales[key] = row
+			}
+			row.OrderCount++
+			row.TotalAmount += order.TotalAmount
+			row.DiscountAmount += order.DiscountAmount
+			if !models.IsSale(order.Status) {
+				row.PaidAmount += order.PaidAmount
+				continue
+			}
+			// 没有记录支付金额的历史订单按总金额计算
+			row.PaidAmount += order.Paid()
+			counted := map[int64]bool{}
+			for _, item := range order.Items {
+				sold := int64(item.Count - item.RefundedCount)
+				if sold <= 0 {
+					continue
+				}
+				key := productKey{order.TenantId, order.Currency, item.SKUId}
+				product, ok := products[key]
+				if !ok {
+					product = &models.DailyProductSales{TenantId: order.TenantId, Day: dayName, Currency: order.Currency, SKUId: item.SKUId, RefreshedAt: refreshedAt}
+					products[key] = product
+				}
+				product.Quantity += sold
+				product.Revenue += (item.Subtotal() - item.Discount) * sold / int64(item.Count)
+				if !counted[item.SKUId] {
+					product.OrderCount++
+					counted[item.SKUId] = true
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	salesRows := make([]*models.DailySales, 0, len(sales))
+	for _, row := range sales {
+		salesRows = append(salesRows, row)
+	}
+	productRows := make([]*models.DailyProductSales, 0, len(products))
+	for _, row := range products {
+		productRows = append(productRows, row)
+	}
+	return r.reports.ReplaceDay(ctx, dayName, salesRows, productRows)
+}
+
+// bucketStart 日期所在时间段的第一天
+func bucketStart(day time.Time, bucket string) time.Time {
+	switch bucket {
+	case BucketWeek:
+		// 周一是一周的第一天
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case BucketMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+// GetSalesSummary 按时间段、租户和币种合计每天的汇总，按时间段、租户、币种排序
+// 金额不同币种之间不能相加，同一个时间段每种币种一条
+func (r *ReportService) GetSalesSummary(ctx context.Context, query *SalesSummaryQuery) ([]*SalesBucket, error) {
+	bucket := query.Bucket
+	if bucket == "" {
+		bucket = BucketDay
+	}
+	if bucket != BucketDay && bucket != BucketWeek && bucket != BucketMonth {
+		return nil, ErrReportBucket
+	}
+	fromDay, toDay, err := dayRange(query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	daily, err := r.reports.ListDailySales(ctx, &dao.ReportQuery{FromDay: fromDay, ToDay: toDay, Currency: query.Currency})
+	if err != nil {
+		return nil, err
+	}
+
+	type bucketKey struct {
+		start    string
+		tenantId string
+		currency string
+	}
+	buckets := map[bucketKey]*SalesBucket{}
+	for _, row := range daily {
+		day, err := time.ParseInLocation(models.DayLayout, row.Day, time.Local)
+		if err != nil {
+			return nil, err
+		}
+		key := bucketKey{bucketStart(day, bucket).Format(models.DayLayout), row.TenantId, row.Currency}
+		summary, ok := buckets[key]
+		if !ok {
+			summary = &SalesBucket{Start: key.start, TenantId: row.TenantId, Currency: row.Currency, StatusCounts: map[int8]int64{}}
+			buckets[key] = summary
+		}
+		summary.OrderCount += row.OrderCount
+		summary.StatusCounts[row.Status] += row.OrderCount
+		if models.IsSale(row.Status) {
+			summary.SalesCount += row.OrderCount
+			summary.Revenue += row.PaidAmount
+			summary.DiscountAmount += row.DiscountAmount
+		}
+	}
+
+	result := make([]*SalesBucket, 0, len(buckets))
+	for _, summary := range buckets {
+		if summary.SalesCount > 0 {
+			summary.AverageOrderValue = summary.Revenue / summary.SalesCount
+		}
+		result = append(result, summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start < result[j].Start
+		}
+		if result[i].TenantId != result[j].TenantId {
+			return result[i].TenantId < result[j].TenantId
+		}
+		return result[i].Currency < result[j].Currency
+	})
+	return result, nil
+}
+
+// GetTopProducts 时间范围内按优惠后的金额排序的商品，不同租户和币种分别合计，不同币种的金额不能直接比较，需要时按币种过滤
+func (r *ReportService) GetTopProducts(ctx context.Context, query *TopProductsQuery) ([]*models.DailyProductSales, error) {
+	fromDay, toDay, err := dayRange(query.From, query.To)
+	if err != nil {
+		return nil, err
+	}
+	return r.reports.TopProducts(ctx, &dao.ReportQuery{FromDay: fromDay, ToDay: toDay, Currency: query.Currency}, query.Limit)
+}
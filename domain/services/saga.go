@@ -0,0 +1,302 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/utils"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"gorm.io/gorm"
+)
+
+// SagaActor 下单流程写入订单版本记录的操作人
+const SagaActor = "saga"
+
+// SagaLease 执行流程的租约时长，每保存一次状态续约一次，需要长于一个步骤包括重试的最长时间
+const SagaLease = time.Minute
+
+// ErrSagaCompensated 下单流程中某一步失败，已经补偿完成，失败原因见 OrderSaga.LastError
+var ErrSagaCompensated = errors.New("place order failed, saga compensated")
+
+// RetryPolicy 单个步骤遇到临时错误时的重试策略，每次重试的间隔翻倍
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// IsTransient 是否是可以重试的临时错误：超时、限流、下游不可用和乐观锁冲突
+func IsTransient(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, dao.ErrVersionConflict) {
+		return true
+	}
+	switch microerrors.Parse(err.Error()).Code {
+	case 408, 429, 500, 502, 503, 504:
+		return true
+	}
+	return false
+}
+
+// sagaStep 流程中的一步，action 和 compensate 都必须是幂等的，重启后可能被重复执行
+type sagaStep struct {
+	name       string
+	action     func(ctx context.Context, saga *models.OrderSaga, order *models.Order) error
+	compensate func(ctx context.Context, saga *models.OrderSaga, order *models.Order) error // 为空表示不需要补偿
+}
+
+// OrderSaga 下单流程编排：预占库存 -> 创建订单 -> 发起支付
+// 每一步完成后保存流程状态，某一步失败时倒序执行已完成步骤的补偿：取消订单、释放库存
+type OrderSaga struct {
+	sagas     dao.SagaDAOInterface
+	orders    OrderServiceInterface
+	inventory InventoryClient
+	payment   PaymentClient
+	retry     RetryPolicy
+	steps     []sagaStep
+}
+
+func NewOrderSaga(sagas dao.SagaDAOInterface, orders OrderServiceInterface, inventory InventoryClient, payment PaymentClient, retry RetryPolicy) *OrderSaga {
+	if retry.MaxAttempts <= 0 {
+		retry.MaxAttempts = 1
+	}
+	s := &OrderSaga{
+		sagas:     sagas,
+		orders:    orders,
+		inventory: inventory,
+		payment:   payment,
+		retry:     retry,
+	}
+	s.steps = []sagaStep{
+		{name: "reserve stock", action: s.reserveStock, compensate: s.releaseStock},
+		{name: "create order", action: s.createOrder, compensate: s.cancelOrder},
+		{name: "request payment", action: s.requestPayment},
+	}
+	return s
+}
+
+// PlaceOrder 执行一次下单流程，订单号同时作为流程ID，同一个订单号重复提交会继续执行已有的流程
+// 流程完成时返回nil，补偿完成时返回 ErrSagaCompensated，其余错误表示流程还没有结束，会由 Recover 继续执行；
+// 订单号对应的流程属于其他用户时按不存在处理，流程正在被其他调用执行时返回 dao.ErrSagaLeased
+func (s *OrderSaga) PlaceOrder(ctx context.Context, order *models.Order) (*models.OrderSaga, error) {
+	if order.OrderId == "" {
+		order.OrderId = s.orders.GenerateOrderId(ctx, order.UserId)
+	}
+	owner := utils.UUID()
+	saga, err := s.sagas.GetSaga(ctx, order.OrderId)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		payload, err := json.Marshal(order)
+		if err != nil {
+			return nil, err
+		}
+		until := time.Now().Add(SagaLease)
+		saga = &models.OrderSaga{
+			SagaId:     order.OrderId,
+			OrderId:    order.OrderId,
+			UserId:     order.UserId,
+			Status:     models.SagaRunning,
+			Payload:    string(payload),
+			LeaseOwner: owner,
+			LeaseUntil: &until,
+		}
+		if err := s.sagas.CreateSaga(ctx, saga); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case saga.UserId != order.UserId:
+		return nil, gorm.ErrRecordNotFound
+	default:
+		if saga, err = s.sagas.AcquireSaga(ctx, order.OrderId, owner, SagaLease); err != nil {
+			return saga, err
+		}
+	}
+	return saga, s.run(ctx, saga)
+}
+
+// Recover 继续执行 idle 时间内没有进展的未结束流程，返回处理的流程数
+// 取得租约后才执行，租约被其他调用取得的流程跳过
+func (s *OrderSaga) Recover(ctx context.Context, idle time.Duration, limit int) (int, error) {
+	sagas, err := s.sagas.ListUnfinishedSagas(ctx, time.Now().Add(-idle), limit)
+	if err != nil {
+		return 0, err
+	}
+	owner := utils.UUID()
+	recovered := 0
+	for _, saga := range sagas {
+		// 后台任务没有租户，按流程所属的租户继续执行
+		tenantCtx := dao.WithTenant(ctx, saga.TenantId)
+		acquired, err := s.sagas.AcquireSaga(tenantCtx, saga.SagaId, owner, SagaLease)
+		if errors.Is(err, dao.ErrSagaLeased) {
+			continue
+		}
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		recovered++
+		if err := s.run(tenantCtx, acquired); err != nil && !errors.Is(err, ErrSagaCompensated) {
+			fmt.Println(err)
+		}
+	}
+	return recovered, nil
+}
+
+// save 保存流程状态并续约
+func (s *OrderSaga) save(ctx context.Context, saga *models.OrderSaga) error {
+	until := time.Now().Add(SagaLease)
+	saga.LeaseUntil = &until
+	return s.sagas.SaveSaga(ctx, saga)
+}
+
+// release 流程停下后释放租约，Recover 或者调用方重试时可以马上继续执行
+func (s *OrderSaga) release(ctx context.Context, saga *models.OrderSaga) {
+	saga.LeaseUntil = nil
+	if err := s.sagas.SaveSaga(ctx, saga); err != nil && !errors.Is(err, dao.ErrSagaLeased) {
+		fmt.Println(err)
+	}
+}
+
+// run 从保存的位置继续执行流程，调用方需要已经持有流程的租约，已经结束的流程直接返回结果
+func (s *OrderSaga) run(ctx context.Context, saga *models.OrderSaga) error {
+	order := &models.Order{}
+	if err := json.Unmarshal([]byte(saga.Payload), order); err != nil {
+		return err
+	}
+	ctx = dao.WithAudit(ctx, SagaActor, "place order")
+	if !models.SagaFinished(saga.Status) {
+		defer s.release(ctx, saga)
+	}
+
+	for saga.Status == models.SagaRunning {
+		step := s.steps[saga.Step]
+		err := s.withRetry(ctx, saga, func() error { return step.action(ctx, saga, order) })
+		// 失败的步骤可能已经在下游生效（比如超时），所以也计入需要补偿的步骤
+		saga.Step++
+		if err != nil {
+			saga.Status, saga.LastError = models.SagaCompensating, fmt.Sprintf("%s: %v", step.name, err)
+		} else if saga.Step == len(s.steps) {
+			saga.Status = models.SagaCompleted
+		}
+		if err := s.save(ctx, saga); err != nil {
+			return err
+		}
+	}
+
+	for saga.Status == models.SagaCompensating {
+		if saga.Step == 0 {
+			saga.Status = models.SagaCompensated
+		} else if step := s.steps[saga.Step-1]; step.compensate != nil {
+			err := s.withRetry(ctx, saga, func() error { return step.compensate(ctx, saga, order) })
+			if err != nil {
+				// 补偿失败时停在当前步骤，等待 Recover 重试
+				saga.LastError = fmt.Sprintf("compensate %s: %v", step.name, err)
+				if saveErr := s.save(ctx, saga); saveErr != nil {
+					return saveErr
+				}
+				return err
+			}
+			saga.Step--
+		} else {
+			saga.Step--
+		}
+		if err := s.save(ctx, saga); err != nil {
+			return err
+		}
+	}
+
+	if saga.Status == models.SagaCompensated {
+		return ErrSagaCompensated
+	}
+	return nil
+}
+
+// withRetry 临时错误按策略重试，其余错误直接返回
+func (s *OrderSaga) withRetry(ctx context.Context, saga *models.OrderSaga, fn func() error) error {
+	backoff := s.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsTransient(err) || attempt >= s.retry.MaxAttempts {
+			return err
+		}
+		saga.Attempts++
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (s *OrderSaga) reserveStock(ctx context.Context, saga *models.OrderSaga, order *models.Order) error {
+	reservationId, err := s.inventory.Reserve(ctx, order.OrderId, StockItems(order.Items))
+	if err != nil {
+		return err
+	}
+	saga.ReservationId = reservationId
+	return nil
+}
+
+func (s *OrderSaga) releaseStock(ctx context.Context, saga *models.OrderSaga, order *models.Order) error {
+	if saga.ReservationId == "" {
+		return nil
+	}
+	return s.inventory.Release(ctx, saga.ReservationId)
+}
+
+// createOrder 订单已经存在说明之前执行过这一步
+func (s *OrderSaga) createOrder(ctx context.Context, saga *models.OrderSaga, order *models.Order) error {
+	_, err := s.orders.GetOrderById(ctx, order.OrderId)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	created := *order
+	created.Items = append([]models.OrderItem(nil), order.Items...)
+	// 库存已经在上一步预占，订单服务不需要再次预占
+	created.ReservationId = saga.ReservationId
+	_, err = s.orders.CreateOrder(ctx, &created)
+	return err
+}
+
+// cancelOrder 订单不存在或已经取消时不需要处理
+func (s *OrderSaga) cancelOrder(ctx context.Context, saga *models.OrderSaga, order *models.Order) error {
+	current, err := s.orders.GetOrderById(ctx, order.OrderId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Status == models.StatusCancelled {
+		return nil
+	}
+	_, err = s.orders.UpdateOrder(ctx, &models.Order{
+		OrderId:      order.OrderId,
+		UserId:       order.UserId,
+		Status:       models.StatusCancelled,
+		OrderVersion: current.OrderVersion + 1,
+	}, current.OrderVersion)
+	return err
+}
+
+// requestPayment 使用创建后的订单，应付金额以订单服务计算的优惠为准
+func (s *OrderSaga) requestPayment(ctx context.Context, saga *models.OrderSaga, order *models.Order) error {
+	created, err := s.orders.GetOrderById(ctx, order.OrderId)
+	if err != nil {
+		return err
+	}
+	paymentId, err := s.payment.RequestPayment(ctx, created)
+	if err != nil {
+		return err
+	}
+	saga.PaymentId = paymentId
+	return nil
+}
@@ -0,0 +1,176 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	ordertesting "github.com/lenny-mo/order/testing"
+	"github.com/lenny-mo/order/utils"
+	"gorm.io/gorm"
+)
+
+type sagaFixture struct {
+	sagas     dao.SagaDAOInterface
+	orders    services.OrderServiceInterface
+	inventory *services.MemoryInventory
+	payment   *ordertesting.MemoryPayment
+	saga      *services.OrderSaga
+}
+
+func newSagaFixture(t testing.TB, mode string) *sagaFixture {
+	t.Helper()
+	db, err := ordertesting.OpenSQLite("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orderDAO, err := ordertesting.NewOrderDAO(db, mode, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sagas := dao.NewSagaDAO(db)
+	if err := sagas.(*dao.SagaDAO).Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	f := &sagaFixture{
+		sagas:     sagas,
+		inventory: services.NewMemoryInventory(map[int64]int32{1: 10}),
+		payment:   ordertesting.NewMemoryPayment(),
+	}
+	f.orders = services.NewOrderService(orderDAO, nil, nil, nil, nil)
+	f.saga = services.NewOrderSaga(sagas, f.orders, f.inventory, f.payment, services.RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond})
+	return f
+}
+
+func sagaOrder(userId int64) *models.Order {
+	return &models.Order{
+		OrderId:  utils.UUID(),
+		UserId:   userId,
+		Currency: "CNY",
+		Items:    []models.OrderItem{{SKUId: 1, Count: 2, Price: 100}},
+	}
+}
+
+func TestPlaceOrderSaga(t *testing.T) {
+	tests := []struct {
+		name          string
+		paymentErr    error
+		wantErr       error
+		wantStatus    string
+		wantOrder     int8
+		wantAvailable int32
+	}{
+		{name: "completed", wantStatus: models.SagaCompleted, wantOrder: models.StatusUnpaid, wantAvailable: 8},
+		{name: "payment fails", paymentErr: errors.New("payment rejected"), wantErr: services.ErrSagaCompensated, wantStatus: models.SagaCompensated, wantOrder: models.StatusCancelled, wantAvailable: 10},
+	}
+	for _, mode := range []string{conf.PersistenceState, conf.PersistenceEvent} {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				f := newSagaFixture(t, mode)
+				f.payment.Fail(tt.paymentErr)
+				ctx := context.Background()
+				order := sagaOrder(1)
+
+				saga, err := f.saga.PlaceOrder(ctx, order)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+				if saga.Status != tt.wantStatus {
+					t.Fatalf("saga status %s at step %d: %s", saga.Status, saga.Step, saga.LastError)
+				}
+				created, err := f.orders.GetOrderById(ctx, order.OrderId)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if created.Status != tt.wantOrder {
+					t.Fatalf("order status %d, want %d", created.Status, tt.wantOrder)
+				}
+				if available := f.inventory.Available(1); available != tt.wantAvailable {
+					t.Fatalf("%d available, want %d", available, tt.wantAvailable)
+				}
+				// 重复提交返回已经结束的流程，不会再次执行
+				again, err := f.saga.PlaceOrder(ctx, order)
+				if !errors.Is(err, tt.wantErr) || again.Status != tt.wantStatus {
+					t.Fatalf("placing again returned %s: %v", again.Status, err)
+				}
+				if available := f.inventory.Available(1); available != tt.wantAvailable {
+					t.Fatalf("%d available after placing again, want %d", available, tt.wantAvailable)
+				}
+			})
+		}
+	}
+}
+
+func TestPlaceOrderSagaOtherUser(t *testing.T) {
+	f := newSagaFixture(t, conf.PersistenceState)
+	ctx := context.Background()
+	order := sagaOrder(1)
+	if _, err := f.saga.PlaceOrder(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	other := *order
+	other.UserId = 2
+	if _, err := f.saga.PlaceOrder(ctx, &other); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("placing another user's order returned %v", err)
+	}
+}
+
+func TestRecoverSaga(t *testing.T) {
+	for _, mode := range []string{conf.PersistenceState, conf.PersistenceEvent} {
+		t.Run(mode, func(t *testing.T) {
+			f := newSagaFixture(t, mode)
+			ctx := context.Background()
+			order := sagaOrder(1)
+			payload, err := json.Marshal(order)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 执行流程的进程在预占库存前退出，没有留下租约
+			saga := &models.OrderSaga{SagaId: order.OrderId, OrderId: order.OrderId, UserId: order.UserId, Status: models.SagaRunning, Payload: string(payload)}
+			if err := f.sagas.CreateSaga(ctx, saga); err != nil {
+				t.Fatal(err)
+			}
+
+			// 其他调用持有租约时 Recover 和重复提交都不会执行
+			leased, err := f.sagas.AcquireSaga(ctx, saga.SagaId, "other", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.saga.PlaceOrder(ctx, order); !errors.Is(err, dao.ErrSagaLeased) {
+				t.Fatalf("placing a leased saga returned %v", err)
+			}
+			if recovered, err := f.saga.Recover(ctx, 0, 10); err != nil || recovered != 0 {
+				t.Fatalf("recovered %d leased sagas: %v", recovered, err)
+			}
+
+			leased.LeaseUntil = nil
+			if err := f.sagas.SaveSaga(ctx, leased); err != nil {
+				t.Fatal(err)
+			}
+			recovered, err := f.saga.Recover(ctx, 0, 10)
+			if err != nil || recovered != 1 {
+				t.Fatalf("recovered %d sagas: %v", recovered, err)
+			}
+			got, err := f.sagas.GetSaga(ctx, saga.SagaId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != models.SagaCompleted || got.LeaseUntil != nil {
+				t.Fatalf("recovered saga status %s lease until %v", got.Status, got.LeaseUntil)
+			}
+			if f.payment.PaymentId(order.OrderId) != got.PaymentId || got.PaymentId == "" {
+				t.Fatalf("saga payment %q, payment service %q", got.PaymentId, f.payment.PaymentId(order.OrderId))
+			}
+			if available := f.inventory.Available(1); available != 8 {
+				t.Fatalf("%d available, want 8", available)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// OrderIndexer SearchOrders 使用的索引，返回的订单号按创建时间倒序，分面统计覆盖全部符合条件的订单
+// 租户从context 中读取，一个租户只能搜到自己的订单
+type OrderIndexer interface {
+	// Index 写入或覆盖订单的索引，items 用于按商品搜索
+	Index(ctx context.Context, order *models.Order, items []models.OrderItem) error
+	// Remove 删除订单的索引，订单被删除后调用
+	Remove(ctx context.Context, orderId string) error
+	Search(ctx context.Context, query *dao.OrderSearchQuery) (*dao.OrderSearchResult, error)
+}
+
+type sqlIndexer struct {
+	orders dao.SearchOrderDAO
+}
+
+// NewSQLIndexer 直接在订单表上搜索，订单表本身就是索引，Index 和 Remove 什么都不做
+func NewSQLIndexer(orderdao dao.OrderDAOInterface) (OrderIndexer, error) {
+	search, ok := orderdao.(dao.SearchOrderDAO)
+	if !ok {
+		return nil, dao.ErrSearchNotSupported
+	}
+	if err := search.MigrateSearch(); err != nil {
+		return nil, err
+	}
+	return &sqlIndexer{orders: search}, nil
+}
+
+func (s *sqlIndexer) Index(ctx context.Context, order *models.Order, items []models.OrderItem) error {
+	return nil
+}
+
+func (s *sqlIndexer) Remove(ctx context.Context, orderId string) error {
+	return nil
+}
+
+func (s *sqlIndexer) Search(ctx context.Context, query *dao.OrderSearchQuery) (*dao.OrderSearchResult, error) {
+	return s.orders.SearchOrders(ctx, query)
+}
+
+// IndexSyncer 按订单变更事件更新索引，事件只带订单号，每次从数据库读出订单的最新状态，
+// 所以事件重复、乱序都不影响结果，订单被删除后从索引中移除
+type IndexSyncer struct {
+	service OrderServiceInterface
+	indexer OrderIndexer
+}
+
+func NewIndexSyncer(service OrderServiceInterface, indexer OrderIndexer) *IndexSyncer {
+	return &IndexSyncer{service: service, indexer: indexer}
+}
+
+// Sync 处理一个订单变更事件，context 中不带租户，可以读到全部租户的订单
+func (s *IndexSyncer) Sync(ctx context.Context, event *OrderChangedEvent) error {
+	order, err := s.service.GetOrderById(ctx, event.OrderId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.indexer.Remove(ctx, event.OrderId)
+	}
+	if err != nil {
+		return err
+	}
+	items, err := s.service.GetOrderItems(ctx, event.OrderId)
+	if err != nil {
+		return err
+	}
+	return s.indexer.Index(ctx, order, items)
+}
+
+// Rebuild 把数据库中未删除的订单全部写入索引，返回写入的订单数，用于新建的索引
+func (s *IndexSyncer) Rebuild(ctx context.Context, orderdao dao.OrderDAOInterface) (int, error) {
+	bulk, ok := orderdao.(dao.BulkOrderDAO)
+	if !ok {
+		return 0, ErrBulkNotSupported
+	}
+	indexed := 0
+	err := bulk.ExportOrders(ctx, &dao.ListOrderQuery{}, dao.DefaultBulkBatch, func(orders []*models.Order) error {
+		for _, order := range orders {
+			if err := s.indexer.Index(ctx, order, order.Items); err != nil {
+				return err
+			}
+		}
+		indexed += len(orders)
+		return nil
+	})
+	return indexed, err
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"gorm.io/gorm"
+)
+
+// MaxMergeOrders 一次最多合并的订单数
+const MaxMergeOrders = 10
+
+// SplitOrder 按 groups 把已支付的订单拆成子订单，每个子订单单独发货、签收和退款，
+// 原订单成为父订单，状态由子订单汇总；子订单号和新订单一样生成，分片模式下和父订单在同一个分片
+func (o *OrderService) SplitOrder(ctx context.Context, orderId string, oldversion int64, groups [][]models.SplitLine) ([]*models.Order, error) {
+	parent, err := o.OrderDAO.GetOrderById(ctx, orderId)
+	if err != nil {
+		return nil, err
+	}
+	subOrderIds := make([]string, 0, len(groups))
+	for range groups {
+		subOrderIds = append(subOrderIds, o.GenerateOrderId(ctx, parent.UserId))
+	}
+	return o.OrderDAO.SplitOrder(ctx, orderId, oldversion, subOrderIds, groups)
+}
+
+func (o *OrderService) ListSubOrders(ctx context.Context, parentId string) ([]*models.Order, error) {
+	return o.OrderDAO.ListSubOrders(ctx, parentId)
+}
+
+// syncParent 子订单的状态变化后重新汇总父订单的状态，和其他子订单并发修改时重试
+// 子订单已经提交，汇总失败只打印日志，下一个子订单变化时会再次汇总
+func (o *OrderService) syncParent(ctx context.Context, child *models.Order) {
+	if child == nil || child.ParentOrderId == "" {
+		return
+	}
+	ctx = dao.WithAudit(ctx, dao.AuditFrom(ctx).Actor, "sub order "+child.OrderId)
+	for attempt := 0; attempt < 3; attempt++ {
+		_, err := o.OrderDAO.SyncParentStatus(ctx, child.ParentOrderId)
+		if !errors.Is(err, dao.ErrVersionConflict) {
+			if err != nil {
+				fmt.Println(err)
+			}
+			return
+		}
+	}
+}
+
+// MergeOrders 把同一个用户的多个未支付订单合并成一个新订单，versions 是每个订单调用方读到的版本号
+// 合并后的订单使用最早的订单的地址和订单数据，相同商品和单价的订单项合并成一项，按促销活动重新计算优惠和应付金额；
+// 配置了库存服务时先为合并后的订单预占库存，合并成功后释放被合并的订单的预占，
+// 预占失败时被合并的订单保持不变，所以合并时库存需要足够同时预占两份
+func (o *OrderService) MergeOrders(ctx context.Context, versions map[string]int64) (*models.Order, error) {
+	if len(versions) < 2 {
+		return nil, fmt.Errorf("%w: need at least two orders", models.ErrInvalidMerge)
+	}
+	if len(versions) > MaxMergeOrders {
+		return nil, fmt.Errorf("%w: can not merge more than %d orders", models.ErrInvalidMerge, MaxMergeOrders)
+	}
+	orderIds := make([]string, 0, len(versions))
+	for orderId := range versions {
+		orderIds = append(orderIds, orderId)
+	}
+	sources, err := o.OrderDAO.GetOrdersByIds(ctx, orderIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) != len(orderIds) {
+		return nil, gorm.ErrRecordNotFound
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].CreatedAt.Before(sources[j].CreatedAt)
+	})
+
+	first := sources[0]
+	merged := &models.Order{
+		OrderId:         o.GenerateOrderId(ctx, first.UserId),
+		OrderVersion:    1,
+		UserId:          first.UserId,
+		TenantId:        first.TenantId,
+		OrderData:       first.OrderData,
+		Status:          models.StatusUnpaid,
+		Currency:        first.Currency,
+		ShippingAddress: first.ShippingAddress,
+		BillingAddress:  first.BillingAddress,
+		Contact:         first.Contact,
+	}
+	if err := models.CheckMerge(merged, sources); err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		items, err := o.OrderDAO.GetOrderItems(ctx, source.OrderId)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return nil, fmt.Errorf("%w: order %s has no items", models.ErrInvalidMerge, source.OrderId)
+		}
+		merged.Items = mergeItems(merged.Items, items)
+	}
+	o.price(merged)
+
+	if o.Inventory != nil {
+		reservationId, err := o.Inventory.Reserve(ctx, merged.OrderId, StockItems(merged.Items))
+		if err != nil {
+			return nil, err
+		}
+		merged.ReservationId = reservationId
+	}
+	if err := o.OrderDAO.MergeOrders(ctx, merged, versions); err != nil {
+		if merged.ReservationId != "" {
+			if releaseErr := o.Inventory.Release(ctx, merged.ReservationId); releaseErr != nil {
+				fmt.Println(releaseErr)
+			}
+		}
+		return nil, err
+	}
+	if o.Inventory != nil {
+		for _, source := range sources {
+			if source.ReservationId == "" {
+				continue
+			}
+			if err := o.Inventory.Release(ctx, source.ReservationId); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// mergeItems 商品和单价都相同的订单项合并数量，优惠由合并后的订单重新计算
+func mergeItems(merged []models.OrderItem, items []models.OrderItem) []models.OrderItem {
+	for _, item := range items {
+		found := false
+		for i := range merged {
+			if merged[i].SKUId == item.SKUId && merged[i].Price == item.Price {
+				merged[i].Count += item.Count
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, models.OrderItem{SKUId: item.SKUId, Count: item.Count, Price: item.Price})
+		}
+	}
+	return merged
+}
@@ -0,0 +1,92 @@
+package services_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/utils"
+)
+
+func TestMergeOrdersMovesReservation(t *testing.T) {
+	for _, mode := range []string{conf.PersistenceState, conf.PersistenceEvent} {
+		t.Run(mode, func(t *testing.T) {
+			inventory := services.NewMemoryInventory(map[int64]int32{1: 6, 2: 5})
+			service := newOrderService(t, mode, inventory, nil)
+			ctx := context.Background()
+			versions := map[string]int64{}
+			for _, items := range [][]models.OrderItem{
+				{{SKUId: 1, Count: 2, Price: 100}},
+				{{SKUId: 1, Count: 1, Price: 100}, {SKUId: 2, Count: 1, Price: 300}},
+			} {
+				order := &models.Order{OrderId: utils.UUID(), OrderVersion: 1, UserId: 1, Currency: "CNY", Items: items}
+				if _, err := service.CreateOrder(ctx, order); err != nil {
+					t.Fatal(err)
+				}
+				versions[order.OrderId] = order.OrderVersion
+			}
+
+			merged, err := service.MergeOrders(ctx, versions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if merged.TotalAmount != 600 || len(merged.Items) != 2 || merged.Items[0].Count != 3 {
+				t.Fatalf("merged total %d with items %v", merged.TotalAmount, merged.Items)
+			}
+			// 被合并的订单的预占释放后，只剩合并后的订单的预占
+			if available := inventory.Available(1); available != 3 {
+				t.Fatalf("%d of sku 1 available, want 3", available)
+			}
+			if available := inventory.Available(2); available != 4 {
+				t.Fatalf("%d of sku 2 available, want 4", available)
+			}
+			if _, err := service.MergeOrders(ctx, versions); err == nil {
+				t.Fatal("merging cancelled orders again succeeded")
+			}
+		})
+	}
+}
+
+func TestSubOrderRefundDerivesParentStatus(t *testing.T) {
+	for _, mode := range []string{conf.PersistenceState, conf.PersistenceEvent} {
+		t.Run(mode, func(t *testing.T) {
+			service := newOrderService(t, mode, nil, nil)
+			ctx := context.Background()
+			order := &models.Order{
+				OrderId:      utils.UUID(),
+				OrderVersion: 1,
+				UserId:       1,
+				Currency:     "CNY",
+				Items:        []models.OrderItem{{SKUId: 1, Count: 1, Price: 100}, {SKUId: 2, Count: 1, Price: 300}},
+			}
+			if _, err := service.CreateOrder(ctx, order); err != nil {
+				t.Fatal(err)
+			}
+			paid, err := service.ConfirmPayment(ctx, &models.OrderPayment{PaymentId: utils.UUID(), OrderId: order.OrderId, Amount: 400, Currency: "CNY"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			children, err := service.SplitOrder(ctx, order.OrderId, paid.OrderVersion, [][]models.SplitLine{{{SKUId: 1, Count: 1}}, {{SKUId: 2, Count: 1}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			refunded, err := service.RefundOrder(ctx, &models.OrderRefund{OrderId: children[0].OrderId, Amount: 100}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if refunded.Status != models.StatusRefunded || refunded.ParentOrderId != order.OrderId {
+				t.Fatalf("sub order status %d parent %q", refunded.Status, refunded.ParentOrderId)
+			}
+			parent, err := service.GetOrderById(ctx, order.OrderId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parent.Status != models.StatusPartiallyRefunded {
+				t.Fatalf("parent status %d, want partially refunded", parent.Status)
+			}
+		})
+	}
+}
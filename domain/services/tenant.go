@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+)
+
+// ErrCurrencyNotAllowed 订单币种和租户配置的币种不一致
+var ErrCurrencyNotAllowed = errors.New("currency is not allowed for this tenant")
+
+// Tenants 每个租户的配置，为nil 时全部租户使用全局配置
+type Tenants struct {
+	Default  string
+	settings map[string]conf.TenantSettings
+}
+
+// NewTenantsFromConfig 校验每个租户的配置
+func NewTenantsFromConfig(cfg *conf.TenantConfig) (*Tenants, error) {
+	tenants := &Tenants{
+		Default:  cfg.Default,
+		settings: map[string]conf.TenantSettings{},
+	}
+	for tenantId, settings := range cfg.Tenants {
+		if settings.OrderIdPrefix != "" {
+			if _, isSlot := dao.SlotOfOrderId(settings.OrderIdPrefix + "-x"); isSlot {
+				return nil, fmt.Errorf("tenant %s: order_id_prefix %q looks like a shard slot", tenantId, settings.OrderIdPrefix)
+			}
+		}
+		if settings.AutoCancelSeconds < 0 {
+			return nil, fmt.Errorf("tenant %s: auto_cancel_seconds must not be negative", tenantId)
+		}
+		tenants.settings[tenantId] = settings
+	}
+	if tenants.Default != "" && !tenants.Known(tenants.Default) {
+		return nil, fmt.Errorf("default tenant %s is not configured", tenants.Default)
+	}
+	return tenants, nil
+}
+
+// Known 没有配置租户时接受任意租户
+func (t *Tenants) Known(tenantId string) bool {
+	if t == nil || len(t.settings) == 0 {
+		return true
+	}
+	_, ok := t.settings[tenantId]
+	return ok
+}
+
+// Settings context 中的租户的配置，没有配置时返回零值
+func (t *Tenants) Settings(ctx context.Context) conf.TenantSettings {
+	if t == nil {
+		return conf.TenantSettings{}
+	}
+	tenantId, _ := dao.TenantFrom(ctx)
+	return t.settings[tenantId]
+}
+
+// AutoCancelTimeouts 配置了自己的超时时间的租户
+func (t *Tenants) AutoCancelTimeouts() map[string]time.Duration {
+	timeouts := map[string]time.Duration{}
+	if t == nil {
+		return timeouts
+	}
+	for tenantId, settings := range t.settings {
+		if settings.AutoCancelSeconds > 0 {
+			timeouts[tenantId] = time.Duration(settings.AutoCancelSeconds) * time.Second
+		}
+	}
+	return timeouts
+}
+
+// applyCurrency 没有传币种时使用租户的币种，传了其他币种时报错
+func (t *Tenants) applyCurrency(ctx context.Context, order *models.Order) error {
+	currency := t.Settings(ctx).Currency
+	if currency == "" {
+		return nil
+	}
+	if order.Currency == "" {
+		order.Currency = currency
+	}
+	if order.Currency != currency {
+		return ErrCurrencyNotAllowed
+	}
+	return nil
+}
+
+// CancelExpiredOrdersByTenant 配置了超时时间的租户按各自的时间取消，其他租户按 timeout 取消，timeout 为0 时不取消
+func CancelExpiredOrdersByTenant(ctx context.Context, orders OrderServiceInterface, tenants *Tenants, timeout time.Duration, limit int) ([]*models.Order, error) {
+	now := time.Now()
+	cancelled := []*models.Order{}
+	for tenantId, tenantTimeout := range tenants.AutoCancelTimeouts() {
+		result, err := orders.CancelExpiredOrders(dao.WithTenant(ctx, tenantId), now.Add(-tenantTimeout), limit)
+		if err != nil {
+			return cancelled, err
+		}
+		cancelled = append(cancelled, result...)
+	}
+	if timeout <= 0 {
+		return cancelled, nil
+	}
+	result, err := orders.CancelExpiredOrders(ctx, now.Add(-timeout), limit)
+	return append(cancelled, result...), err
+}
@@ -0,0 +1,434 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+)
+
+// TopicOrderChanged 订单提交新版本后发布，每个实例收到后唤醒本地的监听者
+const TopicOrderChanged = "go.micro.topic.order.changed"
+
+// OrderChangedEvent 订单变更通知，只用于唤醒监听者，推送的内容从版本记录中读取
+// UserId 为0 表示写入时不知道订单的用户，只通知按订单号监听的监听者
+type OrderChangedEvent struct {
+	OrderId      string `json:"order_id"`
+	UserId       int64  `json:"user_id"`
+	OrderVersion int64  `json:"order_version"`
+}
+
+// ErrWatchTarget 监听时订单号和用户必须且只能指定一个
+var ErrWatchTarget = errors.New("watch needs either an order id or a user id")
+
+// WatchQuery 监听的订单，OrderId 和 UserId 二选一
+type WatchQuery struct {
+	OrderId string
+	UserId  int64
+	// FromVersion 按订单监听时推送这个版本之后的版本，为空时先推送当前版本
+	FromVersion *int64
+	// FromVersions 按用户监听时每个订单最后收到的版本号，不在其中的订单只推送新的版本
+	FromVersions map[string]int64
+}
+
+// OrderWatcher 把订单的新版本推送给监听者
+// 变更通知只负责唤醒，监听者被唤醒后从版本记录中读出上次推送之后的全部版本，
+// 所以通知丢失或合并都不会漏推，定时的兜底检查也能覆盖不经过订单服务的写入
+type OrderWatcher struct {
+	service OrderServiceInterface
+	poll    time.Duration
+	// maxPending 一个监听者最多积压的订单数
+	maxPending int
+
+	mu      sync.Mutex
+	byOrder map[string]map[*watchSubscription]bool
+	byUser  map[int64]map[*watchSubscription]bool
+}
+
+func NewOrderWatcher(service OrderServiceInterface, poll time.Duration, maxPending int) *OrderWatcher {
+	return &OrderWatcher{
+		service:    service,
+		poll:       poll,
+		maxPending: maxPending,
+		byOrder:    map[string]map[*watchSubscription]bool{},
+		byUser:     map[int64]map[*watchSubscription]bool{},
+	}
+}
+
+// watchSubscription 一个监听者待推送的订单，通知只在这里合并，不会因为监听者推送得慢而阻塞写入
+type watchSubscription struct {
+	signal chan struct{}
+
+	mu       sync.Mutex
+	pending  map[string]bool
+	overflow bool
+}
+
+func (s *watchSubscription) wake(orderId string, maxPending int) {
+	s.mu.Lock()
+	if len(s.pending) >= maxPending {
+		// 积压太多时不再记录具体的订单，下次被唤醒时重新检查全部订单
+		s.pending, s.overflow = map[string]bool{}, true
+	} else if !s.overflow {
+		s.pending[orderId] = true
+	}
+	s.mu.Unlock()
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *watchSubscription) take() ([]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	orderIds := make([]string, 0, len(s.pending))
+	for orderId := range s.pending {
+		orderIds = append(orderIds, orderId)
+	}
+	overflow := s.overflow
+	s.pending, s.overflow = map[string]bool{}, false
+	return orderIds, overflow
+}
+
+// Notify 唤醒关注这个订单或这个用户的监听者，不会阻塞
+func (w *OrderWatcher) Notify(event *OrderChangedEvent) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for sub := range w.byOrder[event.OrderId] {
+		sub.wake(event.OrderId, w.maxPending)
+	}
+	if event.UserId == 0 {
+		return
+	}
+	for sub := range w.byUser[event.UserId] {
+		sub.wake(event.OrderId, w.maxPending)
+	}
+}
+
+func (w *OrderWatcher) follow(sub *watchSubscription, orderId string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.byOrder[orderId] == nil {
+		w.byOrder[orderId] = map[*watchSubscription]bool{}
+	}
+	w.byOrder[orderId][sub] = true
+}
+
+func (w *OrderWatcher) subscribe(userId int64) *watchSubscription {
+	sub := &watchSubscription{signal: make(chan struct{}, 1), pending: map[string]bool{}}
+	if userId != 0 {
+		w.mu.Lock()
+		if w.byUser[userId] == nil {
+			w.byUser[userId] = map[*watchSubscription]bool{}
+		}
+		w.byUser[userId][sub] = true
+		w.mu.Unlock()
+	}
+	return sub
+}
+
+func (w *OrderWatcher) unsubscribe(sub *watchSubscription, userId int64, orderIds map[string]int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for orderId := range orderIds {
+		delete(w.byOrder[orderId], sub)
+		if len(w.byOrder[orderId]) == 0 {
+			delete(w.byOrder, orderId)
+		}
+	}
+	delete(w.byUser[userId], sub)
+	if len(w.byUser[userId]) == 0 {
+		delete(w.byUser, userId)
+	}
+}
+
+// Watch 按版本号顺序推送订单的新版本，直到 ctx 结束或 send 返回错误
+// send 阻塞时期间的通知会合并，之后一次读出积压的版本，调用方不需要自己缓冲
+func (w *OrderWatcher) Watch(ctx context.Context, query *WatchQuery, send func(entry *models.OrderHistory) error) error {
+	if (query.OrderId == "") == (query.UserId == 0) {
+		return ErrWatchTarget
+	}
+	// 订单被删除后仍然推送之后的版本，比如恢复
+	ctx = dao.WithDeleted(ctx)
+	sub := w.subscribe(query.UserId)
+	// last 每个订单已经推送的版本号
+	last := map[string]int64{}
+	defer func() {
+		w.unsubscribe(sub, query.UserId, last)
+	}()
+
+	if query.OrderId != "" {
+		// 先关注再读当前版本，读完之前提交的版本会在第一次唤醒时推送
+		w.follow(sub, query.OrderId)
+		last[query.OrderId] = 0
+		current, err := w.service.GetOrderById(ctx, query.OrderId)
+		if err != nil {
+			return err
+		}
+		last[query.OrderId] = current.OrderVersion - 1
+		if query.FromVersion != nil {
+			last[query.OrderId] = *query.FromVersion
+		}
+		if err := w.catchUp(ctx, query.OrderId, last, send); err != nil {
+			return err
+		}
+	} else {
+		if err := w.resync(ctx, sub, query, last, send); err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(w.poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.signal:
+		case <-ticker.C:
+			sub.mu.Lock()
+			sub.overflow = true
+			sub.mu.Unlock()
+		}
+		orderIds, overflow := sub.take()
+		if overflow {
+			if err := w.resync(ctx, sub, query, last, send); err != nil {
+				return err
+			}
+			continue
+		}
+		for _, orderId := range orderIds {
+			if _, ok := last[orderId]; !ok {
+				// 用户的新订单，从创建开始推送
+				w.follow(sub, orderId)
+				last[orderId] = -1
+			}
+			if err := w.catchUp(ctx, orderId, last, send); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// resync 重新检查全部关注的订单，按用户监听时同时发现新的订单
+// 第一次检查时 last 为空，用户已有的订单从 FromVersions 或当前版本开始
+func (w *OrderWatcher) resync(ctx context.Context, sub *watchSubscription, query *WatchQuery, last map[string]int64, send func(entry *models.OrderHistory) error) error {
+	if query.OrderId != "" {
+		return w.catchUp(ctx, query.OrderId, last, send)
+	}
+	first := len(last) == 0
+	orders, err := w.service.ListOrders(ctx, &dao.ListOrderQuery{UserId: query.UserId})
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		version, known := last[order.OrderId]
+		if !known {
+			w.follow(sub, order.OrderId)
+			version = -1
+			if first {
+				version = order.OrderVersion
+				if from, ok := query.FromVersions[order.OrderId]; ok {
+					version = from
+				}
+			}
+			last[order.OrderId] = version
+		}
+		if order.OrderVersion > version {
+			if err := w.catchUp(ctx, order.OrderId, last, send); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// catchUp 推送版本号大于上次推送的全部版本记录
+// 事件溯源模式下追加订单项不改变版本号，同一个版本可能有多条记录，一次全部推送
+func (w *OrderWatcher) catchUp(ctx context.Context, orderId string, last map[string]int64, send func(entry *models.OrderHistory) error) error {
+	history, err := w.service.GetOrderHistory(ctx, orderId)
+	if err != nil {
+		return err
+	}
+	after := last[orderId]
+	for _, entry := range history {
+		if entry.OrderVersion <= after {
+			continue
+		}
+		if err := send(entry); err != nil {
+			return err
+		}
+		last[orderId] = entry.OrderVersion
+	}
+	return nil
+}
+
+// NotifyingOrderService 在订单提交新版本后通知监听者，并通过broker 通知其他实例
+type NotifyingOrderService struct {
+	OrderServiceInterface
+	watcher   *OrderWatcher
+	publisher EventPublisher
+}
+
+// NewNotifyingOrderService watcher 为空时只发布到broker，比如 orderctl 中
+func NewNotifyingOrderService(next OrderServiceInterface, watcher *OrderWatcher, publisher EventPublisher) OrderServiceInterface {
+	return &NotifyingOrderService{
+		OrderServiceInterface: next,
+		watcher:               watcher,
+		publisher:             publisher,
+	}
+}
+
+func (n *NotifyingOrderService) notify(ctx context.Context, orderId string, userId int64, version int64) {
+	event := &OrderChangedEvent{OrderId: orderId, UserId: userId, OrderVersion: version}
+	if n.watcher != nil {
+		n.watcher.Notify(event)
+	}
+	publish(ctx, n.publisher, TopicOrderChanged, event)
+}
+
+func (n *NotifyingOrderService) CreateOrder(ctx context.Context, order *models.Order) (int64, error) {
+	rowAffected, err := n.OrderServiceInterface.CreateOrder(ctx, order)
+	if err == nil && rowAffected > 0 {
+		n.notify(ctx, order.OrderId, order.UserId, order.OrderVersion)
+	}
+	return rowAffected, err
+}
+
+func (n *NotifyingOrderService) UpdateOrder(ctx context.Context, order *models.Order, oldversion int64) (int64, error) {
+	rowAffected, err := n.OrderServiceInterface.UpdateOrder(ctx, order, oldversion)
+	if err == nil && rowAffected > 0 {
+		n.notify(ctx, order.OrderId, order.UserId, order.OrderVersion)
+		n.notifyParent(ctx, order.ParentOrderId, order.UserId)
+	}
+	return rowAffected, err
+}
+
+func (n *NotifyingOrderService) BatchUpdateStatus(ctx context.Context, updates []*StatusUpdate) ([]*StatusUpdateResult, error) {
+	results, err := n.OrderServiceInterface.BatchUpdateStatus(ctx, updates)
+	for _, result := range results {
+		if result.Err == nil {
+			n.notify(ctx, result.OrderId, 0, result.Version)
+			n.notifyParent(ctx, result.ParentOrderId, 0)
+		}
+	}
+	return results, err
+}
+
+func (n *NotifyingOrderService) DeleteOrder(ctx context.Context, orderId string, oldversion int64) (int64, error) {
+	rowAffected, err := n.OrderServiceInterface.DeleteOrder(ctx, orderId, oldversion)
+	if err == nil && rowAffected > 0 {
+		n.notify(ctx, orderId, 0, oldversion+1)
+	}
+	return rowAffected, err
+}
+
+func (n *NotifyingOrderService) RestoreOrder(ctx context.Context, orderId string) (int64, error) {
+	rowAffected, err := n.OrderServiceInterface.RestoreOrder(ctx, orderId)
+	if err == nil && rowAffected > 0 {
+		n.notify(ctx, orderId, 0, 0)
+	}
+	return rowAffected, err
+}
+
+// PurgeOrder 物理删除后其他实例的本地缓存也需要失效，版本号取最大值
+func (n *NotifyingOrderService) PurgeOrder(ctx context.Context, orderId string) (int64, error) {
+	rowAffected, err := n.OrderServiceInterface.PurgeOrder(ctx, orderId)
+	if err == nil && rowAffected > 0 {
+		n.notify(ctx, orderId, 0, math.MaxInt64)
+	}
+	return rowAffected, err
+}
+
+func (n *NotifyingOrderService) EraseOrder(ctx context.Context, orderId string) (int64, error) {
+	rowAffected, err := n.OrderServiceInterface.EraseOrder(ctx, orderId)
+	if err == nil {
+		n.notify(ctx, orderId, 0, math.MaxInt64)
+	}
+	return rowAffected, err
+}
+
+func (n *NotifyingOrderService) RefundOrder(ctx context.Context, refund *models.OrderRefund, lines []models.RefundLine) (*models.Order, error) {
+	order, err := n.OrderServiceInterface.RefundOrder(ctx, refund, lines)
+	if err == nil {
+		n.notify(ctx, order.OrderId, order.UserId, order.OrderVersion)
+		n.notifyParent(ctx, order.ParentOrderId, order.UserId)
+	}
+	return order, err
+}
+
+func (n *NotifyingOrderService) ConfirmPayment(ctx context.Context, payment *models.OrderPayment) (*models.Order, error) {
+	order, err := n.OrderServiceInterface.ConfirmPayment(ctx, payment)
+	if err == nil {
+		n.notify(ctx, order.OrderId, order.UserId, order.OrderVersion)
+	}
+	return order, err
+}
+
+func (n *NotifyingOrderService) ShipOrder(ctx context.Context, fulfillment *models.OrderFulfillment) (*models.Order, error) {
+	order, err := n.OrderServiceInterface.ShipOrder(ctx, fulfillment)
+	if err == nil {
+		n.notify(ctx, order.OrderId, order.UserId, order.OrderVersion)
+		n.notifyParent(ctx, order.ParentOrderId, order.UserId)
+	}
+	return order, err
+}
+
+func (n *NotifyingOrderService) MarkDelivered(ctx context.Context, orderId string, deliveredAt time.Time) (*models.Order, error) {
+	order, err := n.OrderServiceInterface.MarkDelivered(ctx, orderId, deliveredAt)
+	if err == nil {
+		n.notify(ctx, order.OrderId, order.UserId, order.OrderVersion)
+		n.notifyParent(ctx, order.ParentOrderId, order.UserId)
+	}
+	return order, err
+}
+
+func (n *NotifyingOrderService) AnonymizeOrder(ctx context.Context, orderId string) (*models.Order, error) {
+	order, err := n.OrderServiceInterface.AnonymizeOrder(ctx, orderId)
+	if err == nil {
+		n.notify(ctx, order.OrderId, order.UserId, order.OrderVersion)
+	}
+	return order, err
+}
+
+func (n *NotifyingOrderService) CancelExpiredOrders(ctx context.Context, before time.Time, limit int) ([]*models.Order, error) {
+	cancelled, err := n.OrderServiceInterface.CancelExpiredOrders(ctx, before, limit)
+	for _, order := range cancelled {
+		n.notify(ctx, order.OrderId, order.UserId, order.OrderVersion)
+	}
+	return cancelled, err
+}
+
+// notifyParent 子订单变化后父订单的状态可能也重新汇总了，通知只负责唤醒，不需要父订单的版本号
+func (n *NotifyingOrderService) notifyParent(ctx context.Context, parentId string, userId int64) {
+	if parentId != "" {
+		n.notify(ctx, parentId, userId, 0)
+	}
+}
+
+func (n *NotifyingOrderService) SplitOrder(ctx context.Context, orderId string, oldversion int64, groups [][]models.SplitLine) ([]*models.Order, error) {
+	children, err := n.OrderServiceInterface.SplitOrder(ctx, orderId, oldversion, groups)
+	if err == nil {
+		for _, child := range children {
+			n.notify(ctx, child.OrderId, child.UserId, child.OrderVersion)
+		}
+		n.notify(ctx, orderId, children[0].UserId, oldversion+1)
+	}
+	return children, err
+}
+
+func (n *NotifyingOrderService) MergeOrders(ctx context.Context, versions map[string]int64) (*models.Order, error) {
+	merged, err := n.OrderServiceInterface.MergeOrders(ctx, versions)
+	if err == nil {
+		n.notify(ctx, merged.OrderId, merged.UserId, merged.OrderVersion)
+		for orderId, version := range versions {
+			n.notify(ctx, orderId, merged.UserId, version+1)
+		}
+	}
+	return merged, err
+}
@@ -7,6 +7,11 @@ go 1.13
 replace google.golang.org/grpc => google.golang.org/grpc v1.26.0
 
 require (
+	github.com/blevesearch/bleve v1.0.14
+	github.com/ghodss/yaml v1.0.0
+	github.com/glebarez/sqlite v1.11.0
+	github.com/go-redis/redis/v8 v8.11.5
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/golang/protobuf v1.5.3
 	github.com/google/uuid v1.5.0
 	github.com/lenny-mo/emall-utils v0.0.0-20231221153729-8300599172a7
@@ -22,8 +27,11 @@ require (
 	github.com/prometheus/common v0.45.0 // indirect
 	github.com/prometheus/procfs v0.12.0 // indirect
 	github.com/uber/jaeger-client-go v2.30.0+incompatible
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20240122235623-d6294584ab18
+	golang.org/x/sync v0.5.0
 	golang.org/x/tools v0.15.0 // indirect
 	google.golang.org/protobuf v1.31.0
 	gorm.io/driver/mysql v1.5.2
-	gorm.io/gorm v1.25.5
+	gorm.io/gorm v1.25.7
 )
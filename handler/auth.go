@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/lenny-mo/order/auth"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"github.com/micro/go-micro/v2/metadata"
+)
+
+// RoleKey 没有启用认证且 Order.TrustMetadataRole 为true 时调用方在metadata 中传入的角色
+const RoleKey = "Role"
+
+// RoleAdmin 管理员角色，可以物理删除订单
+const RoleAdmin = auth.RoleAdmin
+
+// isAdmin 调用方是否是管理员，启用认证时以token 中的角色为准；
+// 没有认证信息时默认不是管理员，只有配置了信任metadata 才按 RoleKey 判断
+func (o *Order) isAdmin(ctx context.Context) bool {
+	if identity, ok := auth.FromContext(ctx); ok {
+		return identity.HasRole(auth.RoleAdmin)
+	}
+	if !o.TrustMetadataRole {
+		return false
+	}
+	role, _ := metadata.Get(ctx, RoleKey)
+	return role == RoleAdmin
+}
+
+// checkOwnerOf 普通用户只能以自己的身份创建订单和生成订单号，没有启用认证时不检查
+func checkOwnerOf(ctx context.Context, userId int64) error {
+	identity, ok := auth.FromContext(ctx)
+	if !ok || identity.CanAccess(userId) {
+		return nil
+	}
+	return microerrors.Forbidden(SERVICE, "users can only act on their own orders")
+}
+
+// checkOwner 不属于调用方的订单按不存在处理，不暴露订单是否存在
+func checkOwner(ctx context.Context, orderdata *models.Order) error {
+	identity, ok := auth.FromContext(ctx)
+	if !ok || identity.CanAccess(orderdata.UserId) {
+		return nil
+	}
+	return microerrors.NotFound(SERVICE, "order %s not found", orderdata.OrderId)
+}
+
+// authorizeOrder 普通用户调用时先读出订单检查归属，包括已删除的订单
+func (o *Order) authorizeOrder(ctx context.Context, orderId string) error {
+	identity, ok := auth.FromContext(ctx)
+	if !ok || identity.Privileged() {
+		return nil
+	}
+	orderdata, err := o.Service.GetOrderById(dao.WithDeleted(ctx), orderId)
+	if err != nil {
+		return err
+	}
+	return checkOwner(ctx, orderdata)
+}
+
+// authorizeUpdate 普通用户只能修改自己的订单，不能把订单转给别人，状态只能改成已取消，
+// 支付、退款和发货的状态由对应的服务修改
+func (o *Order) authorizeUpdate(ctx context.Context, orderdata *models.Order) error {
+	identity, ok := auth.FromContext(ctx)
+	if !ok || identity.Privileged() {
+		return nil
+	}
+	if orderdata.UserId != 0 && orderdata.UserId != identity.UserId {
+		return microerrors.Forbidden(SERVICE, "users can not change the owner of an order")
+	}
+	if orderdata.Status != models.StatusUnpaid && orderdata.Status != models.StatusCancelled {
+		return microerrors.Forbidden(SERVICE, "users can only cancel their orders")
+	}
+	return o.authorizeOrder(ctx, orderdata.OrderId)
+}
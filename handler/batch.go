@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"gorm.io/gorm"
+)
+
+// BatchGetOrders 普通用户查询到的其他用户的订单按不存在处理
+func (o *Order) BatchGetOrders(ctx context.Context, req *order.BatchGetRequest, res *order.BatchGetResponse) error {
+	defer observe()()
+
+	if req.WithDeleted {
+		ctx = dao.WithDeleted(ctx)
+	}
+	orders, missing, err := o.Service.BatchGetOrders(ctx, req.OrderIds)
+	if errors.Is(err, services.ErrBatchTooLarge) {
+		return microerrors.BadRequest(SERVICE, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	res.Orders = make([]*order.OrderInfo, 0, len(orders))
+	res.MissingIds = missing
+	for _, orderdata := range orders {
+		if checkOwner(ctx, orderdata) != nil {
+			res.MissingIds = append(res.MissingIds, orderdata.OrderId)
+			continue
+		}
+		res.Orders = append(res.Orders, toOrderInfo(orderdata))
+	}
+	return nil
+}
+
+// BatchUpdateStatus 先逐个检查权限，没有权限的订单直接记为失败，其余的交给service 修改
+func (o *Order) BatchUpdateStatus(ctx context.Context, req *order.BatchUpdateStatusRequest, res *order.BatchUpdateStatusResponse) error {
+	defer observe()()
+
+	if len(req.Updates) > services.MaxBatchSize {
+		return microerrors.BadRequest(SERVICE, services.ErrBatchTooLarge.Error())
+	}
+	res.Results = make([]*order.StatusUpdateResult, len(req.Updates))
+	updates := []*services.StatusUpdate{}
+	positions := []int{}
+	for i, update := range req.Updates {
+		res.Results[i] = &order.StatusUpdateResult{OrderId: update.OrderId}
+		if update.OrderId == "" {
+			setBatchError(res.Results[i], microerrors.BadRequest(SERVICE, "order id is required"))
+			continue
+		}
+		if err := o.authorizeUpdate(ctx, &models.Order{OrderId: update.OrderId, Status: int8(update.Status)}); err != nil {
+			setBatchError(res.Results[i], err)
+			continue
+		}
+		updates = append(updates, &services.StatusUpdate{
+			OrderId: update.OrderId,
+			Status:  int8(update.Status),
+			Version: update.Version,
+			Reason:  update.Reason,
+		})
+		positions = append(positions, i)
+	}
+
+	results, err := o.Service.BatchUpdateStatus(auditContext(ctx, "batch update status"), updates)
+	if err != nil {
+		return err
+	}
+	for i, result := range results {
+		info := res.Results[positions[i]]
+		if result.Err != nil {
+			setBatchError(info, result.Err)
+			continue
+		}
+		info.Success = true
+		info.OrderVersion = result.Version
+	}
+	for _, info := range res.Results {
+		if info.Success {
+			res.Succeeded++
+		} else {
+			res.Failed++
+		}
+	}
+	return nil
+}
+
+// setBatchError 记录一个订单的失败原因，按错误类型转换成对应的HTTP 状态码
+func setBatchError(info *order.StatusUpdateResult, err error) {
+	var microErr *microerrors.Error
+	switch {
+	case errors.As(err, &microErr):
+		info.Code, info.Error = microErr.Code, microErr.Detail
+		return
+	case errors.Is(err, models.ErrInvalidTransition):
+		info.Code = http.StatusBadRequest
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		info.Code = http.StatusNotFound
+	case errors.Is(err, dao.ErrVersionConflict):
+		info.Code = http.StatusConflict
+	default:
+		info.Code = http.StatusInternalServerError
+	}
+	info.Error = err.Error()
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/lenny-mo/order/auth"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+func (o *Order) ListOrders(ctx context.Context, req *order.ListRequest, res *order.ListResponse) error {
+	defer observe()()
+
+	query := &dao.ListOrderQuery{
+		UserId:       req.UserId,
+		Offset:       int(req.Offset),
+		Limit:        int(req.Limit),
+		ContactEmail: req.ContactEmail,
+		ContactPhone: req.ContactPhone,
+	}
+	// 普通用户只能查询自己的订单
+	if identity, ok := auth.FromContext(ctx); ok && !identity.Privileged() {
+		// 用户ID 为0 会去掉用户条件，查出租户的全部订单
+		if identity.UserId == 0 {
+			return microerrors.Forbidden(SERVICE, "token has no user id")
+		}
+		if query.UserId != 0 && query.UserId != identity.UserId {
+			return microerrors.Forbidden(SERVICE, "users can only list their own orders")
+		}
+		query.UserId = identity.UserId
+	}
+	if req.Status != nil {
+		status := int8(*req.Status)
+		query.Status = &status
+	}
+	if req.WithDeleted {
+		ctx = dao.WithDeleted(ctx)
+	}
+	orders, err := o.Service.ListOrders(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	res.Orders = make([]*order.OrderInfo, 0, len(orders))
+	for _, orderdata := range orders {
+		res.Orders = append(res.Orders, toOrderInfo(orderdata))
+	}
+	return nil
+}
+
+func (o *Order) DeleteOrder(ctx context.Context, req *order.DeleteRequest, res *order.DeleteResponse) error {
+	defer observe()()
+
+	if err := o.authorizeOrder(ctx, req.OrderId); err != nil {
+		return err
+	}
+	rowAffected, err := o.Service.DeleteOrder(auditContext(ctx, req.Reason), req.OrderId, req.Version)
+	if err != nil {
+		return versionError(err)
+	}
+	res.RowsAffected = int32(rowAffected)
+	return nil
+}
+
+func (o *Order) RestoreOrder(ctx context.Context, req *order.RestoreRequest, res *order.RestoreResponse) error {
+	defer observe()()
+
+	if err := o.authorizeOrder(ctx, req.OrderId); err != nil {
+		return err
+	}
+	rowAffected, err := o.Service.RestoreOrder(auditContext(ctx, req.Reason), req.OrderId)
+	if err != nil {
+		return err
+	}
+	res.RowsAffected = int32(rowAffected)
+	return nil
+}
+
+func (o *Order) PurgeOrder(ctx context.Context, req *order.PurgeRequest, res *order.PurgeResponse) error {
+	defer observe()()
+
+	if !o.isAdmin(ctx) {
+		return microerrors.Forbidden(SERVICE, "purge order requires admin role")
+	}
+	rowAffected, err := o.Service.PurgeOrder(auditContext(ctx, req.Reason), req.OrderId)
+	if err != nil {
+		return err
+	}
+	res.RowsAffected = int32(rowAffected)
+	return nil
+}
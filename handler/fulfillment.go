@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"gorm.io/gorm"
+)
+
+// toFulfillmentInfo 把履约信息转换成proto 中的Fulfillment
+func toFulfillmentInfo(fulfillment *models.OrderFulfillment) *order.Fulfillment {
+	info := &order.Fulfillment{
+		Carrier:        fulfillment.Carrier,
+		TrackingNumber: fulfillment.TrackingNumber,
+	}
+	if fulfillment.ShippedAt != nil {
+		info.ShippedAt = fulfillment.ShippedAt.Unix()
+	}
+	if fulfillment.DeliveredAt != nil {
+		info.DeliveredAt = fulfillment.DeliveredAt.Unix()
+	}
+	return info
+}
+
+// transitionError 把状态流转的错误转换成对应的错误码
+func transitionError(err error) error {
+	switch {
+	case errors.Is(err, models.ErrInvalidTransition):
+		return microerrors.BadRequest(SERVICE, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return microerrors.NotFound(SERVICE, "order not found")
+	default:
+		return err
+	}
+}
+
+func (o *Order) ShipOrder(ctx context.Context, req *order.ShipRequest, res *order.ShipResponse) error {
+	defer observe()()
+
+	if req.OrderId == "" || req.Carrier == "" || req.TrackingNumber == "" {
+		return microerrors.BadRequest(SERVICE, "ship order needs order id, carrier and tracking number")
+	}
+	fulfillment := &models.OrderFulfillment{
+		OrderId:        req.OrderId,
+		Carrier:        req.Carrier,
+		TrackingNumber: req.TrackingNumber,
+	}
+	if req.ShippedAt > 0 {
+		shippedAt := time.Unix(req.ShippedAt, 0)
+		fulfillment.ShippedAt = &shippedAt
+	}
+	updated, err := o.Service.ShipOrder(auditContext(ctx, "ship"), fulfillment)
+	if err != nil {
+		return transitionError(err)
+	}
+
+	res.Status = order.OrderStatus(updated.Status)
+	res.OrderVersion = updated.OrderVersion
+	return nil
+}
+
+func (o *Order) MarkDelivered(ctx context.Context, req *order.DeliverRequest, res *order.DeliverResponse) error {
+	defer observe()()
+
+	if req.OrderId == "" {
+		return microerrors.BadRequest(SERVICE, "mark delivered needs order id")
+	}
+	var deliveredAt time.Time
+	if req.DeliveredAt > 0 {
+		deliveredAt = time.Unix(req.DeliveredAt, 0)
+	}
+	updated, err := o.Service.MarkDelivered(auditContext(ctx, "deliver"), req.OrderId, deliveredAt)
+	if err != nil {
+		return transitionError(err)
+	}
+
+	res.Status = order.OrderStatus(updated.Status)
+	res.OrderVersion = updated.OrderVersion
+	return nil
+}
@@ -6,10 +6,14 @@ import (
 	"time"
 
 	m "github.com/lenny-mo/emall-utils/metrics"
+	"github.com/lenny-mo/order/auth"
+	"github.com/lenny-mo/order/domain/dao"
 	"github.com/lenny-mo/order/domain/models"
 	"github.com/lenny-mo/order/domain/services"
 	"github.com/lenny-mo/order/proto/order"
-	"github.com/lenny-mo/order/utils"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"github.com/micro/go-micro/v2/metadata"
+	"gorm.io/gorm"
 )
 
 // 需要实现的接口
@@ -21,10 +25,56 @@ import (
 //		// 更新操作涉及到乐观锁做并发控制，所以需要传入版本号
 //		UpdateOrder(context.Context, *UpdateRequest, *UpdateResponse) error
 //		// 用户在创建订单的时候需要先调用此方法生成订单号
-//		GenerateUUID(context.Context, *Empty, *GenerateUUIDResponse) error
+//		GenerateUUID(context.Context, *GenerateUUIDRequest, *GenerateUUIDResponse) error
+//		// 订单的全部版本记录，用于排查谁在什么时候修改了订单
+//		GetOrderHistory(context.Context, *GetHistoryRequest, *GetHistoryResponse) error
+//		// 订单列表，默认不包含已删除的订单
+//		ListOrders(context.Context, *ListRequest, *ListResponse) error
+//		// 软删除、恢复和物理删除订单
+//		DeleteOrder(context.Context, *DeleteRequest, *DeleteResponse) error
+//		RestoreOrder(context.Context, *RestoreRequest, *RestoreResponse) error
+//		PurgeOrder(context.Context, *PurgeRequest, *PurgeResponse) error
+//		// 整单或按订单项部分退款，以及订单的退款记录
+//		RefundOrder(context.Context, *RefundRequest, *RefundResponse) error
+//		ListRefunds(context.Context, *ListRefundsRequest, *ListRefundsResponse) error
+//		// 支付服务确认支付，HTTP 回调见 PaymentWebhook
+//		ConfirmPayment(context.Context, *ConfirmPaymentRequest, *ConfirmPaymentResponse) error
+//		// 下单流程：预占库存、创建订单、发起支付
+//		PlaceOrder(context.Context, *PlaceOrderRequest, *PlaceOrderResponse) error
+//		// 按促销活动试算订单金额
+//		PreviewOrder(context.Context, *PreviewRequest, *PreviewResponse) error
+//		// 发货和签收
+//		ShipOrder(context.Context, *ShipRequest, *ShipResponse) error
+//		MarkDelivered(context.Context, *DeliverRequest, *DeliverResponse) error
+//		// 按用户导出和删除数据，以及审计记录
+//		ExportUserData(context.Context, *ExportUserDataRequest, *ExportUserDataResponse) error
+//		EraseUserData(context.Context, *EraseUserDataRequest, *EraseUserDataResponse) error
+//		ListPrivacyRequests(context.Context, *ListPrivacyRequestsRequest, *ListPrivacyRequestsResponse) error
+//		// 批量查询订单和批量修改订单状态
+//		BatchGetOrders(context.Context, *BatchGetRequest, *BatchGetResponse) error
+//		BatchUpdateStatus(context.Context, *BatchUpdateStatusRequest, *BatchUpdateStatusResponse) error
+//		// 推送订单的新版本
+//		WatchOrders(context.Context, *WatchRequest, Order_WatchOrdersStream) error
+//		// 按仓库拆单和合并未支付的订单
+//		SplitOrder(context.Context, *SplitOrderRequest, *SplitOrderResponse) error
+//		MergeOrders(context.Context, *MergeOrdersRequest, *MergeOrdersResponse) error
 //	}
 type Order struct {
-	Service services.OrderService
+	Service services.OrderServiceInterface
+	// 下单流程编排
+	Saga *services.OrderSaga
+	// 校验支付确认签名的HMAC 密钥
+	PaymentSecret []byte
+	// 用户数据导出和删除
+	Privacy services.PrivacyServiceInterface
+	// 推送订单的新版本，为空时不支持 WatchOrders
+	Watcher *services.OrderWatcher
+	// 订单搜索的索引，为空时不支持 SearchOrders
+	Search services.OrderIndexer
+	// 销售报表，为空时不支持报表RPC
+	Reports *services.ReportService
+	// 没有启用认证时是否按metadata 中的 Role 判断管理员，默认管理员接口全部拒绝
+	TrustMetadataRole bool
 }
 
 // 用于指定prometheus监控label
@@ -34,16 +84,143 @@ const (
 	OS      = "centOS"
 )
 
-func (o *Order) InsertOrder(ctx context.Context, req *order.InserRequest, res *order.InserResponse) error {
-	// prometheus 请求数+1
+// observe prometheus 请求数+1，返回的函数在请求结束时记录处理时间
+func observe() func() {
 	m.CounterRequestProcess(SERVICE, VERSION, OS)
-	// 记录支付处理开始时间
 	startTime := time.Now()
-	defer func() {
+	return func() {
 		duration := time.Since(startTime).Seconds()
 		m.RecordPaymentResponseTime(SERVICE, VERSION, OS, duration) // Histogram 指标
 		m.TaskExecutionTime(SERVICE, VERSION, OS, duration)         // Summary 指标
-	}()
+	}
+}
+
+// toOrderInfo 把订单模型转换成proto 中的OrderInfo
+func toOrderInfo(orderdata *models.Order) *order.OrderInfo {
+	info := &order.OrderInfo{
+		OrderId:        orderdata.OrderId,
+		UserId:         orderdata.UserId,
+		OrderVersion:   orderdata.OrderVersion,
+		OrderData:      orderdata.OrderData,
+		Status:         order.OrderStatus(orderdata.Status),
+		TotalAmount:    orderdata.TotalAmount,
+		Currency:       orderdata.Currency,
+		PaidAmount:     orderdata.PaidAmount,
+		ReservationId:  orderdata.ReservationId,
+		DiscountAmount: orderdata.DiscountAmount,
+		TenantId:       orderdata.TenantId,
+		ParentOrderId:  orderdata.ParentOrderId,
+		SubOrderCount:  orderdata.SubOrderCount,
+		MergedInto:     orderdata.MergedInto,
+	}
+	if !orderdata.ShippingAddress.IsZero() {
+		info.ShippingAddress = toAddressInfo(orderdata.ShippingAddress)
+	}
+	if !orderdata.BillingAddress.IsZero() {
+		info.BillingAddress = toAddressInfo(orderdata.BillingAddress)
+	}
+	if !orderdata.Contact.IsZero() {
+		info.Contact = &order.Contact{
+			Name:  orderdata.Contact.Name,
+			Phone: orderdata.Contact.Phone,
+			Email: orderdata.Contact.Email,
+		}
+	}
+	if orderdata.DeletedAt.Valid {
+		info.DeletedAt = orderdata.DeletedAt.Time.Unix()
+	}
+	for _, item := range orderdata.Items {
+		itemInfo := &order.OrderItemInfo{
+			SKUId:         item.SKUId,
+			Count:         item.Count,
+			Price:         item.Price,
+			RefundedCount: item.RefundedCount,
+			Discount:      item.Discount,
+		}
+		for _, discount := range item.AppliedDiscounts() {
+			itemInfo.Discounts = append(itemInfo.Discounts, &order.AppliedDiscount{
+				PromotionId: discount.PromotionId,
+				Name:        discount.Name,
+				Amount:      discount.Amount,
+			})
+		}
+		info.Items = append(info.Items, itemInfo)
+	}
+	return info
+}
+
+func toAddressInfo(address models.Address) *order.Address {
+	return &order.Address{
+		Line1:      address.Line1,
+		Line2:      address.Line2,
+		City:       address.City,
+		State:      address.State,
+		PostalCode: address.PostalCode,
+		Country:    address.Country,
+	}
+}
+
+// toAddress 把proto 中的地址转换成模型，没有传时返回空地址
+func toAddress(address *order.Address) models.Address {
+	if address == nil {
+		return models.Address{}
+	}
+	return models.Address{
+		Line1:      address.Line1,
+		Line2:      address.Line2,
+		City:       address.City,
+		State:      address.State,
+		PostalCode: address.PostalCode,
+		Country:    address.Country,
+	}
+}
+
+func toContact(contact *order.Contact) models.Contact {
+	if contact == nil {
+		return models.Contact{}
+	}
+	return models.Contact{
+		Name:  contact.Name,
+		Phone: contact.Phone,
+		Email: contact.Email,
+	}
+}
+
+// toOrderItems 把proto 中的订单项转换成订单项模型
+func toOrderItems(items []*order.OrderItemInfo) []models.OrderItem {
+	orderItems := make([]models.OrderItem, 0, len(items))
+	for _, item := range items {
+		orderItems = append(orderItems, models.OrderItem{
+			SKUId: item.SKUId,
+			Count: item.Count,
+			Price: item.Price,
+		})
+	}
+	return orderItems
+}
+
+// versionError 乐观锁冲突返回409，调用方重新读取订单后再修改
+func versionError(err error) error {
+	if errors.Is(err, dao.ErrVersionConflict) {
+		return microerrors.Conflict(SERVICE, err.Error())
+	}
+	return err
+}
+
+// ActorKey 调用方在metadata 中传入的操作人，写入订单版本记录
+const ActorKey = "Actor"
+
+// auditContext 启用认证时操作人是token 中的调用方，否则从metadata 中取出，和修改原因一起放入context
+func auditContext(ctx context.Context, reason string) context.Context {
+	if identity, ok := auth.FromContext(ctx); ok {
+		return dao.WithAudit(ctx, identity.Subject, reason)
+	}
+	actor, _ := metadata.Get(ctx, ActorKey)
+	return dao.WithAudit(ctx, actor, reason)
+}
+
+func (o *Order) InsertOrder(ctx context.Context, req *order.InserRequest, res *order.InserResponse) error {
+	defer observe()()
 
 	order := &models.Order{
 		UserId:       req.OrderData.UserId,
@@ -51,8 +228,18 @@ func (o *Order) InsertOrder(ctx context.Context, req *order.InserRequest, res *o
 		OrderId:      req.OrderData.OrderId,
 		Status:       int8(req.OrderData.Status),
 		OrderVersion: req.OrderData.OrderVersion,
+		TotalAmount:  req.OrderData.TotalAmount,
+		Currency:     req.OrderData.Currency,
+		Items:        toOrderItems(req.OrderData.Items),
+
+		ShippingAddress: toAddress(req.OrderData.ShippingAddress),
+		BillingAddress:  toAddress(req.OrderData.BillingAddress),
+		Contact:         toContact(req.OrderData.Contact),
+	}
+	if err := checkOwnerOf(ctx, order.UserId); err != nil {
+		return err
 	}
-	rowAffected, err := o.Service.CreateOrder(order)
+	rowAffected, err := o.Service.CreateOrder(auditContext(ctx, "create"), order)
 	if err != nil {
 		return err
 	}
@@ -66,42 +253,52 @@ func (o *Order) InsertOrder(ctx context.Context, req *order.InserRequest, res *o
 }
 
 func (o *Order) GetOrder(ctx context.Context, req *order.GetRequest, res *order.GetResponse) error {
-	// prometheus 请求数+1
-	m.CounterRequestProcess(SERVICE, VERSION, OS)
-	// 记录支付处理开始时间
-	startTime := time.Now()
-	defer func() {
-		duration := time.Since(startTime).Seconds()
-		m.RecordPaymentResponseTime(SERVICE, VERSION, OS, duration) // Histogram 指标
-		m.TaskExecutionTime(SERVICE, VERSION, OS, duration)         // Summary 指标
-	}()
+	defer observe()()
 
-	orderdata, err := o.Service.GetOrderById(req.OrderId)
+	if req.WithDeleted {
+		ctx = dao.WithDeleted(ctx)
+	}
+	orderdata, err := o.Service.GetOrderById(ctx, req.OrderId)
 	if err != nil {
 		return err
 	}
+	if err := checkOwner(ctx, orderdata); err != nil {
+		return err
+	}
+	if req.WithItems {
+		// 缓存里的订单是共享的，不能直接修改
+		withItems := *orderdata
+		if withItems.Items, err = o.Service.GetOrderItems(ctx, req.OrderId); err != nil {
+			return err
+		}
+		orderdata = &withItems
+	}
 
-	res.OrderData = &order.OrderInfo{
-		OrderId:      orderdata.OrderId,
-		UserId:       orderdata.UserId,
-		OrderVersion: orderdata.OrderVersion,
-		OrderData:    orderdata.OrderData,
-		Status:       order.OrderStatus(orderdata.Status),
+	res.OrderData = toOrderInfo(orderdata)
+	if req.WithFulfillment {
+		fulfillment, err := o.Service.GetFulfillment(ctx, req.OrderId)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			res.OrderData.Fulfillment = toFulfillmentInfo(fulfillment)
+		}
+	}
+	if req.WithSubOrders && orderdata.SubOrderCount > 0 {
+		children, err := o.Service.ListSubOrders(ctx, req.OrderId)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			res.SubOrders = append(res.SubOrders, toOrderInfo(child))
+		}
 	}
 
 	return nil
 }
 
 func (o *Order) UpdateOrder(ctx context.Context, req *order.UpdateRequest, res *order.UpdateResponse) error {
-	// prometheus 请求数+1
-	m.CounterRequestProcess(SERVICE, VERSION, OS)
-	// 记录支付处理开始时间
-	startTime := time.Now()
-	defer func() {
-		duration := time.Since(startTime).Seconds()
-		m.RecordPaymentResponseTime(SERVICE, VERSION, OS, duration) // Histogram 指标
-		m.TaskExecutionTime(SERVICE, VERSION, OS, duration)         // Summary 指标
-	}()
+	defer observe()()
 
 	order := &models.Order{
 		UserId:       req.OrderData.UserId,
@@ -109,11 +306,21 @@ func (o *Order) UpdateOrder(ctx context.Context, req *order.UpdateRequest, res *
 		OrderId:      req.OrderData.OrderId,
 		Status:       int8(req.OrderData.Status),
 		OrderVersion: req.OrderData.OrderVersion,
+
+		ShippingAddress: toAddress(req.OrderData.ShippingAddress),
+		BillingAddress:  toAddress(req.OrderData.BillingAddress),
+		Contact:         toContact(req.OrderData.Contact),
 	}
-	rowAffected, err := o.Service.UpdateOrder(order, req.Oldversion)
-	if err != nil {
+	if err := o.authorizeUpdate(ctx, order); err != nil {
 		return err
 	}
+	rowAffected, err := o.Service.UpdateOrder(auditContext(ctx, req.Reason), order, req.Oldversion)
+	if errors.Is(err, models.ErrInvalidTransition) {
+		return microerrors.BadRequest(SERVICE, err.Error())
+	}
+	if err != nil {
+		return versionError(err)
+	}
 	if rowAffected == 0 {
 		res.RowsAffected = 0
 		return errors.New("update order failed, row affected is 0")
@@ -122,7 +329,39 @@ func (o *Order) UpdateOrder(ctx context.Context, req *order.UpdateRequest, res *
 	return nil
 }
 
-func (o *Order) GenerateUUID(ctx context.Context, req *order.Empty, res *order.GenerateUUIDResponse) error {
-	res.Uuid = utils.UUID()
+func (o *Order) GenerateUUID(ctx context.Context, req *order.GenerateUUIDRequest, res *order.GenerateUUIDResponse) error {
+	if err := checkOwnerOf(ctx, req.UserId); err != nil {
+		return err
+	}
+	res.Uuid = o.Service.GenerateOrderId(ctx, req.UserId)
+	return nil
+}
+
+func (o *Order) GetOrderHistory(ctx context.Context, req *order.GetHistoryRequest, res *order.GetHistoryResponse) error {
+	defer observe()()
+
+	if err := o.authorizeOrder(ctx, req.OrderId); err != nil {
+		return err
+	}
+	history, err := o.Service.GetOrderHistory(ctx, req.OrderId)
+	if err != nil {
+		return err
+	}
+
+	res.History = make([]*order.OrderHistoryEntry, 0, len(history))
+	for _, entry := range history {
+		res.History = append(res.History, toHistoryEntry(entry))
+	}
 	return nil
 }
+
+func toHistoryEntry(entry *models.OrderHistory) *order.OrderHistoryEntry {
+	return &order.OrderHistoryEntry{
+		OrderVersion: entry.OrderVersion,
+		Status:       order.OrderStatus(entry.Status),
+		Diff:         entry.Diff,
+		Actor:        entry.Actor,
+		Reason:       entry.Reason,
+		Timestamp:    entry.CreatedAt.Unix(),
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"gorm.io/gorm"
+)
+
+// PaymentActor 支付确认写入订单版本记录的操作人
+const PaymentActor = "payment"
+
+// SignatureHeader 支付回调的签名放在这个请求头中，签名规则和 ConfirmPayment 相同
+const SignatureHeader = "X-Payment-Signature"
+
+// confirmPayment RPC 和HTTP 回调共用的处理逻辑，返回的错误都是 go-micro 的错误，Code 就是HTTP 状态码
+func (o *Order) confirmPayment(ctx context.Context, payment *models.OrderPayment, signature string) (*models.Order, error) {
+	if !services.VerifyPayment(o.PaymentSecret, payment, signature) {
+		return nil, microerrors.Unauthorized(SERVICE, "invalid payment signature")
+	}
+	ctx = dao.WithAudit(ctx, PaymentActor, "payment "+payment.PaymentId)
+	orderdata, err := o.Service.ConfirmPayment(ctx, payment)
+	if errors.Is(err, models.ErrPaymentMismatch) {
+		return nil, microerrors.Conflict(SERVICE, "%s: %s", err.Error(), payment.Detail)
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, microerrors.NotFound(SERVICE, "order %s not found", payment.OrderId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return orderdata, nil
+}
+
+func (o *Order) ConfirmPayment(ctx context.Context, req *order.ConfirmPaymentRequest, res *order.ConfirmPaymentResponse) error {
+	defer observe()()
+
+	payment := &models.OrderPayment{
+		PaymentId: req.PaymentId,
+		OrderId:   req.OrderId,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+	}
+	orderdata, err := o.confirmPayment(ctx, payment, req.Signature)
+	if err != nil {
+		return err
+	}
+	res.Status = order.OrderStatus(orderdata.Status)
+	res.OrderVersion = orderdata.OrderVersion
+	return nil
+}
+
+// paymentWebhookRequest 支付回调的请求体
+type paymentWebhookRequest struct {
+	OrderId   string `json:"order_id"`
+	PaymentId string `json:"payment_id"`
+	Amount    int64  `json:"amount"`
+	Currency  string `json:"currency"`
+}
+
+// PaymentWebhook 支付服务的HTTP 回调，只接受POST，成功时返回订单状态和版本号
+// 2xx 和 409 表示回调已经处理，支付服务不需要重试
+func (o *Order) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	defer observe()()
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &paymentWebhookRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment := &models.OrderPayment{
+		PaymentId: req.PaymentId,
+		OrderId:   req.OrderId,
+		Amount:    req.Amount,
+		Currency:  req.Currency,
+	}
+	orderdata, err := o.confirmPayment(r.Context(), payment, r.Header.Get(SignatureHeader))
+	if err != nil {
+		code := int(microerrors.Parse(err.Error()).Code)
+		if code == 0 {
+			code = http.StatusInternalServerError
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":        order.OrderStatus(orderdata.Status).String(),
+		"order_version": orderdata.OrderVersion,
+	})
+}
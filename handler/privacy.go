@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+func (o *Order) ExportUserData(ctx context.Context, req *order.ExportUserDataRequest, res *order.ExportUserDataResponse) error {
+	defer observe()()
+
+	if !o.isAdmin(ctx) {
+		return microerrors.Forbidden(SERVICE, "export user data requires admin role")
+	}
+	if req.UserId == 0 {
+		return microerrors.BadRequest(SERVICE, "export user data needs user id")
+	}
+	request, archive, err := o.Privacy.ExportUserData(auditContext(ctx, req.Reason), req.UserId)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(archive)
+	if err != nil {
+		return err
+	}
+
+	res.RequestId = request.RequestId
+	res.Archive = data
+	return nil
+}
+
+func (o *Order) EraseUserData(ctx context.Context, req *order.EraseUserDataRequest, res *order.EraseUserDataResponse) error {
+	defer observe()()
+
+	if !o.isAdmin(ctx) {
+		return microerrors.Forbidden(SERVICE, "erase user data requires admin role")
+	}
+	if req.UserId == 0 {
+		return microerrors.BadRequest(SERVICE, "erase user data needs user id")
+	}
+	request, result, err := o.Privacy.EraseUserData(auditContext(ctx, req.Reason), req.UserId)
+	if err != nil {
+		return err
+	}
+
+	res.RequestId = request.RequestId
+	res.Orders = int32(result.Orders)
+	res.Anonymized = int32(result.Anonymized)
+	res.Deleted = int32(result.Deleted)
+	return nil
+}
+
+func (o *Order) ListPrivacyRequests(ctx context.Context, req *order.ListPrivacyRequestsRequest, res *order.ListPrivacyRequestsResponse) error {
+	defer observe()()
+
+	if !o.isAdmin(ctx) {
+		return microerrors.Forbidden(SERVICE, "list privacy requests requires admin role")
+	}
+	requests, err := o.Privacy.ListPrivacyRequests(ctx, req.UserId)
+	if err != nil {
+		return err
+	}
+
+	res.Requests = make([]*order.PrivacyRequestInfo, 0, len(requests))
+	for _, request := range requests {
+		res.Requests = append(res.Requests, &order.PrivacyRequestInfo{
+			RequestId: request.RequestId,
+			UserId:    request.UserId,
+			Kind:      request.Kind,
+			Status:    request.Status,
+			Actor:     request.Actor,
+			Reason:    request.Reason,
+			Detail:    request.Detail,
+			Error:     request.Error,
+			CreatedAt: request.CreatedAt.Unix(),
+		})
+	}
+	return nil
+}
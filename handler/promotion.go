@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+func (o *Order) PreviewOrder(ctx context.Context, req *order.PreviewRequest, res *order.PreviewResponse) error {
+	defer observe()()
+
+	if req.OrderData == nil {
+		return microerrors.BadRequest(SERVICE, "preview order needs order data")
+	}
+	preview, err := o.Service.PreviewOrder(ctx, &models.Order{
+		UserId:   req.OrderData.UserId,
+		Currency: req.OrderData.Currency,
+		Items:    toOrderItems(req.OrderData.Items),
+	})
+	if err != nil {
+		return err
+	}
+
+	res.OrderData = toOrderInfo(preview)
+	for _, item := range preview.Items {
+		res.Subtotal += item.Subtotal()
+	}
+	return nil
+}
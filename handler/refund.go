@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+// toRefundInfo 把退款记录转换成proto 中的RefundInfo
+func toRefundInfo(refund *models.OrderRefund) *order.RefundInfo {
+	info := &order.RefundInfo{
+		RefundId:  refund.RefundId,
+		OrderId:   refund.OrderId,
+		Amount:    refund.Amount,
+		Reason:    refund.Reason,
+		Status:    refund.Status,
+		CreatedAt: refund.CreatedAt.Unix(),
+	}
+	for _, line := range refund.RefundLines() {
+		info.Lines = append(info.Lines, &order.RefundLine{
+			SKUId:  line.SKUId,
+			Count:  line.Count,
+			Amount: line.Amount,
+		})
+	}
+	return info
+}
+
+func (o *Order) RefundOrder(ctx context.Context, req *order.RefundRequest, res *order.RefundResponse) error {
+	defer observe()()
+
+	lines := make([]models.RefundLine, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		lines = append(lines, models.RefundLine{SKUId: line.SKUId, Count: line.Count})
+	}
+	refund := &models.OrderRefund{
+		RefundId: req.RefundId,
+		OrderId:  req.OrderId,
+		Reason:   req.Reason,
+	}
+	orderdata, err := o.Service.RefundOrder(auditContext(ctx, "refund: "+req.Reason), refund, lines)
+	if errors.Is(err, models.ErrRefundNotAllowed) || errors.Is(err, models.ErrRefundExceeded) {
+		return microerrors.BadRequest(SERVICE, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+
+	res.Refund = toRefundInfo(refund)
+	res.Status = order.OrderStatus(orderdata.Status)
+	res.OrderVersion = orderdata.OrderVersion
+	return nil
+}
+
+func (o *Order) ListRefunds(ctx context.Context, req *order.ListRefundsRequest, res *order.ListRefundsResponse) error {
+	defer observe()()
+
+	if err := o.authorizeOrder(ctx, req.OrderId); err != nil {
+		return err
+	}
+	refunds, err := o.Service.ListRefunds(ctx, req.OrderId)
+	if err != nil {
+		return err
+	}
+
+	res.Refunds = make([]*order.RefundInfo, 0, len(refunds))
+	for _, refund := range refunds {
+		res.Refunds = append(res.Refunds, toRefundInfo(refund))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+// defaultReportDays 没有指定开始时间时统计最近30天
+const defaultReportDays = 30
+
+// reportRange To 为0 时到现在，From 为0 时从 To 之前30天开始
+func reportRange(from, to int64) (time.Time, time.Time) {
+	end := time.Now()
+	if to > 0 {
+		end = time.Unix(to, 0)
+	}
+	start := end.AddDate(0, 0, -defaultReportDays)
+	if from > 0 {
+		start = time.Unix(from, 0)
+	}
+	return start, end
+}
+
+func reportError(err error) error {
+	if errors.Is(err, services.ErrReportRange) || errors.Is(err, services.ErrReportBucket) {
+		return microerrors.BadRequest(SERVICE, err.Error())
+	}
+	return err
+}
+
+var reportBuckets = map[order.ReportBucket]string{
+	order.ReportBucket_DAY:   services.BucketDay,
+	order.ReportBucket_WEEK:  services.BucketWeek,
+	order.ReportBucket_MONTH: services.BucketMonth,
+}
+
+// GetSalesSummary 只统计调用方所在租户的订单，没有租户的系统调用按租户分别返回
+func (o *Order) GetSalesSummary(ctx context.Context, req *order.SalesSummaryRequest, res *order.SalesSummaryResponse) error {
+	defer observe()()
+
+	if o.Reports == nil {
+		return microerrors.InternalServerError(SERVICE, "reporting is not enabled")
+	}
+	bucket, ok := reportBuckets[req.Bucket]
+	if !ok {
+		return microerrors.BadRequest(SERVICE, services.ErrReportBucket.Error())
+	}
+	from, to := reportRange(req.From, req.To)
+	buckets, err := o.Reports.GetSalesSummary(ctx, &services.SalesSummaryQuery{
+		From:     from,
+		To:       to,
+		Bucket:   bucket,
+		Currency: req.Currency,
+	})
+	if err != nil {
+		return reportError(err)
+	}
+
+	res.Buckets = make([]*order.SalesBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		info := &order.SalesBucket{
+			Start:             bucket.Start,
+			TenantId:          bucket.TenantId,
+			Currency:          bucket.Currency,
+			OrderCount:        bucket.OrderCount,
+			SalesCount:        bucket.SalesCount,
+			Revenue:           bucket.Revenue,
+			DiscountAmount:    bucket.DiscountAmount,
+			AverageOrderValue: bucket.AverageOrderValue,
+		}
+		for status := models.StatusUnpaid; status <= models.StatusDelivered; status++ {
+			if count, ok := bucket.StatusCounts[status]; ok {
+				info.StatusCounts = append(info.StatusCounts, &order.StatusFacet{Status: order.OrderStatus(status), Count: count})
+			}
+		}
+		res.Buckets = append(res.Buckets, info)
+	}
+	return nil
+}
+
+func (o *Order) GetTopProducts(ctx context.Context, req *order.TopProductsRequest, res *order.TopProductsResponse) error {
+	defer observe()()
+
+	if o.Reports == nil {
+		return microerrors.InternalServerError(SERVICE, "reporting is not enabled")
+	}
+	from, to := reportRange(req.From, req.To)
+	products, err := o.Reports.GetTopProducts(ctx, &services.TopProductsQuery{
+		From:     from,
+		To:       to,
+		Currency: req.Currency,
+		Limit:    int(req.Limit),
+	})
+	if err != nil {
+		return reportError(err)
+	}
+
+	res.Products = make([]*order.ProductSales, 0, len(products))
+	for _, product := range products {
+		res.Products = append(res.Products, &order.ProductSales{
+			TenantId:   product.TenantId,
+			Currency:   product.Currency,
+			SKUId:      product.SKUId,
+			Quantity:   product.Quantity,
+			Revenue:    product.Revenue,
+			OrderCount: product.OrderCount,
+		})
+	}
+	return nil
+}
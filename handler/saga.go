@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"gorm.io/gorm"
+)
+
+// PlaceOrder 同步执行下单流程，补偿完成也返回成功，由 SagaStatus 和 Error 说明失败原因
+func (o *Order) PlaceOrder(ctx context.Context, req *order.PlaceOrderRequest, res *order.PlaceOrderResponse) error {
+	defer observe()()
+
+	if o.Saga == nil {
+		return microerrors.InternalServerError(SERVICE, "place order saga is not configured")
+	}
+	if req.OrderData == nil || len(req.OrderData.Items) == 0 {
+		return microerrors.BadRequest(SERVICE, "place order needs at least one item")
+	}
+	if err := checkOwnerOf(ctx, req.OrderData.UserId); err != nil {
+		return err
+	}
+	orderdata := &models.Order{
+		OrderId:     req.OrderData.OrderId,
+		UserId:      req.OrderData.UserId,
+		OrderData:   req.OrderData.OrderData,
+		TotalAmount: req.OrderData.TotalAmount,
+		Currency:    req.OrderData.Currency,
+		Items:       toOrderItems(req.OrderData.Items),
+	}
+	saga, err := o.Saga.PlaceOrder(ctx, orderdata)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return microerrors.NotFound(SERVICE, "order %s not found", orderdata.OrderId)
+	case errors.Is(err, dao.ErrSagaLeased):
+		return microerrors.Conflict(SERVICE, "order %s is being placed, retry later", orderdata.OrderId)
+	case err != nil && !errors.Is(err, services.ErrSagaCompensated):
+		return err
+	}
+
+	res.OrderId = saga.OrderId
+	res.SagaStatus = saga.Status
+	res.ReservationId = saga.ReservationId
+	res.PaymentId = saga.PaymentId
+	if saga.Status == models.SagaCompensated {
+		res.Error = saga.LastError
+	}
+	return nil
+}
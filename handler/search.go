@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lenny-mo/order/auth"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+)
+
+// SearchOrders 普通用户只能搜索自己的订单；索引只返回订单号，订单从数据库或缓存中读出
+func (o *Order) SearchOrders(ctx context.Context, req *order.SearchRequest, res *order.SearchResponse) error {
+	defer observe()()
+
+	if o.Search == nil {
+		return microerrors.InternalServerError(SERVICE, "searching orders is not enabled")
+	}
+	query := &dao.OrderSearchQuery{
+		Text:   req.Text,
+		UserId: req.UserId,
+		SKUId:  req.SKUId,
+		Offset: int(req.Offset),
+		Limit:  int(req.Limit),
+	}
+	if identity, ok := auth.FromContext(ctx); ok && !identity.Privileged() {
+		// 用户ID 为0 会去掉用户条件，查出租户的全部订单
+		if identity.UserId == 0 {
+			return microerrors.Forbidden(SERVICE, "token has no user id")
+		}
+		if query.UserId != 0 && query.UserId != identity.UserId {
+			return microerrors.Forbidden(SERVICE, "users can only search their own orders")
+		}
+		query.UserId = identity.UserId
+	}
+	if req.Status != nil {
+		status := int8(*req.Status)
+		query.Status = &status
+	}
+	if req.CreatedFrom > 0 {
+		query.CreatedAfter = time.Unix(req.CreatedFrom, 0)
+	}
+	if req.CreatedTo > 0 {
+		query.CreatedBefore = time.Unix(req.CreatedTo, 0)
+	}
+	if query.Offset < 0 {
+		return microerrors.BadRequest(SERVICE, "offset must not be negative")
+	}
+
+	result, err := o.Search.Search(ctx, query)
+	if errors.Is(err, dao.ErrSearchNotSupported) {
+		return microerrors.BadRequest(SERVICE, err.Error())
+	}
+	if err != nil {
+		return err
+	}
+	orders, err := o.loadSearchResult(ctx, result.OrderIds)
+	if err != nil {
+		return err
+	}
+
+	res.Orders = make([]*order.OrderInfo, 0, len(orders))
+	for _, orderdata := range orders {
+		res.Orders = append(res.Orders, toOrderInfo(orderdata))
+	}
+	res.Total = result.Total
+	for status := models.StatusUnpaid; status <= models.StatusDelivered; status++ {
+		if count, ok := result.StatusCounts[status]; ok {
+			res.StatusFacets = append(res.StatusFacets, &order.StatusFacet{Status: order.OrderStatus(status), Count: count})
+		}
+	}
+	for _, day := range result.DayCounts {
+		res.DayFacets = append(res.DayFacets, &order.DayFacet{Day: day.Day, Count: day.Count})
+	}
+	return nil
+}
+
+// loadSearchResult 按索引返回的顺序读出订单，每次最多读 MaxBatchSize 个
+// 本地索引还没有同步到删除时订单已经读不到，直接跳过并从索引中移除
+func (o *Order) loadSearchResult(ctx context.Context, orderIds []string) ([]*models.Order, error) {
+	orders := []*models.Order{}
+	for start := 0; start < len(orderIds); start += services.MaxBatchSize {
+		end := start + services.MaxBatchSize
+		if end > len(orderIds) {
+			end = len(orderIds)
+		}
+		found, missing, err := o.Service.BatchGetOrders(ctx, orderIds[start:end])
+		if err != nil {
+			return nil, err
+		}
+		for _, orderId := range missing {
+			if err := o.Search.Remove(ctx, orderId); err != nil {
+				return nil, err
+			}
+		}
+		orders = append(orders, found...)
+	}
+	return orders, nil
+}
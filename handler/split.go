@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"gorm.io/gorm"
+)
+
+// splitError 把拆单和合单的错误转换成对应的错误码
+func splitError(err error) error {
+	switch {
+	case errors.Is(err, models.ErrInvalidSplit), errors.Is(err, models.ErrInvalidMerge), errors.Is(err, models.ErrInvalidTransition):
+		return microerrors.BadRequest(SERVICE, err.Error())
+	case errors.Is(err, dao.ErrVersionConflict):
+		return microerrors.Conflict(SERVICE, err.Error())
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return microerrors.NotFound(SERVICE, "order not found")
+	default:
+		return err
+	}
+}
+
+// SplitOrder 由履约服务按仓库拆单，返回的子订单包含订单项
+func (o *Order) SplitOrder(ctx context.Context, req *order.SplitOrderRequest, res *order.SplitOrderResponse) error {
+	defer observe()()
+
+	if req.OrderId == "" || len(req.SubOrders) < 2 {
+		return microerrors.BadRequest(SERVICE, "split order needs order id and at least two sub orders")
+	}
+	groups := make([][]models.SplitLine, 0, len(req.SubOrders))
+	for _, group := range req.SubOrders {
+		lines := make([]models.SplitLine, 0, len(group.Lines))
+		for _, line := range group.Lines {
+			lines = append(lines, models.SplitLine{SKUId: line.SKUId, Count: line.Count})
+		}
+		groups = append(groups, lines)
+	}
+	children, err := o.Service.SplitOrder(auditContext(ctx, "split: "+req.Reason), req.OrderId, req.Oldversion, groups)
+	if err != nil {
+		return splitError(err)
+	}
+
+	for _, child := range children {
+		res.SubOrders = append(res.SubOrders, toOrderInfo(child))
+	}
+	res.OrderVersion = req.Oldversion + 1
+	return nil
+}
+
+// MergeOrders 普通用户只能合并自己的订单，没有权限的订单按不存在处理
+func (o *Order) MergeOrders(ctx context.Context, req *order.MergeOrdersRequest, res *order.MergeOrdersResponse) error {
+	defer observe()()
+
+	if len(req.Orders) < 2 {
+		return microerrors.BadRequest(SERVICE, "merge orders needs at least two orders")
+	}
+	versions := map[string]int64{}
+	for _, ref := range req.Orders {
+		if ref.OrderId == "" {
+			return microerrors.BadRequest(SERVICE, "order id is required")
+		}
+		if _, ok := versions[ref.OrderId]; ok {
+			return microerrors.BadRequest(SERVICE, "order %s is listed twice", ref.OrderId)
+		}
+		if err := o.authorizeOrder(ctx, ref.OrderId); err != nil {
+			return err
+		}
+		versions[ref.OrderId] = ref.Version
+	}
+	merged, err := o.Service.MergeOrders(auditContext(ctx, "merge: "+req.Reason), versions)
+	if err != nil {
+		return splitError(err)
+	}
+
+	res.Order = toOrderInfo(merged)
+	return nil
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/lenny-mo/order/auth"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/services"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"github.com/micro/go-micro/v2/metadata"
+	"github.com/micro/go-micro/v2/server"
+)
+
+// TenantKey 调用方在metadata 中传入的租户
+const TenantKey = "Tenant"
+
+// NewTenantWrapper 从metadata 中取出租户放入context，DAO 按这个租户隔离全部读写
+// 启用认证时token 中的租户优先，和metadata 不一致时拒绝；都没有时使用默认租户，
+// 单租户部署不需要传租户，全部订单属于空租户
+func NewTenantWrapper(tenants *services.Tenants) server.HandlerWrapper {
+	return func(next server.HandlerFunc) server.HandlerFunc {
+		return func(ctx context.Context, req server.Request, rsp interface{}) error {
+			tenantId, _ := metadata.Get(ctx, TenantKey)
+			if identity, ok := auth.FromContext(ctx); ok && identity.TenantId != "" {
+				if tenantId != "" && tenantId != identity.TenantId {
+					return microerrors.Forbidden(SERVICE, "%s can not access tenant %s", identity.Subject, tenantId)
+				}
+				tenantId = identity.TenantId
+			}
+			if tenantId == "" && tenants != nil {
+				tenantId = tenants.Default
+			}
+			if !tenants.Known(tenantId) {
+				return microerrors.BadRequest(SERVICE, "unknown tenant %q", tenantId)
+			}
+			return next(dao.WithTenant(ctx, tenantId), req, rsp)
+		}
+	}
+}
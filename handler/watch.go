@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"gorm.io/gorm"
+)
+
+// WatchOrders 按订单监听时检查订单归属，按用户监听时只能监听自己
+// 流在客户端断开或服务退出时结束，客户端用最后收到的版本号重新连接
+func (o *Order) WatchOrders(ctx context.Context, req *order.WatchRequest, stream order.Order_WatchOrdersStream) error {
+	if o.Watcher == nil {
+		return microerrors.InternalServerError(SERVICE, "watching orders is not enabled")
+	}
+	if (req.OrderId == "") == (req.UserId == 0) {
+		return microerrors.BadRequest(SERVICE, services.ErrWatchTarget.Error())
+	}
+	if req.OrderId != "" {
+		if err := o.authorizeOrder(ctx, req.OrderId); err != nil {
+			return err
+		}
+	} else if err := checkOwnerOf(ctx, req.UserId); err != nil {
+		return err
+	}
+
+	query := &services.WatchQuery{
+		OrderId:      req.OrderId,
+		UserId:       req.UserId,
+		FromVersion:  req.FromVersion,
+		FromVersions: req.FromVersions,
+	}
+	err := o.Watcher.Watch(ctx, query, func(entry *models.OrderHistory) error {
+		return stream.Send(&order.OrderUpdate{
+			OrderId: entry.OrderId,
+			UserId:  entry.UserId,
+			Entry:   toHistoryEntry(entry),
+		})
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return microerrors.NotFound(SERVICE, "order %s not found", req.OrderId)
+	}
+	return err
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"strconv"
+	"net/http"
+	"time"
 
 	m "github.com/lenny-mo/emall-utils/metrics"
 	"github.com/lenny-mo/emall-utils/tracer"
+	"github.com/lenny-mo/order/auth"
 	"github.com/lenny-mo/order/conf"
 	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/encryption"
 	"github.com/lenny-mo/order/domain/models"
 	"github.com/lenny-mo/order/domain/services"
 	"github.com/lenny-mo/order/handler"
@@ -54,23 +58,65 @@ func main() {
 	mysqlConf := conf.GetMysqlFromConsul(consulCof, "mysql")
 
 	// 5. 初始化数据库连接
-	dsn := mysqlConf.User + ":" + mysqlConf.Password + "@tcp(" + mysqlConf.Host + ":" + strconv.FormatInt(mysqlConf.Port, 10) + ")/" + mysqlConf.DB + "?charset=utf8mb4&parseTime=True&loc=Local"
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(mysql.Open(mysqlConf.DSN()), &gorm.Config{})
 	if err != nil {
 		fmt.Println(err)
 		panic(err)
 	}
+	// 按context 中的租户隔离全部读写
+	if err := db.Use(dao.TenantPlugin{}); err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
 
-	// 禁止复表的存在, 如果没有表则创建
-	if !db.Migrator().HasTable(&models.Order{}) {
-		db.Migrator().CreateTable(&models.Order{})
+	// 开启加密时订单数据、地址和联系方式加密存储，必须在读写订单之前设置
+	encryptionConf := conf.GetEncryptionFromConsul(consulCof, "encryption")
+	if encryptionConf.Enabled {
+		kms, err := encryption.NewLocalKMS(encryptionConf.KeyFile)
+		if err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		keyring, err := encryption.NewKeyring(context.Background(), kms)
+		if err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		encryption.SetDefault(keyring)
+	}
+
+	// 禁止复表的存在, 如果没有表则创建，已有的表会补上新增的字段
+	if err := db.AutoMigrate(&models.Order{}, &models.OrderHistory{}, &models.OrderItem{}, &models.OrderRefund{}, &models.OrderPayment{}, &models.OrderFulfillment{}); err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+
+	// 6. 配置了分片库时使用分片DAO，配置了事件溯源时使用事件溯源DAO，否则使用单表DAO
+	orderDAO := dao.NewOrderDAO(db)
+	shardConf := conf.GetShardFromConsul(consulCof, "shard")
+	persistenceConf := conf.GetPersistenceFromConsul(consulCof, "persistence")
+	switch {
+	case persistenceConf.Mode == conf.PersistenceEvent:
+		eventDAO := dao.NewEventSourcedOrderDAO(db, persistenceConf.SnapshotEvery)
+		if err := eventDAO.(*dao.EventSourcedOrderDAO).Migrate(); err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		orderDAO = eventDAO
+	case len(shardConf.Databases) > 0:
+		layout, err := dao.OpenShardLayout(shardConf)
+		if err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		orderDAO = dao.NewShardedOrderDAO(layout)
 	}
 
 	// 设置prometheus
 	m.PrometheusBoot(9092)
 
 	// 创建服务
-	service := micro.NewService(
+	options := []micro.Option{
 		micro.Name(serviceName),
 		micro.Version("latest"),
 		micro.Address("127.0.0.1:8084"), // 服务监听地址
@@ -82,22 +128,230 @@ func main() {
 		micro.WrapHandler(ratelimit.NewHandlerWrapper(conf.QPS)),
 		// 添加prometheus
 		micro.WrapHandler(prometheus.NewHandlerWrapper()),
-	)
+	}
+	// 开启认证时校验metadata 中的JWT 和RPC 权限，放在最内层，被限流和拒绝的请求同样有监控
+	authConf := conf.GetAuthFromConsul(consulCof, "auth")
+	if authConf.Enabled {
+		verifier, policy, err := auth.NewFromConfig(authConf)
+		if err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		options = append(options, micro.WrapHandler(auth.NewHandlerWrapper(serviceName, verifier, policy)))
+	}
+	// 租户放在认证之后，token 中绑定的租户优先；配置错误时不启动
+	tenants, err := services.NewTenantsFromConfig(conf.GetTenantFromConsul(consulCof, "tenant"))
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	options = append(options, micro.WrapHandler(handler.NewTenantWrapper(tenants)))
+	service := micro.NewService(options...)
 
 	//
 	service.Init()
 
 	// 7. 创建service 和 handler 并且注册服务
-	orderDAO := dao.NewOrderDAO(db)
-	orderService := services.NewOrderService(*orderDAO.(*dao.OrderDAO))
+	// 开启库存预占时创建订单先预占库存，取消和超时释放，支付后确认
+	inventoryConf := conf.GetInventoryFromConsul(consulCof, "inventory")
+	inventoryClient := services.NewInventoryClient(service.Client(), inventoryConf.Service)
+	var orderInventory services.InventoryClient
+	if inventoryConf.Enabled {
+		orderInventory = inventoryClient
+	}
+	// 创建订单时按配置的促销活动计算优惠，配置错误时不启动
+	promotions, err := services.NewPromotionEngineFromConfig(conf.GetPromotionFromConsul(consulCof, "promotion"))
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	// 退款等领域事件通过broker 发布给支付服务
+	publisher := services.NewMicroPublisher(service.Client())
+	orderService := services.NewOrderService(orderDAO, publisher, orderInventory, promotions, tenants)
+	// WatchOrders 的监听者直接读数据库，订单提交新版本后唤醒本实例的监听者，并通过broker 唤醒其他实例的监听者
+	watchConf := conf.GetWatchFromConsul(consulCof, "watch")
+	orderWatcher := services.NewOrderWatcher(orderService, time.Duration(watchConf.PollSeconds)*time.Second, watchConf.MaxPending)
+	// SearchOrders 默认直接查订单表；使用本地bleve 索引时由订单变更事件同步，新建的索引先从数据库重建
+	searchConf := conf.GetSearchFromConsul(consulCof, "search")
+	var orderIndexer services.OrderIndexer
+	var indexSyncer *services.IndexSyncer
+	switch searchConf.Backend {
+	case "bleve":
+		bleveIndexer, created, err := services.NewBleveIndexer(searchConf.IndexPath)
+		if err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		orderIndexer = bleveIndexer
+		indexSyncer = services.NewIndexSyncer(orderService, bleveIndexer)
+		if created {
+			go func() {
+				indexed, err := indexSyncer.Rebuild(context.Background(), orderDAO)
+				fmt.Println("indexed orders:", indexed, err)
+			}()
+		}
+	default:
+		if orderIndexer, err = services.NewSQLIndexer(orderDAO); err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+	}
+	// GetOrder 走读穿缓存，配置了redis 时使用本地LRU+redis 两级缓存
+	// 本地LRU 只在本实例内失效，其他实例修改订单后通过 go.micro.topic.order.changed 失效
+	cacheConf := conf.GetCacheFromConsul(consulCof, "cache")
+	ttl := time.Duration(cacheConf.TTLSeconds) * time.Second
+	localCache := services.NewLRUCache(cacheConf.Capacity, ttl)
+	var orderCache services.OrderCache = localCache
+	if cacheConf.RedisAddr != "" {
+		redisClient := services.NewRedisClient(cacheConf.RedisAddr, cacheConf.RedisPassword, cacheConf.RedisDB)
+		orderCache = services.NewTieredCache(localCache, services.NewRedisCache(redisClient, ttl))
+	}
+	orderService = services.NewNotifyingOrderService(orderService, orderWatcher, publisher)
+	if err := micro.RegisterSubscriber(services.TopicOrderChanged, service.Server(), func(ctx context.Context, event *services.OrderChangedEvent) error {
+		localCache.Invalidate(event.OrderId, event.OrderVersion)
+		orderWatcher.Notify(event)
+		if indexSyncer != nil {
+			return indexSyncer.Sync(ctx, event)
+		}
+		return nil
+	}); err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	orderService = services.NewCachedOrderService(orderService, orderCache)
+	// 下单流程通过go-micro 调用库存服务和支付服务，流程状态保存在默认库中
+	sagaConf := conf.GetSagaFromConsul(consulCof, "saga")
+	sagaDAO := dao.NewSagaDAO(db)
+	if err := sagaDAO.(*dao.SagaDAO).Migrate(); err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	orderSaga := services.NewOrderSaga(sagaDAO, orderService,
+		inventoryClient,
+		services.NewPaymentClient(service.Client(), sagaConf.PaymentService),
+		services.RetryPolicy{
+			MaxAttempts: sagaConf.MaxAttempts,
+			Backoff:     time.Duration(sagaConf.BackoffMs) * time.Millisecond,
+		})
+	// 后台继续执行进程退出时没有结束的流程
+	if sagaConf.RecoverSeconds > 0 {
+		go func() {
+			idle := time.Duration(sagaConf.RecoverSeconds) * time.Second
+			for range time.Tick(idle) {
+				if _, err := orderSaga.Recover(context.Background(), idle, 100); err != nil {
+					fmt.Println(err)
+				}
+			}
+		}()
+	}
+
+	// 后台把旧主密钥加密的数据和明文用当前主密钥重新加密
+	if encryptionConf.Enabled && encryptionConf.Reencrypt {
+		go func() {
+			for _, reencryptor := range []interface{}{orderDAO, sagaDAO} {
+				if r, ok := reencryptor.(dao.Reencryptor); ok {
+					rewritten, err := r.Reencrypt(context.Background(), encryptionConf.ReencryptBatch)
+					fmt.Println("reencrypted rows:", rewritten, err)
+				}
+			}
+		}()
+	}
+
+	// 后台取消超时未支付的订单，需要显式开启：启用库存服务并配置了超时时间，或者租户配置了自己的超时时间
+	var cancelTimeout time.Duration
+	if inventoryConf.Enabled {
+		cancelTimeout = time.Duration(inventoryConf.AutoCancelSeconds) * time.Second
+	}
+	if cancelTimeout > 0 || len(tenants.AutoCancelTimeouts()) > 0 {
+		go func() {
+			timeout := cancelTimeout
+			for range time.Tick(time.Minute) {
+				if _, err := services.CancelExpiredOrdersByTenant(context.Background(), orderService, tenants, timeout, 100); err != nil {
+					fmt.Println(err)
+				}
+			}
+		}()
+	}
+
+	// 用户数据导出和删除的审计记录保存在默认库中
+	privacyDAO := dao.NewPrivacyDAO(db)
+	if err := privacyDAO.(*dao.PrivacyDAO).Migrate(); err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+
+	// 销售报表：后台从订单重新计算每天的汇总写入默认库，配置了从库时读订单和汇总都走从库
+	reportConf := conf.GetReportFromConsul(consulCof, "report")
+	replicaDB, reportOrderDAO := db, orderDAO
+	if reportConf.Replica.Host != "" {
+		if replicaDB, err = gorm.Open(mysql.Open(reportConf.Replica.DSN()), &gorm.Config{}); err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		if err := replicaDB.Use(dao.TenantPlugin{}); err != nil {
+			fmt.Println(err)
+			panic(err)
+		}
+		// 分片库没有配置从库，仍然读分片的主库
+		switch {
+		case persistenceConf.Mode == conf.PersistenceEvent:
+			reportOrderDAO = dao.NewEventSourcedOrderDAO(replicaDB, persistenceConf.SnapshotEvery)
+		case len(shardConf.Databases) == 0:
+			reportOrderDAO = dao.NewOrderDAO(replicaDB)
+		}
+	}
+	reportDAO := dao.NewReportDAO(db, replicaDB)
+	if err := reportDAO.(*dao.ReportDAO).Migrate(); err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	reports, err := services.NewReportService(reportDAO, reportOrderDAO)
+	if err != nil {
+		fmt.Println(err)
+		panic(err)
+	}
+	if reportConf.RefreshSeconds > 0 {
+		go func() {
+			if err := reports.Refresh(context.Background(), reportConf.BackfillDays); err != nil {
+				fmt.Println(err)
+			}
+			for range time.Tick(time.Duration(reportConf.RefreshSeconds) * time.Second) {
+				if err := reports.Refresh(context.Background(), reportConf.RefreshDays); err != nil {
+					fmt.Println(err)
+				}
+			}
+		}()
+	}
+
+	paymentConf := conf.GetPaymentFromConsul(consulCof, "payment")
+	orderHandler := &handler.Order{
+		Service:       orderService,
+		Saga:          orderSaga,
+		PaymentSecret: []byte(paymentConf.Secret),
+		Privacy:       services.NewPrivacyService(orderService, privacyDAO, sagaDAO),
+		Watcher:       orderWatcher,
+		Search:        orderIndexer,
+		Reports:       reports,
+		// 启用认证后以token 中的角色为准，这个配置不生效
+		TrustMetadataRole: authConf.TrustMetadataRole,
+	}
 	// 使用proto文件夹下的registry handler 方法注册
-	err = order.RegisterOrderHandler(service.Server(), &handler.Order{
-		Service: *orderService.(*services.OrderService),
-	})
+	err = order.RegisterOrderHandler(service.Server(), orderHandler)
 	if err != nil {
 		panic(err)
 	}
 
+	// 支付回调的HTTP 服务
+	if paymentConf.WebhookAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/payment/webhook", orderHandler.PaymentWebhook)
+		go func() {
+			if err := http.ListenAndServe(paymentConf.WebhookAddr, mux); err != nil {
+				fmt.Println(err)
+			}
+		}()
+	}
+
 	// 8. 启动service
 	if err = service.Run(); err != nil {
 		fmt.Println(err)
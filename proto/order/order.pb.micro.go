@@ -48,7 +48,54 @@ type OrderService interface {
 	// 更新操作涉及到乐观锁做并发控制，所以需要传入版本号
 	UpdateOrder(ctx context.Context, in *UpdateRequest, opts ...client.CallOption) (*UpdateResponse, error)
 	// 用户在创建订单的时候需要先调用此方法生成订单号
-	GenerateUUID(ctx context.Context, in *Empty, opts ...client.CallOption) (*GenerateUUIDResponse, error)
+	// 分片部署时订单号会携带用户所在的分片槽位，因此需要传入UserId
+	GenerateUUID(ctx context.Context, in *GenerateUUIDRequest, opts ...client.CallOption) (*GenerateUUIDResponse, error)
+	// 订单的全部版本记录，用于排查谁在什么时候修改了订单
+	GetOrderHistory(ctx context.Context, in *GetHistoryRequest, opts ...client.CallOption) (*GetHistoryResponse, error)
+	// 订单列表，默认不包含已删除的订单
+	ListOrders(ctx context.Context, in *ListRequest, opts ...client.CallOption) (*ListResponse, error)
+	// 软删除订单，需要传入当前版本号
+	DeleteOrder(ctx context.Context, in *DeleteRequest, opts ...client.CallOption) (*DeleteResponse, error)
+	// 恢复软删除的订单
+	RestoreOrder(ctx context.Context, in *RestoreRequest, opts ...client.CallOption) (*RestoreResponse, error)
+	// 物理删除订单和订单项，只允许管理员调用
+	PurgeOrder(ctx context.Context, in *PurgeRequest, opts ...client.CallOption) (*PurgeResponse, error)
+	// 退款，不传订单项时退还剩余的全部金额，否则按订单项部分退款
+	RefundOrder(ctx context.Context, in *RefundRequest, opts ...client.CallOption) (*RefundResponse, error)
+	// 订单的全部退款记录
+	ListRefunds(ctx context.Context, in *ListRefundsRequest, opts ...client.CallOption) (*ListRefundsResponse, error)
+	// 支付服务确认支付，需要携带HMAC 签名，同一个PaymentId 重复调用只生效一次
+	ConfirmPayment(ctx context.Context, in *ConfirmPaymentRequest, opts ...client.CallOption) (*ConfirmPaymentResponse, error)
+	// 下单流程：预占库存、创建订单、发起支付，失败时自动补偿
+	PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...client.CallOption) (*PlaceOrderResponse, error)
+	// 按当前的促销活动计算订单的优惠和应付金额，不落库
+	PreviewOrder(ctx context.Context, in *PreviewRequest, opts ...client.CallOption) (*PreviewResponse, error)
+	// 发货，只有已支付或部分退款的订单可以发货
+	ShipOrder(ctx context.Context, in *ShipRequest, opts ...client.CallOption) (*ShipResponse, error)
+	// 签收，只有已发货的订单可以签收
+	MarkDelivered(ctx context.Context, in *DeliverRequest, opts ...client.CallOption) (*DeliverResponse, error)
+	// 导出用户的全部订单数据，只允许管理员调用
+	ExportUserData(ctx context.Context, in *ExportUserDataRequest, opts ...client.CallOption) (*ExportUserDataResponse, error)
+	// 删除用户数据，需要保留做账的订单只清除个人信息，只允许管理员调用
+	EraseUserData(ctx context.Context, in *EraseUserDataRequest, opts ...client.CallOption) (*EraseUserDataResponse, error)
+	// 用户数据导出和删除的审计记录，只允许管理员调用
+	ListPrivacyRequests(ctx context.Context, in *ListPrivacyRequestsRequest, opts ...client.CallOption) (*ListPrivacyRequestsResponse, error)
+	// 一次查询多个订单，找到的订单和不存在的订单号分开返回
+	BatchGetOrders(ctx context.Context, in *BatchGetRequest, opts ...client.CallOption) (*BatchGetResponse, error)
+	// 按版本号批量修改订单状态，每个订单单独成功或失败，不会因为一个订单失败而整批失败
+	BatchUpdateStatus(ctx context.Context, in *BatchUpdateStatusRequest, opts ...client.CallOption) (*BatchUpdateStatusResponse, error)
+	// 按订单或用户监听订单的变化，每个新版本提交后推送一次，断线重连时从最后收到的版本继续
+	WatchOrders(ctx context.Context, in *WatchRequest, opts ...client.CallOption) (Order_WatchOrdersService, error)
+	// 按订单号片段、用户、商品、状态和创建时间搜索订单，同时返回按状态和按天的订单数
+	SearchOrders(ctx context.Context, in *SearchRequest, opts ...client.CallOption) (*SearchResponse, error)
+	// 按时间段、租户和币种汇总订单数和销售额，读每天的汇总表
+	GetSalesSummary(ctx context.Context, in *SalesSummaryRequest, opts ...client.CallOption) (*SalesSummaryResponse, error)
+	// 时间范围内销售额最高的商品
+	GetTopProducts(ctx context.Context, in *TopProductsRequest, opts ...client.CallOption) (*TopProductsResponse, error)
+	// 把已支付的订单按商品拆成子订单，子订单各自发货和退款，原订单的状态由子订单汇总
+	SplitOrder(ctx context.Context, in *SplitOrderRequest, opts ...client.CallOption) (*SplitOrderResponse, error)
+	// 把同一个用户的多个未支付订单合并成一个新订单并重新计算金额，被合并的订单取消
+	MergeOrders(ctx context.Context, in *MergeOrdersRequest, opts ...client.CallOption) (*MergeOrdersResponse, error)
 }
 
 type orderService struct {
@@ -93,7 +140,7 @@ func (c *orderService) UpdateOrder(ctx context.Context, in *UpdateRequest, opts
 	return out, nil
 }
 
-func (c *orderService) GenerateUUID(ctx context.Context, in *Empty, opts ...client.CallOption) (*GenerateUUIDResponse, error) {
+func (c *orderService) GenerateUUID(ctx context.Context, in *GenerateUUIDRequest, opts ...client.CallOption) (*GenerateUUIDResponse, error) {
 	req := c.c.NewRequest(c.name, "Order.GenerateUUID", in)
 	out := new(GenerateUUIDResponse)
 	err := c.c.Call(ctx, req, out, opts...)
@@ -103,6 +150,275 @@ func (c *orderService) GenerateUUID(ctx context.Context, in *Empty, opts ...clie
 	return out, nil
 }
 
+func (c *orderService) GetOrderHistory(ctx context.Context, in *GetHistoryRequest, opts ...client.CallOption) (*GetHistoryResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.GetOrderHistory", in)
+	out := new(GetHistoryResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) ListOrders(ctx context.Context, in *ListRequest, opts ...client.CallOption) (*ListResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.ListOrders", in)
+	out := new(ListResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) DeleteOrder(ctx context.Context, in *DeleteRequest, opts ...client.CallOption) (*DeleteResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.DeleteOrder", in)
+	out := new(DeleteResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) RestoreOrder(ctx context.Context, in *RestoreRequest, opts ...client.CallOption) (*RestoreResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.RestoreOrder", in)
+	out := new(RestoreResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) PurgeOrder(ctx context.Context, in *PurgeRequest, opts ...client.CallOption) (*PurgeResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.PurgeOrder", in)
+	out := new(PurgeResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) RefundOrder(ctx context.Context, in *RefundRequest, opts ...client.CallOption) (*RefundResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.RefundOrder", in)
+	out := new(RefundResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) ListRefunds(ctx context.Context, in *ListRefundsRequest, opts ...client.CallOption) (*ListRefundsResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.ListRefunds", in)
+	out := new(ListRefundsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) ConfirmPayment(ctx context.Context, in *ConfirmPaymentRequest, opts ...client.CallOption) (*ConfirmPaymentResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.ConfirmPayment", in)
+	out := new(ConfirmPaymentResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) PlaceOrder(ctx context.Context, in *PlaceOrderRequest, opts ...client.CallOption) (*PlaceOrderResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.PlaceOrder", in)
+	out := new(PlaceOrderResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) PreviewOrder(ctx context.Context, in *PreviewRequest, opts ...client.CallOption) (*PreviewResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.PreviewOrder", in)
+	out := new(PreviewResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) ShipOrder(ctx context.Context, in *ShipRequest, opts ...client.CallOption) (*ShipResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.ShipOrder", in)
+	out := new(ShipResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) MarkDelivered(ctx context.Context, in *DeliverRequest, opts ...client.CallOption) (*DeliverResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.MarkDelivered", in)
+	out := new(DeliverResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) ExportUserData(ctx context.Context, in *ExportUserDataRequest, opts ...client.CallOption) (*ExportUserDataResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.ExportUserData", in)
+	out := new(ExportUserDataResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) EraseUserData(ctx context.Context, in *EraseUserDataRequest, opts ...client.CallOption) (*EraseUserDataResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.EraseUserData", in)
+	out := new(EraseUserDataResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) ListPrivacyRequests(ctx context.Context, in *ListPrivacyRequestsRequest, opts ...client.CallOption) (*ListPrivacyRequestsResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.ListPrivacyRequests", in)
+	out := new(ListPrivacyRequestsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) BatchGetOrders(ctx context.Context, in *BatchGetRequest, opts ...client.CallOption) (*BatchGetResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.BatchGetOrders", in)
+	out := new(BatchGetResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) BatchUpdateStatus(ctx context.Context, in *BatchUpdateStatusRequest, opts ...client.CallOption) (*BatchUpdateStatusResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.BatchUpdateStatus", in)
+	out := new(BatchUpdateStatusResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) WatchOrders(ctx context.Context, in *WatchRequest, opts ...client.CallOption) (Order_WatchOrdersService, error) {
+	req := c.c.NewRequest(c.name, "Order.WatchOrders", &WatchRequest{})
+	stream, err := c.c.Stream(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.Send(in); err != nil {
+		return nil, err
+	}
+	return &orderServiceWatchOrders{stream}, nil
+}
+
+type Order_WatchOrdersService interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Recv() (*OrderUpdate, error)
+}
+
+type orderServiceWatchOrders struct {
+	stream client.Stream
+}
+
+func (x *orderServiceWatchOrders) Close() error {
+	return x.stream.Close()
+}
+
+func (x *orderServiceWatchOrders) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *orderServiceWatchOrders) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *orderServiceWatchOrders) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *orderServiceWatchOrders) Recv() (*OrderUpdate, error) {
+	m := new(OrderUpdate)
+	err := x.stream.Recv(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *orderService) SearchOrders(ctx context.Context, in *SearchRequest, opts ...client.CallOption) (*SearchResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.SearchOrders", in)
+	out := new(SearchResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) GetSalesSummary(ctx context.Context, in *SalesSummaryRequest, opts ...client.CallOption) (*SalesSummaryResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.GetSalesSummary", in)
+	out := new(SalesSummaryResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) GetTopProducts(ctx context.Context, in *TopProductsRequest, opts ...client.CallOption) (*TopProductsResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.GetTopProducts", in)
+	out := new(TopProductsResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) SplitOrder(ctx context.Context, in *SplitOrderRequest, opts ...client.CallOption) (*SplitOrderResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.SplitOrder", in)
+	out := new(SplitOrderResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *orderService) MergeOrders(ctx context.Context, in *MergeOrdersRequest, opts ...client.CallOption) (*MergeOrdersResponse, error) {
+	req := c.c.NewRequest(c.name, "Order.MergeOrders", in)
+	out := new(MergeOrdersResponse)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Order service
 
 type OrderHandler interface {
@@ -112,7 +428,54 @@ type OrderHandler interface {
 	// 更新操作涉及到乐观锁做并发控制，所以需要传入版本号
 	UpdateOrder(context.Context, *UpdateRequest, *UpdateResponse) error
 	// 用户在创建订单的时候需要先调用此方法生成订单号
-	GenerateUUID(context.Context, *Empty, *GenerateUUIDResponse) error
+	// 分片部署时订单号会携带用户所在的分片槽位，因此需要传入UserId
+	GenerateUUID(context.Context, *GenerateUUIDRequest, *GenerateUUIDResponse) error
+	// 订单的全部版本记录，用于排查谁在什么时候修改了订单
+	GetOrderHistory(context.Context, *GetHistoryRequest, *GetHistoryResponse) error
+	// 订单列表，默认不包含已删除的订单
+	ListOrders(context.Context, *ListRequest, *ListResponse) error
+	// 软删除订单，需要传入当前版本号
+	DeleteOrder(context.Context, *DeleteRequest, *DeleteResponse) error
+	// 恢复软删除的订单
+	RestoreOrder(context.Context, *RestoreRequest, *RestoreResponse) error
+	// 物理删除订单和订单项，只允许管理员调用
+	PurgeOrder(context.Context, *PurgeRequest, *PurgeResponse) error
+	// 退款，不传订单项时退还剩余的全部金额，否则按订单项部分退款
+	RefundOrder(context.Context, *RefundRequest, *RefundResponse) error
+	// 订单的全部退款记录
+	ListRefunds(context.Context, *ListRefundsRequest, *ListRefundsResponse) error
+	// 支付服务确认支付，需要携带HMAC 签名，同一个PaymentId 重复调用只生效一次
+	ConfirmPayment(context.Context, *ConfirmPaymentRequest, *ConfirmPaymentResponse) error
+	// 下单流程：预占库存、创建订单、发起支付，失败时自动补偿
+	PlaceOrder(context.Context, *PlaceOrderRequest, *PlaceOrderResponse) error
+	// 按当前的促销活动计算订单的优惠和应付金额，不落库
+	PreviewOrder(context.Context, *PreviewRequest, *PreviewResponse) error
+	// 发货，只有已支付或部分退款的订单可以发货
+	ShipOrder(context.Context, *ShipRequest, *ShipResponse) error
+	// 签收，只有已发货的订单可以签收
+	MarkDelivered(context.Context, *DeliverRequest, *DeliverResponse) error
+	// 导出用户的全部订单数据，只允许管理员调用
+	ExportUserData(context.Context, *ExportUserDataRequest, *ExportUserDataResponse) error
+	// 删除用户数据，需要保留做账的订单只清除个人信息，只允许管理员调用
+	EraseUserData(context.Context, *EraseUserDataRequest, *EraseUserDataResponse) error
+	// 用户数据导出和删除的审计记录，只允许管理员调用
+	ListPrivacyRequests(context.Context, *ListPrivacyRequestsRequest, *ListPrivacyRequestsResponse) error
+	// 一次查询多个订单，找到的订单和不存在的订单号分开返回
+	BatchGetOrders(context.Context, *BatchGetRequest, *BatchGetResponse) error
+	// 按版本号批量修改订单状态，每个订单单独成功或失败，不会因为一个订单失败而整批失败
+	BatchUpdateStatus(context.Context, *BatchUpdateStatusRequest, *BatchUpdateStatusResponse) error
+	// 按订单或用户监听订单的变化，每个新版本提交后推送一次，断线重连时从最后收到的版本继续
+	WatchOrders(context.Context, *WatchRequest, Order_WatchOrdersStream) error
+	// 按订单号片段、用户、商品、状态和创建时间搜索订单，同时返回按状态和按天的订单数
+	SearchOrders(context.Context, *SearchRequest, *SearchResponse) error
+	// 按时间段、租户和币种汇总订单数和销售额，读每天的汇总表
+	GetSalesSummary(context.Context, *SalesSummaryRequest, *SalesSummaryResponse) error
+	// 时间范围内销售额最高的商品
+	GetTopProducts(context.Context, *TopProductsRequest, *TopProductsResponse) error
+	// 把已支付的订单按商品拆成子订单，子订单各自发货和退款，原订单的状态由子订单汇总
+	SplitOrder(context.Context, *SplitOrderRequest, *SplitOrderResponse) error
+	// 把同一个用户的多个未支付订单合并成一个新订单并重新计算金额，被合并的订单取消
+	MergeOrders(context.Context, *MergeOrdersRequest, *MergeOrdersResponse) error
 }
 
 func RegisterOrderHandler(s server.Server, hdlr OrderHandler, opts ...server.HandlerOption) error {
@@ -120,7 +483,30 @@ func RegisterOrderHandler(s server.Server, hdlr OrderHandler, opts ...server.Han
 		InsertOrder(ctx context.Context, in *InserRequest, out *InserResponse) error
 		GetOrder(ctx context.Context, in *GetRequest, out *GetResponse) error
 		UpdateOrder(ctx context.Context, in *UpdateRequest, out *UpdateResponse) error
-		GenerateUUID(ctx context.Context, in *Empty, out *GenerateUUIDResponse) error
+		GenerateUUID(ctx context.Context, in *GenerateUUIDRequest, out *GenerateUUIDResponse) error
+		GetOrderHistory(ctx context.Context, in *GetHistoryRequest, out *GetHistoryResponse) error
+		ListOrders(ctx context.Context, in *ListRequest, out *ListResponse) error
+		DeleteOrder(ctx context.Context, in *DeleteRequest, out *DeleteResponse) error
+		RestoreOrder(ctx context.Context, in *RestoreRequest, out *RestoreResponse) error
+		PurgeOrder(ctx context.Context, in *PurgeRequest, out *PurgeResponse) error
+		RefundOrder(ctx context.Context, in *RefundRequest, out *RefundResponse) error
+		ListRefunds(ctx context.Context, in *ListRefundsRequest, out *ListRefundsResponse) error
+		ConfirmPayment(ctx context.Context, in *ConfirmPaymentRequest, out *ConfirmPaymentResponse) error
+		PlaceOrder(ctx context.Context, in *PlaceOrderRequest, out *PlaceOrderResponse) error
+		PreviewOrder(ctx context.Context, in *PreviewRequest, out *PreviewResponse) error
+		ShipOrder(ctx context.Context, in *ShipRequest, out *ShipResponse) error
+		MarkDelivered(ctx context.Context, in *DeliverRequest, out *DeliverResponse) error
+		ExportUserData(ctx context.Context, in *ExportUserDataRequest, out *ExportUserDataResponse) error
+		EraseUserData(ctx context.Context, in *EraseUserDataRequest, out *EraseUserDataResponse) error
+		ListPrivacyRequests(ctx context.Context, in *ListPrivacyRequestsRequest, out *ListPrivacyRequestsResponse) error
+		BatchGetOrders(ctx context.Context, in *BatchGetRequest, out *BatchGetResponse) error
+		BatchUpdateStatus(ctx context.Context, in *BatchUpdateStatusRequest, out *BatchUpdateStatusResponse) error
+		WatchOrders(ctx context.Context, stream server.Stream) error
+		SearchOrders(ctx context.Context, in *SearchRequest, out *SearchResponse) error
+		GetSalesSummary(ctx context.Context, in *SalesSummaryRequest, out *SalesSummaryResponse) error
+		GetTopProducts(ctx context.Context, in *TopProductsRequest, out *TopProductsResponse) error
+		SplitOrder(ctx context.Context, in *SplitOrderRequest, out *SplitOrderResponse) error
+		MergeOrders(ctx context.Context, in *MergeOrdersRequest, out *MergeOrdersResponse) error
 	}
 	type Order struct {
 		order
@@ -145,6 +531,134 @@ func (h *orderHandler) UpdateOrder(ctx context.Context, in *UpdateRequest, out *
 	return h.OrderHandler.UpdateOrder(ctx, in, out)
 }
 
-func (h *orderHandler) GenerateUUID(ctx context.Context, in *Empty, out *GenerateUUIDResponse) error {
+func (h *orderHandler) GenerateUUID(ctx context.Context, in *GenerateUUIDRequest, out *GenerateUUIDResponse) error {
 	return h.OrderHandler.GenerateUUID(ctx, in, out)
 }
+
+func (h *orderHandler) GetOrderHistory(ctx context.Context, in *GetHistoryRequest, out *GetHistoryResponse) error {
+	return h.OrderHandler.GetOrderHistory(ctx, in, out)
+}
+
+func (h *orderHandler) ListOrders(ctx context.Context, in *ListRequest, out *ListResponse) error {
+	return h.OrderHandler.ListOrders(ctx, in, out)
+}
+
+func (h *orderHandler) DeleteOrder(ctx context.Context, in *DeleteRequest, out *DeleteResponse) error {
+	return h.OrderHandler.DeleteOrder(ctx, in, out)
+}
+
+func (h *orderHandler) RestoreOrder(ctx context.Context, in *RestoreRequest, out *RestoreResponse) error {
+	return h.OrderHandler.RestoreOrder(ctx, in, out)
+}
+
+func (h *orderHandler) PurgeOrder(ctx context.Context, in *PurgeRequest, out *PurgeResponse) error {
+	return h.OrderHandler.PurgeOrder(ctx, in, out)
+}
+
+func (h *orderHandler) RefundOrder(ctx context.Context, in *RefundRequest, out *RefundResponse) error {
+	return h.OrderHandler.RefundOrder(ctx, in, out)
+}
+
+func (h *orderHandler) ListRefunds(ctx context.Context, in *ListRefundsRequest, out *ListRefundsResponse) error {
+	return h.OrderHandler.ListRefunds(ctx, in, out)
+}
+
+func (h *orderHandler) ConfirmPayment(ctx context.Context, in *ConfirmPaymentRequest, out *ConfirmPaymentResponse) error {
+	return h.OrderHandler.ConfirmPayment(ctx, in, out)
+}
+
+func (h *orderHandler) PlaceOrder(ctx context.Context, in *PlaceOrderRequest, out *PlaceOrderResponse) error {
+	return h.OrderHandler.PlaceOrder(ctx, in, out)
+}
+
+func (h *orderHandler) PreviewOrder(ctx context.Context, in *PreviewRequest, out *PreviewResponse) error {
+	return h.OrderHandler.PreviewOrder(ctx, in, out)
+}
+
+func (h *orderHandler) ShipOrder(ctx context.Context, in *ShipRequest, out *ShipResponse) error {
+	return h.OrderHandler.ShipOrder(ctx, in, out)
+}
+
+func (h *orderHandler) MarkDelivered(ctx context.Context, in *DeliverRequest, out *DeliverResponse) error {
+	return h.OrderHandler.MarkDelivered(ctx, in, out)
+}
+
+func (h *orderHandler) ExportUserData(ctx context.Context, in *ExportUserDataRequest, out *ExportUserDataResponse) error {
+	return h.OrderHandler.ExportUserData(ctx, in, out)
+}
+
+func (h *orderHandler) EraseUserData(ctx context.Context, in *EraseUserDataRequest, out *EraseUserDataResponse) error {
+	return h.OrderHandler.EraseUserData(ctx, in, out)
+}
+
+func (h *orderHandler) ListPrivacyRequests(ctx context.Context, in *ListPrivacyRequestsRequest, out *ListPrivacyRequestsResponse) error {
+	return h.OrderHandler.ListPrivacyRequests(ctx, in, out)
+}
+
+func (h *orderHandler) BatchGetOrders(ctx context.Context, in *BatchGetRequest, out *BatchGetResponse) error {
+	return h.OrderHandler.BatchGetOrders(ctx, in, out)
+}
+
+func (h *orderHandler) BatchUpdateStatus(ctx context.Context, in *BatchUpdateStatusRequest, out *BatchUpdateStatusResponse) error {
+	return h.OrderHandler.BatchUpdateStatus(ctx, in, out)
+}
+
+func (h *orderHandler) WatchOrders(ctx context.Context, stream server.Stream) error {
+	m := new(WatchRequest)
+	if err := stream.Recv(m); err != nil {
+		return err
+	}
+	return h.OrderHandler.WatchOrders(ctx, m, &orderWatchOrdersStream{stream})
+}
+
+type Order_WatchOrdersStream interface {
+	Context() context.Context
+	SendMsg(interface{}) error
+	RecvMsg(interface{}) error
+	Close() error
+	Send(*OrderUpdate) error
+}
+
+type orderWatchOrdersStream struct {
+	stream server.Stream
+}
+
+func (x *orderWatchOrdersStream) Close() error {
+	return x.stream.Close()
+}
+
+func (x *orderWatchOrdersStream) Context() context.Context {
+	return x.stream.Context()
+}
+
+func (x *orderWatchOrdersStream) SendMsg(m interface{}) error {
+	return x.stream.Send(m)
+}
+
+func (x *orderWatchOrdersStream) RecvMsg(m interface{}) error {
+	return x.stream.Recv(m)
+}
+
+func (x *orderWatchOrdersStream) Send(m *OrderUpdate) error {
+	return x.stream.Send(m)
+}
+
+func (h *orderHandler) SearchOrders(ctx context.Context, in *SearchRequest, out *SearchResponse) error {
+	return h.OrderHandler.SearchOrders(ctx, in, out)
+}
+
+func (h *orderHandler) GetSalesSummary(ctx context.Context, in *SalesSummaryRequest, out *SalesSummaryResponse) error {
+	return h.OrderHandler.GetSalesSummary(ctx, in, out)
+}
+
+func (h *orderHandler) GetTopProducts(ctx context.Context, in *TopProductsRequest, out *TopProductsResponse) error {
+	return h.OrderHandler.GetTopProducts(ctx, in, out)
+}
+
+func (h *orderHandler) SplitOrder(ctx context.Context, in *SplitOrderRequest, out *SplitOrderResponse) error {
+	return h.OrderHandler.SplitOrder(ctx, in, out)
+}
+
+func (h *orderHandler) MergeOrders(ctx context.Context, in *MergeOrdersRequest, out *MergeOrdersResponse) error {
+	return h.OrderHandler.MergeOrders(ctx, in, out)
+}
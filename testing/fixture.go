@@ -0,0 +1,258 @@
+// Package testing 在进程内启动订单服务，用于端到端测试：go-micro 使用内存的注册中心、传输和broker，
+// 数据库是SQLite 内存库，库存和支付是进程内的实现。其他服务的集成测试也可以引入这个包启动订单服务
+package testing
+
+import (
+	"context"
+	"time"
+
+	"github.com/lenny-mo/order/auth"
+	"github.com/lenny-mo/order/client"
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/domain/services"
+	"github.com/lenny-mo/order/handler"
+	"github.com/lenny-mo/order/proto/order"
+	"github.com/micro/go-micro/v2"
+	brokermemory "github.com/micro/go-micro/v2/broker/memory"
+	microclient "github.com/micro/go-micro/v2/client"
+	"github.com/micro/go-micro/v2/client/selector"
+	"github.com/micro/go-micro/v2/metadata"
+	registrymemory "github.com/micro/go-micro/v2/registry/memory"
+	"github.com/micro/go-micro/v2/server"
+	transportmemory "github.com/micro/go-micro/v2/transport/memory"
+	"gorm.io/gorm"
+)
+
+// Options 测试服务的配置
+type Options struct {
+	// Persistence conf.PersistenceState 或 conf.PersistenceEvent，默认直接读写订单表
+	Persistence   string
+	SnapshotEvery int
+	// Stock 每个sku 的初始库存，为空时创建订单不预占库存，下单流程预占会失败
+	Stock map[int64]int32
+	// Tenants 为空时不区分租户
+	Tenants *conf.TenantConfig
+	// Promotions 为空时不计算优惠
+	Promotions *services.PromotionEngine
+	// PaymentSecret 校验支付确认签名的HMAC 密钥
+	PaymentSecret []byte
+	// Wrappers 额外的handler wrapper，放在租户之前，比如认证
+	Wrappers []server.HandlerWrapper
+	// ClientOptions 创建 Fixture.Client 的选项
+	ClientOptions []client.Option
+	// TrustMetadataRole 没有启用认证时按metadata 中的 Role 判断管理员，见 AsAdmin
+	TrustMetadataRole bool
+}
+
+type Option func(*Options)
+
+// WithEventSourcing 使用事件溯源DAO，每 snapshotEvery 个事件保存一次快照
+func WithEventSourcing(snapshotEvery int) Option {
+	return func(o *Options) {
+		o.Persistence = conf.PersistenceEvent
+		o.SnapshotEvery = snapshotEvery
+	}
+}
+
+// WithPersistence 按 conf.PersistenceConfig 中的模式创建DAO，用于同一组测试跑两种模式
+func WithPersistence(mode string) Option {
+	return func(o *Options) {
+		o.Persistence = mode
+	}
+}
+
+// WithStock 开启库存预占，stock 是每个sku 的初始库存
+func WithStock(stock map[int64]int32) Option {
+	return func(o *Options) {
+		o.Stock = stock
+	}
+}
+
+func WithTenants(tenants *conf.TenantConfig) Option {
+	return func(o *Options) {
+		o.Tenants = tenants
+	}
+}
+
+func WithPromotions(promotions *services.PromotionEngine) Option {
+	return func(o *Options) {
+		o.Promotions = promotions
+	}
+}
+
+func WithPaymentSecret(secret []byte) Option {
+	return func(o *Options) {
+		o.PaymentSecret = secret
+	}
+}
+
+// WithAuth 校验metadata 中的JWT 和RPC 权限，调用时用 auth.AuthorizationKey 传入token
+func WithAuth(verifier *auth.Verifier, policy auth.Policy) Option {
+	return func(o *Options) {
+		o.Wrappers = append(o.Wrappers, auth.NewHandlerWrapper(client.ServiceName, verifier, policy))
+	}
+}
+
+// WithMetadataRole 没有启用认证时信任metadata 中的角色，AsAdmin 传入的管理员角色才会生效
+func WithMetadataRole() Option {
+	return func(o *Options) {
+		o.TrustMetadataRole = true
+	}
+}
+
+func WithClientOptions(opts ...client.Option) Option {
+	return func(o *Options) {
+		o.ClientOptions = append(o.ClientOptions, opts...)
+	}
+}
+
+// Fixture 进程内的订单服务，Client 和 RPC 通过内存传输调用，其余字段用于准备数据和检查结果
+type Fixture struct {
+	Client *client.Client
+	RPC    order.OrderService
+	// MicroClient 连接同一个注册中心和broker，可以调用同一进程中注册的其他测试服务
+	MicroClient microclient.Client
+	Server      server.Server
+
+	DB        *gorm.DB
+	OrderDAO  dao.OrderDAOInterface
+	SagaDAO   dao.SagaDAOInterface
+	Orders    services.OrderServiceInterface
+	Saga      *services.OrderSaga
+	Handler   *handler.Order
+	Inventory *services.MemoryInventory
+	Payment   *MemoryPayment
+}
+
+// New 建库并启动服务，用完后调用 Close
+func New(opts ...Option) (*Fixture, error) {
+	options := Options{Persistence: conf.PersistenceState}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	tenants, err := services.NewTenantsFromConfig(tenantConfig(options.Tenants))
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := OpenSQLite("")
+	if err != nil {
+		return nil, err
+	}
+	f := &Fixture{
+		DB:        db,
+		Inventory: services.NewMemoryInventory(options.Stock),
+		Payment:   NewMemoryPayment(),
+	}
+	if f.OrderDAO, err = NewOrderDAO(db, options.Persistence, options.SnapshotEvery); err != nil {
+		return nil, err
+	}
+	f.SagaDAO = dao.NewSagaDAO(db)
+	privacyDAO := dao.NewPrivacyDAO(db)
+	reportDAO := dao.NewReportDAO(db, nil)
+	for _, migrate := range []func() error{f.SagaDAO.(*dao.SagaDAO).Migrate, privacyDAO.(*dao.PrivacyDAO).Migrate, reportDAO.(*dao.ReportDAO).Migrate} {
+		if err := migrate(); err != nil {
+			return nil, err
+		}
+	}
+
+	// 每个 Fixture 使用自己的注册中心、传输和broker，不修改go-micro 的默认client 和server，可以并行启动多个
+	registry := registrymemory.NewRegistry()
+	transport := transportmemory.NewTransport()
+	broker := brokermemory.NewBroker()
+	serverOptions := []server.Option{
+		server.Name(client.ServiceName),
+		server.Address("127.0.0.1:0"),
+		server.Registry(registry),
+		server.Transport(transport),
+		server.Broker(broker),
+	}
+	for _, wrapper := range options.Wrappers {
+		serverOptions = append(serverOptions, server.WrapHandler(wrapper))
+	}
+	serverOptions = append(serverOptions, server.WrapHandler(handler.NewTenantWrapper(tenants)))
+	f.Server = server.NewServer(serverOptions...)
+	// go-micro 的protobuf 编码写不出没有响应体的错误，调用方只能等到超时；用json 编码才能收到错误码
+	f.MicroClient = microclient.NewClient(
+		microclient.ContentType("application/json"),
+		microclient.Selector(selector.NewSelector(selector.Registry(registry))),
+		microclient.Registry(registry),
+		microclient.Transport(transport),
+		microclient.Broker(broker),
+	)
+
+	// 和main.go 相同的组装方式，库存和支付换成进程内的实现
+	var orderInventory services.InventoryClient
+	if options.Stock != nil {
+		orderInventory = f.Inventory
+	}
+	publisher := services.NewMicroPublisher(f.MicroClient)
+	orderService := services.NewOrderService(f.OrderDAO, publisher, orderInventory, options.Promotions, tenants)
+	watcher := services.NewOrderWatcher(orderService, time.Second, 1000)
+	indexer, err := services.NewSQLIndexer(f.OrderDAO)
+	if err != nil {
+		return nil, err
+	}
+	orderService = services.NewNotifyingOrderService(orderService, watcher, publisher)
+	if err := micro.RegisterSubscriber(services.TopicOrderChanged, f.Server, func(ctx context.Context, event *services.OrderChangedEvent) error {
+		watcher.Notify(event)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	f.Orders = services.NewCachedOrderService(orderService, services.NewLRUCache(1000, time.Minute))
+	f.Saga = services.NewOrderSaga(f.SagaDAO, f.Orders, f.Inventory, f.Payment, services.RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond})
+	reports, err := services.NewReportService(reportDAO, f.OrderDAO)
+	if err != nil {
+		return nil, err
+	}
+	f.Handler = &handler.Order{
+		Service:           f.Orders,
+		Saga:              f.Saga,
+		PaymentSecret:     options.PaymentSecret,
+		Privacy:           services.NewPrivacyService(f.Orders, privacyDAO, f.SagaDAO),
+		Watcher:           watcher,
+		Search:            indexer,
+		Reports:           reports,
+		TrustMetadataRole: options.TrustMetadataRole,
+	}
+	if err := order.RegisterOrderHandler(f.Server, f.Handler); err != nil {
+		return nil, err
+	}
+	if err := f.Server.Start(); err != nil {
+		return nil, err
+	}
+
+	f.RPC = order.NewOrderService(client.ServiceName, f.MicroClient)
+	f.Client = client.Wrap(f.RPC, options.ClientOptions...)
+	return f, nil
+}
+
+func tenantConfig(cfg *conf.TenantConfig) *conf.TenantConfig {
+	if cfg == nil {
+		return &conf.TenantConfig{}
+	}
+	return cfg
+}
+
+// Close 停止服务并关闭数据库，内存库随之删除
+func (f *Fixture) Close() error {
+	err := f.Server.Stop()
+	if sqlDB, dbErr := f.DB.DB(); dbErr == nil {
+		if closeErr := sqlDB.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// WithTenant 调用时通过metadata 传入租户，没有启用认证时使用
+func WithTenant(ctx context.Context, tenantId string) context.Context {
+	return metadata.MergeContext(ctx, metadata.Metadata{handler.TenantKey: tenantId}, true)
+}
+
+// AsAdmin 没有启用认证时以管理员身份调用，需要 Fixture 使用 WithMetadataRole
+func AsAdmin(ctx context.Context) context.Context {
+	return metadata.MergeContext(ctx, metadata.Metadata{handler.RoleKey: handler.RoleAdmin}, true)
+}
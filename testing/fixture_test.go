@@ -0,0 +1,291 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/lenny-mo/order/auth"
+	"github.com/lenny-mo/order/client"
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/proto/order"
+	microerrors "github.com/micro/go-micro/v2/errors"
+	"github.com/micro/go-micro/v2/metadata"
+)
+
+var persistenceModes = []string{conf.PersistenceState, conf.PersistenceEvent}
+
+func newFixture(t *testing.T, opts ...Option) *Fixture {
+	t.Helper()
+	f, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func insertOrder(t *testing.T, f *Fixture, userId int64) *order.OrderInfo {
+	t.Helper()
+	ctx := context.Background()
+	orderId, err := f.Client.GenerateOrderId(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &order.OrderInfo{
+		OrderId:      orderId,
+		OrderVersion: 1,
+		UserId:       userId,
+		OrderData:    "first",
+		Currency:     "CNY",
+		Items:        []*order.OrderItemInfo{{SKUId: 1, Count: 2, Price: 150}, {SKUId: 2, Count: 1, Price: 700}},
+	}
+	if err := f.Client.InsertOrder(ctx, info); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+// edit 修改订单数据后的新版本
+func edit(info *order.OrderInfo, data string, version int64) *order.OrderInfo {
+	return &order.OrderInfo{
+		OrderId:      info.OrderId,
+		OrderVersion: version,
+		UserId:       info.UserId,
+		OrderData:    data,
+		Status:       info.Status,
+	}
+}
+
+func TestInsertGetUpdate(t *testing.T) {
+	for _, mode := range persistenceModes {
+		t.Run(mode, func(t *testing.T) {
+			f := newFixture(t, WithPersistence(mode))
+			defer f.Close()
+			ctx := context.Background()
+
+			inserted := insertOrder(t, f, 42)
+			got, err := f.Client.GetOrder(ctx, inserted.OrderId, client.WithItems())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.UserId != 42 || got.OrderVersion != 1 || got.OrderData != "first" || got.Status != order.OrderStatus_UNPAID {
+				t.Fatalf("unexpected order %+v", got)
+			}
+			if got.TotalAmount != 1000 || len(got.Items) != 2 {
+				t.Fatalf("total %d with %d items, want 1000 with 2 items", got.TotalAmount, len(got.Items))
+			}
+
+			got.OrderData = "second"
+			got.OrderVersion = 2
+			if err := f.Client.UpdateOrder(ctx, got, 1, "edit"); err != nil {
+				t.Fatal(err)
+			}
+			updated, err := f.Client.GetOrder(ctx, inserted.OrderId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated.OrderVersion != 2 || updated.OrderData != "second" {
+				t.Fatalf("version %d data %q after update", updated.OrderVersion, updated.OrderData)
+			}
+			history, err := f.Client.GetOrderHistory(ctx, inserted.OrderId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(history) == 0 {
+				t.Fatal("order has no history")
+			}
+			// 事件溯源模式下订单项也各有一条版本记录，只检查第一条和最后一条
+			last := history[len(history)-1]
+			if history[0].Reason != "create" || last.OrderVersion != 2 || last.Reason != "edit" {
+				t.Fatalf("unexpected history %+v", history)
+			}
+		})
+	}
+}
+
+func TestGetMissingOrder(t *testing.T) {
+	for _, mode := range persistenceModes {
+		t.Run(mode, func(t *testing.T) {
+			f := newFixture(t, WithPersistence(mode))
+			defer f.Close()
+
+			_, err := f.Client.GetOrder(context.Background(), "missing")
+			if err == nil {
+				t.Fatal("expected an error for a missing order")
+			}
+		})
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	for _, mode := range persistenceModes {
+		t.Run(mode, func(t *testing.T) {
+			f := newFixture(t, WithPersistence(mode))
+			defer f.Close()
+			ctx := context.Background()
+
+			inserted := insertOrder(t, f, 7)
+			if err := f.Client.UpdateOrder(ctx, edit(inserted, "first writer", 2), 1, "first"); err != nil {
+				t.Fatal(err)
+			}
+			err := f.Client.UpdateOrder(ctx, edit(inserted, "second writer", 2), 1, "second")
+			if !client.IsConflict(err) {
+				t.Fatalf("expected a version conflict, got %v", err)
+			}
+
+			// 第一次修改时插入一次并发修改，UpdateWithRetry 重新读取后成功
+			calls := 0
+			updated, err := f.Client.UpdateWithRetry(ctx, inserted.OrderId, "retry", func(info *order.OrderInfo) error {
+				calls++
+				if calls == 1 {
+					if err := f.Client.UpdateOrder(ctx, edit(info, "concurrent", info.OrderVersion+1), info.OrderVersion, "concurrent"); err != nil {
+						return err
+					}
+				}
+				info.OrderData = "retried"
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls != 2 || updated.OrderVersion != 4 {
+				t.Fatalf("fn called %d times, version %d, want 2 calls and version 4", calls, updated.OrderVersion)
+			}
+			got, err := f.Client.GetOrder(ctx, inserted.OrderId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.OrderData != "retried" || got.OrderVersion != 4 {
+				t.Fatalf("data %q version %d after retry", got.OrderData, got.OrderVersion)
+			}
+		})
+	}
+}
+
+func TestPlaceOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		paymentErr error
+		wantStatus string
+		wantStock  int32
+	}{
+		{name: "completed", wantStatus: models.SagaCompleted, wantStock: 8},
+		{name: "payment fails", paymentErr: errors.New("payment rejected"), wantStatus: models.SagaCompensated, wantStock: 10},
+	}
+	for _, mode := range persistenceModes {
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				f := newFixture(t, WithPersistence(mode), WithStock(map[int64]int32{1: 10}))
+				defer f.Close()
+				ctx := context.Background()
+				f.Payment.Fail(tt.paymentErr)
+
+				orderId, err := f.Client.GenerateOrderId(ctx, 9)
+				if err != nil {
+					t.Fatal(err)
+				}
+				res, err := f.Client.PlaceOrder(ctx, &order.OrderInfo{
+					OrderId:  orderId,
+					UserId:   9,
+					Currency: "CNY",
+					Items:    []*order.OrderItemInfo{{SKUId: 1, Count: 2, Price: 100}},
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if res.SagaStatus != tt.wantStatus {
+					t.Fatalf("saga status %s, want %s: %s", res.SagaStatus, tt.wantStatus, res.Error)
+				}
+				if available := f.Inventory.Available(1); available != tt.wantStock {
+					t.Fatalf("sku 1 has %d available, want %d", available, tt.wantStock)
+				}
+				if tt.paymentErr == nil && f.Payment.PaymentId(orderId) == "" {
+					t.Fatal("payment was not requested")
+				}
+			})
+		}
+	}
+}
+
+func TestPurgeOrderRequiresTrustedRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantCode int32
+	}{
+		{name: "metadata role not trusted", wantCode: 403},
+		{name: "metadata role trusted", opts: []Option{WithMetadataRole()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFixture(t, tt.opts...)
+			defer f.Close()
+			info := insertOrder(t, f, 1)
+
+			_, err := f.RPC.PurgeOrder(AsAdmin(context.Background()), &order.PurgeRequest{OrderId: info.OrderId})
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if code := microerrors.Parse(err.Error()).Code; code != tt.wantCode {
+				t.Fatalf("got %v, want code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+// userToken 签发一个普通用户的token
+func userToken(t *testing.T, secret []byte, subject string) context.Context {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: subject, ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+	}).SignedString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.NewContext(context.Background(), metadata.Metadata{auth.AuthorizationKey: "Bearer " + token})
+}
+
+func TestUserTokenRequiresUserId(t *testing.T) {
+	secret := []byte("test-secret")
+	verifier := auth.NewVerifier(auth.NewKeySet(&auth.Key{Kid: "test", Value: secret}), "", "")
+	f := newFixture(t, WithAuth(verifier, auth.DefaultPolicy()))
+	defer f.Close()
+
+	tests := []struct {
+		name     string
+		subject  string
+		wantCode int32
+	}{
+		{name: "numeric subject", subject: "1"},
+		{name: "non numeric subject", subject: "alice", wantCode: 401},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := f.RPC.ListOrders(userToken(t, secret, tt.subject), &order.ListRequest{})
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if code := microerrors.Parse(err.Error()).Code; code != tt.wantCode {
+				t.Fatalf("got %v, want code %d", err, tt.wantCode)
+			}
+		})
+	}
+
+	// 没有经过验签的身份缺少用户ID 时，handler 也不会去掉用户条件
+	ctx := auth.NewContext(context.Background(), &auth.Identity{Subject: "alice", Roles: []string{auth.RoleUser}})
+	if err := f.Handler.ListOrders(ctx, &order.ListRequest{}, &order.ListResponse{}); microerrors.Parse(err.Error()).Code != 403 {
+		t.Fatalf("listing without a user id returned %v", err)
+	}
+	if err := f.Handler.SearchOrders(ctx, &order.SearchRequest{Text: "x"}, &order.SearchResponse{}); microerrors.Parse(err.Error()).Code != 403 {
+		t.Fatalf("searching without a user id returned %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package testing
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lenny-mo/order/domain/models"
+	"github.com/lenny-mo/order/utils"
+)
+
+// MemoryPayment 进程内的支付服务，实现 services.PaymentClient，同一个订单重复发起返回同一个支付单号
+type MemoryPayment struct {
+	mu       sync.Mutex
+	err      error
+	payments map[string]string
+}
+
+func NewMemoryPayment() *MemoryPayment {
+	return &MemoryPayment{payments: map[string]string{}}
+}
+
+func (p *MemoryPayment) RequestPayment(ctx context.Context, order *models.Order) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return "", p.err
+	}
+	if id, ok := p.payments[order.OrderId]; ok {
+		return id, nil
+	}
+	id := utils.UUID()
+	p.payments[order.OrderId] = id
+	return id, nil
+}
+
+// Fail 之后的支付请求都返回 err，传入nil 时恢复
+func (p *MemoryPayment) Fail(err error) {
+	p.mu.Lock()
+	p.err = err
+	p.mu.Unlock()
+}
+
+// PaymentId 订单发起过的支付单号，没有发起时返回空
+func (p *MemoryPayment) PaymentId(orderId string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.payments[orderId]
+}
@@ -0,0 +1,36 @@
+package testing
+
+import (
+	"github.com/glebarez/sqlite"
+	"github.com/lenny-mo/order/conf"
+	"github.com/lenny-mo/order/domain/dao"
+	"github.com/lenny-mo/order/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// OpenSQLite 打开一个进程内的SQLite 内存库并按租户隔离读写，name 相同的连接共享同一个库，为空时每次打开新库
+// 最后一个连接关闭后库被删除；驱动是纯Go 实现，不需要cgo
+func OpenSQLite(name string) (*gorm.DB, error) {
+	if name == "" {
+		name = utils.UUID()
+	}
+	db, err := gorm.Open(sqlite.Open("file:"+name+"?mode=memory&cache=shared"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Use(dao.TenantPlugin{}); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// NewOrderDAO 在 db 上按持久化模式创建订单DAO 并建表，mode 是 conf.PersistenceState 或 conf.PersistenceEvent
+func NewOrderDAO(db *gorm.DB, mode string, snapshotEvery int) (dao.OrderDAOInterface, error) {
+	if mode == conf.PersistenceEvent {
+		orderDAO := dao.NewEventSourcedOrderDAO(db, snapshotEvery)
+		return orderDAO, orderDAO.(*dao.EventSourcedOrderDAO).Migrate()
+	}
+	orderDAO := dao.NewOrderDAO(db)
+	return orderDAO, orderDAO.(*dao.OrderDAO).Migrate()
+}